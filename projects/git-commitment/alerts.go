@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/erik/git-commitment/metrics"
+	"github.com/erik/git-commitment/sinks"
+)
+
+// Webhooks which take longer than this are given up on.
+const alertWebhookTimeout = 10 * time.Second
+
+// What can be done when an alert fires.
+const (
+	AlertBell    = "bell"
+	AlertSay     = "say"
+	AlertWebhook = "webhook"
+)
+
+// AlertRule fires when a metric crosses a threshold and stays there for a
+// while. It fires once each time, and not again until the metric is back
+// within bounds.
+type AlertRule struct {
+	// As in output, so this can be a rolling average, e.g.
+	// "smoothed_power_3s".
+	Metric string
+
+	// Zero for no threshold.
+	Above, Below float64
+	// Percent below the current workout step's power target. Nothing fires
+	// outside of a workout step with a power target.
+	BelowTarget float64
+
+	For     time.Duration
+	Actions []string
+	Message string
+	Webhook string
+}
+
+// parseMetricName checks that name is something which could show up in
+// output, i.e. a kind, optionally followed by a window.
+func parseMetricName(name string) error {
+	if _, err := metrics.ParseKind(name); err == nil {
+		return nil
+	}
+
+	if i := strings.LastIndex(name, "_"); i > 0 {
+		if _, err := metrics.ParseKind(name[:i]); err == nil {
+			if _, err := time.ParseDuration(name[i+1:]); err == nil {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("unknown metric: %q", name)
+}
+
+func (r *AlertRule) validate() error {
+	if err := parseMetricName(r.Metric); err != nil {
+		return err
+	}
+
+	if r.Above == 0 && r.Below == 0 && r.BelowTarget == 0 {
+		return fmt.Errorf("%s: needs one of above, below or below_target", r.Metric)
+	}
+
+	for _, action := range r.Actions {
+		switch action {
+		case AlertBell, AlertSay:
+		case AlertWebhook:
+			if r.Webhook == "" {
+				return fmt.Errorf("%s: webhook action needs a webhook URL", r.Metric)
+			}
+		default:
+			return fmt.Errorf("%s: unknown action: %q", r.Metric, action)
+		}
+	}
+
+	return nil
+}
+
+// triggered checks the rule against a single value, given the current
+// power target (0 for none).
+func (r *AlertRule) triggered(value, target float64) bool {
+	switch {
+	case r.Above != 0 && value > r.Above:
+		return true
+	case r.Below != 0 && value < r.Below:
+		return true
+	case r.BelowTarget != 0 && target > 0 && value < target*(1-r.BelowTarget/100):
+		return true
+	}
+
+	return false
+}
+
+// message is what to say about m triggering the rule, if one wasn't
+// configured.
+func (r *AlertRule) message(m metrics.Metric, target float64) string {
+	if r.Message != "" {
+		return r.Message
+	}
+
+	name := strings.ReplaceAll(r.Metric, "_", " ")
+
+	var msg string
+	switch {
+	case r.Above != 0 && m.Value > r.Above:
+		msg = fmt.Sprintf("%s above %g", name, r.Above)
+	case r.Below != 0 && m.Value < r.Below:
+		msg = fmt.Sprintf("%s below %g", name, r.Below)
+	default:
+		msg = fmt.Sprintf("%s below target of %.0f", name, target)
+	}
+
+	// Every sensor has its own battery, so it's no use without knowing
+	// which one.
+	if m.Kind == metrics.BatteryLevel {
+		msg += " on " + m.Source()
+	}
+
+	return msg
+}
+
+// AlertWebhookPayload is POSTed as JSON to a rule's webhook.
+type AlertWebhookPayload struct {
+	Message string    `json:"message"`
+	Metric  string    `json:"metric"`
+	Value   float64   `json:"value"`
+	Source  string    `json:"source"`
+	Time    time.Time `json:"time"`
+}
+
+// alertState tracks a rule for a single source, since e.g. each sensor
+// reports its own battery level.
+type alertState struct {
+	since time.Time
+	fired bool
+}
+
+// Alerts evaluates rules against the metric stream.
+type Alerts struct {
+	rules     []AlertRule
+	announcer *sinks.Announcer
+	client    *http.Client
+
+	mu sync.Mutex
+	// Watts, 0 outside of a workout step with a power target.
+	target float64
+}
+
+// NewAlerts evaluates rules, speaking through announcer for any with the
+// say action.
+func NewAlerts(rules []AlertRule, announcer *sinks.Announcer) *Alerts {
+	return &Alerts{
+		rules:     rules,
+		announcer: announcer,
+		client:    &http.Client{Timeout: alertWebhookTimeout},
+	}
+}
+
+// SetTarget sets the power target for below_target rules, 0 for none.
+func (a *Alerts) SetTarget(watts float64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.target = watts
+}
+
+func (a *Alerts) currentTarget() float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.target
+}
+
+// Run checks every metric against the rules until the channel is closed.
+func (a *Alerts) Run(in <-chan metrics.Metric) {
+	states := make([]map[string]*alertState, len(a.rules))
+	for i := range states {
+		states[i] = map[string]*alertState{}
+	}
+
+	for m := range in {
+		name := m.Name()
+		target := a.currentTarget()
+
+		for i := range a.rules {
+			rule := &a.rules[i]
+			if rule.Metric != name {
+				continue
+			}
+
+			state, ok := states[i][m.Source()]
+			if !ok {
+				state = &alertState{}
+				states[i][m.Source()] = state
+			}
+
+			if !rule.triggered(m.Value, target) {
+				state.since = time.Time{}
+				state.fired = false
+				continue
+			}
+
+			if state.since.IsZero() {
+				state.since = m.Timestamp
+			}
+
+			if !state.fired && m.Timestamp.Sub(state.since) >= rule.For {
+				state.fired = true
+				a.fire(rule, m, rule.message(m, target))
+			}
+		}
+	}
+}
+
+func (a *Alerts) fire(rule *AlertRule, m metrics.Metric, msg string) {
+	slog.Warn("alert", "message", msg, "metric", m.Name(), "value", m.Value)
+
+	for _, action := range rule.Actions {
+		switch action {
+		case AlertBell:
+			os.Stdout.WriteString("\a")
+
+		case AlertSay:
+			if a.announcer != nil {
+				a.announcer.Announce(msg)
+			}
+
+		case AlertWebhook:
+			payload := AlertWebhookPayload{
+				Message: msg,
+				Metric:  m.Name(),
+				Value:   m.Value,
+				Source:  m.Source(),
+				Time:    m.Timestamp,
+			}
+			// Don't hold up the metric stream waiting on some server.
+			go a.postWebhook(rule.Webhook, payload)
+		}
+	}
+}
+
+func (a *Alerts) postWebhook(url string, payload AlertWebhookPayload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		slog.Warn("failed to encode alert", "err", err)
+		return
+	}
+
+	resp, err := a.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		slog.Warn("failed to send alert webhook", "err", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		slog.Warn("alert webhook failed", "status", resp.Status)
+	}
+}
+
+// needsAnnouncer is whether any of the rules speak.
+func needsAnnouncer(rules []AlertRule) bool {
+	for _, rule := range rules {
+		for _, action := range rule.Actions {
+			if action == AlertSay {
+				return true
+			}
+		}
+	}
+	return false
+}
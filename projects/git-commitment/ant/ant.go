@@ -0,0 +1,108 @@
+// Package ant receives broadcasts from ANT+ sensors through a USB stick,
+// for the (mostly older) sensors which don't speak Bluetooth.
+//
+// The stick is driven over its serial interface. On Linux, newer Garmin
+// sticks need the usbserial driver to show up as one, e.g.
+//
+//	modprobe usbserial vendor=0x0fcf product=0x1008
+package ant
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Every message starts with this byte.
+const messageSync = 0xA4
+
+// Message IDs, from the ANT Message Protocol and Usage document.
+const (
+	msgChannelEvent    = 0x40
+	msgAssignChannel   = 0x42
+	msgChannelPeriod   = 0x43
+	msgSearchTimeout   = 0x44
+	msgChannelRFFreq   = 0x45
+	msgSetNetworkKey   = 0x46
+	msgResetSystem     = 0x4A
+	msgOpenChannel     = 0x4B
+	msgCloseChannel    = 0x4C
+	msgBroadcastData   = 0x4E
+	msgAcknowledgeData = 0x4F
+	msgChannelID       = 0x51
+	msgStartup         = 0x6F
+)
+
+// Channel response code for success.
+const responseNoError = 0x00
+
+// Everything ANT+ happens on network 0 with this key, on 2457MHz.
+var networkKey = []byte{0xB9, 0xA5, 0x21, 0xFB, 0xBD, 0x72, 0xC3, 0x45}
+
+const (
+	network = 0
+	rfFreq  = 57
+)
+
+var errBadChecksum = errors.New("bad checksum")
+
+// encodeMessage frames a message: sync, length, ID, data and a checksum
+// which XORs everything before it.
+func encodeMessage(id byte, data ...byte) []byte {
+	msg := append([]byte{messageSync, byte(len(data)), id}, data...)
+
+	var checksum byte
+	for _, b := range msg {
+		checksum ^= b
+	}
+
+	return append(msg, checksum)
+}
+
+// readMessage reads the next message, skipping anything before the sync
+// byte.
+func readMessage(r *bufio.Reader) (byte, []byte, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		if b == messageSync {
+			break
+		}
+	}
+
+	length, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// ID, data and checksum
+	buf := make([]byte, int(length)+2)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return 0, nil, err
+	}
+
+	checksum := byte(messageSync) ^ length
+	for _, b := range buf[:len(buf)-1] {
+		checksum ^= b
+	}
+	if checksum != buf[len(buf)-1] {
+		return 0, nil, errBadChecksum
+	}
+
+	return buf[0], buf[1 : len(buf)-1], nil
+}
+
+// channelResponse is the stick's response to a channel command, which is an
+// error unless the code is responseNoError.
+type channelResponse struct {
+	channel byte
+	msg     byte
+	code    byte
+}
+
+func (e channelResponse) Error() string {
+	return fmt.Sprintf("channel %d: command 0x%02X failed with code 0x%02X", e.channel, e.msg, e.code)
+}
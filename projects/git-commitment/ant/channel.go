@@ -0,0 +1,138 @@
+package ant
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/erik/git-commitment/gatt"
+	"github.com/erik/git-commitment/metrics"
+)
+
+// Channel receives from a single sensor, decoding its broadcasts into
+// metrics and sending them to each of its sinks, the same as a BLE source.
+type Channel struct {
+	stick  *Stick
+	number byte
+	addr   Address
+	// Set once opened
+	decode func([]byte)
+	// Also gets every page, see Observe. Guarded by the stick's mu.
+	observe func([]byte)
+
+	// Guards sinks and closed. Held while sending to the sinks.
+	mu     sync.Mutex
+	sinks  []chan metrics.Metric
+	closed bool
+
+	// Unix nanoseconds of the last broadcast we received. Accessed
+	// atomically.
+	lastSeen int64
+
+	// Attached to every metric we emit.
+	Alias string
+
+	// In meters, for speed sensors.
+	WheelCircumference float64
+}
+
+func newChannel(stick *Stick, number byte, addr Address) *Channel {
+	return &Channel{
+		stick:  stick,
+		number: number,
+		addr:   addr,
+		sinks:  []chan metrics.Metric{},
+
+		WheelCircumference: gatt.DefaultWheelCircumference,
+	}
+}
+
+// Open starts searching for the sensor, which can take a while. Set up
+// the channel before calling this. Searching continues until the channel
+// or stick is closed.
+func (ch *Channel) Open() error {
+	// The stick ignores anything for the channel until this is set.
+	ch.stick.mu.Lock()
+	ch.decode = ch.addr.Type.decoder(ch.emit, ch.WheelCircumference)
+	ch.stick.mu.Unlock()
+
+	// Transmission type 0 is a wildcard
+	return ch.stick.open(ch.number, channelReceive, ch.addr, 0)
+}
+
+// Address is what the channel was opened with.
+func (ch *Channel) Address() Address {
+	return ch.addr
+}
+
+func (ch *Channel) AddSink(sink chan metrics.Metric) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	ch.sinks = append(ch.sinks, sink)
+}
+
+// Close stops sending metrics to the sinks. Safe to call more than once.
+func (ch *Channel) Close() {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	ch.closed = true
+}
+
+// LastSeen is when we last received a broadcast, or zero if we haven't
+// found the sensor yet.
+func (ch *Channel) LastSeen() time.Time {
+	seen := atomic.LoadInt64(&ch.lastSeen)
+	if seen == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, seen)
+}
+
+// Send sends a page to the sensor, for the few which take commands. It's
+// sent as acknowledged data, but we don't hear about failures.
+func (ch *Channel) Send(page []byte) error {
+	return ch.stick.write(msgAcknowledgeData, append([]byte{ch.number}, page...)...)
+}
+
+// Observe passes every page received to f as well, for anything beyond
+// metrics, e.g. replies to what we Send. It replaces any earlier f.
+func (ch *Channel) Observe(f func(page []byte)) {
+	ch.stick.mu.Lock()
+	defer ch.stick.mu.Unlock()
+
+	ch.observe = f
+}
+
+func (ch *Channel) receive(page []byte) {
+	atomic.StoreInt64(&ch.lastSeen, time.Now().UnixNano())
+	ch.decode(page)
+
+	ch.stick.mu.Lock()
+	observe := ch.observe
+	ch.stick.mu.Unlock()
+
+	if observe != nil {
+		observe(page)
+	}
+}
+
+func (ch *Channel) emit(m metrics.Metric) {
+	if m.Timestamp.IsZero() {
+		m.Timestamp = time.Now()
+	}
+	m.Address = ch.addr.String()
+	m.Alias = ch.Alias
+
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	if ch.closed {
+		return
+	}
+
+	for _, sink := range ch.sinks {
+		sink <- m
+	}
+}
@@ -0,0 +1,60 @@
+package ant
+
+import (
+	"time"
+
+	"github.com/erik/git-commitment/gatt"
+)
+
+// The stick only holds one acknowledged page at a time, so wait for the
+// first to go out (at the next message period) before sending another.
+const fecPageGap = 250 * time.Millisecond
+
+// FECControl drives a trainer over ANT+ FE-C, using the same pages Tacx
+// trainers take over BLE.
+type FECControl struct {
+	ch *Channel
+
+	calibration gatt.CalibrationListener
+}
+
+// NewFECControl controls the trainer found by an open FitnessEquipment
+// channel. Pages sent before the trainer is found are lost.
+func NewFECControl(ch *Channel) *FECControl {
+	ctrl := &FECControl{ch: ch}
+	ch.Observe(func(page []byte) {
+		if event, ok := gatt.DecodeFECCalibration(page); ok {
+			ctrl.calibration.Report(event)
+		}
+	})
+
+	return ctrl
+}
+
+func (ctrl *FECControl) SetTargetPower(watts int) error {
+	return ctrl.ch.Send(gatt.FECTargetPowerPage(watts))
+}
+
+// Level is a percentage of the trainer's maximum resistance.
+func (ctrl *FECControl) SetResistance(level float64) error {
+	return ctrl.ch.Send(gatt.FECBasicResistancePage(level))
+}
+
+// Wind and grade go in separate pages, and the trainer combines them.
+func (ctrl *FECControl) SetGrade(params gatt.SimulationParams) error {
+	if err := ctrl.ch.Send(gatt.FECWindResistancePage(params)); err != nil {
+		return err
+	}
+	time.Sleep(fecPageGap)
+	return ctrl.ch.Send(gatt.FECTrackResistancePage(params))
+}
+
+// Calibrate asks the trainer for a spindown.
+func (ctrl *FECControl) Calibrate(onEvent func(gatt.CalibrationEvent)) error {
+	ctrl.calibration.Listen(onEvent)
+	return ctrl.ch.Send(gatt.FECSpinDownPage())
+}
+
+func (ctrl *FECControl) Capabilities() gatt.TrainerCapabilities {
+	return gatt.FECCapabilities
+}
@@ -0,0 +1,428 @@
+package ant
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/erik/git-commitment/gatt"
+	"github.com/erik/git-commitment/metrics"
+)
+
+// DeviceType is the ANT+ device profile a sensor implements.
+type DeviceType byte
+
+const (
+	BikePower        DeviceType = 11
+	HeartRate        DeviceType = 120
+	BikeSpeedCadence DeviceType = 121
+	// Light electric vehicles, which is how e-bike systems that speak
+	// ANT+, like Shimano STEPS, broadcast their state. Over BLE they only
+	// have their own undocumented services.
+	LEV DeviceType = 20
+	// Electronic groupsets, e.g. Shimano Di2 and SRAM AXS.
+	Shifting DeviceType = 34
+	// Smart trainers, which also take commands. This is how Elite
+	// trainers without FTMS are controlled.
+	FitnessEquipment DeviceType = 17
+)
+
+// What each device type is called in addresses.
+var deviceTypeNames = map[DeviceType]string{
+	HeartRate:        "hr",
+	BikePower:        "power",
+	BikeSpeedCadence: "speed-cadence",
+	LEV:              "lev",
+	Shifting:         "shifting",
+	FitnessEquipment: "fe-c",
+}
+
+// period is how often the sensor broadcasts, in 1/32768ths of a second.
+func (t DeviceType) period() uint16 {
+	switch t {
+	case HeartRate:
+		return 8070
+	case BikePower:
+		return 8182
+	case BikeSpeedCadence:
+		return 8086
+	}
+	return 8192
+}
+
+// Prefix for ANT+ devices, to tell them apart from BLE addresses.
+const addressPrefix = "ant:"
+
+// Address identifies an ANT+ sensor by type and device number. Device
+// number 0 is a wildcard, matching whichever sensor is found first.
+//
+// Written as "ant:<type>[:<device number>]", e.g. "ant:hr:12345" or
+// "ant:power".
+type Address struct {
+	Type   DeviceType
+	Device uint16
+}
+
+// IsAddress reports whether addr is meant to be an ANT+ address.
+func IsAddress(addr string) bool {
+	return strings.HasPrefix(addr, addressPrefix)
+}
+
+func ParseAddress(addr string) (Address, error) {
+	if !IsAddress(addr) {
+		return Address{}, fmt.Errorf("not an ANT+ address: %q", addr)
+	}
+
+	parts := strings.Split(strings.TrimPrefix(addr, addressPrefix), ":")
+	if len(parts) > 2 {
+		return Address{}, fmt.Errorf("bad ANT+ address: %q", addr)
+	}
+
+	var a Address
+	found := false
+	for t, name := range deviceTypeNames {
+		if name == parts[0] {
+			a.Type = t
+			found = true
+		}
+	}
+	if !found {
+		return Address{}, fmt.Errorf("unknown ANT+ device type: %q", parts[0])
+	}
+
+	if len(parts) == 2 {
+		device, err := strconv.ParseUint(parts[1], 10, 16)
+		if err != nil {
+			return Address{}, fmt.Errorf("bad ANT+ device number: %w", err)
+		}
+		a.Device = uint16(device)
+	}
+
+	return a, nil
+}
+
+func (a Address) String() string {
+	if a.Device == 0 {
+		return addressPrefix + deviceTypeNames[a.Type]
+	}
+	return fmt.Sprintf("%s%s:%d", addressPrefix, deviceTypeNames[a.Type], a.Device)
+}
+
+// decoder returns the function which turns broadcast pages from this type
+// of device into metrics. Wheel circumference (in meters) only matters for
+// speed sensors.
+func (t DeviceType) decoder(emit func(metrics.Metric), wheelCircumference float64) func([]byte) {
+	switch t {
+	case HeartRate:
+		return func(page []byte) { decodeHeartRate(page, emit) }
+	case BikePower:
+		return (&powerDecoder{emit: emit}).decode
+	case BikeSpeedCadence:
+		return (&speedCadenceDecoder{
+			emit:               emit,
+			wheel:              gatt.NewRevolutionCounter(16),
+			crank:              gatt.NewRevolutionCounter(16),
+			wheelCircumference: wheelCircumference,
+		}).decode
+	case LEV:
+		return func(page []byte) { decodeLEV(page, emit) }
+	case Shifting:
+		return func(page []byte) { decodeShifting(page, emit) }
+	case FitnessEquipment:
+		return func(page []byte) { decodeFitnessEquipment(page, emit) }
+	}
+	return func([]byte) {}
+}
+
+// Every heart rate page ends with the same four bytes, whatever the page
+// number.
+//
+// uint16  heart_beat_event_time    seconds with resolution 1/1024
+// uint8   heart_beat_count         unitless
+// uint8   computed_heart_rate      beats per minute
+func decodeHeartRate(page []byte, emit func(metrics.Metric)) {
+	// No contact
+	if page[7] == 0 {
+		return
+	}
+
+	emit(metrics.Metric{
+		Kind:  metrics.HeartRate,
+		Value: float64(page[7]),
+	})
+}
+
+// Power data pages we understand.
+const (
+	// Standard power-only main data page.
+	powerPageStandard = 0x10
+	// Torque effectiveness and pedal smoothness.
+	powerPagePedaling = 0x13
+)
+
+type powerDecoder struct {
+	emit func(metrics.Metric)
+
+	// Pages are broadcast several times per update, so skip repeats. Each
+	// page has its own event count.
+	eventCounts map[byte]byte
+}
+
+func (d *powerDecoder) decode(page []byte) {
+	switch page[0] {
+	case powerPageStandard, powerPagePedaling:
+	default:
+		return
+	}
+
+	if count, ok := d.eventCounts[page[0]]; ok && page[1] == count {
+		return
+	}
+	if d.eventCounts == nil {
+		d.eventCounts = map[byte]byte{}
+	}
+	d.eventCounts[page[0]] = page[1]
+
+	if page[0] == powerPagePedaling {
+		d.decodePedaling(page)
+	} else {
+		d.decodeStandard(page)
+	}
+}
+
+// uint8   page_number              0x10
+// uint8   update_event_count       unitless
+// uint8   pedal_power              percent, bit 7 set if it's the right pedal
+// uint8   instantaneous_cadence    RPM, 0xFF if not available
+// uint16  accumulated_power        watts
+// uint16  instantaneous_power      watts
+func (d *powerDecoder) decodeStandard(page []byte) {
+	// Zeros included, so coasting isn't held at the last power.
+	power := binary.LittleEndian.Uint16(page[6:])
+	d.emit(metrics.Metric{
+		Kind:  metrics.CyclingPower,
+		Value: float64(power),
+	})
+
+	if page[3] != 0xFF {
+		d.emit(metrics.Metric{
+			Kind:  metrics.CyclingCadence,
+			Value: float64(page[3]),
+		})
+	}
+
+	// Without the right pedal bit we don't know which side it's for.
+	if page[2] != 0xFF && page[2]&0x80 != 0 && power > 0 {
+		d.emit(metrics.Metric{
+			Kind:  metrics.PedalPowerBalance,
+			Value: 100 - float64(page[2]&0x7F),
+		})
+	}
+}
+
+// uint8   page_number              0x13
+// uint8   update_event_count       unitless
+// uint8   left_torque_eff          percent with resolution 1/2
+// uint8   right_torque_eff         percent with resolution 1/2
+// uint8   left_smoothness          percent with resolution 1/2
+// uint8   right_smoothness         percent with resolution 1/2
+// uint16  reserved
+//
+// Any of these may be 0xFF if not available, and right_smoothness is 0xFE
+// if the power meter only gives combined smoothness, in left_smoothness.
+func (d *powerDecoder) decodePedaling(page []byte) {
+	emit := func(kind metrics.Kind, value byte) {
+		if value == 0xFF {
+			return
+		}
+
+		d.emit(metrics.Metric{
+			Kind:  kind,
+			Value: float64(value) / 2,
+		})
+	}
+
+	emit(metrics.TorqueEffectivenessLeft, page[2])
+	emit(metrics.TorqueEffectivenessRight, page[3])
+
+	if page[5] == 0xFE {
+		emit(metrics.PedalSmoothness, page[4])
+	} else {
+		emit(metrics.PedalSmoothnessLeft, page[4])
+		emit(metrics.PedalSmoothnessRight, page[5])
+	}
+}
+
+// LEV data pages we understand.
+const (
+	levPageSystem1 = 0x01
+	levPageSystem2 = 0x03
+)
+
+// uint8   page_number              0x01
+// uint8   temperature_state        unused
+// uint8   travel_mode_state        assist level in bits 3-5, 0 for off
+// uint8   system_state             unused
+// uint8   gear_state               unused
+// uint8   error_message            unused
+// uint12  speed                    km/h with resolution 1/10
+//
+// Page 0x03 has the same layout, except for these two:
+//
+// uint8   battery_soc              percent in bits 0-6, bit 7 set when empty
+// uint8   percent_assist           percent
+//
+// The motor's power isn't broadcast, only how much it's assisting.
+func decodeLEV(page []byte, emit func(metrics.Metric)) {
+	if page[0] != levPageSystem1 && page[0] != levPageSystem2 {
+		return
+	}
+
+	emit(metrics.Metric{
+		Kind:  metrics.AssistLevel,
+		Value: float64(page[2] >> 3 & 0x07),
+	})
+	emit(metrics.Metric{
+		Kind:  metrics.CyclingSpeed,
+		Value: float64(binary.LittleEndian.Uint16(page[6:])&0x0FFF) / 10,
+	})
+
+	if page[0] != levPageSystem2 {
+		return
+	}
+
+	emit(metrics.Metric{
+		Kind:  metrics.BatteryLevel,
+		Value: float64(page[1] & 0x7F),
+	})
+	emit(metrics.Metric{
+		Kind:  metrics.MotorAssist,
+		Value: float64(page[5]),
+	})
+}
+
+// Shifting data page we understand.
+const shiftingPageStatus = 0x01
+
+// uint8   page_number              0x01
+// uint8   event_count              unused
+// uint8   reserved
+// uint8   current_gear             rear in bits 0-4, front in bits 5-7
+// uint8   total_gears              same layout, unused
+// uint8   invalid_shift_counts     unused
+// uint8   failed_shift_counts      unused
+// uint8   reserved
+//
+// Gears count from 0, with all bits set if the system doesn't have that
+// derailleur (e.g. a 1x drivetrain).
+func decodeShifting(page []byte, emit func(metrics.Metric)) {
+	if page[0] != shiftingPageStatus {
+		return
+	}
+
+	if rear := page[3] & 0x1F; rear != 0x1F {
+		emit(metrics.Metric{
+			Kind:  metrics.RearGear,
+			Value: float64(rear) + 1,
+		})
+	}
+	if front := page[3] >> 5; front != 0x07 {
+		emit(metrics.Metric{
+			Kind:  metrics.FrontGear,
+			Value: float64(front) + 1,
+		})
+	}
+}
+
+// FE-C data pages we understand.
+const (
+	fecPageGeneral = 0x10
+	fecPageTrainer = 0x19
+)
+
+// uint8   page_number              0x10
+// uint8   equipment_type           unused
+// uint8   elapsed_time             unused
+// uint8   distance                 unused
+// uint16  speed                    meters per second with resolution 1/1000
+// uint8   heart_rate               unused
+// uint8   capabilities_and_state   unused
+//
+// uint8   page_number              0x19
+// uint8   event_count              unused
+// uint8   cadence                  RPM, 0xFF if unknown
+// uint16  accumulated_power        unused
+// uint12  power                    watts, 0xFFF if unknown
+// uint4   trainer_status           unused
+// uint8   flags_and_state          unused
+func decodeFitnessEquipment(page []byte, emit func(metrics.Metric)) {
+	switch page[0] {
+	case fecPageGeneral:
+		if speed := binary.LittleEndian.Uint16(page[4:]); speed != 0xFFFF {
+			emit(metrics.Metric{
+				Kind:  metrics.CyclingSpeed,
+				Value: float64(speed) / 1000 * 3.6,
+			})
+		}
+
+	case fecPageTrainer:
+		if page[2] != 0xFF {
+			emit(metrics.Metric{
+				Kind:  metrics.CyclingCadence,
+				Value: float64(page[2]),
+			})
+		}
+		if power := binary.LittleEndian.Uint16(page[5:]) & 0x0FFF; power != 0x0FFF {
+			emit(metrics.Metric{
+				Kind:  metrics.CyclingPower,
+				Value: float64(power),
+			})
+		}
+	}
+}
+
+type speedCadenceDecoder struct {
+	emit func(metrics.Metric)
+
+	wheel gatt.RevolutionCounter
+	crank gatt.RevolutionCounter
+
+	// In meters
+	wheelCircumference float64
+	distance           float64
+}
+
+// There's only the one page, without a page number.
+//
+// uint16  cadence_event_time       seconds with resolution 1/1024
+// uint16  cumulative_cadence_revs  unitless
+// uint16  speed_event_time         seconds with resolution 1/1024
+// uint16  cumulative_speed_revs    unitless
+func (d *speedCadenceDecoder) decode(page []byte) {
+	crankTime := binary.LittleEndian.Uint16(page[0:])
+	crankRevs := binary.LittleEndian.Uint16(page[2:])
+	wheelTime := binary.LittleEndian.Uint16(page[4:])
+	wheelRevs := binary.LittleEndian.Uint16(page[6:])
+
+	if revs, ticks, ok := d.crank.Update(uint32(crankRevs), crankTime); ok {
+		d.emit(metrics.Metric{
+			Kind:  metrics.CyclingCadence,
+			Value: float64(revs) / (float64(ticks) / 1024) * 60,
+		})
+	}
+
+	if revs, ticks, ok := d.wheel.Update(uint32(wheelRevs), wheelTime); ok {
+		meters := float64(revs) * d.wheelCircumference
+		d.distance += meters
+
+		d.emit(metrics.Metric{
+			Kind:  metrics.CyclingSpeed,
+			Value: meters / (float64(ticks) / 1024) * 3.6,
+		})
+		d.emit(metrics.Metric{
+			Kind:  metrics.CyclingDistance,
+			Value: d.distance,
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package ant
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Mask for the baud rate bits, which syscall doesn't define.
+const cbaud = 0010017
+
+// configureSerial puts the port into raw mode, so that nothing gets
+// translated or echoed on the way through.
+func configureSerial(f *os.File) error {
+	var t syscall.Termios
+	if err := ioctl(f, syscall.TCGETS, &t); err != nil {
+		return err
+	}
+
+	t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	t.Oflag &^= syscall.OPOST
+	t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	t.Cflag &^= syscall.CSIZE | syscall.PARENB | cbaud
+	t.Cflag |= syscall.CS8 | syscall.CLOCAL | syscall.CREAD | syscall.B115200
+	t.Ispeed = syscall.B115200
+	t.Ospeed = syscall.B115200
+
+	// Block until at least one byte is available
+	t.Cc[syscall.VMIN] = 1
+	t.Cc[syscall.VTIME] = 0
+
+	return ioctl(f, syscall.TCSETS, &t)
+}
+
+func ioctl(f *os.File, req uint, t *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), uintptr(req), uintptr(unsafe.Pointer(t)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package ant
+
+import (
+	"os"
+)
+
+// Elsewhere we rely on the port already being in raw mode, e.g. with
+// `stty -f /dev/cu.usbserial-XXXX raw 115200`.
+func configureSerial(f *os.File) error {
+	return nil
+}
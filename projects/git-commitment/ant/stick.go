@@ -0,0 +1,269 @@
+package ant
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Sticks have (at least) this many channels, one per sensor.
+const maxChannels = 8
+
+// How long to wait for the stick to respond to a command.
+const commandTimeout = 1 * time.Second
+
+// Stick is an ANT USB stick, receiving from any number of sensors.
+type Stick struct {
+	// Guards writes, channels (including their decoders), transmitters
+	// and pending
+	mu sync.Mutex
+	rw io.ReadWriteCloser
+
+	// By channel number
+	channels     map[byte]*Channel
+	transmitters []*Transmitter
+	// Channel numbers are handed out in order
+	used byte
+	// Response to the command currently in flight, if any.
+	pending chan channelResponse
+}
+
+// OpenStick opens the stick's serial device, e.g. /dev/ttyUSB0, and gets
+// it ready to open channels.
+func OpenStick(path string) (*Stick, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := configureSerial(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	stick := &Stick{rw: f, channels: map[byte]*Channel{}}
+	go stick.readLoop()
+
+	// Start from a clean slate, in case something else left channels
+	// open. There's no response besides a startup message, so just give
+	// it a moment.
+	if err := stick.write(msgResetSystem, 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	time.Sleep(500 * time.Millisecond)
+
+	key := append([]byte{network}, networkKey...)
+	if err := stick.command(msgSetNetworkKey, key...); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to set network key: %w", err)
+	}
+
+	return stick, nil
+}
+
+// Channel sets aside a channel for the sensor at addr. Nothing is received
+// until it's opened.
+func (s *Stick) Channel(addr Address) (*Channel, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	num, err := s.reserve()
+	if err != nil {
+		return nil, err
+	}
+
+	ch := newChannel(s, num, addr)
+	s.channels[num] = ch
+
+	return ch, nil
+}
+
+// Transmitter sets aside a channel for broadcasting as the sensor at addr,
+// which needs a device number. Nothing is sent until it's opened.
+func (s *Stick) Transmitter(addr Address) (*Transmitter, error) {
+	if addr.Device == 0 {
+		return nil, fmt.Errorf("can't transmit without a device number: %s", addr)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	num, err := s.reserve()
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Transmitter{stick: s, number: num, addr: addr}
+	s.transmitters = append(s.transmitters, t)
+
+	return t, nil
+}
+
+// reserve hands out the next free channel number. Must hold mu.
+func (s *Stick) reserve() (byte, error) {
+	if s.used >= maxChannels {
+		return 0, fmt.Errorf("no free channels, at most %d devices", maxChannels)
+	}
+
+	s.used++
+	return s.used - 1, nil
+}
+
+// Channel types
+const (
+	channelReceive  = 0x00
+	channelTransmit = 0x10
+)
+
+// open configures a channel and opens it. Receive channels start searching
+// for their sensor, transmit channels start broadcasting.
+func (s *Stick) open(number, channelType byte, addr Address, transmissionType byte) error {
+	type command struct {
+		id   byte
+		data []byte
+	}
+
+	period := addr.Type.period()
+	commands := []command{
+		{msgAssignChannel, []byte{number, channelType, network}},
+		{msgChannelID, []byte{number, byte(addr.Device), byte(addr.Device >> 8), byte(addr.Type), transmissionType}},
+		{msgChannelPeriod, []byte{number, byte(period), byte(period >> 8)}},
+		{msgChannelRFFreq, []byte{number, rfFreq}},
+	}
+	if channelType == channelReceive {
+		// Keep searching forever
+		commands = append(commands, command{msgSearchTimeout, []byte{number, 0xFF}})
+	}
+	commands = append(commands, command{msgOpenChannel, []byte{number}})
+
+	for _, cmd := range commands {
+		if err := s.command(cmd.id, cmd.data...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close closes every channel and the stick itself.
+func (s *Stick) Close() error {
+	s.mu.Lock()
+	channels := s.channels
+	transmitters := s.transmitters
+	s.mu.Unlock()
+
+	for _, ch := range channels {
+		ch.Close()
+		s.write(msgCloseChannel, ch.number)
+	}
+	for _, t := range transmitters {
+		t.Close()
+		s.write(msgCloseChannel, t.number)
+	}
+
+	return s.rw.Close()
+}
+
+func (s *Stick) write(id byte, data ...byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.rw.Write(encodeMessage(id, data...))
+	return err
+}
+
+// command sends a channel command and waits for the stick to respond to
+// it. Commands are sent one at a time.
+func (s *Stick) command(id byte, data ...byte) error {
+	s.mu.Lock()
+	response := make(chan channelResponse, 1)
+	s.pending = response
+	_, err := s.rw.Write(encodeMessage(id, data...))
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.pending = nil
+		s.mu.Unlock()
+	}()
+
+	if err != nil {
+		return err
+	}
+
+	select {
+	case res := <-response:
+		if res.msg != id {
+			return fmt.Errorf("unexpected response to 0x%02X: %w", id, res)
+		}
+		if res.code != responseNoError {
+			return res
+		}
+		return nil
+
+	case <-time.After(commandTimeout):
+		return fmt.Errorf("timed out waiting for response to 0x%02X", id)
+	}
+}
+
+// readLoop dispatches everything the stick sends until it's closed.
+func (s *Stick) readLoop() {
+	r := bufio.NewReader(s.rw)
+
+	for {
+		id, data, err := readMessage(r)
+		if err == errBadChecksum {
+			slog.Debug("ant: dropping message with bad checksum")
+			continue
+		} else if err != nil {
+			return
+		}
+
+		switch id {
+		case msgChannelEvent:
+			if len(data) < 3 {
+				continue
+			}
+
+			// 0x01 means an event on the channel rather than a response
+			// to a command, e.g. going back to searching. Nothing we
+			// need to act on.
+			if data[1] == 0x01 {
+				continue
+			}
+
+			s.mu.Lock()
+			if s.pending != nil {
+				s.pending <- channelResponse{channel: data[0], msg: data[1], code: data[2]}
+				s.pending = nil
+			}
+			s.mu.Unlock()
+
+		case msgBroadcastData, msgAcknowledgeData:
+			// Channel number then an 8 byte page, possibly followed
+			// by extended data we don't ask for.
+			if len(data) < 9 {
+				continue
+			}
+
+			s.mu.Lock()
+			var ch *Channel
+			if c, ok := s.channels[data[0]]; ok && c.decode != nil {
+				ch = c
+			}
+			s.mu.Unlock()
+
+			if ch != nil {
+				ch.receive(data[1:9])
+			}
+
+		case msgStartup:
+			// Expected after a reset
+		}
+	}
+}
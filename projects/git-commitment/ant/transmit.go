@@ -0,0 +1,141 @@
+package ant
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/erik/git-commitment/gatt"
+)
+
+// Transmission type for the sensors we pretend to be: independent channel
+// with a 2 byte device number.
+const transmissionType = 0x05
+
+// DefaultBridgeDevice is the device number we broadcast as unless told
+// otherwise ("GC").
+const DefaultBridgeDevice = 0x4743
+
+// Transmitter broadcasts as a sensor, for head units which only speak ANT+.
+// The stick keeps repeating the last page sent, so there's no need to send
+// on every channel period.
+type Transmitter struct {
+	stick  *Stick
+	number byte
+	addr   Address
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// Open starts broadcasting. Send a page first, otherwise receivers see
+// zeros until the first one.
+func (t *Transmitter) Open() error {
+	return t.stick.open(t.number, channelTransmit, t.addr, transmissionType)
+}
+
+// Address is what we're broadcasting as.
+func (t *Transmitter) Address() Address {
+	return t.addr
+}
+
+// Send sets the page to broadcast. Does nothing once closed.
+func (t *Transmitter) Send(page []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return nil
+	}
+
+	return t.stick.write(msgBroadcastData, append([]byte{t.number}, page...)...)
+}
+
+// Close stops sending pages. Safe to call more than once.
+func (t *Transmitter) Close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.closed = true
+}
+
+// HeartRateEncoder makes up heart rate pages from readings, in the format
+// decodeHeartRate expects.
+type HeartRateEncoder struct {
+	beats gatt.CrankCounter
+	pages int
+}
+
+// Page 0, with the toggle bit flipped every 4 pages as the profile asks.
+func (e *HeartRateEncoder) Encode(bpm float64, now time.Time) []byte {
+	// Same idea as crank revolutions, just with heart beats.
+	beats := e.beats.Update(bpm, now)
+
+	var page byte
+	if (e.pages/4)%2 == 1 {
+		page |= 0x80
+	}
+	e.pages++
+
+	buf := []byte{page, 0xFF, 0xFF, 0xFF, 0, 0, byte(beats.Revs), clampByte(bpm)}
+	binary.LittleEndian.PutUint16(buf[4:], beats.EventTime)
+	return buf
+}
+
+// PowerEncoder makes up standard power-only pages from readings, in the
+// format powerDecoder expects.
+type PowerEncoder struct {
+	eventCount  byte
+	accumulated uint16
+}
+
+// Encode builds the next page. Every call counts as a new power event.
+// Cadence is left out if it's negative.
+func (e *PowerEncoder) Encode(watts, cadence float64) []byte {
+	power := uint16(math.Round(math.Max(0, math.Min(watts, math.MaxUint16))))
+
+	e.eventCount++
+	e.accumulated += power
+
+	cadenceByte := byte(0xFF)
+	if cadence >= 0 {
+		cadenceByte = clampByte(math.Min(cadence, 254))
+	}
+
+	buf := []byte{powerPageStandard, e.eventCount, 0xFF, cadenceByte, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint16(buf[4:], e.accumulated)
+	binary.LittleEndian.PutUint16(buf[6:], power)
+	return buf
+}
+
+// SpeedCadenceEncoder makes up speed and cadence pages from readings, in
+// the format speedCadenceDecoder expects.
+type SpeedCadenceEncoder struct {
+	wheel, crank gatt.CrankCounter
+
+	// In meters
+	WheelCircumference float64
+}
+
+// Encode builds the next page from speed (km/h) and cadence (RPM).
+func (e *SpeedCadenceEncoder) Encode(speed, cadence float64, now time.Time) []byte {
+	crank := e.crank.Update(cadence, now)
+
+	wheelRPM := 0.0
+	if e.WheelCircumference > 0 {
+		wheelRPM = speed / 3.6 / e.WheelCircumference * 60
+	}
+	wheel := e.wheel.Update(wheelRPM, now)
+
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint16(buf[0:], crank.EventTime)
+	binary.LittleEndian.PutUint16(buf[2:], crank.Revs)
+	binary.LittleEndian.PutUint16(buf[4:], wheel.EventTime)
+	binary.LittleEndian.PutUint16(buf[6:], wheel.Revs)
+	return buf
+}
+
+func clampByte(v float64) byte {
+	return byte(math.Round(math.Max(0, math.Min(v, math.MaxUint8))))
+}
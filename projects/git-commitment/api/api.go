@@ -0,0 +1,5 @@
+// Package api is the gRPC API described in telemetry.proto. Everything
+// else in here is generated from it, so run go generate after changing it.
+package api
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative telemetry.proto
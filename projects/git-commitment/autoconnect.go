@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/erik/git-commitment/gatt"
+	"tinygo.org/x/bluetooth"
+)
+
+// How long to scan for in -auto mode before picking devices.
+const DefaultAutoScanDuration = 10 * time.Second
+
+// autoDiscover scans for devices advertising each of the given services,
+// and returns the addresses of the ones to connect to, at most one per
+// service. Devices which advertise several services (e.g. a trainer with
+// both power and FTMS) are only returned once.
+//
+// If strongest is set, scans for the full duration and picks the device with
+// the best RSSI for each service, otherwise takes the first one we see and
+// stops early once every service is covered.
+func autoDiscover(
+	ctx context.Context,
+	adapter *bluetooth.Adapter,
+	services []bluetooth.UUID,
+	duration time.Duration,
+	strongest bool,
+) ([]string, error) {
+	type candidate struct {
+		addr string
+		rssi int16
+	}
+
+	mu := sync.Mutex{}
+	picked := map[bluetooth.UUID]candidate{}
+
+	onScanResult := func(bt *bluetooth.Adapter, result bluetooth.ScanResult) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		for _, svc := range services {
+			if !result.HasServiceUUID(svc) {
+				continue
+			}
+
+			prev, ok := picked[svc]
+			if ok && (!strongest || prev.rssi >= result.RSSI) {
+				continue
+			}
+
+			picked[svc] = candidate{result.Address.String(), result.RSSI}
+		}
+
+		if !strongest && len(picked) == len(services) {
+			bt.StopScan()
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	go func() {
+		<-ctx.Done()
+		adapter.StopScan()
+	}()
+
+	slog.Info("scanning for devices", "duration", duration)
+	if err := adapter.Scan(onScanResult); err != nil {
+		return nil, err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	addrs := []string{}
+	seen := map[string]bool{}
+
+	for _, svc := range services {
+		c, ok := picked[svc]
+		if !ok {
+			continue
+		}
+
+		slog.Info("picked device", "service", gatt.KnownServiceNames[svc], "device", config.DeviceName(c.addr), "rssi", c.rssi)
+
+		if !seen[c.addr] {
+			seen[c.addr] = true
+			addrs = append(addrs, c.addr)
+		}
+	}
+
+	return addrs, nil
+}
@@ -0,0 +1,28 @@
+package ble
+
+import (
+	"tinygo.org/x/bluetooth"
+)
+
+// ParseAddress parses a device address in this platform's format.
+//
+// CoreBluetooth doesn't expose MAC addresses, instead every device gets a
+// UUID which is stable on this machine (but differs between machines).
+func ParseAddress(addr string) (bluetooth.Address, error) {
+	uuid, err := bluetooth.ParseUUID(addr)
+	if err != nil {
+		return bluetooth.Address{}, err
+	}
+
+	return bluetooth.Address{UUID: uuid}, nil
+}
+
+// PlatformAddress picks which of a device's known addresses to use on this
+// platform. Here that's the CoreBluetooth UUID, falling back to the address
+// in case that's a UUID already.
+func PlatformAddress(address, uuid string) string {
+	if uuid != "" {
+		return uuid
+	}
+	return address
+}
@@ -0,0 +1,27 @@
+//go:build !darwin
+// +build !darwin
+
+package ble
+
+import (
+	"tinygo.org/x/bluetooth"
+)
+
+// ParseAddress parses a device address in this platform's format.
+//
+// Everywhere other than macOS identifies devices by MAC address, e.g.
+// "F1:2C:7A:91:0B:3E".
+func ParseAddress(addr string) (bluetooth.Address, error) {
+	mac, err := bluetooth.ParseMAC(addr)
+	if err != nil {
+		return bluetooth.Address{}, err
+	}
+
+	return bluetooth.Address{MACAddress: bluetooth.MACAddress{MAC: mac}}, nil
+}
+
+// PlatformAddress picks which of a device's known addresses to use on this
+// platform, which is always the MAC address.
+func PlatformAddress(address, uuid string) string {
+	return address
+}
@@ -0,0 +1,55 @@
+package ble
+
+import (
+	"context"
+	"time"
+
+	"tinygo.org/x/bluetooth"
+)
+
+// BatteryPollInterval is how often battery levels are read. They don't
+// change quickly, and not every device notifies.
+const BatteryPollInterval = 5 * time.Minute
+
+// FindBatteryLevel returns the device's Battery Level characteristic, or
+// nil if it doesn't have the Battery Service.
+func FindBatteryLevel(device *bluetooth.Device) (*bluetooth.DeviceCharacteristic, error) {
+	services, err := device.DiscoverServices([]bluetooth.UUID{
+		bluetooth.ServiceUUIDBattery,
+	})
+	if err != nil || len(services) == 0 {
+		return nil, err
+	}
+
+	chars, err := services[0].DiscoverCharacteristics([]bluetooth.UUID{
+		bluetooth.CharacteristicUUIDBatteryLevel,
+	})
+	if err != nil || len(chars) == 0 {
+		return nil, err
+	}
+
+	return &chars[0], nil
+}
+
+// PollBatteryLevel reads the battery level (in percent) straight away and
+// then every BatteryPollInterval, passing it to emit. Stops once ctx is
+// done or a read fails, which usually means the device went away.
+func PollBatteryLevel(ctx context.Context, char *bluetooth.DeviceCharacteristic, emit func(level float64)) {
+	ticker := time.NewTicker(BatteryPollInterval)
+	defer ticker.Stop()
+
+	for ctx.Err() == nil {
+		buf := make([]byte, 1)
+		n, err := char.Read(buf)
+		if err != nil || n < 1 {
+			return
+		}
+		emit(float64(buf[0]))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
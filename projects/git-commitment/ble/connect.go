@@ -0,0 +1,130 @@
+package ble
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"tinygo.org/x/bluetooth"
+)
+
+// Defaults for how long to wait for a device to connect, and how many
+// times to try before giving up on it (0 means keep trying forever).
+const (
+	DefaultConnectTimeout = 30 * time.Second
+	DefaultConnectRetries = 5
+)
+
+// ErrConnectTimeout is returned when a device doesn't connect in time.
+var ErrConnectTimeout = errors.New("timed out connecting")
+
+// ConnectWithTimeout is adapter.Connect with a time limit. Not every
+// platform respects ConnectionTimeout (neither macOS nor Linux do), so we
+// enforce it ourselves. If the connection does eventually succeed after
+// we've given up, it's disconnected again.
+func ConnectWithTimeout(
+	adapter *bluetooth.Adapter,
+	addr bluetooth.Addresser,
+	timeout time.Duration,
+) (*bluetooth.Device, error) {
+	type result struct {
+		device *bluetooth.Device
+		err    error
+	}
+
+	params := bluetooth.ConnectionParams{
+		ConnectionTimeout: bluetooth.NewDuration(timeout),
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		device, err := adapter.Connect(addr, params)
+		done <- result{device, err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.device, res.err
+
+	case <-time.After(timeout):
+		go func() {
+			if res := <-done; res.err == nil {
+				res.device.Disconnect()
+			}
+		}()
+
+		return nil, ErrConnectTimeout
+	}
+}
+
+// How long a device can go without sending a notification before we assume
+// the connection has dropped. Sensors typically notify about once a second.
+const DefaultDeviceTimeout = 10 * time.Second
+
+// Bounds on how long to wait between connection attempts.
+const (
+	minReconnectBackoff = 1 * time.Second
+	maxReconnectBackoff = 1 * time.Minute
+)
+
+// ReconnectBackoff is how long to wait before the given (0-based) connection
+// attempt, doubling each time up to maxReconnectBackoff.
+func ReconnectBackoff(attempt int) time.Duration {
+	if attempt == 0 {
+		return 0
+	}
+
+	backoff := minReconnectBackoff
+	for i := 1; i < attempt && backoff < maxReconnectBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > maxReconnectBackoff {
+		backoff = maxReconnectBackoff
+	}
+	return backoff
+}
+
+// WatchConnection blocks until none of the device's sources have received a
+// notification within timeout, then disconnects the device so it can be
+// connected to again from scratch. Returns false without disconnecting if
+// the context is cancelled first.
+//
+// The bluetooth package doesn't tell us when a connection we made drops
+// (the connect handler is only ever called for new connections), so going
+// quiet is the only signal we get.
+func WatchConnection(
+	ctx context.Context,
+	device *bluetooth.Device,
+	sources []*Source,
+	timeout time.Duration,
+) bool {
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+
+		var lastSeen time.Time
+		for _, src := range sources {
+			if seen := src.LastSeen(); seen.After(lastSeen) {
+				lastSeen = seen
+			}
+		}
+
+		if time.Since(lastSeen) > timeout {
+			break
+		}
+	}
+
+	if err := device.Disconnect(); err != nil {
+		slog.Debug("failed to disconnect stale device", "err", err)
+	}
+
+	return true
+}
@@ -0,0 +1,60 @@
+package ble
+
+import (
+	"strings"
+
+	"github.com/erik/git-commitment/metrics"
+	"tinygo.org/x/bluetooth"
+)
+
+var deviceInfoCharacteristicUUIDs = []bluetooth.UUID{
+	bluetooth.CharacteristicUUIDManufacturerNameString,
+	bluetooth.CharacteristicUUIDModelNumberString,
+	bluetooth.CharacteristicUUIDFirmwareRevisionString,
+	bluetooth.CharacteristicUUIDSerialNumberString,
+}
+
+// ReadDeviceInfo reads whatever the device reports about itself through the
+// Device Information Service.
+func ReadDeviceInfo(device *bluetooth.Device) (metrics.DeviceInfo, error) {
+	info := metrics.DeviceInfo{}
+
+	services, err := device.DiscoverServices([]bluetooth.UUID{
+		bluetooth.ServiceUUIDDeviceInformation,
+	})
+	if err != nil || len(services) == 0 {
+		return info, err
+	}
+
+	chars, err := services[0].DiscoverCharacteristics(deviceInfoCharacteristicUUIDs)
+	if err != nil {
+		return info, err
+	}
+
+	for _, char := range chars {
+		buf := make([]byte, 64)
+		n, err := char.Read(buf)
+		if err != nil {
+			return info, err
+		}
+		if n > len(buf) {
+			n = len(buf)
+		}
+
+		// Some devices pad these out with NUL bytes.
+		value := strings.TrimRight(string(buf[:n]), "\x00 ")
+
+		switch char.UUID() {
+		case bluetooth.CharacteristicUUIDManufacturerNameString:
+			info.Manufacturer = value
+		case bluetooth.CharacteristicUUIDModelNumberString:
+			info.Model = value
+		case bluetooth.CharacteristicUUIDFirmwareRevisionString:
+			info.Firmware = value
+		case bluetooth.CharacteristicUUIDSerialNumberString:
+			info.Serial = value
+		}
+	}
+
+	return info, nil
+}
@@ -0,0 +1,6 @@
+package ble
+
+// The bluetooth package can't turn notifications off through BlueZ, and
+// enabling them a second time registers a second callback, so once they're
+// on they stay on for as long as we're connected. See Source.RemoveSink.
+const canDisableNotifications = false
@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package ble
+
+// Elsewhere, enabling notifications with a nil callback turns them off.
+// Versions of the bluetooth package which can't return an error instead,
+// and we fall back to dropping them. See Source.RemoveSink.
+const canDisableNotifications = true
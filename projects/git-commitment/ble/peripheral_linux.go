@@ -0,0 +1,84 @@
+package ble
+
+import (
+	"sync"
+
+	"tinygo.org/x/bluetooth"
+)
+
+// DefaultPeripheralName is what we advertise ourselves as, unless told
+// otherwise.
+const DefaultPeripheralName = "git-commitment"
+
+// Peripheral exposes services of our own for other devices (a watch, an app
+// on another machine) to connect to. Everything shares one advertisement,
+// since BlueZ only lets each adapter configure it once.
+type Peripheral struct {
+	adapter *bluetooth.Adapter
+	name    string
+
+	// Guards uuids and advertising
+	mu          sync.Mutex
+	uuids       []bluetooth.UUID
+	advertising bool
+}
+
+// NewPeripheral enables the adapter to host services advertised under name.
+// Nothing is advertised until Advertise is called.
+func NewPeripheral(adapter *bluetooth.Adapter, name string) (*Peripheral, error) {
+	if err := adapter.Enable(); err != nil {
+		return nil, err
+	}
+
+	return &Peripheral{adapter: adapter, name: name}, nil
+}
+
+// AddService makes the service available to anything which connects.
+// Characteristics are written through the handles set in the service's
+// config, which notifies subscribers.
+func (p *Peripheral) AddService(svc *bluetooth.Service) error {
+	if err := p.adapter.AddService(svc); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.uuids = append(p.uuids, svc.UUID)
+	return nil
+}
+
+// Advertise starts advertising every service added so far. Only the first
+// call does anything, services added afterwards are still available but
+// won't be listed in the advertisement.
+func (p *Peripheral) Advertise() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.advertising {
+		return nil
+	}
+
+	adv := p.adapter.DefaultAdvertisement()
+	err := adv.Configure(bluetooth.AdvertisementOptions{
+		LocalName:    p.name,
+		ServiceUUIDs: p.uuids,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := adv.Start(); err != nil {
+		return err
+	}
+
+	p.advertising = true
+	return nil
+}
+
+// Notify sets the value of one of our characteristics, notifying anything
+// subscribed to it.
+func (p *Peripheral) Notify(char *bluetooth.Characteristic, value []byte) error {
+	_, err := char.Write(value)
+	return err
+}
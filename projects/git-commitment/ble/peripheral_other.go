@@ -0,0 +1,38 @@
+//go:build !linux
+// +build !linux
+
+package ble
+
+import (
+	"errors"
+
+	"tinygo.org/x/bluetooth"
+)
+
+// DefaultPeripheralName is what we advertise ourselves as, unless told
+// otherwise.
+const DefaultPeripheralName = "git-commitment"
+
+// Acting as a peripheral is only implemented by the bluetooth package on
+// Linux (through BlueZ).
+var errPeripheralUnsupported = errors.New("acting as a BLE peripheral isn't supported on this platform")
+
+// Peripheral exposes services of our own for other devices to connect to.
+// Only supported on Linux.
+type Peripheral struct{}
+
+func NewPeripheral(adapter *bluetooth.Adapter, name string) (*Peripheral, error) {
+	return nil, errPeripheralUnsupported
+}
+
+func (p *Peripheral) AddService(svc *bluetooth.Service) error {
+	return errPeripheralUnsupported
+}
+
+func (p *Peripheral) Advertise() error {
+	return errPeripheralUnsupported
+}
+
+func (p *Peripheral) Notify(char *bluetooth.Characteristic, value []byte) error {
+	return errPeripheralUnsupported
+}
@@ -0,0 +1,298 @@
+// Package ble connects to Bluetooth LE sensors and streams their
+// measurements as metrics.
+package ble
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/erik/git-commitment/gatt"
+	"github.com/erik/git-commitment/metrics"
+	"tinygo.org/x/bluetooth"
+)
+
+// Warn about a source when more than this fraction of its notifications
+// fail to decode, over a window of decodeErrorWindow. Windows with too few
+// notifications to say are skipped.
+const (
+	DecodeErrorThreshold        = 0.05
+	decodeErrorWindow           = 1 * time.Minute
+	decodeErrorMinNotifications = 20
+)
+
+// Source decodes notifications from a single characteristic into metrics,
+// and sends them to each of its sinks. Sinks can be added and removed at any
+// time.
+type Source struct {
+	// Guards sinks, emitted, notifying, watching and closed.
+	mu    sync.Mutex
+	sinks []sourceSink
+	// Held while sending to the sinks, so Close can wait for that to
+	// finish.
+	sending sync.Mutex
+
+	// Unix nanoseconds of the last notification we received, used to
+	// notice when a device has gone away. Accessed atomically.
+	lastSeen int64
+	// Set while notifications are turned off for want of sinks, when
+	// there's nothing to hear.
+	paused atomic.Bool
+
+	// If set, report the source as stale after this long without data.
+	// Must be set before the first sink is added.
+	StaleTimeout time.Duration
+	done         chan struct{}
+
+	// Which kinds of metric we've sent, so we know what to zero out when
+	// the source goes stale.
+	emitted map[metrics.Kind]bool
+	// Set while notifications are enabled on the characteristic.
+	notifying bool
+	// Set once we've started watching for the source going stale.
+	watching bool
+	// Once closed, nothing more is sent to the sinks.
+	closed bool
+
+	// Attached to every metric we emit.
+	Address string
+	Alias   string
+	Info    metrics.DeviceInfo
+
+	Decoder *gatt.Decoder
+	// Decode stats as of the start of the current window, only touched
+	// from the notification callback.
+	errorWindowStart time.Time
+	errorWindowStats gatt.DecodeStats
+
+	svc *bluetooth.DeviceService
+	ch  *bluetooth.DeviceCharacteristic
+}
+
+type sourceSink struct {
+	ch chan metrics.Metric
+	// Closed by RemoveSink, so a send to a sink that's no longer being
+	// read doesn't block forever.
+	removed chan struct{}
+}
+
+// NewSource returns a source for the given characteristic, or an error if
+// we don't know how to decode it. decoder is the previous source's for the
+// same characteristic, when reconnecting, to carry on with its running
+// totals. Nil to start afresh.
+func NewSource(
+	svc *bluetooth.DeviceService,
+	ch *bluetooth.DeviceCharacteristic,
+	decoder *gatt.Decoder,
+) (*Source, error) {
+	src := &Source{
+		sinks:   []sourceSink{},
+		svc:     svc,
+		ch:      ch,
+		done:    make(chan struct{}),
+		emitted: map[metrics.Kind]bool{},
+	}
+
+	if decoder != nil {
+		decoder.Reuse(src.emit)
+		src.errorWindowStats = decoder.Stats()
+		src.Decoder = decoder
+		return src, nil
+	}
+
+	decoder, err := gatt.NewDecoder(ch.UUID(), src.emit)
+	if err != nil {
+		return nil, err
+	}
+	src.Decoder = decoder
+
+	return src, nil
+}
+
+func (src *Source) Name() string {
+	if name, ok := gatt.KnownCharacteristicNames[src.ch.UUID()]; ok {
+		return name
+	}
+	return fmt.Sprintf("<unknown: %s>", src.ch.UUID().String())
+}
+
+func (src *Source) AddSink(sink chan metrics.Metric) {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+
+	src.sinks = append(src.sinks, sourceSink{ch: sink, removed: make(chan struct{})})
+
+	// Start listenening first time we add a sink
+	if src.notifying {
+		return
+	}
+
+	atomic.StoreInt64(&src.lastSeen, time.Now().UnixNano())
+	src.errorWindowStart = time.Now()
+
+	err := src.ch.EnableNotifications(func(buf []byte) {
+		atomic.StoreInt64(&src.lastSeen, time.Now().UnixNano())
+
+		// Nobody's listening, don't bother parsing.
+		if !src.hasSinks() {
+			return
+		}
+
+		if err := src.Decoder.Decode(buf); err != nil {
+			slog.Debug("failed to decode notification", "device", src.deviceName(),
+				"source", src.Name(), "err", err, "data", fmt.Sprintf("% x", buf))
+		}
+		src.checkDecodeErrors()
+	})
+	if err != nil {
+		slog.Warn("failed to enable notifications", "err", err)
+		return
+	}
+	src.notifying = true
+	src.paused.Store(false)
+
+	if src.StaleTimeout > 0 && !src.watching {
+		src.watching = true
+		go src.watchStale()
+	}
+}
+
+// RemoveSink stops sending metrics to sink, including any send already
+// under way, so the sink doesn't need to be drained. Once the last sink is
+// gone, notifications are turned off until a sink is added again, so the
+// sensor can stop sending them.
+//
+// That isn't possible on Linux (see canDisableNotifications), or with
+// versions of the bluetooth package which don't support it, including
+// v0.3.0 on macOS, so there incoming notifications are dropped without
+// being parsed instead.
+func (src *Source) RemoveSink(sink chan metrics.Metric) {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+
+	for i, s := range src.sinks {
+		if s.ch == sink {
+			close(s.removed)
+			src.sinks = append(src.sinks[:i], src.sinks[i+1:]...)
+			break
+		}
+	}
+
+	if len(src.sinks) > 0 || !src.notifying || !canDisableNotifications {
+		return
+	}
+
+	if err := src.ch.EnableNotifications(nil); err != nil {
+		slog.Debug("failed to disable notifications, dropping them instead",
+			"device", src.deviceName(), "source", src.Name(), "err", err)
+		return
+	}
+	src.notifying = false
+	src.paused.Store(true)
+}
+
+func (src *Source) hasSinks() bool {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+
+	return len(src.sinks) > 0
+}
+
+// Close stops any background work for the source, once it's no longer in
+// use, and stops sending metrics to the sinks. Once it returns nothing more
+// will be sent, so the sinks can be closed. Safe to call more than once.
+func (src *Source) Close() {
+	src.mu.Lock()
+	if !src.closed {
+		src.closed = true
+		close(src.done)
+	}
+	src.mu.Unlock()
+
+	// Wait out anything being sent.
+	src.sending.Lock()
+	src.sending.Unlock()
+}
+
+// LastSeen is when we last received a notification (or started listening).
+// While notifications are turned off it's now, since we aren't expecting
+// to hear anything.
+func (src *Source) LastSeen() time.Time {
+	if src.paused.Load() {
+		return time.Now()
+	}
+	return time.Unix(0, atomic.LoadInt64(&src.lastSeen))
+}
+
+func (src *Source) emit(m metrics.Metric) {
+	if m.Timestamp.IsZero() {
+		m.Timestamp = time.Now()
+	}
+	m.Address = src.Address
+	m.Alias = src.Alias
+	m.Characteristic = src.ch.UUID()
+	m.Info = src.Info
+
+	src.sending.Lock()
+	defer src.sending.Unlock()
+
+	// Sent to the sinks as they were, without holding mu, so that sinks
+	// can be added and removed while a send is blocked.
+	src.mu.Lock()
+	if src.closed {
+		src.mu.Unlock()
+		return
+	}
+	src.emitted[m.Kind] = true
+	sinks := append([]sourceSink(nil), src.sinks...)
+	src.mu.Unlock()
+
+	for _, sink := range sinks {
+		select {
+		case sink.ch <- m:
+		case <-sink.removed:
+		case <-src.done:
+			return
+		}
+	}
+}
+
+// deviceName is how we refer to the source's device in log messages.
+func (src *Source) deviceName() string {
+	if src.Alias != "" {
+		return src.Alias
+	}
+	return src.Address
+}
+
+// DecodeStats is how many notifications we've had, and how many of them
+// couldn't be decoded.
+func (src *Source) DecodeStats() gatt.DecodeStats {
+	return src.Decoder.Stats()
+}
+
+// checkDecodeErrors warns once per window if too many notifications failed
+// to decode, which usually means a flaky connection or a sensor we don't
+// understand.
+func (src *Source) checkDecodeErrors() {
+	now := time.Now()
+	if now.Sub(src.errorWindowStart) < decodeErrorWindow {
+		return
+	}
+
+	stats := src.Decoder.Stats()
+	total := stats.Notifications - src.errorWindowStats.Notifications
+	errors := stats.Errors - src.errorWindowStats.Errors
+	src.errorWindowStart, src.errorWindowStats = now, stats
+
+	if total < decodeErrorMinNotifications {
+		return
+	}
+
+	if rate := float64(errors) / float64(total); rate > DecodeErrorThreshold {
+		slog.Warn("high decode error rate", "device", src.deviceName(), "source", src.Name(),
+			"errors", errors, "notifications", total, "window", decodeErrorWindow)
+	}
+}
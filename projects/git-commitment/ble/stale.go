@@ -0,0 +1,67 @@
+package ble
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/erik/git-commitment/metrics"
+)
+
+// How long a source can go without a notification before its data is
+// considered stale.
+const DefaultStaleTimeout = 5 * time.Second
+
+// Metrics which should drop to zero rather than holding on to their last
+// value when a source goes stale. Nobody's pedaling if the power meter
+// stops talking.
+var staleZeroMetrics = []metrics.Kind{
+	metrics.CyclingPower,
+	metrics.CyclingCadence,
+	metrics.RunningCadence,
+}
+
+// watchStale emits a metrics.SourceStale status whenever the source goes quiet
+// for longer than StaleTimeout (value 1) or starts talking again (value 0),
+// along with zeroes for any staleZeroMetrics we've been sending. Runs until
+// the source is closed.
+func (src *Source) watchStale() {
+	ticker := time.NewTicker(src.StaleTimeout / 2)
+	defer ticker.Stop()
+
+	stale := false
+
+	for {
+		select {
+		case <-src.done:
+			return
+		case <-ticker.C:
+		}
+
+		quiet := time.Since(src.LastSeen()) > src.StaleTimeout
+		if quiet == stale {
+			continue
+		}
+		stale = quiet
+
+		if !stale {
+			src.emit(metrics.Metric{Kind: metrics.SourceStale, Value: 0})
+			continue
+		}
+
+		slog.Warn("source went stale", "device", src.deviceName(), "source", src.Name())
+		src.emit(metrics.Metric{Kind: metrics.SourceStale, Value: 1})
+
+		for _, kind := range staleZeroMetrics {
+			if src.hasEmitted(kind) {
+				src.emit(metrics.Metric{Kind: kind, Value: 0})
+			}
+		}
+	}
+}
+
+func (src *Source) hasEmitted(kind metrics.Kind) bool {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+
+	return src.emitted[kind]
+}
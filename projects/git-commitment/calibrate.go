@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/erik/git-commitment/gatt"
+	"github.com/erik/git-commitment/metrics"
+	"github.com/erik/git-commitment/sinks"
+)
+
+// Spindown speed (km/h) for trainers which don't tell us theirs. Most ask
+// for somewhere in the mid 30s.
+const defaultSpindownSpeed = 35.0
+
+// Below this (km/h), the flywheel has stopped as far as we care.
+const coastStoppedSpeed = 2.0
+
+// How long to wait for the trainer's own result once the flywheel has
+// stopped, before going with our timing of the coast down.
+const calibrationResultTimeout = 15 * time.Second
+
+// runCalibration walks the rider through a spindown on the first trainer
+// to connect: getting the flywheel up to speed, coasting, and reading back
+// the result. Successful calibrations are stored against the trainer, if
+// there's a store. Speeds are the trainer's (or any) speed readings, for
+// telling when to stop pedaling, and are shown to the rider in units. done
+// is called once it's over, however it went.
+func runCalibration(
+	ctx context.Context,
+	trainers <-chan TrainerConnection,
+	speeds <-chan metrics.Metric,
+	store *sinks.Store,
+	units metrics.Units,
+	w io.Writer,
+	done func(),
+) {
+	defer done()
+
+	fmt.Fprintln(w, "Waiting for a trainer to connect...")
+
+	var conn TrainerConnection
+	select {
+	case <-ctx.Done():
+		return
+	case conn = <-trainers:
+	}
+
+	// Only the first trainer is calibrated. Any others, or this one
+	// reconnecting, are left alone rather than left waiting.
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-trainers:
+			}
+		}
+	}()
+
+	name := config.DeviceName(conn.address)
+	if !conn.trainer.Capabilities().Calibrate {
+		fmt.Fprintf(w, "%s doesn't support spindown calibration.\n", name)
+		return
+	}
+
+	if store != nil {
+		if last, err := store.LastCalibration(conn.address); err != nil {
+			slog.Warn("failed to read last calibration", "err", err)
+		} else if last.IsZero() {
+			fmt.Fprintf(w, "%s hasn't been calibrated before.\n", name)
+		} else {
+			days := int(time.Since(last).Hours() / 24)
+			fmt.Fprintf(w, "%s was last calibrated %s (%d days ago).\n", name, last.Local().Format("2006-01-02"), days)
+		}
+	}
+
+	// Dropping progress is better than holding up the trainer's
+	// notifications, the result comes last anyway.
+	events := make(chan gatt.CalibrationEvent, 8)
+	err := conn.trainer.Calibrate(func(event gatt.CalibrationEvent) {
+		select {
+		case events <- event:
+		default:
+		}
+	})
+	if err != nil {
+		fmt.Fprintf(w, "Failed to start spindown: %v\n", err)
+		return
+	}
+
+	const (
+		spinningUp = iota
+		coasting
+		stopped
+	)
+	state := spinningUp
+
+	target := defaultSpindownSpeed
+	fmt.Fprintf(w, "Pedal up to %.1f %s.\n", units.Speed(target), units.SpeedUnit())
+
+	speed := 0.0
+	var coastStart time.Time
+	var coastFrom float64
+	var coastTime time.Duration
+	var resultTimeout <-chan time.Time
+
+	startCoasting := func(now time.Time) {
+		state = coasting
+		coastStart, coastFrom = now, speed
+		fmt.Fprintln(w, "Stop pedaling and let the flywheel coast to a stop.")
+	}
+
+	finish := func(result string) {
+		fmt.Fprintf(w, "Calibration complete: %s\n", result)
+		if store == nil {
+			return
+		}
+
+		if err := store.AddCalibration(conn.address, time.Now(), result); err != nil {
+			slog.Warn("failed to store calibration", "err", err)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Fprintln(w, "Calibration cancelled.")
+			return
+
+		case event := <-events:
+			slog.Debug("calibration progress", "event", event)
+
+			if event.Done && event.Failed {
+				fmt.Fprintf(w, "Calibration failed: %s\n", event.Message)
+				return
+			} else if event.Done {
+				finish(event.Message)
+				return
+			}
+
+			if state != spinningUp {
+				continue
+			}
+			if event.TargetSpeed > 0 && event.TargetSpeed != target {
+				target = event.TargetSpeed
+				fmt.Fprintf(w, "Pedal up to %.1f %s.\n", units.Speed(target), units.SpeedUnit())
+			}
+			if event.StopPedaling {
+				startCoasting(time.Now())
+			}
+
+		case m := <-speeds:
+			speed = m.Value
+
+			switch {
+			case state == spinningUp && speed >= target:
+				startCoasting(m.Timestamp)
+
+			case state == coasting && speed < coastStoppedSpeed:
+				state = stopped
+				coastTime = m.Timestamp.Sub(coastStart)
+				fmt.Fprintf(w, "Coasted to a stop in %.1fs, waiting for the trainer's result...\n", coastTime.Seconds())
+				resultTimeout = time.After(calibrationResultTimeout)
+			}
+
+		case <-resultTimeout:
+			// Some trainers never say, e.g. the KICKR, but they've still
+			// calibrated.
+			finish(fmt.Sprintf("coasted down from %.1f %s in %.1fs, the trainer didn't report a result",
+				units.Speed(coastFrom), units.SpeedUnit(), coastTime.Seconds()))
+			return
+		}
+	}
+}
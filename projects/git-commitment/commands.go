@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/erik/git-commitment/ble"
+	"github.com/erik/git-commitment/metrics"
+	"github.com/erik/git-commitment/sim"
+	"github.com/erik/git-commitment/sinks"
+	"github.com/erik/git-commitment/upload"
+)
+
+// command is a subcommand, e.g. "git-commitment ride -tui".
+type command struct {
+	name string
+	// Positional arguments, for usage.
+	args  string
+	about string
+	// Registers any flags beyond -config.
+	flags func(fs *flag.FlagSet)
+	run   func(args []string)
+}
+
+var (
+	flagReplaySpeed float64
+	flagFTPUpdate   bool
+)
+
+var commands = []command{
+	{
+		name:  "scan",
+		about: "scan for nearby devices until interrupted",
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&flagScanFormat, "format", "text", "output format: text or json (one object per line)")
+		},
+		run: func(args []string) {
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			scanDevices(ctx, flagScanFormat)
+		},
+	},
+	{
+		name:  "ride",
+		about: "connect to sensors and record a session until interrupted",
+		flags: sessionFlags,
+		run: func(args []string) {
+			runSession(sessionMode{})
+		},
+	},
+	{
+		name:  "workout",
+		args:  "<file>",
+		about: "ride a structured workout (.json, .zwo, .erg or .mrc), controlling the trainer",
+		flags: sessionFlags,
+		run: func(args []string) {
+			if len(args) != 1 {
+				fatal("expected a workout file")
+			}
+
+			flagWorkoutFile = args[0]
+			runSession(sessionMode{})
+		},
+	},
+	{
+		name:  "calibrate",
+		about: "connect to the trainer and walk through a spindown calibration",
+		flags: sessionFlags,
+		run: func(args []string) {
+			mode := sessionMode{calibrate: true}
+			if flagStorePath != "" {
+				mode.calibrations = openStore()
+				defer mode.calibrations.Close()
+			}
+
+			// Only the calibration date is worth keeping, not the
+			// session.
+			flagStorePath = ""
+			runSession(mode)
+		},
+	},
+	{
+		name:  "replay",
+		args:  "<metric log>",
+		about: "feed a metric log written with -log-file back through the pipeline, as if riding again",
+		flags: func(fs *flag.FlagSet) {
+			sessionFlags(fs)
+			fs.Float64Var(&flagReplaySpeed, "speed", 1, "replay this many times faster than real time")
+		},
+		run: func(args []string) {
+			if len(args) != 1 {
+				fatal("expected a metric log")
+			}
+			if flagReplaySpeed <= 0 {
+				fatal("-speed must be positive")
+			}
+
+			ms, err := sinks.ReadMetricLog(args[0])
+			if err != nil {
+				fatal("failed to read metric log", "err", err)
+			}
+
+			replay := sim.NewReplay(ms, flagReplaySpeed)
+			slog.Info("replaying metric log", "path", args[0], "duration", replay.Duration().Round(time.Second))
+
+			// It's already been stored once.
+			flagStorePath = ""
+			runSession(sessionMode{source: replay})
+		},
+	},
+	{
+		name:  "devices",
+		about: "list the devices and profiles in the config file",
+		run: func(args []string) {
+			listDevices(config)
+		},
+	},
+	{
+		name:  "weigh",
+		args:  "<device>",
+		about: "read a measurement from a BLE weight scale and store it",
+		flags: func(fs *flag.FlagSet) {
+			storeFlags(fs)
+			unitsFlag(fs)
+			fs.DurationVar(&flagWeighTimeout, "timeout", 2*time.Minute, "how long to wait for someone to step on the scale")
+			fs.StringVar(&flagIntervalsKey, "intervals-api-key", "", "also send weight and body fat to intervals.icu, using this API key")
+			fs.StringVar(&flagIntervalsAthlete, "intervals-athlete", upload.IntervalsDefaultAthlete, "intervals.icu athlete id to send to, 0 for the API key's own")
+		},
+		run: func(args []string) {
+			if len(args) != 1 {
+				fatal("expected a scale address or alias")
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			ctx, cancel := context.WithTimeout(ctx, flagWeighTimeout)
+			defer cancel()
+
+			var store *sinks.Store
+			if flagStorePath != "" {
+				store = openStore()
+				defer store.Close()
+			}
+
+			if err := weigh(ctx, args[0], store, os.Stdout); err != nil {
+				fatal("failed to weigh in", "err", err)
+			}
+		},
+	},
+	{
+		name:  "sessions",
+		about: "list stored sessions, or browse them in a web browser",
+		flags: func(fs *flag.FlagSet) {
+			storeFlags(fs)
+			zoneFlags(fs)
+			unitsFlag(fs)
+			fs.StringVar(&flagHTTPAddr, "http", "", "serve a page for browsing sessions at /sessions on this address instead, e.g. :8080")
+		},
+		run: func(args []string) {
+			store := openStore()
+			defer store.Close()
+
+			if flagHTTPAddr != "" {
+				if err := serveSessions(store, flagHTTPAddr); err != nil {
+					fatal("failed to serve sessions", "err", err)
+				}
+				return
+			}
+
+			if err := listSessions(store, os.Stdout); err != nil {
+				fatal("failed to list sessions", "err", err)
+			}
+		},
+	},
+	{
+		name:  "bests",
+		about: "list the best power for each duration across stored sessions",
+		flags: storeFlags,
+		run: func(args []string) {
+			store := openStore()
+			defer store.Close()
+
+			if err := listPowerBests(store, os.Stdout); err != nil {
+				fatal("failed to list power bests", "err", err)
+			}
+		},
+	},
+	{
+		name:  "ftp",
+		args:  "[id]",
+		about: "estimate FTP from a stored session's power curve (default the latest)",
+		flags: func(fs *flag.FlagSet) {
+			storeFlags(fs)
+			fs.BoolVar(&flagFTPUpdate, "update", false, "set the estimate as the FTP for zones and workouts in the config file")
+		},
+		run: func(args []string) {
+			if len(args) > 1 {
+				fatal("expected at most a session id")
+			}
+
+			id := int64(0)
+			if len(args) == 1 {
+				var err error
+				if id, err = strconv.ParseInt(args[0], 10, 64); err != nil {
+					fatal("bad session id", "id", args[0])
+				}
+			}
+
+			store := openStore()
+			defer store.Close()
+
+			if err := estimateFTP(store, id, flagFTPUpdate, os.Stdout); err != nil {
+				fatal("failed to estimate FTP", "err", err)
+			}
+		},
+	},
+	{
+		name:  "export",
+		args:  "<id> [tcx|csv]",
+		about: "write a stored session to stdout",
+		flags: func(fs *flag.FlagSet) {
+			storeFlags(fs)
+			unitsFlag(fs)
+		},
+		run: func(args []string) {
+			if len(args) < 1 || len(args) > 2 {
+				fatal("expected a session id, and optionally a format")
+			}
+
+			id, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				fatal("bad session id", "id", args[0])
+			}
+
+			format := "tcx"
+			if len(args) > 1 {
+				format = args[1]
+			}
+
+			store := openStore()
+			defer store.Close()
+
+			if err := sinks.ExportSession(os.Stdout, store, id, format, displayUnits()); err != nil {
+				fatal("failed to export session", "err", err)
+			}
+		},
+	},
+}
+
+// unitsFlag is for commands which show measurements.
+func unitsFlag(fs *flag.FlagSet) {
+	fs.StringVar(&flagUnits, "units", "metric", "units to show speed, distance, weight and temperature in: metric or imperial")
+}
+
+// displayUnits parses -units, exiting if it's bad.
+func displayUnits() metrics.Units {
+	units, err := metrics.ParseUnits(flagUnits)
+	if err != nil {
+		fatal("bad -units", "err", err)
+	}
+	return units
+}
+
+// storeFlags are for commands which use the session store.
+func storeFlags(fs *flag.FlagSet) {
+	fs.StringVar(&flagStorePath, "db", sinks.DefaultStorePath(), "SQLite database to store sessions in, empty to disable")
+}
+
+func openStore() *sinks.Store {
+	if flagStorePath == "" {
+		fatal("no session store, see -db")
+	}
+
+	store, err := sinks.NewStore(flagStorePath)
+	if err != nil {
+		fatal("failed to open session store", "err", err)
+	}
+
+	return store
+}
+
+func listDevices(cfg *Config) {
+	if len(cfg.Devices) == 0 {
+		fmt.Println("No devices configured in", flagConfigPath)
+		return
+	}
+
+	for _, dev := range cfg.Devices {
+		name := dev.Alias
+		if name == "" {
+			name = "-"
+		}
+
+		fmt.Printf("%-16s %s\n", name, ble.PlatformAddress(dev.Address, dev.UUID))
+	}
+
+	if len(cfg.Profiles) > 0 {
+		fmt.Println()
+	}
+	for name, devices := range cfg.Profiles {
+		fmt.Printf("profile %s: %s\n", name, strings.Join(devices, ", "))
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s <command> [flags] [args]\n\ncommands:\n", filepath.Base(os.Args[0]))
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.about)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun %s <command> -h for the flags each command takes.\n", filepath.Base(os.Args[0]))
+}
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] == "-h" || os.Args[1] == "-help" || os.Args[1] == "help" {
+		usage()
+		os.Exit(2)
+	}
+
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == os.Args[1] {
+			cmd = &commands[i]
+		}
+	}
+
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	fs.StringVar(&flagConfigPath, "config", defaultConfigPath(), "config file, flags take precedence over anything set here")
+	fs.BoolVar(&flagVerbose, "v", false, "log debugging detail")
+	fs.BoolVar(&flagQuiet, "q", false, "only log warnings and errors")
+	fs.BoolVar(&flagLogJSON, "log-json", false, "log to stderr as JSON, one object per line")
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s %s [flags] %s\n\n%s\n\nflags:\n",
+			filepath.Base(os.Args[0]), cmd.name, cmd.args, cmd.about)
+		fs.PrintDefaults()
+	}
+	fs.Parse(os.Args[2:])
+	setupLogging(flagVerbose, flagQuiet, flagLogJSON)
+
+	var err error
+	if config, err = LoadConfig(flagConfigPath); err != nil {
+		fatal("failed to load config file", "err", err)
+	}
+
+	if err := applyConfig(fs, config); err != nil {
+		fatal("invalid config file", "err", err)
+	}
+
+	cmd.run(fs.Args())
+}
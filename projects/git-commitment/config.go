@@ -0,0 +1,684 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/erik/git-commitment/ble"
+	"github.com/erik/git-commitment/metrics"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is everything that can be set from the config file. Anything
+// given on the command line takes precedence. For example:
+//
+//	ftp: 250
+//	threshold_hr: 172
+//	cp: 240
+//	w_prime: 18500
+//	wheel_circumference: 2105
+//	units: metric
+//	ant_stick: /dev/ttyUSB0
+//
+//	devices:
+//	  - address: F1:2C:7A:91:0B:3E
+//	    uuid: 5B1E0C6A-3F0D-4E5B-9D55-0C2F8D7E4A11
+//	    alias: kickr
+//	  - address: D4:22:19:E8:5F:01
+//	    alias: hrm
+//	  - address: C8:9E:4B:20:7D:66
+//	    alias: gravel-cadence
+//	    wheel_circumference: 2096
+//	  - address: ant:power:12345
+//	    alias: old-powermeter
+//	  - address: E2:51:0C:3A:9B:47
+//	    alias: turbo-speed
+//	    trainer: kurt-kinetic
+//
+//	profiles:
+//	  indoor: [kickr, hrm]
+//	  gravel: [gravel-cadence, hrm]
+//
+//	sources:
+//	  cycling_power: [old-powermeter, kickr]
+//	  heart_rate: [hrm]
+//
+//	filters:
+//	  cycling_power: median:5
+//	  cycling_cadence: exponential:0.3
+//	  heart_rate: average:3
+//
+//	sinks:
+//	  enabled: [stdout, log, http]
+//	  log_file: ~/rides/metrics.jsonl
+//	  log_format: jsonl
+//	  http: ":8080"
+//	  rebroadcast: true
+//	  ftms_bridge: true
+//	  ant_bridge: true
+//	  peripheral_name: trainer-mirror
+//	  influx:
+//	    url: http://localhost:8086
+//	    org: home
+//	    bucket: training
+//	    token: ...
+//	  mqtt:
+//	    broker: homeassistant.local:1883
+//	    username: trainer
+//	    password: ...
+//	  nats:
+//	    url: nats://localhost:4222
+//	  redis:
+//	    url: localhost:6379
+//	    retention: 720h
+//	  osc:
+//	    addr: localhost:57120
+//	    address: /bike/{metric}
+//	    addresses:
+//	      heart_rate: /pulse
+//	  wled:
+//	    url: udp://wled.local
+//	    zone: hr
+//	    colors: ["#808080", "#0000ff", "#00ff00", "#ff6000", "#ff0000"]
+//
+//	alerts:
+//	  - metric: heart_rate
+//	    above: 175
+//	    for: 30s
+//	    actions: [bell, say]
+//	  - metric: smoothed_power_10s
+//	    below_target: 10
+//	    for: 20s
+//	    actions: [say]
+//	  - metric: battery_level
+//	    below: 15
+//	    actions: [webhook]
+//	    webhook: https://example.com/hooks/trainer
+type Config struct {
+	FTP                *int   `yaml:"ftp"`
+	MaxHR              *int   `yaml:"max_hr"`
+	ThresholdHR        *int   `yaml:"threshold_hr"`
+	CP                 *int   `yaml:"cp"`
+	WPrime             *int   `yaml:"w_prime"`
+	Weight             string `yaml:"weight"`
+	Age                *int   `yaml:"age"`
+	Sex                string `yaml:"sex"`
+	WheelCircumference *int   `yaml:"wheel_circumference"`
+	Units              string `yaml:"units"`
+	TargetPower        *int   `yaml:"target_power"`
+	AutoLap            string `yaml:"auto_lap"`
+	AutoLapKm          string `yaml:"auto_lap_km"`
+	AutoLapIntervals   *bool  `yaml:"auto_lap_intervals"`
+	AutoPause          string `yaml:"auto_pause"`
+	PowerMatch         *bool  `yaml:"power_match"`
+	TargetHR           string `yaml:"target_hr"`
+	HRLag              string `yaml:"hr_lag"`
+	StallCadence       *int   `yaml:"stall_cadence"`
+	StallRecovery      *int   `yaml:"stall_recover_cadence"`
+	PowerWindows       string `yaml:"power_windows"`
+	HRVWindows         string `yaml:"hrv_windows"`
+	StaleTimeout       string `yaml:"stale_timeout"`
+	ConnectTimeout     string `yaml:"connect_timeout"`
+	ConnectRetries     *int   `yaml:"connect_retries"`
+	ANTStick           string `yaml:"ant_stick"`
+	ANTDevice          *int   `yaml:"ant_device"`
+	Fan                string `yaml:"fan"`
+	FanSpeeds          string `yaml:"fan_speeds"`
+	FanPlugs           string `yaml:"fan_plugs"`
+	FanMaxSpeed        *int   `yaml:"fan_max_speed"`
+	IntervalsAPIKey    string `yaml:"intervals_api_key"`
+	IntervalsAthlete   string `yaml:"intervals_athlete"`
+
+	Devices []DeviceConfig `yaml:"devices"`
+	Sinks   SinkConfig     `yaml:"sinks"`
+
+	// Named sets of devices (by alias or address) to connect to together.
+	Profiles map[string][]string `yaml:"profiles"`
+
+	// Metric kind -> devices (by alias or address) to take it from, most
+	// preferred first. When more than one device sends the same kind, only
+	// one is used at a time.
+	Sources map[string][]string `yaml:"sources"`
+
+	// Metric kind -> filter to smooth it with before it reaches the sinks,
+	// one of average:N or median:N over the last N readings, or
+	// exponential:A with smoothing factor A.
+	Filters map[string]string `yaml:"filters"`
+
+	// Threshold rules checked against every metric.
+	Alerts []AlertConfig `yaml:"alerts"`
+}
+
+// DeviceConfig holds per-device options, which override the global ones.
+type DeviceConfig struct {
+	Address string `yaml:"address"`
+	// macOS doesn't give us MAC addresses, so the same device can be
+	// given its CoreBluetooth UUID here to share a config between
+	// machines.
+	UUID string `yaml:"uuid"`
+
+	// Short name to refer to the device by, instead of the address.
+	Alias string `yaml:"alias"`
+
+	// mm
+	WheelCircumference int `yaml:"wheel_circumference"`
+
+	// For speed sensors on classic trainers, estimate power from speed
+	// with one of metrics.TrainerCurves, or a curve of our own given as
+	// coefficients (see metrics.TrainerCurve).
+	Trainer      string    `yaml:"trainer"`
+	TrainerCurve []float64 `yaml:"trainer_curve"`
+}
+
+type SinkConfig struct {
+	// Which sinks to use, see -sinks.
+	Enabled []string `yaml:"enabled"`
+
+	TCX       string `yaml:"tcx"`
+	LogFile   string `yaml:"log_file"`
+	LogFormat string `yaml:"log_format"`
+	HTTP      string `yaml:"http"`
+	HTTPToken string `yaml:"http_token"`
+	GRPC      string `yaml:"grpc"`
+	TUI       *bool  `yaml:"tui"`
+
+	// Output without -tui, see -format.
+	Format        string `yaml:"format"`
+	StatusBarFile string `yaml:"statusbar_file"`
+
+	// Broadcast over UDP, see -udp-port.
+	UDPPort     *int   `yaml:"udp_port"`
+	UDPInterval string `yaml:"udp_interval"`
+
+	// Spoken announcements, see -audio.
+	Audio      *bool  `yaml:"audio"`
+	AudioEvery string `yaml:"audio_every"`
+	TTSCommand string `yaml:"tts_command"`
+
+	// Desktop notifications, see -notify.
+	Notify *bool `yaml:"notify"`
+
+	// Session start, lap and end events, see -webhook.
+	Webhook string `yaml:"webhook"`
+
+	// Act as a BLE sensor mirroring what we receive, see -rebroadcast.
+	Rebroadcast    *bool  `yaml:"rebroadcast"`
+	PeripheralName string `yaml:"peripheral_name"`
+	// Act as an FTMS trainer, see -ftms-bridge.
+	FTMSBridge *bool `yaml:"ftms_bridge"`
+	// Broadcast as ANT+ sensors, see -ant-bridge.
+	ANTBridge *bool `yaml:"ant_bridge"`
+
+	Influx InfluxConfig `yaml:"influx"`
+	MQTT   MQTTConfig   `yaml:"mqtt"`
+	NATS   NATSConfig   `yaml:"nats"`
+	Redis  RedisConfig  `yaml:"redis"`
+	OSC    OSCConfig    `yaml:"osc"`
+	WLED   WLEDConfig   `yaml:"wled"`
+
+	// Pointer so that the database can be disabled with an explicit
+	// empty string.
+	DB *string `yaml:"db"`
+}
+
+// InfluxConfig is where to write metrics for long term storage, see
+// -influx-url.
+type InfluxConfig struct {
+	URL    string `yaml:"url"`
+	Org    string `yaml:"org"`
+	Bucket string `yaml:"bucket"`
+	Token  string `yaml:"token"`
+}
+
+// MQTTConfig is where to publish live metrics, see -mqtt.
+type MQTTConfig struct {
+	Broker   string `yaml:"broker"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	Topic    string `yaml:"topic"`
+	// Pointer so that discovery can be disabled with an explicit empty
+	// string.
+	Discovery *string `yaml:"discovery"`
+}
+
+// NATSConfig is where to stream metrics, see -nats.
+type NATSConfig struct {
+	URL     string `yaml:"url"`
+	Subject string `yaml:"subject"`
+}
+
+// RedisConfig is where to keep metrics as time series, see -redis.
+type RedisConfig struct {
+	URL       string `yaml:"url"`
+	Prefix    string `yaml:"prefix"`
+	Retention string `yaml:"retention"`
+}
+
+// OSCConfig is where to send metrics as OSC messages, see -osc.
+type OSCConfig struct {
+	Addr    string `yaml:"addr"`
+	Address string `yaml:"address"`
+	// Metric name -> OSC address, for metrics which shouldn't go to
+	// Address.
+	Addresses map[string]string `yaml:"addresses"`
+}
+
+// WLEDConfig is an LED strip to show the current zone on, see -wled.
+type WLEDConfig struct {
+	URL  string `yaml:"url"`
+	Zone string `yaml:"zone"`
+	// Hex colors such as "#ff0000", one per zone. Zones past the end use
+	// the last one.
+	Colors []string `yaml:"colors"`
+}
+
+// AlertConfig is a single threshold rule, see AlertRule.
+type AlertConfig struct {
+	// Name as in output, e.g. heart_rate or smoothed_power_3s.
+	Metric      string  `yaml:"metric"`
+	Above       float64 `yaml:"above"`
+	Below       float64 `yaml:"below"`
+	BelowTarget float64 `yaml:"below_target"`
+	// How long the threshold has to be crossed for, e.g. "30s".
+	For string `yaml:"for"`
+	// Any of bell, say and webhook. Defaults to bell.
+	Actions []string `yaml:"actions"`
+	// Optional, otherwise one is made up from the rule.
+	Message string `yaml:"message"`
+	Webhook string `yaml:"webhook"`
+}
+
+// defaultConfigPath follows the XDG convention, same as the session store.
+func defaultConfigPath() string {
+	configDir := os.Getenv("XDG_CONFIG_HOME")
+	if configDir == "" {
+		home, _ := os.UserHomeDir()
+		configDir = filepath.Join(home, ".config")
+	}
+
+	return filepath.Join(configDir, "git-commitment", "config.yaml")
+}
+
+// LoadConfig reads the config file at path. A missing file isn't an error,
+// it just means there's nothing configured.
+func LoadConfig(path string) (*Config, error) {
+	cfg := &Config{}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+
+	// Empty file is fine too
+	if err := dec.Decode(cfg); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// Device returns the config for the device with the given address or
+// alias, if any.
+func (cfg *Config) Device(nameOrAddr string) (DeviceConfig, bool) {
+	for _, dev := range cfg.Devices {
+		if strings.EqualFold(dev.Address, nameOrAddr) ||
+			(dev.UUID != "" && strings.EqualFold(dev.UUID, nameOrAddr)) ||
+			(dev.Alias != "" && dev.Alias == nameOrAddr) {
+			return dev, true
+		}
+	}
+
+	return DeviceConfig{}, false
+}
+
+// ResolveDevice turns an alias into the device's address for this
+// platform. Anything we don't have an alias for is assumed to already be
+// an address.
+func (cfg *Config) ResolveDevice(nameOrAddr string) string {
+	if dev, ok := cfg.Device(nameOrAddr); ok {
+		return ble.PlatformAddress(dev.Address, dev.UUID)
+	}
+	return nameOrAddr
+}
+
+// Alias returns the configured alias for a device, or empty if it doesn't
+// have one.
+func (cfg *Config) Alias(addr string) string {
+	if dev, ok := cfg.Device(addr); ok {
+		return dev.Alias
+	}
+	return ""
+}
+
+// DeviceName is what to call a device in output: its alias if it has one,
+// otherwise the address.
+func (cfg *Config) DeviceName(addr string) string {
+	if alias := cfg.Alias(addr); alias != "" {
+		return alias
+	}
+	return addr
+}
+
+// applyConfig sets any flags in fs which weren't given on the command line
+// from the config file. Anything fs doesn't have is ignored, since not every
+// command takes every flag.
+func applyConfig(fs *flag.FlagSet, cfg *Config) error {
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	set := func(name string, value interface{}) error {
+		if given[name] || fs.Lookup(name) == nil {
+			return nil
+		}
+
+		// Numbers and switches are pointers, so that zero and false can
+		// be told apart from being left out. Empty strings are left out.
+		switch v := value.(type) {
+		case *int:
+			if v == nil {
+				return nil
+			}
+			value = *v
+		case *bool:
+			if v == nil {
+				return nil
+			}
+			value = *v
+		}
+
+		str := fmt.Sprint(value)
+		if str == "" {
+			return nil
+		}
+
+		return fs.Set(name, str)
+	}
+
+	settings := []struct {
+		name  string
+		value interface{}
+	}{
+		{"ftp", cfg.FTP},
+		{"max-hr", cfg.MaxHR},
+		{"threshold-hr", cfg.ThresholdHR},
+		{"cp", cfg.CP},
+		{"w-prime", cfg.WPrime},
+		{"weight", cfg.Weight},
+		{"age", cfg.Age},
+		{"sex", cfg.Sex},
+		{"wheel-circumference", cfg.WheelCircumference},
+		{"units", cfg.Units},
+		{"target-power", cfg.TargetPower},
+		{"auto-lap", cfg.AutoLap},
+		{"auto-lap-km", cfg.AutoLapKm},
+		{"auto-lap-intervals", cfg.AutoLapIntervals},
+		{"auto-pause", cfg.AutoPause},
+		{"power-match", cfg.PowerMatch},
+		{"target-hr", cfg.TargetHR},
+		{"hr-lag", cfg.HRLag},
+		{"stall-cadence", cfg.StallCadence},
+		{"stall-recover-cadence", cfg.StallRecovery},
+		{"power-windows", cfg.PowerWindows},
+		{"hrv-windows", cfg.HRVWindows},
+		{"stale-timeout", cfg.StaleTimeout},
+		{"connect-timeout", cfg.ConnectTimeout},
+		{"connect-retries", cfg.ConnectRetries},
+		{"ant-stick", expandHome(cfg.ANTStick)},
+		{"ant-device", cfg.ANTDevice},
+		{"fan", cfg.Fan},
+		{"fan-speeds", cfg.FanSpeeds},
+		{"fan-plugs", cfg.FanPlugs},
+		{"fan-max-speed", cfg.FanMaxSpeed},
+		{"intervals-api-key", cfg.IntervalsAPIKey},
+		{"intervals-athlete", cfg.IntervalsAthlete},
+		{"tcx", expandHome(cfg.Sinks.TCX)},
+		{"log-file", expandHome(cfg.Sinks.LogFile)},
+		{"log-format", cfg.Sinks.LogFormat},
+		{"http", cfg.Sinks.HTTP},
+		{"http-token", cfg.Sinks.HTTPToken},
+		{"grpc", cfg.Sinks.GRPC},
+		{"udp-port", cfg.Sinks.UDPPort},
+		{"udp-interval", cfg.Sinks.UDPInterval},
+		{"audio", cfg.Sinks.Audio},
+		{"audio-every", cfg.Sinks.AudioEvery},
+		{"tts-command", cfg.Sinks.TTSCommand},
+		{"notify", cfg.Sinks.Notify},
+		{"webhook", cfg.Sinks.Webhook},
+		{"tui", cfg.Sinks.TUI},
+		{"format", cfg.Sinks.Format},
+		{"statusbar-file", expandHome(cfg.Sinks.StatusBarFile)},
+		{"rebroadcast", cfg.Sinks.Rebroadcast},
+		{"peripheral-name", cfg.Sinks.PeripheralName},
+		{"ftms-bridge", cfg.Sinks.FTMSBridge},
+		{"ant-bridge", cfg.Sinks.ANTBridge},
+		{"influx-url", cfg.Sinks.Influx.URL},
+		{"influx-org", cfg.Sinks.Influx.Org},
+		{"influx-bucket", cfg.Sinks.Influx.Bucket},
+		{"influx-token", cfg.Sinks.Influx.Token},
+		{"mqtt", cfg.Sinks.MQTT.Broker},
+		{"mqtt-username", cfg.Sinks.MQTT.Username},
+		{"mqtt-password", cfg.Sinks.MQTT.Password},
+		{"mqtt-topic", cfg.Sinks.MQTT.Topic},
+		{"nats", cfg.Sinks.NATS.URL},
+		{"nats-subject", cfg.Sinks.NATS.Subject},
+		{"redis", cfg.Sinks.Redis.URL},
+		{"redis-prefix", cfg.Sinks.Redis.Prefix},
+		{"redis-retention", cfg.Sinks.Redis.Retention},
+		{"osc", cfg.Sinks.OSC.Addr},
+		{"osc-address", cfg.Sinks.OSC.Address},
+		{"wled", cfg.Sinks.WLED.URL},
+		{"wled-zone", cfg.Sinks.WLED.Zone},
+		{"sinks", strings.Join(cfg.Sinks.Enabled, ",")},
+	}
+
+	for _, s := range settings {
+		if err := set(s.name, s.value); err != nil {
+			return fmt.Errorf("bad config value for %s: %w", s.name, err)
+		}
+	}
+
+	if cfg.Sinks.DB != nil && !given["db"] {
+		flagStorePath = expandHome(*cfg.Sinks.DB)
+	}
+	if cfg.Sinks.MQTT.Discovery != nil && !given["mqtt-discovery"] {
+		flagMQTTDiscovery = *cfg.Sinks.MQTT.Discovery
+	}
+
+	if flagProfile != "" {
+		devices, ok := cfg.Profiles[flagProfile]
+		if !ok {
+			return fmt.Errorf("unknown profile: %q", flagProfile)
+		}
+
+		flagDeviceAddrs = append(flagDeviceAddrs, devices...)
+	} else if !given["device"] && !flagAuto && !flagPick {
+		for _, dev := range cfg.Devices {
+			flagDeviceAddrs = append(flagDeviceAddrs, ble.PlatformAddress(dev.Address, dev.UUID))
+		}
+	}
+
+	for i, addr := range flagDeviceAddrs {
+		flagDeviceAddrs[i] = cfg.ResolveDevice(addr)
+	}
+
+	return nil
+}
+
+// setConfigValue sets a top level value in the config file at path,
+// creating the file if there isn't one. This goes through yaml.Node rather
+// than Config so that comments and everything else are kept as they are.
+func setConfigValue(path, key, value string) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	doc := yaml.Node{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	// Empty or missing file
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{
+			Kind:    yaml.DocumentNode,
+			Content: []*yaml.Node{{Kind: yaml.MappingNode}},
+		}
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s: expected a mapping at the top level", path)
+	}
+
+	found := false
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == key {
+			node := root.Content[i+1]
+			node.Kind, node.Tag, node.Style, node.Value = yaml.ScalarNode, "", 0, value
+			found = true
+		}
+	}
+	if !found {
+		root.Content = append(root.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Value: key},
+			&yaml.Node{Kind: yaml.ScalarNode, Value: value},
+		)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	enc := yaml.NewEncoder(f)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return err
+	}
+
+	return enc.Close()
+}
+
+// expandHome replaces a leading ~/ with the user's home directory.
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~/") {
+		return path
+	}
+
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, path[2:])
+}
+
+// SourcePriorities resolves the configured sources into addresses by kind.
+func (cfg *Config) SourcePriorities() (map[metrics.Kind][]string, error) {
+	priorities := map[metrics.Kind][]string{}
+	for name, devices := range cfg.Sources {
+		kind, err := metrics.ParseKind(name)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, dev := range devices {
+			priorities[kind] = append(priorities[kind], cfg.ResolveDevice(dev))
+		}
+	}
+
+	return priorities, nil
+}
+
+// TrainerCurves finds the power curves of any classic trainers, by the
+// address of their speed sensor.
+func (cfg *Config) TrainerCurves() (map[string]metrics.TrainerCurve, error) {
+	curves := map[string]metrics.TrainerCurve{}
+	for _, dev := range cfg.Devices {
+		addr := ble.PlatformAddress(dev.Address, dev.UUID)
+
+		switch {
+		case len(dev.TrainerCurve) > 0:
+			curves[addr] = dev.TrainerCurve
+
+		case dev.Trainer != "":
+			curve, err := metrics.LookupTrainerCurve(dev.Trainer)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", cfg.DeviceName(addr), err)
+			}
+			curves[addr] = curve
+		}
+	}
+
+	return curves, nil
+}
+
+// MetricFilters parses the configured filters by kind.
+func (cfg *Config) MetricFilters() (map[metrics.Kind]metrics.FilterSpec, error) {
+	filters := map[metrics.Kind]metrics.FilterSpec{}
+	for name, filter := range cfg.Filters {
+		kind, err := metrics.ParseKind(name)
+		if err != nil {
+			return nil, err
+		}
+
+		spec, err := metrics.ParseFilter(filter)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		filters[kind] = spec
+	}
+
+	return filters, nil
+}
+
+// AlertRules parses and checks the configured alerts.
+func (cfg *Config) AlertRules() ([]AlertRule, error) {
+	rules := []AlertRule{}
+	for _, alert := range cfg.Alerts {
+		rule := AlertRule{
+			Metric:      alert.Metric,
+			Above:       alert.Above,
+			Below:       alert.Below,
+			BelowTarget: alert.BelowTarget,
+			Actions:     alert.Actions,
+			Message:     alert.Message,
+			Webhook:     alert.Webhook,
+		}
+
+		if alert.For != "" {
+			d, err := time.ParseDuration(alert.For)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", alert.Metric, err)
+			}
+			rule.For = d
+		}
+
+		if len(rule.Actions) == 0 {
+			rule.Actions = []string{AlertBell}
+		}
+
+		if err := rule.validate(); err != nil {
+			return nil, err
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
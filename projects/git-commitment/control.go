@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/erik/git-commitment/gatt"
+	"github.com/erik/git-commitment/metrics"
+)
+
+// TrainerConnection identifies a trainer by device address, so that a
+// reconnected trainer replaces the old one rather than being added again.
+type TrainerConnection struct {
+	address string
+	trainer gatt.TrainerController
+}
+
+type ControlKind int
+
+const (
+	ControlTargetPower ControlKind = iota
+	ControlGrade
+	ControlWindSpeed
+	ControlRollingResistance
+	ControlSpindown
+	// Every simulation parameter at once, e.g. from the FTMS bridge.
+	ControlSimulation
+	// Relative to the current target or grade, e.g. from the keyboard.
+	ControlAdjustPower
+	ControlAdjustGrade
+	// Treadmills only, in km/h and percent.
+	ControlTargetSpeed
+	ControlIncline
+	// A fixed resistance level, for trainers that support it.
+	ControlResistance
+)
+
+// ControlCommand is a request to change how connected trainers behave,
+// e.g. a new ERG target.
+type ControlCommand struct {
+	kind  ControlKind
+	value float64
+	// Only for ControlSimulation
+	sim gatt.SimulationParams
+}
+
+// readControlCommands parses one command per line from r, for example:
+//
+//	power 250
+//	grade 4.5
+//	wind -2
+//	crr 0.005
+//	speed 12.5
+//	incline 1
+//	resistance 8
+//	spindown
+//
+// Unrecognized lines are reported and skipped.
+func readControlCommands(r io.Reader, commands chan<- ControlCommand) {
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		if len(fields) == 1 && fields[0] == "spindown" {
+			commands <- ControlCommand{kind: ControlSpindown}
+			continue
+		}
+
+		if len(fields) != 2 {
+			slog.Warn("bad command", "command", scanner.Text())
+			continue
+		}
+
+		value, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			slog.Warn("bad command value", "value", fields[1])
+			continue
+		}
+
+		switch fields[0] {
+		case "power", "p":
+			commands <- ControlCommand{kind: ControlTargetPower, value: value}
+		case "grade", "g":
+			commands <- ControlCommand{kind: ControlGrade, value: value}
+		case "wind", "w":
+			commands <- ControlCommand{kind: ControlWindSpeed, value: value}
+		case "crr":
+			commands <- ControlCommand{kind: ControlRollingResistance, value: value}
+		case "speed":
+			commands <- ControlCommand{kind: ControlTargetSpeed, value: value}
+		case "incline":
+			commands <- ControlCommand{kind: ControlIncline, value: value}
+		case "resistance", "r":
+			commands <- ControlCommand{kind: ControlResistance, value: value}
+
+		default:
+			slog.Warn("unknown command", "command", fields[0])
+		}
+	}
+}
+
+// runTrainerControl applies control commands to every connected trainer,
+// including any which connect later on.
+//
+// Trainers are either in ERG mode, holding a target power, simulation mode
+// or resistance mode, holding a fixed resistance level. Setting any of the
+// simulation parameters switches out of the other two.
+// Treadmills take speed and incline targets instead, which are sent to
+// every connected machine that accepts them once set.
+//
+// If power is non-nil, ERG targets are power matched: readings from
+// anything other than a connected trainer (i.e. a power meter) are used to
+// correct the target sent to the trainers.
+//
+// weight is the rider and bike in kg, for simulation mode.
+func runTrainerControl(
+	trainers <-chan TrainerConnection,
+	commands <-chan ControlCommand,
+	targetPower int,
+	power <-chan metrics.Metric,
+	weight float64,
+) {
+	connected := map[string]gatt.TrainerController{}
+
+	simulating := false
+	sim := gatt.DefaultSimulationParams
+	sim.Weight = weight
+
+	// Only used without a target power.
+	resistance, resistanceSet := 0.0, false
+
+	// Zero speed means we haven't been asked for one.
+	targetSpeed, incline := 0.0, 0.0
+	inclineSet := false
+
+	matcher := powerMatcher{}
+	var adjust <-chan time.Time
+	if power != nil {
+		ticker := time.NewTicker(powerMatchInterval)
+		defer ticker.Stop()
+		adjust = ticker.C
+	}
+
+	applyTreadmill := func(trainer gatt.TrainerController) {
+		treadmill, ok := trainer.(gatt.Treadmill)
+		if !ok {
+			return
+		}
+
+		caps := trainer.Capabilities()
+		if targetSpeed > 0 && caps.Speed {
+			if err := treadmill.SetTargetSpeed(targetSpeed); err != nil {
+				slog.Warn("failed to set target speed", "err", err)
+			}
+		}
+		if inclineSet && caps.Incline {
+			if err := treadmill.SetTargetIncline(incline); err != nil {
+				slog.Warn("failed to set incline", "err", err)
+			}
+		}
+	}
+
+	apply := func(trainer gatt.TrainerController) {
+		caps := trainer.Capabilities()
+
+		if simulating {
+			if !caps.Grade {
+				slog.Warn("trainer doesn't support simulation mode")
+				return
+			}
+			if err := trainer.SetGrade(sim); err != nil {
+				slog.Warn("failed to set simulation parameters", "err", err)
+			}
+			return
+		}
+
+		if targetPower <= 0 {
+			if !resistanceSet {
+				return
+			}
+
+			if !caps.Resistance {
+				slog.Warn("trainer doesn't support resistance mode")
+				return
+			}
+			if err := trainer.SetResistance(resistance); err != nil {
+				slog.Warn("failed to set resistance", "err", err)
+			}
+			return
+		}
+
+		if !caps.Power {
+			slog.Warn("trainer doesn't support ERG mode")
+			return
+		}
+		if err := trainer.SetTargetPower(matcher.target(targetPower)); err != nil {
+			slog.Warn("failed to set target power", "err", err)
+		}
+	}
+
+	// Mid-ride, so there's no walking through it like the calibrate
+	// command does.
+	spindown := func(trainer gatt.TrainerController) {
+		if !trainer.Capabilities().Calibrate {
+			slog.Warn("trainer doesn't support spindown calibration")
+			return
+		}
+
+		err := trainer.Calibrate(func(event gatt.CalibrationEvent) {
+			slog.Info("spindown", "event", event)
+		})
+		if err != nil {
+			slog.Warn("failed to start spindown", "err", err)
+		} else {
+			slog.Info("spindown started: get up to speed, then stop pedaling")
+		}
+	}
+
+	for {
+		select {
+		case m := <-power:
+			if _, ok := connected[m.Address]; !ok {
+				matcher.observe(m.Value, m.Timestamp)
+			}
+
+		case now := <-adjust:
+			if simulating || !matcher.adjust(targetPower, now) {
+				continue
+			}
+
+			slog.Debug("power match: setting trainer target", "watts", matcher.target(targetPower))
+			for _, trainer := range connected {
+				apply(trainer)
+			}
+
+		case conn := <-trainers:
+			// Picks up where we left off if this is a reconnection.
+			connected[conn.address] = conn.trainer
+			applyTreadmill(conn.trainer)
+			apply(conn.trainer)
+
+		case cmd := <-commands:
+			switch cmd.kind {
+			case ControlTargetPower:
+				simulating, resistanceSet = false, false
+				targetPower = int(cmd.value)
+				slog.Info("setting target power", "watts", targetPower)
+
+			case ControlResistance:
+				simulating, targetPower = false, 0
+				resistance, resistanceSet = cmd.value, true
+				slog.Info("setting resistance", "level", resistance)
+
+			case ControlGrade:
+				simulating = true
+				sim.Grade = cmd.value
+				slog.Info("setting grade", "percent", sim.Grade)
+
+			case ControlAdjustPower:
+				simulating, resistanceSet = false, false
+				targetPower += int(cmd.value)
+				if targetPower < 0 {
+					targetPower = 0
+				}
+				slog.Info("setting target power", "watts", targetPower)
+
+			case ControlAdjustGrade:
+				simulating = true
+				sim.Grade += cmd.value
+				slog.Info("setting grade", "percent", sim.Grade)
+
+			case ControlWindSpeed:
+				simulating = true
+				sim.WindSpeed = cmd.value
+				slog.Info("setting wind speed", "mps", sim.WindSpeed)
+
+			case ControlRollingResistance:
+				simulating = true
+				sim.Crr = cmd.value
+				slog.Info("setting rolling resistance", "crr", sim.Crr)
+
+			case ControlSimulation:
+				simulating = true
+				sim = cmd.sim
+				sim.Weight = weight
+				slog.Info("setting simulation", "grade", sim.Grade, "wind", sim.WindSpeed)
+
+			case ControlSpindown:
+				for _, trainer := range connected {
+					spindown(trainer)
+				}
+				continue
+
+			case ControlTargetSpeed:
+				targetSpeed = cmd.value
+				slog.Info("setting target speed", "kmh", targetSpeed)
+				for _, trainer := range connected {
+					applyTreadmill(trainer)
+				}
+				continue
+
+			case ControlIncline:
+				incline, inclineSet = cmd.value, true
+				slog.Info("setting incline", "percent", incline)
+				for _, trainer := range connected {
+					applyTreadmill(trainer)
+				}
+				continue
+			}
+
+			for _, trainer := range connected {
+				apply(trainer)
+			}
+		}
+	}
+}
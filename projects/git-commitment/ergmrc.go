@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Classic ERG and MRC workout files, which look something like this:
+//
+//	[COURSE HEADER]
+//	VERSION = 2
+//	UNITS = ENGLISH
+//	DESCRIPTION = Sweet spot
+//	FILE NAME = sweetspot.mrc
+//	MINUTES PERCENT
+//	[END COURSE HEADER]
+//	[COURSE DATA]
+//	0.00	50
+//	10.00	88
+//	30.00	88
+//	30.00	50
+//	40.00	50
+//	[END COURSE DATA]
+//
+// The data is a series of points on the power profile, which is linearly
+// interpolated between them. Two points at the same time give a step
+// change. ERG files use absolute watts, MRC files use percent of FTP.
+func parseErgWorkout(r io.Reader, ftp float64) (*Workout, error) {
+	type point struct {
+		minutes float64
+		power   float64
+	}
+
+	var (
+		name    string
+		percent bool
+		section string
+		points  []point
+	)
+
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			section = strings.ToUpper(line)
+			continue
+		}
+
+		switch section {
+		case "[COURSE HEADER]":
+			upper := strings.ToUpper(line)
+
+			if strings.HasPrefix(upper, "MINUTES") {
+				percent = strings.Contains(upper, "PERCENT")
+			} else if kv := strings.SplitN(line, "=", 2); len(kv) == 2 {
+				key := strings.ToUpper(strings.TrimSpace(kv[0]))
+				value := strings.TrimSpace(kv[1])
+
+				if key == "DESCRIPTION" || (key == "FILE NAME" && name == "") {
+					name = value
+				}
+			}
+
+		case "[COURSE DATA]":
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("line %d: expected <minutes> <power>", lineNum)
+			}
+
+			minutes, err := strconv.ParseFloat(fields[0], 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNum, err)
+			}
+			power, err := strconv.ParseFloat(fields[1], 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNum, err)
+			}
+
+			if percent {
+				power = power * ftp / 100
+			}
+
+			points = append(points, point{minutes, power})
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	workout := &Workout{Name: name}
+
+	for i := 1; i < len(points); i++ {
+		prev, cur := points[i-1], points[i]
+		if cur.minutes <= prev.minutes {
+			continue
+		}
+
+		step := WorkoutStep{
+			Duration: time.Duration((cur.minutes - prev.minutes) * float64(time.Minute)),
+			Power:    prev.power,
+		}
+		if cur.power != prev.power {
+			step.PowerEnd, step.Ramp = cur.power, true
+		}
+
+		workout.Steps = append(workout.Steps, step)
+	}
+
+	if len(workout.Steps) == 0 {
+		return nil, fmt.Errorf("workout has no steps")
+	}
+
+	return workout, nil
+}
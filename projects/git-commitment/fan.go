@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/erik/git-commitment/metrics"
+	"github.com/erik/git-commitment/mqtt"
+)
+
+// DefaultFanSpeeds are the fan speeds (in percent) for each zone, starting
+// at zone 1. Zones past the end use the last speed.
+var DefaultFanSpeeds = []int{0, 30, 50, 70, 100}
+
+// Hysteresis: a new speed has to be wanted for this long before the fan
+// changes. Speeding up is quicker than slowing down, since a short easy
+// spell is no reason to stop cooling off.
+const (
+	fanHoldUp   = 15 * time.Second
+	fanHoldDown = 60 * time.Second
+)
+
+// No zone for this long (e.g. we stopped pedaling) means the fan can go
+// off.
+const fanIdleTimeout = 30 * time.Second
+
+// Fan is anything whose speed we can set.
+type Fan interface {
+	// Percent, 0 is off.
+	SetSpeed(percent int) error
+}
+
+// FanConnection identifies a fan by address, so that a reconnected fan
+// replaces the old one.
+type FanConnection struct {
+	address string
+	fan     Fan
+}
+
+// ParseFanSpeeds parses a list of speeds by zone, e.g. "0,30,50,70,100".
+func ParseFanSpeeds(s string) ([]int, error) {
+	speeds := []int{}
+	for _, part := range strings.Split(s, ",") {
+		speed, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		if speed < 0 || speed > 100 {
+			return nil, fmt.Errorf("fan speed out of range: %d", speed)
+		}
+		speeds = append(speeds, speed)
+	}
+
+	return speeds, nil
+}
+
+// DefaultFanMaxSpeed is the speed (in km/h) at which fans linked to speed
+// are at full speed.
+const DefaultFanMaxSpeed = 40
+
+// Fans linked to speed go up and down in steps of this many percent, so
+// they aren't being told something new every second.
+const fanSpeedStep = 10
+
+// fanCommand is a change asked for by hand: either a change in speed, which
+// takes the fans off automatic, or going back to automatic.
+type fanCommand struct {
+	delta int
+	auto  bool
+}
+
+// FanController sets fan speed from the current power or heart rate zone,
+// or in proportion to speed, unless it's been set by hand, see Adjust.
+type FanController struct {
+	// PowerZone, HeartRateZone or CyclingSpeed, -1 for manual control
+	// only.
+	kind metrics.Kind
+	// Fan speed for a value of kind
+	speedFor func(value float64) int
+
+	fans     <-chan FanConnection
+	commands chan fanCommand
+}
+
+// NewFanController sets fan speeds by zone, given the speed for each zone
+// starting at zone 1.
+func NewFanController(kind metrics.Kind, speeds []int, fans <-chan FanConnection) *FanController {
+	speedFor := func(zone float64) int {
+		switch {
+		case zone < 1:
+			return 0
+		case int(zone) > len(speeds):
+			return speeds[len(speeds)-1]
+		}
+		return speeds[int(zone)-1]
+	}
+
+	return &FanController{kind: kind, speedFor: speedFor, fans: fans, commands: make(chan fanCommand)}
+}
+
+// NewSpeedFanController sets fan speeds in proportion to speed, at full
+// speed from maxSpeed (in km/h) up, the way a Headwind's own speed mode
+// does.
+func NewSpeedFanController(maxSpeed float64, fans <-chan FanConnection) *FanController {
+	speedFor := func(kmh float64) int {
+		percent := math.Round(kmh/maxSpeed*100/fanSpeedStep) * fanSpeedStep
+		return int(math.Max(0, math.Min(100, percent)))
+	}
+
+	return &FanController{kind: metrics.CyclingSpeed, speedFor: speedFor, fans: fans, commands: make(chan fanCommand)}
+}
+
+// NewManualFanController only changes fan speed when told to, see Adjust.
+func NewManualFanController(fans <-chan FanConnection) *FanController {
+	return &FanController{kind: -1, fans: fans, commands: make(chan fanCommand)}
+}
+
+// Adjust changes the fan speed by delta percent, taking it off automatic
+// until Auto is called.
+func (c *FanController) Adjust(delta int) {
+	go func() { c.commands <- fanCommand{delta: delta} }()
+}
+
+// Auto goes back to setting fan speed automatically, after Adjust.
+func (c *FanController) Auto() {
+	go func() { c.commands <- fanCommand{auto: true} }()
+}
+
+// Run consumes metrics until the channel is closed, then turns every fan
+// off.
+func (c *FanController) Run(in <-chan metrics.Metric) {
+	connected := map[string]Fan{}
+	setSpeed := func(fan Fan, speed int) {
+		if err := fan.SetSpeed(speed); err != nil {
+			slog.Warn("failed to set fan speed", "err", err)
+		}
+	}
+	setAll := func(speed int) {
+		for _, fan := range connected {
+			setSpeed(fan, speed)
+		}
+	}
+
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	// -1 until we've set a speed
+	current := -1
+	// Set by hand, so leave it alone
+	manual := c.kind < 0
+	value := 0.0
+	var valueAt time.Time
+
+	pending := -1
+	var pendingSince time.Time
+
+	for {
+		select {
+		case conn := <-c.fans:
+			connected[conn.address] = conn.fan
+			if current >= 0 {
+				setSpeed(conn.fan, current)
+			}
+
+		case cmd := <-c.commands:
+			if cmd.auto {
+				if c.kind < 0 {
+					slog.Warn("fan is only controlled by hand, use -fan power, hr or speed")
+					continue
+				}
+				slog.Info("fan speed back on automatic")
+				manual = false
+				continue
+			}
+
+			manual = true
+			current = max(0, min(100, max(current, 0)+cmd.delta))
+			pending = -1
+			slog.Info("fan speed", "percent", current, "manual", true)
+			setAll(current)
+
+		case m, ok := <-in:
+			if !ok {
+				setAll(0)
+				return
+			}
+
+			if m.Kind == c.kind && m.Window == 0 {
+				value = m.Value
+				valueAt = m.Timestamp
+			}
+
+		case now := <-ticker.C:
+			// Leave the fans alone until there's something to go on.
+			if manual || valueAt.IsZero() {
+				continue
+			}
+			if now.Sub(valueAt) > fanIdleTimeout {
+				value = 0
+			}
+
+			want := c.speedFor(value)
+			if want == current {
+				pending = -1
+				continue
+			}
+
+			if want != pending {
+				pending = want
+				pendingSince = now
+			}
+
+			hold := fanHoldUp
+			if want < current {
+				hold = fanHoldDown
+			}
+			if current >= 0 && now.Sub(pendingSince) < hold {
+				continue
+			}
+
+			slog.Info("fan speed", "percent", want, c.kind.String(), value)
+			current = want
+			pending = -1
+			setAll(current)
+		}
+	}
+}
+
+// PlugLadder is a fan (or several) on a row of Tasmota smart plugs, turned
+// on one after another as the speed goes up. Typically one plug per speed
+// setting, with the fan's own switch left on.
+type PlugLadder struct {
+	broker string
+	opts   mqtt.Options
+	client *mqtt.Client
+
+	// Tasmota topics of each plug, in the order they're turned on.
+	topics []string
+}
+
+func NewPlugLadder(broker string, opts mqtt.Options, topics []string) (*PlugLadder, error) {
+	client, err := mqtt.Dial(broker, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PlugLadder{broker: broker, opts: opts, client: client, topics: topics}, nil
+}
+
+// SetSpeed turns on enough plugs to reach the speed, and the rest off.
+func (l *PlugLadder) SetSpeed(percent int) error {
+	if l.client.Err() != nil {
+		l.client.Close()
+
+		client, err := mqtt.Dial(l.broker, l.opts)
+		if err != nil {
+			return err
+		}
+		l.client = client
+	}
+
+	on := int(math.Ceil(float64(percent) / 100 * float64(len(l.topics))))
+	for i, topic := range l.topics {
+		state := "OFF"
+		if i < on {
+			state = "ON"
+		}
+
+		err := l.client.Publish(mqtt.Message{
+			Topic:   "cmnd/" + topic + "/POWER",
+			Payload: []byte(state),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package gatt
+
+import (
+	"encoding/binary"
+
+	"github.com/erik/git-commitment/metrics"
+)
+
+// The CORE body temperature sensor uses its own service, documented at
+// https://github.com/CoreBodyTemp/CoreBodyTemp
+var (
+	CoreServiceUUID        = mustParseUUID("00002100-5b1e-4347-b07c-97b514dae121")
+	CoreCharacteristicUUID = mustParseUUID("00002101-5b1e-4347-b07c-97b514dae121")
+)
+
+const (
+	CoreFlagHasSkinTemperature = 1 << 0
+	CoreFlagHasCoreReserved    = 1 << 1
+	CoreFlagHasQualityAndState = 1 << 2
+	// 0 for Celsius, 1 for Fahrenheit
+	CoreFlagFahrenheit         = 1 << 3
+	CoreFlagHasHeartRate       = 1 << 4
+	CoreFlagHasHeatStrainIndex = 1 << 5
+
+	// Bits 6-7 reserved
+)
+
+// Sent in place of a temperature the sensor doesn't have yet, e.g. while
+// it's still warming up.
+const coreTemperatureInvalid = 0x7fff
+
+// One flag byte, followed by core temperature. Everything else is optional
+// based on the flag bits set.
+//
+// sint16  core_temperature         degrees with resolution 1/100
+// sint16  skin_temperature         degrees with resolution 1/100
+// sint16  core_reserved            unused
+// uint8   quality_and_state        unused
+// uint8   heart_rate               beats per minute, from a paired strap
+// uint8   heat_strain_index        unitless with resolution 1/10
+//
+// Temperatures are always emitted in Celsius. Heart rate is left to the
+// strap it came from.
+func (d *Decoder) handleCoreTemperature(buf []byte) error {
+	if len(buf) < 3 {
+		return errShort(buf, 3)
+	}
+
+	flags := buf[0]
+	offset := 1
+
+	temperature := func(raw uint16) (float64, bool) {
+		if raw == coreTemperatureInvalid {
+			return 0, false
+		}
+
+		degrees := float64(int16(raw)) / 100
+		if flags&CoreFlagFahrenheit != 0 {
+			degrees = (degrees - 32) * 5 / 9
+		}
+		return degrees, true
+	}
+
+	if core, ok := temperature(binary.LittleEndian.Uint16(buf[offset:])); ok {
+		d.emit(metrics.Metric{
+			Kind:  metrics.CoreTemperature,
+			Value: core,
+		})
+	}
+	offset += 2
+
+	if flags&CoreFlagHasSkinTemperature != 0 {
+		if len(buf) < offset+2 {
+			return errShort(buf, offset+2)
+		}
+
+		if skin, ok := temperature(binary.LittleEndian.Uint16(buf[offset:])); ok {
+			d.emit(metrics.Metric{
+				Kind:  metrics.SkinTemperature,
+				Value: skin,
+			})
+		}
+
+		offset += 2
+	}
+	if flags&CoreFlagHasCoreReserved != 0 {
+		offset += 2
+	}
+	if flags&CoreFlagHasQualityAndState != 0 {
+		offset += 1
+	}
+	if flags&CoreFlagHasHeartRate != 0 {
+		offset += 1
+	}
+
+	if flags&CoreFlagHasHeatStrainIndex != 0 {
+		if len(buf) < offset+1 {
+			return errShort(buf, offset+1)
+		}
+
+		// Not calculated until the sensor has enough data.
+		if hsi := buf[offset]; hsi != 0xff {
+			d.emit(metrics.Metric{
+				Kind:  metrics.HeatStrainIndex,
+				Value: float64(hsi) / 10,
+			})
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package gatt
+
+// Counter follows a cumulative field reported by a sensor (revolutions,
+// event times, running totals), which rolls over once it fills the width
+// of the field on the wire.
+//
+// Sensors also start again from zero when they're reset, which usually
+// shows up after a reconnect as the counter jumping backwards. Since
+// readings arrive far more often than a counter could get halfway round,
+// any step of more than half the range is taken to be a reset rather than
+// a huge jump forwards.
+type Counter struct {
+	// Width of the field on the wire, e.g. 16 for a uint16.
+	Bits uint
+
+	initialized bool
+	last        uint32
+}
+
+func (c *Counter) mask() uint32 {
+	if c.Bits >= 32 {
+		return 0xFFFFFFFF
+	}
+	return 1<<c.Bits - 1
+}
+
+// Update stores the new reading and returns how far the counter has moved
+// since the previous one. Returns false if there's nothing to compare
+// against, i.e. for the first reading and after a reset.
+func (c *Counter) Update(value uint32) (uint32, bool) {
+	mask := c.mask()
+	value &= mask
+
+	prev, initialized := c.last, c.initialized
+	c.last, c.initialized = value, true
+
+	if !initialized {
+		return 0, false
+	}
+
+	delta := (value - prev) & mask
+	if delta > mask/2 {
+		return 0, false
+	}
+
+	return delta, true
+}
+
+// Reset forgets the previous reading, so the next one starts afresh.
+func (c *Counter) Reset() {
+	c.initialized = false
+}
+
+// RevolutionCounter follows the cumulative revolution count and event time
+// reported by speed and cadence sensors. Event times are always 16 bits,
+// in 1/1024ths of a second.
+type RevolutionCounter struct {
+	revs      Counter
+	eventTime Counter
+}
+
+// NewRevolutionCounter follows revolutions counted in a field revsBits
+// wide.
+func NewRevolutionCounter(revsBits uint) RevolutionCounter {
+	return RevolutionCounter{revs: Counter{Bits: revsBits}, eventTime: Counter{Bits: 16}}
+}
+
+// Update stores the new reading and returns the number of revolutions and
+// elapsed event time (in sensor ticks) since the previous reading.
+//
+// Returns false if there is no previous reading, either counter looks to
+// have been reset, or no new revolution event has happened since.
+func (r *RevolutionCounter) Update(revs uint32, eventTime uint16) (uint32, uint16, bool) {
+	deltaRevs, revsOk := r.revs.Update(revs)
+	deltaTime, timeOk := r.eventTime.Update(uint32(eventTime))
+
+	if !revsOk || !timeOk || deltaTime == 0 {
+		return 0, 0, false
+	}
+
+	return deltaRevs, uint16(deltaTime), true
+}
+
+// Reset forgets the previous reading, so the next one starts afresh.
+func (r *RevolutionCounter) Reset() {
+	r.revs.Reset()
+	r.eventTime.Reset()
+}
@@ -0,0 +1,107 @@
+package gatt
+
+import "testing"
+
+func TestCounter(t *testing.T) {
+	type step struct {
+		value uint32
+		delta uint32
+		ok    bool
+	}
+
+	tests := []struct {
+		name  string
+		bits  uint
+		steps []step
+	}{
+		{
+			name:  "first reading",
+			bits:  16,
+			steps: []step{{100, 0, false}, {110, 10, true}},
+		},
+		{
+			name:  "no change",
+			bits:  16,
+			steps: []step{{500, 0, false}, {500, 0, true}},
+		},
+		{
+			name:  "wraps at 16 bits",
+			bits:  16,
+			steps: []step{{65530, 0, false}, {4, 10, true}},
+		},
+		{
+			name:  "wraps at 24 bits",
+			bits:  24,
+			steps: []step{{0xFFFFF0, 0, false}, {0x10, 0x20, true}},
+		},
+		{
+			name:  "wraps at 32 bits",
+			bits:  32,
+			steps: []step{{0xFFFFFFF0, 0, false}, {0x10, 0x20, true}},
+		},
+		{
+			name:  "ignores bits past the width",
+			bits:  16,
+			steps: []step{{0x10005, 0, false}, {0x20007, 2, true}},
+		},
+		{
+			name:  "reset by the sensor",
+			bits:  16,
+			steps: []step{{1000, 0, false}, {10, 0, false}, {15, 5, true}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := Counter{Bits: test.bits}
+			for i, s := range test.steps {
+				delta, ok := c.Update(s.value)
+				if delta != s.delta || ok != s.ok {
+					t.Errorf("step %d: Update(%#x) = %d, %v, want %d, %v", i, s.value, delta, ok, s.delta, s.ok)
+				}
+			}
+		})
+	}
+}
+
+func TestCounterReset(t *testing.T) {
+	c := Counter{Bits: 16}
+	c.Update(100)
+	c.Reset()
+
+	if delta, ok := c.Update(200); ok {
+		t.Errorf("Update after Reset = %d, true, want false", delta)
+	}
+	if delta, ok := c.Update(210); delta != 10 || !ok {
+		t.Errorf("Update = %d, %v, want 10, true", delta, ok)
+	}
+}
+
+func TestRevolutionCounter(t *testing.T) {
+	r := NewRevolutionCounter(16)
+	if _, _, ok := r.Update(10, 1000); ok {
+		t.Error("first reading should have nothing to compare against")
+	}
+
+	// No new revolution event since
+	if _, _, ok := r.Update(10, 1000); ok {
+		t.Error("unchanged event time should be skipped")
+	}
+
+	revs, ticks, ok := r.Update(12, 2024)
+	if revs != 2 || ticks != 1024 || !ok {
+		t.Errorf("Update = %d, %d, %v, want 2, 1024, true", revs, ticks, ok)
+	}
+
+	// Event time wraps
+	r.Update(13, 65000)
+	revs, ticks, ok = r.Update(14, 500)
+	if revs != 1 || ticks != 1036 || !ok {
+		t.Errorf("Update = %d, %d, %v, want 1, 1036, true", revs, ticks, ok)
+	}
+
+	r.Reset()
+	if _, _, ok := r.Update(15, 1500); ok {
+		t.Error("first reading after Reset should have nothing to compare against")
+	}
+}
@@ -0,0 +1,494 @@
+package gatt
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/erik/git-commitment/metrics"
+	"tinygo.org/x/bluetooth"
+)
+
+// Decoder turns notifications from a single characteristic into metrics.
+// Some sensors only report cumulative values, so a decoder holds on to
+// whatever state it needs between notifications.
+//
+// Metrics are only partially filled in (kind and value); it's up to the
+// caller to attach where they came from.
+type Decoder struct {
+	// Held while decoding, so that the decoder can be handed on from one
+	// source to the next, see Reuse.
+	mu      sync.Mutex
+	emit    func(metrics.Metric)
+	handler func([]byte) error
+
+	// Counted from the notification goroutine, read from anywhere.
+	notifications atomic.Uint64
+	errors        atomic.Uint64
+
+	// Speed and cadence sensors only report cumulative counts, so we need
+	// to hold on to the previous reading to calculate anything.
+	wheelRevs RevolutionCounter
+	crankRevs RevolutionCounter
+
+	// Power meters can report running totals too, which roll over, and
+	// so can trainers, treadmills, ellipticals and stair climbers.
+	torque        accumulator
+	energy        accumulator
+	bikeDistance  accumulator
+	treadDistance accumulator
+	strides       accumulator
+
+	// Power vectors can be split over several notifications per crank
+	// revolution, so we piece them back together.
+	vector crankProfile
+
+	// In meters
+	WheelCircumference float64
+	distance           float64
+}
+
+// NewDecoder returns a decoder for the given characteristic, which calls
+// emit with every metric decoded. Returns an error if we don't know how to
+// decode the characteristic.
+func NewDecoder(uuid bluetooth.UUID, emit func(metrics.Metric)) (*Decoder, error) {
+	d := &Decoder{
+		emit:               emit,
+		WheelCircumference: DefaultWheelCircumference,
+
+		wheelRevs:     NewRevolutionCounter(32),
+		crankRevs:     NewRevolutionCounter(16),
+		torque:        newAccumulator(16),
+		energy:        newAccumulator(16),
+		bikeDistance:  newAccumulator(24),
+		treadDistance: newAccumulator(24),
+		strides:       newAccumulator(16),
+	}
+
+	switch uuid {
+	case bluetooth.CharacteristicUUIDCyclingPowerMeasurement:
+		d.handler = d.handleCyclingPowerMeasurement
+
+	case bluetooth.CharacteristicUUIDCyclingPowerVector:
+		d.handler = d.handleCyclingPowerVector
+
+	case bluetooth.CharacteristicUUIDHeartRateMeasurement:
+		d.handler = d.handleHeartRateMeasurement
+
+	case bluetooth.CharacteristicUUIDCSCMeasurement:
+		d.handler = d.handleSpeedCadenceMeasurement
+
+	case bluetooth.CharacteristicUUIDIndoorBikeData:
+		d.handler = d.handleIndoorBikeData
+
+	case bluetooth.CharacteristicUUIDTreadmillData:
+		d.handler = d.handleTreadmillData
+
+	case bluetooth.CharacteristicUUIDCrossTrainerData:
+		d.handler = d.handleCrossTrainerData
+
+	case bluetooth.CharacteristicUUIDStairClimberData:
+		d.handler = d.handleStairClimberData
+
+	case bluetooth.CharacteristicUUIDRSCMeasurement:
+		d.handler = d.handleRunningSpeedCadenceMeasurement
+
+	case CoreCharacteristicUUID:
+		d.handler = d.handleCoreTemperature
+
+	default:
+		return nil, fmt.Errorf("no decoder for characteristic: %s", uuid.String())
+	}
+
+	return d, nil
+}
+
+// ErrMalformed is returned for notifications which don't match the spec,
+// usually because they're cut short.
+var ErrMalformed = errors.New("malformed notification")
+
+func errShort(buf []byte, want int) error {
+	return fmt.Errorf("%w: %d bytes, expected at least %d", ErrMalformed, len(buf), want)
+}
+
+// DecodeStats counts the notifications a decoder has seen.
+type DecodeStats struct {
+	Notifications uint64 `json:"notifications"`
+	Errors        uint64 `json:"errors"`
+}
+
+// Decode a single notification. Anything decoded before running into a
+// problem is still emitted.
+func (d *Decoder) Decode(buf []byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.notifications.Add(1)
+
+	err := d.handler(buf)
+	if err != nil {
+		d.errors.Add(1)
+	}
+
+	return err
+}
+
+// Reuse hands the decoder on to a new emit, e.g. after reconnecting to
+// the same sensor, so that running totals such as distance carry on from
+// where they were rather than starting again from zero. Speed and cadence
+// start afresh, since the time between readings across a reconnect says
+// nothing about how fast anything was going.
+func (d *Decoder) Reuse(emit func(metrics.Metric)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.emit = emit
+	d.wheelRevs.Reset()
+	d.crankRevs.Reset()
+	d.vector = crankProfile{}
+}
+
+// Stats is how many notifications have been decoded so far, and how many
+// of them failed.
+func (d *Decoder) Stats() DecodeStats {
+	return DecodeStats{
+		Notifications: d.notifications.Load(),
+		Errors:        d.errors.Load(),
+	}
+}
+
+const (
+	// BPM size, 0 if u8, 1 if u16
+	HeartRateFlagSize = 1 << 0
+
+	// 00 unsupported
+	// 01 unsupported
+	// 10 supported, not detected
+	// 11 supported, detected
+	HeartRateFlagContactStatus = (1 << 1) | (1 << 2)
+
+	HeartRateFlagHasEnergyExpended = 1 << 3
+	HeartRateFlagHasRRInterval     = 1 << 4
+
+	// bits 5-8 reserved
+)
+
+func (d *Decoder) handleHeartRateMeasurement(buf []byte) error {
+	if len(buf) < 2 {
+		return errShort(buf, 2)
+	}
+
+	flag := buf[0]
+
+	is16Bit := (flag & HeartRateFlagSize) != 0
+	contactStatus := (flag & HeartRateFlagContactStatus) >> 1
+
+	contactSupported := contactStatus&(0b10) != 0
+	contactFound := contactStatus&(0b01) != 0
+
+	// No use sending this metric if the sensor isn't reading.
+	if contactSupported && !contactFound {
+		return nil
+	}
+
+	var hr int = int(buf[1])
+	offset := 2
+	if is16Bit {
+		if len(buf) < 3 {
+			return errShort(buf, 3)
+		}
+
+		hr = int(int16(binary.LittleEndian.Uint16(buf[1:])))
+		offset = 3
+	}
+
+	d.emit(metrics.Metric{
+		Kind:  metrics.HeartRate,
+		Value: float64(hr),
+	})
+
+	// Cumulative since the sensor was last reset, in kilojoules. Sensors
+	// will typically only include this every few packets.
+	if flag&HeartRateFlagHasEnergyExpended != 0 {
+		if len(buf) < offset+2 {
+			return errShort(buf, offset+2)
+		}
+
+		energy := binary.LittleEndian.Uint16(buf[offset:])
+		d.emit(metrics.Metric{
+			Kind:  metrics.EnergyExpended,
+			Value: float64(energy),
+		})
+
+		offset += 2
+	}
+
+	// Any number of RR intervals may follow, oldest first, each a uint16
+	// with resolution 1/1024s.
+	if flag&HeartRateFlagHasRRInterval != 0 {
+		for ; offset+2 <= len(buf); offset += 2 {
+			rr := binary.LittleEndian.Uint16(buf[offset:])
+
+			d.emit(metrics.Metric{
+				Kind:  metrics.HeartRateRRInterval,
+				Value: float64(rr) / 1024 * 1000,
+			})
+		}
+	}
+
+	return nil
+}
+
+const (
+	CyclingPowerFlagHasPedalPowerBalance           = 1 << 0
+	CyclingPowerFlagPedalPowerBalanceReference     = 1 << 1
+	CyclingPowerFlagHasAccumulatedTorque           = 1 << 2
+	CyclingPowerFlagAccumulatedTorqueSource        = 1 << 3
+	CyclingPowerFlagHasWheelRevolution             = 1 << 4
+	CyclingPowerFlagHasCrankRevolution             = 1 << 5
+	CyclingPowerFlagHasExtremeForceMagnitudes      = 1 << 6
+	CyclingPowerFlagHasExtremeTorqueMagnitudes     = 1 << 7
+	CyclingPowerFlagHasExtremeAngles               = 1 << 8
+	CyclingPowerFlagHasTopDeadSpotAngle            = 1 << 9
+	CyclingPowerFlagHasBottomDeadSpotAngle         = 1 << 10
+	CyclingPowerFlagHasAccumulatedEnergy           = 1 << 11
+	CyclingPowerFlagHasOffsetCompensationIndicator = 1 << 12
+
+	// Bits 13-16 reserved
+)
+
+// Two flag bytes, followed by a 16 bit power reading. All subsequent
+// fields are optional, based on the flag bits set.
+//
+// sint16  instantaneous_power      watts with resolution 1
+// uint8   pedal_power_balance      percentage with resolution 1/2
+// uint16  accumulated_torque       newton meters with resolution 1/32
+// uint32  wheel_rev_cumulative     unitless
+// uint16  wheel_rev_last_time      seconds with resolution 1/2048
+// uint16  crank_rev_cumulative     unitless
+// uint16  crank_rev_last_time      seconds with resolution 1/1024
+// sint16  extreme_force_max_magn   newtons with resolution 1
+// sint16  extreme_force_min_magn   newtons with resolution 1
+// sint16  extreme_torque_max_magn  newton meters with resolution 1/32
+// sint16  extreme_torque_min_magn  newton meters with resolution 1/32
+// uint12  extreme_angles_max       degrees with resolution 1
+// uint12  extreme_angles_min       degrees with resolution 1
+// uint16  top_dead_spot_angle      degrees with resolution 1
+// uint16  bottom_dead_spot_angle   degrees with resolution 1
+// uint16  accumulated_energy       kilojoules with resolution 1
+func (d *Decoder) handleCyclingPowerMeasurement(buf []byte) error {
+	if len(buf) < 4 {
+		return errShort(buf, 4)
+	}
+
+	flags := binary.LittleEndian.Uint16(buf[0:])
+	powerWatts := int16(binary.LittleEndian.Uint16(buf[2:]))
+
+	// Power meters keep sending zeros while coasting, which need passing
+	// on: everything downstream holds the last power it saw.
+	d.emit(metrics.Metric{
+		Kind:  metrics.CyclingPower,
+		Value: float64(powerWatts),
+	})
+
+	// These fields are optional, so we need to index over them, can't skip directly.
+	offset := 4
+	if flags&CyclingPowerFlagHasPedalPowerBalance != 0 {
+		if len(buf) < offset+1 {
+			return errShort(buf, offset+1)
+		}
+
+		// Without the reference we can't tell which pedal the balance is
+		// for, and it's meaningless while coasting.
+		if flags&CyclingPowerFlagPedalPowerBalanceReference != 0 && powerWatts > 0 {
+			d.emit(metrics.Metric{
+				Kind:  metrics.PedalPowerBalance,
+				Value: float64(buf[offset]) / 2,
+			})
+		}
+
+		offset += 1
+	}
+	if flags&CyclingPowerFlagHasAccumulatedTorque != 0 {
+		if len(buf) < offset+2 {
+			return errShort(buf, offset+2)
+		}
+
+		torque := d.torque.update(uint32(binary.LittleEndian.Uint16(buf[offset:])))
+		d.emit(metrics.Metric{
+			Kind:  metrics.AccumulatedTorque,
+			Value: float64(torque) / 32,
+		})
+
+		offset += 2
+	}
+
+	if flags&CyclingPowerFlagHasWheelRevolution != 0 {
+		if len(buf) < offset+6 {
+			return errShort(buf, offset+6)
+		}
+
+		rev := binary.LittleEndian.Uint32(buf[offset:])
+		time := binary.LittleEndian.Uint16(buf[offset+4:])
+
+		// Note that this is a different resolution than CSC uses.
+		d.updateWheelRevolutions(rev, time, 2048)
+
+		offset += 4 + 2
+	}
+
+	if flags&CyclingPowerFlagHasCrankRevolution != 0 {
+		if len(buf) < offset+4 {
+			return errShort(buf, offset+4)
+		}
+
+		rev := binary.LittleEndian.Uint16(buf[offset:])
+		time := binary.LittleEndian.Uint16(buf[offset+2:])
+		d.updateCrankRevolutions(rev, time)
+
+		offset += 2 + 2
+	}
+
+	// Nothing we use between here and the accumulated energy.
+	skip := []struct {
+		flag uint16
+		size int
+	}{
+		{CyclingPowerFlagHasExtremeForceMagnitudes, 2 + 2},
+		{CyclingPowerFlagHasExtremeTorqueMagnitudes, 2 + 2},
+		{CyclingPowerFlagHasExtremeAngles, 3},
+		{CyclingPowerFlagHasTopDeadSpotAngle, 2},
+		{CyclingPowerFlagHasBottomDeadSpotAngle, 2},
+	}
+	for _, field := range skip {
+		if flags&field.flag != 0 {
+			offset += field.size
+		}
+	}
+
+	if flags&CyclingPowerFlagHasAccumulatedEnergy != 0 {
+		if len(buf) < offset+2 {
+			return errShort(buf, offset+2)
+		}
+
+		d.emit(metrics.Metric{
+			Kind:  metrics.AccumulatedEnergy,
+			Value: float64(d.energy.update(uint32(binary.LittleEndian.Uint16(buf[offset:])))),
+		})
+	}
+
+	return nil
+}
+
+// Circumference of a 700x25c tire, in meters.
+const DefaultWheelCircumference = 2.105
+
+// accumulator turns a cumulative counter which rolls over into a running
+// total since the first reading. Resets are skipped over, so the total
+// never goes backwards.
+type accumulator struct {
+	counter Counter
+	total   uint64
+}
+
+func newAccumulator(bits uint) accumulator {
+	return accumulator{counter: Counter{Bits: bits}}
+}
+
+// update stores the new reading and returns the total so far, in the same
+// units as the counter.
+func (a *accumulator) update(value uint32) uint64 {
+	if delta, ok := a.counter.Update(value); ok {
+		a.total += uint64(delta)
+	}
+
+	return a.total
+}
+
+const (
+	CSCFlagHasWheelRevolution = 1 << 0
+	CSCFlagHasCrankRevolution = 1 << 1
+
+	// Bits 2-7 reserved
+)
+
+// One flag byte, with all subsequent fields optional based on which bits
+// are set.
+//
+// uint32  wheel_rev_cumulative     unitless
+// uint16  wheel_rev_last_time      seconds with resolution 1/1024
+// uint16  crank_rev_cumulative     unitless
+// uint16  crank_rev_last_time      seconds with resolution 1/1024
+func (d *Decoder) handleSpeedCadenceMeasurement(buf []byte) error {
+	if len(buf) < 1 {
+		return errShort(buf, 1)
+	}
+
+	flags := buf[0]
+	offset := 1
+
+	if flags&CSCFlagHasWheelRevolution != 0 {
+		if len(buf) < offset+6 {
+			return errShort(buf, offset+6)
+		}
+
+		rev := binary.LittleEndian.Uint32(buf[offset:])
+		time := binary.LittleEndian.Uint16(buf[offset+4:])
+		d.updateWheelRevolutions(rev, time, 1024)
+
+		offset += 4 + 2
+	}
+
+	if flags&CSCFlagHasCrankRevolution != 0 {
+		if len(buf) < offset+4 {
+			return errShort(buf, offset+4)
+		}
+
+		rev := binary.LittleEndian.Uint16(buf[offset:])
+		time := binary.LittleEndian.Uint16(buf[offset+2:])
+		d.updateCrankRevolutions(rev, time)
+	}
+
+	return nil
+}
+
+// Wheel revolution data is a 32 bit cumulative count of revolutions, plus
+// the last event time, the resolution of which depends on the service.
+func (d *Decoder) updateWheelRevolutions(rev uint32, eventTime uint16, ticksPerSecond float64) {
+	revs, ticks, ok := d.wheelRevs.Update(rev, eventTime)
+	if !ok {
+		return
+	}
+
+	meters := float64(revs) * d.WheelCircumference
+	seconds := float64(ticks) / ticksPerSecond
+
+	d.distance += meters
+
+	d.emit(metrics.Metric{
+		Kind:  metrics.CyclingSpeed,
+		Value: meters / seconds * 3.6,
+	})
+	d.emit(metrics.Metric{
+		Kind:  metrics.CyclingDistance,
+		Value: d.distance,
+	})
+}
+
+// Crank revolution data is encoded identically for both CSC and Cycling
+// Power: 16 bit cumulative revolutions, and the last event time with
+// resolution 1/1024s.
+func (d *Decoder) updateCrankRevolutions(rev, eventTime uint16) {
+	revs, ticks, ok := d.crankRevs.Update(uint32(rev), eventTime)
+	if !ok {
+		return
+	}
+
+	seconds := float64(ticks) / 1024
+	d.emit(metrics.Metric{
+		Kind:  metrics.CyclingCadence,
+		Value: float64(revs) / seconds * 60,
+	})
+}
@@ -0,0 +1,99 @@
+package gatt
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// Encoders for the same measurements we decode, for when we're the one
+// sending them.
+
+// EncodeHeartRateMeasurement builds a heart rate measurement with only the
+// heart rate set, using the 8 bit format whenever it fits.
+func EncodeHeartRateMeasurement(bpm float64) []byte {
+	value := uint16(math.Round(math.Max(0, math.Min(bpm, math.MaxUint16))))
+	if value <= math.MaxUint8 {
+		return []byte{0, byte(value)}
+	}
+
+	buf := []byte{HeartRateFlagSize, 0, 0}
+	binary.LittleEndian.PutUint16(buf[1:], value)
+	return buf
+}
+
+// EncodeCyclingPowerMeasurement builds a cycling power measurement with the
+// instantaneous power, and crank revolution data if crank is non-nil.
+func EncodeCyclingPowerMeasurement(watts float64, crank *CrankRevolutions) []byte {
+	var flags uint16
+	if crank != nil {
+		flags |= CyclingPowerFlagHasCrankRevolution
+	}
+
+	power := int16(math.Round(math.Max(math.MinInt16, math.Min(watts, math.MaxInt16))))
+
+	buf := make([]byte, 4, 8)
+	binary.LittleEndian.PutUint16(buf[0:], flags)
+	binary.LittleEndian.PutUint16(buf[2:], uint16(power))
+
+	if crank != nil {
+		buf = append(buf, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint16(buf[4:], crank.Revs)
+		binary.LittleEndian.PutUint16(buf[6:], crank.EventTime)
+	}
+
+	return buf
+}
+
+// CyclingPowerFeatureCrankRevolution is the Cycling Power Feature bit
+// saying crank revolution data is supported.
+const CyclingPowerFeatureCrankRevolution = 1 << 3
+
+// CrankRevolutions is cumulative crank revolution data, as sent by power
+// meters and cadence sensors. Both fields roll over.
+type CrankRevolutions struct {
+	Revs uint16
+	// Time of the last revolution, in seconds with resolution 1/1024
+	EventTime uint16
+}
+
+// CrankCounter makes up crank revolution data from cadence readings, for
+// when we only have the cadence but need to send revolutions.
+type CrankCounter struct {
+	start time.Time
+	last  time.Time
+
+	// Fractional revolutions, so slow cadences still add up.
+	revs float64
+	// Last whole revolution, in seconds since start.
+	eventTime float64
+}
+
+// Update advances the count to now at the given cadence (in RPM), and
+// returns the resulting revolution data.
+func (c *CrankCounter) Update(cadence float64, now time.Time) CrankRevolutions {
+	if c.start.IsZero() {
+		c.start = now
+		c.last = now
+	}
+
+	elapsed := now.Sub(c.last).Seconds()
+	c.last = now
+
+	if cadence > 0 && elapsed > 0 {
+		before := math.Floor(c.revs)
+		c.revs += cadence / 60 * elapsed
+
+		// Backdate the event to when the last whole revolution
+		// would have finished.
+		if whole := math.Floor(c.revs); whole > before {
+			sinceLast := (c.revs - whole) / (cadence / 60)
+			c.eventTime = now.Sub(c.start).Seconds() - sinceLast
+		}
+	}
+
+	return CrankRevolutions{
+		Revs:      uint16(uint64(c.revs)),
+		EventTime: uint16(uint64(c.eventTime * 1024)),
+	}
+}
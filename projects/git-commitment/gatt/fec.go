@@ -0,0 +1,237 @@
+package gatt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"math"
+
+	"tinygo.org/x/bluetooth"
+)
+
+// Tacx trainers from before FTMS (and many since) tunnel ANT+ FE-C over
+// BLE: each write is a full ANT message carrying an 8 byte data page. See
+// the "FE-C over BLE" application note on the Tacx developer site.
+//
+// The trainer also sends FE-C pages back on a notify characteristic. We
+// only read calibration results from them, everything else is already in
+// its Cycling Power service.
+var (
+	TacxFECServiceUUID              = mustParseUUID("6e40fec1-b5a3-f393-e0a9-e50e24dcca9e")
+	TacxFECCharacteristicUUID       = mustParseUUID("6e40fec3-b5a3-f393-e0a9-e50e24dcca9e")
+	TacxFECNotifyCharacteristicUUID = mustParseUUID("6e40fec2-b5a3-f393-e0a9-e50e24dcca9e")
+)
+
+// FE-C data pages we send, or read calibration results from. The same
+// pages work over ANT+ and BLE.
+const (
+	FECPageCalibrationRequest    = 0x01
+	FECPageCalibrationResponse   = 0x01
+	FECPageCalibrationInProgress = 0x02
+	FECPageBasicResistance       = 0x30
+	FECPageTargetPower           = 0x31
+	FECPageWindResistance        = 0x32
+	FECPageTrackResistance       = 0x33
+)
+
+const (
+	fecCalibrationSpinDown   = 1 << 7
+	fecCalibrationZeroOffset = 1 << 6
+
+	// Speed condition in bits 6-7 of the calibration in progress page.
+	fecSpeedConditionOK = 0x02
+)
+
+// ANT framing around each page written over BLE.
+const (
+	fecSync           = 0xA4
+	fecMsgAcknowledge = 0x4F
+	fecChannel        = 0x05
+)
+
+// FECCapabilities are what any FE-C trainer supports, whichever way the
+// pages get to it.
+var FECCapabilities = TrainerCapabilities{Power: true, Grade: true, Resistance: true, Calibrate: true}
+
+// uint8   page_number              0x31
+// ...     reserved                 5 bytes
+// uint16  target_power             watts with resolution 1/4
+func FECTargetPowerPage(watts int) []byte {
+	power := uint16(math.Min(math.Max(float64(watts), 0), 4000) * 4)
+
+	page := []byte{FECPageTargetPower, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0, 0}
+	binary.LittleEndian.PutUint16(page[6:], power)
+	return page
+}
+
+// uint8   page_number              0x30
+// ...     reserved                 6 bytes
+// uint8   total_resistance         percent with resolution 1/2
+func FECBasicResistancePage(percent float64) []byte {
+	percent = math.Min(math.Max(percent, 0), 100)
+	return []byte{FECPageBasicResistance, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, uint8(math.Round(percent * 2))}
+}
+
+// uint8   page_number              0x32
+// ...     reserved                 4 bytes
+// uint8   wind_resistance          kg/m with resolution 1/100
+// sint8   wind_speed               km/h, offset by 127
+// uint8   drafting_factor          0xFF for none
+func FECWindResistancePage(params SimulationParams) []byte {
+	wind := math.Min(math.Max(params.WindSpeed*3.6, -127), 127)
+	cw := math.Min(math.Max(params.Cw, 0), 1.86)
+
+	return []byte{
+		FECPageWindResistance, 0xFF, 0xFF, 0xFF, 0xFF,
+		uint8(math.Round(cw * 100)),
+		uint8(math.Round(wind + 127)),
+		0xFF,
+	}
+}
+
+// uint8   page_number              0x33
+// ...     reserved                 4 bytes
+// uint16  grade                    percent with resolution 1/100, offset by 200
+// uint8   crr                      unitless with resolution 5/100000
+func FECTrackResistancePage(params SimulationParams) []byte {
+	grade := math.Min(math.Max(params.Grade, -200), 200)
+	crr := math.Min(math.Max(params.Crr, 0), 0.0127)
+
+	page := []byte{FECPageTrackResistance, 0xFF, 0xFF, 0xFF, 0xFF, 0, 0, uint8(math.Round(crr / 0.00005))}
+	binary.LittleEndian.PutUint16(page[5:], uint16(math.Round((grade+200)*100)))
+	return page
+}
+
+// uint8   page_number              0x01
+// uint8   mode                     see fecCalibrationSpinDown
+// ...     reserved                 6 bytes
+func FECSpinDownPage() []byte {
+	return []byte{FECPageCalibrationRequest, fecCalibrationSpinDown, 0, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+}
+
+// DecodeFECCalibration turns a page from the trainer into calibration
+// progress, if it's one of the calibration pages.
+//
+// uint8   page_number              0x02
+// uint8   status                   see fecCalibrationSpinDown, set if pending
+// uint8   conditions               speed condition in bits 6-7
+// uint8   temperature              unused
+// uint16  target_speed             meters per second with resolution 1/1000
+// uint16  target_spin_down_time    unused
+//
+// uint8   page_number              0x01
+// uint8   status                   see fecCalibrationSpinDown, set if successful
+// uint8   temperature              unused
+// uint16  zero_offset              unused
+// uint16  spin_down_time           milliseconds, 0xFFFF if not done
+// uint8   reserved
+func DecodeFECCalibration(page []byte) (CalibrationEvent, bool) {
+	if len(page) < 8 {
+		return CalibrationEvent{}, false
+	}
+
+	switch page[0] {
+	case FECPageCalibrationInProgress:
+		event := CalibrationEvent{Message: "spin down in progress"}
+		if speed := binary.LittleEndian.Uint16(page[4:]); speed != 0xFFFF {
+			event.TargetSpeed = float64(speed) / 1000 * 3.6
+		}
+		if page[2]>>6 == fecSpeedConditionOK {
+			event.StopPedaling = true
+			event.Message = "spin down: stop pedaling"
+		}
+		return event, true
+
+	case FECPageCalibrationResponse:
+		event := CalibrationEvent{Done: true, Message: "spin down calibration complete"}
+		if page[1]&fecCalibrationSpinDown == 0 {
+			event.Failed = true
+			event.Message = "spin down calibration failed"
+		} else if ms := binary.LittleEndian.Uint16(page[5:]); ms != 0xFFFF {
+			event.Message = fmt.Sprintf("spin down time %.2fs", float64(ms)/1000)
+		}
+		return event, true
+	}
+
+	return CalibrationEvent{}, false
+}
+
+// TacxFECControl drives a trainer through FE-C pages written to the Tacx
+// FE-C over BLE characteristic.
+type TacxFECControl struct {
+	ch *bluetooth.DeviceCharacteristic
+
+	calibration CalibrationListener
+}
+
+// NewTacxFECControl needs no handshake, the trainer takes pages as soon as
+// we're connected. Pages sent back on notify are only needed for
+// calibration results, so it may be nil.
+func NewTacxFECControl(ch, notify *bluetooth.DeviceCharacteristic) (*TacxFECControl, error) {
+	ctrl := &TacxFECControl{ch: ch}
+
+	if notify != nil {
+		if err := notify.EnableNotifications(ctrl.handlePage); err != nil {
+			return nil, err
+		}
+	}
+
+	return ctrl, nil
+}
+
+// uint8   sync                     always 0xA4
+// uint8   length                   always 9
+// uint8   message_id               acknowledged data
+// uint8   channel                  always 5
+// ...     page                     8 bytes
+// uint8   checksum                 XOR of everything before it
+func (ctrl *TacxFECControl) write(page []byte) error {
+	buf := append([]byte{fecSync, byte(len(page) + 1), fecMsgAcknowledge, fecChannel}, page...)
+
+	var checksum byte
+	for _, b := range buf {
+		checksum ^= b
+	}
+
+	_, err := ctrl.ch.WriteWithoutResponse(append(buf, checksum))
+	return err
+}
+
+func (ctrl *TacxFECControl) SetTargetPower(watts int) error {
+	return ctrl.write(FECTargetPowerPage(watts))
+}
+
+// Level is a percentage of the trainer's maximum resistance.
+func (ctrl *TacxFECControl) SetResistance(level float64) error {
+	return ctrl.write(FECBasicResistancePage(level))
+}
+
+// Wind and grade go in separate pages, and the trainer combines them.
+func (ctrl *TacxFECControl) SetGrade(params SimulationParams) error {
+	if err := ctrl.write(FECWindResistancePage(params)); err != nil {
+		return err
+	}
+	return ctrl.write(FECTrackResistancePage(params))
+}
+
+// Calibrate asks the trainer for a spindown.
+func (ctrl *TacxFECControl) Calibrate(onEvent func(CalibrationEvent)) error {
+	ctrl.calibration.Listen(onEvent)
+	return ctrl.write(FECSpinDownPage())
+}
+
+// Framed the same way as what we write, see write.
+func (ctrl *TacxFECControl) handlePage(buf []byte) {
+	if len(buf) < 12 || buf[0] != fecSync {
+		slog.Debug("dropping malformed FE-C message", "message", fmt.Sprintf("% x", buf))
+		return
+	}
+
+	if event, ok := DecodeFECCalibration(buf[4:12]); ok {
+		ctrl.calibration.Report(event)
+	}
+}
+
+func (ctrl *TacxFECControl) Capabilities() TrainerCapabilities {
+	return FECCapabilities
+}
@@ -0,0 +1,833 @@
+package gatt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
+
+	"github.com/erik/git-commitment/metrics"
+	"tinygo.org/x/bluetooth"
+)
+
+// https://www.bluetooth.com/specifications/specs/fitness-machine-service-1-0/
+
+const (
+	// Note that this one is inverted: instantaneous speed is present
+	// only when the bit is *not* set.
+	IndoorBikeFlagMoreData                = 1 << 0
+	IndoorBikeFlagHasAverageSpeed         = 1 << 1
+	IndoorBikeFlagHasInstantaneousCadence = 1 << 2
+	IndoorBikeFlagHasAverageCadence       = 1 << 3
+	IndoorBikeFlagHasTotalDistance        = 1 << 4
+	IndoorBikeFlagHasResistanceLevel      = 1 << 5
+	IndoorBikeFlagHasInstantaneousPower   = 1 << 6
+	IndoorBikeFlagHasAveragePower         = 1 << 7
+	IndoorBikeFlagHasExpendedEnergy       = 1 << 8
+	IndoorBikeFlagHasHeartRate            = 1 << 9
+	IndoorBikeFlagHasMetabolicEquivalent  = 1 << 10
+	IndoorBikeFlagHasElapsedTime          = 1 << 11
+	IndoorBikeFlagHasRemainingTime        = 1 << 12
+
+	// Bits 13-16 reserved
+)
+
+// Two flag bytes, with all subsequent fields optional based on the flag
+// bits set.
+//
+// uint16  instantaneous_speed      km/h with resolution 1/100
+// uint16  average_speed            km/h with resolution 1/100
+// uint16  instantaneous_cadence    rpm with resolution 1/2
+// uint16  average_cadence          rpm with resolution 1/2
+// uint24  total_distance           meters with resolution 1
+// sint16  resistance_level         unitless
+// sint16  instantaneous_power      watts with resolution 1
+// sint16  average_power            watts with resolution 1
+// uint16  total_energy             kilocalories with resolution 1
+// uint16  energy_per_hour          kilocalories with resolution 1
+// uint8   energy_per_minute        kilocalories with resolution 1
+// uint8   heart_rate               beats per minute with resolution 1
+// uint8   metabolic_equivalent     with resolution 1/10
+// uint16  elapsed_time             seconds with resolution 1
+// uint16  remaining_time           seconds with resolution 1
+//
+// Trainers may split a single measurement across multiple notifications,
+// which is what the "more data" flag is for, but since every field is
+// optional we can treat each notification independently.
+func (d *Decoder) handleIndoorBikeData(buf []byte) error {
+	if len(buf) < 2 {
+		return errShort(buf, 2)
+	}
+
+	flags := binary.LittleEndian.Uint16(buf[0:])
+	offset := 2
+
+	if flags&IndoorBikeFlagMoreData == 0 {
+		if len(buf) < offset+2 {
+			return errShort(buf, offset+2)
+		}
+
+		speed := binary.LittleEndian.Uint16(buf[offset:])
+		d.emit(metrics.Metric{
+			Kind:  metrics.CyclingSpeed,
+			Value: float64(speed) / 100,
+		})
+
+		offset += 2
+	}
+	if flags&IndoorBikeFlagHasAverageSpeed != 0 {
+		offset += 2
+	}
+
+	if flags&IndoorBikeFlagHasInstantaneousCadence != 0 {
+		if len(buf) < offset+2 {
+			return errShort(buf, offset+2)
+		}
+
+		cadence := binary.LittleEndian.Uint16(buf[offset:])
+		d.emit(metrics.Metric{
+			Kind:  metrics.CyclingCadence,
+			Value: float64(cadence) / 2,
+		})
+
+		offset += 2
+	}
+	if flags&IndoorBikeFlagHasAverageCadence != 0 {
+		offset += 2
+	}
+
+	if flags&IndoorBikeFlagHasTotalDistance != 0 {
+		if len(buf) < offset+3 {
+			return errShort(buf, offset+3)
+		}
+
+		distance := uint32(buf[offset]) |
+			uint32(buf[offset+1])<<8 |
+			uint32(buf[offset+2])<<16
+		d.emit(metrics.Metric{
+			Kind:  metrics.CyclingDistance,
+			Value: float64(d.bikeDistance.update(distance)),
+		})
+
+		offset += 3
+	}
+	if flags&IndoorBikeFlagHasResistanceLevel != 0 {
+		offset += 2
+	}
+
+	if flags&IndoorBikeFlagHasInstantaneousPower != 0 {
+		if len(buf) < offset+2 {
+			return errShort(buf, offset+2)
+		}
+
+		// Zeros included, same as with the power meters.
+		powerWatts := int16(binary.LittleEndian.Uint16(buf[offset:]))
+		d.emit(metrics.Metric{
+			Kind:  metrics.CyclingPower,
+			Value: float64(powerWatts),
+		})
+
+		offset += 2
+	}
+
+	// Remaining fields aren't used yet.
+
+	return nil
+}
+
+const (
+	// Inverted like the indoor bike's.
+	TreadmillFlagMoreData               = 1 << 0
+	TreadmillFlagHasAverageSpeed        = 1 << 1
+	TreadmillFlagHasTotalDistance       = 1 << 2
+	TreadmillFlagHasInclination         = 1 << 3
+	TreadmillFlagHasElevationGain       = 1 << 4
+	TreadmillFlagHasInstantaneousPace   = 1 << 5
+	TreadmillFlagHasAveragePace         = 1 << 6
+	TreadmillFlagHasExpendedEnergy      = 1 << 7
+	TreadmillFlagHasHeartRate           = 1 << 8
+	TreadmillFlagHasMetabolicEquivalent = 1 << 9
+	TreadmillFlagHasElapsedTime         = 1 << 10
+	TreadmillFlagHasRemainingTime       = 1 << 11
+	TreadmillFlagHasForceAndPower       = 1 << 12
+
+	// Bits 13-16 reserved
+)
+
+// Two flag bytes, with all subsequent fields optional based on the flag
+// bits set.
+//
+// uint16  instantaneous_speed      km/h with resolution 1/100
+// uint16  average_speed            km/h with resolution 1/100
+// uint24  total_distance           meters with resolution 1
+// sint16  inclination              percent with resolution 1/10
+// sint16  ramp_angle               degrees with resolution 1/10
+// uint16  positive_elevation_gain  meters with resolution 1/10
+// uint16  negative_elevation_gain  meters with resolution 1/10
+// uint8   instantaneous_pace       km/min with resolution 1/10
+// uint8   average_pace             km/min with resolution 1/10
+// ...
+//
+// Pace is only used when there's no speed, since it's so much coarser.
+// Nothing after it is used yet.
+func (d *Decoder) handleTreadmillData(buf []byte) error {
+	if len(buf) < 2 {
+		return errShort(buf, 2)
+	}
+
+	flags := binary.LittleEndian.Uint16(buf[0:])
+	offset := 2
+
+	hasSpeed := flags&TreadmillFlagMoreData == 0
+	if hasSpeed {
+		if len(buf) < offset+2 {
+			return errShort(buf, offset+2)
+		}
+
+		// Belts keep reporting while stopped, and pace isn't defined
+		// when we're not moving.
+		if speed := float64(binary.LittleEndian.Uint16(buf[offset:])) / 100; speed > 0 {
+			d.emit(metrics.Metric{
+				Kind:  metrics.RunningPace,
+				Value: 3600 / speed,
+			})
+		}
+
+		offset += 2
+	}
+	if flags&TreadmillFlagHasAverageSpeed != 0 {
+		offset += 2
+	}
+
+	if flags&TreadmillFlagHasTotalDistance != 0 {
+		if len(buf) < offset+3 {
+			return errShort(buf, offset+3)
+		}
+
+		distance := uint32(buf[offset]) |
+			uint32(buf[offset+1])<<8 |
+			uint32(buf[offset+2])<<16
+		d.emit(metrics.Metric{
+			Kind:  metrics.RunningDistance,
+			Value: float64(d.treadDistance.update(distance)),
+		})
+
+		offset += 3
+	}
+
+	if flags&TreadmillFlagHasInclination != 0 {
+		if len(buf) < offset+4 {
+			return errShort(buf, offset+4)
+		}
+
+		incline := int16(binary.LittleEndian.Uint16(buf[offset:]))
+		d.emit(metrics.Metric{
+			Kind:  metrics.Incline,
+			Value: float64(incline) / 10,
+		})
+
+		offset += 4
+	}
+	if flags&TreadmillFlagHasElevationGain != 0 {
+		offset += 4
+	}
+
+	if flags&TreadmillFlagHasInstantaneousPace != 0 && !hasSpeed {
+		if len(buf) < offset+1 {
+			return errShort(buf, offset+1)
+		}
+
+		if kmPerMin := float64(buf[offset]) / 10; kmPerMin > 0 {
+			d.emit(metrics.Metric{
+				Kind:  metrics.RunningPace,
+				Value: 60 / kmPerMin,
+			})
+		}
+	}
+
+	return nil
+}
+
+const (
+	// Inverted like the indoor bike's.
+	CrossTrainerFlagMoreData               = 1 << 0
+	CrossTrainerFlagHasAverageSpeed        = 1 << 1
+	CrossTrainerFlagHasTotalDistance       = 1 << 2
+	CrossTrainerFlagHasStepCount           = 1 << 3
+	CrossTrainerFlagHasStrideCount         = 1 << 4
+	CrossTrainerFlagHasElevationGain       = 1 << 5
+	CrossTrainerFlagHasInclination         = 1 << 6
+	CrossTrainerFlagHasResistanceLevel     = 1 << 7
+	CrossTrainerFlagHasInstantaneousPower  = 1 << 8
+	CrossTrainerFlagHasAveragePower        = 1 << 9
+	CrossTrainerFlagHasExpendedEnergy      = 1 << 10
+	CrossTrainerFlagHasHeartRate           = 1 << 11
+	CrossTrainerFlagHasMetabolicEquivalent = 1 << 12
+	CrossTrainerFlagHasElapsedTime         = 1 << 13
+	CrossTrainerFlagHasRemainingTime       = 1 << 14
+	CrossTrainerFlagMovingBackward         = 1 << 15
+
+	// Bits 16-23 reserved
+)
+
+// Three flag bytes, with all subsequent fields optional based on the flag
+// bits set.
+//
+// uint16  instantaneous_speed      km/h with resolution 1/100
+// uint16  average_speed            km/h with resolution 1/100
+// uint24  total_distance           meters with resolution 1
+// uint16  step_per_minute          steps per minute with resolution 1
+// uint16  average_step_rate        steps per minute with resolution 1
+// uint16  stride_count             strides with resolution 1/10
+// uint16  positive_elevation_gain  meters with resolution 1
+// uint16  negative_elevation_gain  meters with resolution 1
+// sint16  inclination              percent with resolution 1/10
+// sint16  ramp_angle               degrees with resolution 1/10
+// sint16  resistance_level         unitless with resolution 1/10
+// sint16  instantaneous_power      watts with resolution 1
+// ...
+//
+// Speed doesn't mean much on an elliptical, so we only report distance.
+// Nothing after power is used yet.
+func (d *Decoder) handleCrossTrainerData(buf []byte) error {
+	if len(buf) < 3 {
+		return errShort(buf, 3)
+	}
+
+	flags := uint32(buf[0]) | uint32(buf[1])<<8 | uint32(buf[2])<<16
+	offset := 3
+
+	if flags&CrossTrainerFlagMoreData == 0 {
+		offset += 2
+	}
+	if flags&CrossTrainerFlagHasAverageSpeed != 0 {
+		offset += 2
+	}
+
+	if flags&CrossTrainerFlagHasTotalDistance != 0 {
+		if len(buf) < offset+3 {
+			return errShort(buf, offset+3)
+		}
+
+		distance := uint32(buf[offset]) |
+			uint32(buf[offset+1])<<8 |
+			uint32(buf[offset+2])<<16
+		d.emit(metrics.Metric{
+			Kind:  metrics.RunningDistance,
+			Value: float64(d.treadDistance.update(distance)),
+		})
+
+		offset += 3
+	}
+
+	if flags&CrossTrainerFlagHasStepCount != 0 {
+		if len(buf) < offset+4 {
+			return errShort(buf, offset+4)
+		}
+
+		steps := binary.LittleEndian.Uint16(buf[offset:])
+		d.emit(metrics.Metric{
+			Kind:  metrics.RunningCadence,
+			Value: float64(steps),
+		})
+
+		offset += 4
+	}
+
+	if flags&CrossTrainerFlagHasStrideCount != 0 {
+		if len(buf) < offset+2 {
+			return errShort(buf, offset+2)
+		}
+
+		strides := binary.LittleEndian.Uint16(buf[offset:])
+		d.emit(metrics.Metric{
+			Kind:  metrics.StrideCount,
+			Value: float64(d.strides.update(uint32(strides))) / 10,
+		})
+
+		offset += 2
+	}
+	if flags&CrossTrainerFlagHasElevationGain != 0 {
+		offset += 4
+	}
+
+	if flags&CrossTrainerFlagHasInclination != 0 {
+		if len(buf) < offset+4 {
+			return errShort(buf, offset+4)
+		}
+
+		incline := int16(binary.LittleEndian.Uint16(buf[offset:]))
+		d.emit(metrics.Metric{
+			Kind:  metrics.Incline,
+			Value: float64(incline) / 10,
+		})
+
+		offset += 4
+	}
+	if flags&CrossTrainerFlagHasResistanceLevel != 0 {
+		offset += 2
+	}
+
+	if flags&CrossTrainerFlagHasInstantaneousPower != 0 {
+		if len(buf) < offset+2 {
+			return errShort(buf, offset+2)
+		}
+
+		// Reported as cycling power so zones, W' balance and the rest
+		// work the same as on a bike.
+		powerWatts := int16(binary.LittleEndian.Uint16(buf[offset:]))
+		d.emit(metrics.Metric{
+			Kind:  metrics.CyclingPower,
+			Value: float64(powerWatts),
+		})
+	}
+
+	return nil
+}
+
+const (
+	// Inverted like the indoor bike's, but for floors rather than speed.
+	StairClimberFlagMoreData               = 1 << 0
+	StairClimberFlagHasStepPerMinute       = 1 << 1
+	StairClimberFlagHasAverageStepRate     = 1 << 2
+	StairClimberFlagHasElevationGain       = 1 << 3
+	StairClimberFlagHasStrideCount         = 1 << 4
+	StairClimberFlagHasExpendedEnergy      = 1 << 5
+	StairClimberFlagHasHeartRate           = 1 << 6
+	StairClimberFlagHasMetabolicEquivalent = 1 << 7
+	StairClimberFlagHasElapsedTime         = 1 << 8
+	StairClimberFlagHasRemainingTime       = 1 << 9
+
+	// Bits 10-16 reserved
+)
+
+// Two flag bytes, with all subsequent fields optional based on the flag
+// bits set.
+//
+// uint16  floors                   floors with resolution 1
+// uint16  step_per_minute          steps per minute with resolution 1
+// uint16  average_step_rate        steps per minute with resolution 1
+// uint16  positive_elevation_gain  meters with resolution 1
+// uint16  stride_count             strides with resolution 1
+// ...
+//
+// Nothing after stride count is used yet.
+func (d *Decoder) handleStairClimberData(buf []byte) error {
+	if len(buf) < 2 {
+		return errShort(buf, 2)
+	}
+
+	flags := binary.LittleEndian.Uint16(buf[0:])
+	offset := 2
+
+	if flags&StairClimberFlagMoreData == 0 {
+		offset += 2
+	}
+
+	if flags&StairClimberFlagHasStepPerMinute != 0 {
+		if len(buf) < offset+2 {
+			return errShort(buf, offset+2)
+		}
+
+		steps := binary.LittleEndian.Uint16(buf[offset:])
+		d.emit(metrics.Metric{
+			Kind:  metrics.RunningCadence,
+			Value: float64(steps),
+		})
+
+		offset += 2
+	}
+	if flags&StairClimberFlagHasAverageStepRate != 0 {
+		offset += 2
+	}
+	if flags&StairClimberFlagHasElevationGain != 0 {
+		offset += 2
+	}
+
+	if flags&StairClimberFlagHasStrideCount != 0 {
+		if len(buf) < offset+2 {
+			return errShort(buf, offset+2)
+		}
+
+		strides := binary.LittleEndian.Uint16(buf[offset:])
+		d.emit(metrics.Metric{
+			Kind:  metrics.StrideCount,
+			Value: float64(d.strides.update(uint32(strides))),
+		})
+	}
+
+	return nil
+}
+
+// Control point op codes. Every request is answered with an indication
+// starting with FTMSOpResponseCode.
+const (
+	FTMSOpRequestControl = 0x00
+	FTMSOpReset          = 0x01
+	FTMSOpSetTargetSpeed = 0x02
+	FTMSOpSetIncline     = 0x03
+	FTMSOpSetResistance  = 0x04
+	FTMSOpSetTargetPower = 0x05
+	FTMSOpStartOrResume  = 0x07
+	FTMSOpStopOrPause    = 0x08
+	FTMSOpSetSimulation  = 0x11
+	FTMSOpSpinDown       = 0x13
+	FTMSOpResponseCode   = 0x80
+)
+
+// Parameter for FTMSOpSpinDown.
+const ftmsSpinDownStart = 0x01
+
+const (
+	FTMSResultSuccess             = 0x01
+	FTMSResultOpCodeNotSupported  = 0x02
+	FTMSResultInvalidParameter    = 0x03
+	FTMSResultOperationFailed     = 0x04
+	FTMSResultControlNotPermitted = 0x05
+)
+
+var FTMSResultNames = map[byte]string{
+	FTMSResultSuccess:             "success",
+	FTMSResultOpCodeNotSupported:  "op code not supported",
+	FTMSResultInvalidParameter:    "invalid parameter",
+	FTMSResultOperationFailed:     "operation failed",
+	FTMSResultControlNotPermitted: "control not permitted",
+}
+
+// SupportedRange is what a fitness machine will accept for a target,
+// from one of the Supported ... Range characteristics.
+type SupportedRange struct {
+	Min, Max float64
+	// Smallest change the machine can make, 0 if it didn't say.
+	Increment float64
+}
+
+func (r SupportedRange) String() string {
+	return fmt.Sprintf("%g-%g in steps of %g", r.Min, r.Max, r.Increment)
+}
+
+// Clamp returns the closest value to v the machine will accept.
+func (r SupportedRange) Clamp(v float64) float64 {
+	if r.Increment > 0 {
+		v = r.Min + math.Round((v-r.Min)/r.Increment)*r.Increment
+	}
+	return math.Max(r.Min, math.Min(r.Max, v))
+}
+
+// FitnessMachineLimits are the supported ranges read from a fitness
+// machine. Either may be nil if the machine doesn't say.
+type FitnessMachineLimits struct {
+	Power      *SupportedRange
+	Resistance *SupportedRange
+}
+
+// ReadSupportedRange reads either the Supported Power Range or Supported
+// Resistance Level Range characteristic, which share a layout:
+//
+// sint16  minimum                  watts, or resistance with resolution 1/10
+// sint16  maximum                  same as minimum
+// uint16  minimum_increment        same as minimum
+func ReadSupportedRange(ch *bluetooth.DeviceCharacteristic) (SupportedRange, error) {
+	buf := make([]byte, 6)
+	n, err := ch.Read(buf)
+	if err != nil {
+		return SupportedRange{}, err
+	}
+	if n < len(buf) {
+		return SupportedRange{}, errShort(buf[:n], len(buf))
+	}
+
+	scale := 1.0
+	if ch.UUID() == bluetooth.CharacteristicUUIDSupportedResistanceLevelRange {
+		scale = 10
+	}
+
+	return SupportedRange{
+		Min:       float64(int16(binary.LittleEndian.Uint16(buf[0:]))) / scale,
+		Max:       float64(int16(binary.LittleEndian.Uint16(buf[2:]))) / scale,
+		Increment: float64(binary.LittleEndian.Uint16(buf[4:])) / scale,
+	}, nil
+}
+
+// DefaultFitnessMachineCapabilities are assumed when we can't read the
+// Fitness Machine Feature characteristic. It's mandatory, so this should
+// be rare, and we'd rather try than refuse.
+var DefaultFitnessMachineCapabilities = TrainerCapabilities{
+	Power:      true,
+	Grade:      true,
+	Resistance: true,
+	Speed:      true,
+	Incline:    true,
+}
+
+// ReadFitnessMachineCapabilities reads the targets a machine accepts from
+// its Fitness Machine Feature characteristic:
+//
+// uint32  fitness_machine_features unused
+// uint32  target_setting_features  see the FTMSTarget constants
+func ReadFitnessMachineCapabilities(ch *bluetooth.DeviceCharacteristic) (TrainerCapabilities, error) {
+	buf := make([]byte, 8)
+	n, err := ch.Read(buf)
+	if err != nil {
+		return TrainerCapabilities{}, err
+	}
+	if n < len(buf) {
+		return TrainerCapabilities{}, errShort(buf[:n], len(buf))
+	}
+
+	targets := binary.LittleEndian.Uint32(buf[4:])
+	return TrainerCapabilities{
+		Power:      targets&FTMSTargetPower != 0,
+		Grade:      targets&FTMSTargetIndoorSimulation != 0,
+		Resistance: targets&FTMSTargetResistance != 0,
+		Calibrate:  targets&FTMSTargetSpinDown != 0,
+		Speed:      targets&FTMSTargetSpeed != 0,
+		Incline:    targets&FTMSTargetIncline != 0,
+	}, nil
+}
+
+// FitnessMachineControl sends commands to a trainer through the Fitness
+// Machine Control Point.
+type FitnessMachineControl struct {
+	ch     *bluetooth.DeviceCharacteristic
+	caps   TrainerCapabilities
+	limits FitnessMachineLimits
+
+	calibration CalibrationListener
+
+	// Status op code -> the target we last asked for, so we can tell
+	// our own changes apart from another app's.
+	mu        sync.Mutex
+	requested map[byte]float64
+}
+
+// NewFitnessMachineControl takes control of the fitness machine, which
+// needs to happen before it will accept any other commands. Power and
+// resistance targets are clamped to limits.
+func NewFitnessMachineControl(ch *bluetooth.DeviceCharacteristic, caps TrainerCapabilities, limits FitnessMachineLimits) (*FitnessMachineControl, error) {
+	ctrl := &FitnessMachineControl{
+		ch:        ch,
+		caps:      caps,
+		limits:    limits,
+		requested: map[byte]float64{},
+	}
+
+	if err := ch.EnableNotifications(ctrl.handleResponse); err != nil {
+		return nil, err
+	}
+
+	if err := ctrl.write(FTMSOpRequestControl); err != nil {
+		return nil, err
+	}
+	if err := ctrl.write(FTMSOpStartOrResume); err != nil {
+		return nil, err
+	}
+
+	return ctrl, nil
+}
+
+func (ctrl *FitnessMachineControl) write(opCode byte, params ...byte) error {
+	buf := append([]byte{opCode}, params...)
+	_, err := ctrl.ch.WriteWithoutResponse(buf)
+	return err
+}
+
+func (ctrl *FitnessMachineControl) request(statusOp byte, target float64) {
+	ctrl.mu.Lock()
+	defer ctrl.mu.Unlock()
+
+	ctrl.requested[statusOp] = target
+}
+
+// Requested reports whether a target change the machine told us about is
+// what we last asked for, rather than coming from another app connected
+// to the same machine.
+func (ctrl *FitnessMachineControl) Requested(event FitnessMachineEvent) bool {
+	ctrl.mu.Lock()
+	defer ctrl.mu.Unlock()
+
+	target, ok := ctrl.requested[event.Op]
+	// Allow for the resolution the target was sent with.
+	return ok && math.Abs(target-event.Target) < 0.1
+}
+
+func (ctrl *FitnessMachineControl) Capabilities() TrainerCapabilities {
+	return ctrl.caps
+}
+
+// sint16  target_power             watts with resolution 1
+func (ctrl *FitnessMachineControl) SetTargetPower(watts int) error {
+	if !ctrl.caps.Power {
+		return ErrUnsupported
+	}
+
+	if r := ctrl.limits.Power; r != nil {
+		if clamped := int(r.Clamp(float64(watts))); clamped != watts {
+			slog.Debug("clamping target power to supported range", "watts", watts, "clamped", clamped, "range", r)
+			watts = clamped
+		}
+	}
+
+	buf := make([]byte, 2)
+	binary.LittleEndian.PutUint16(buf, uint16(int16(watts)))
+
+	ctrl.request(FTMSStatusTargetPowerChanged, float64(watts))
+	return ctrl.write(FTMSOpSetTargetPower, buf...)
+}
+
+// uint8   target_resistance        unitless with resolution 1/10
+func (ctrl *FitnessMachineControl) SetResistance(level float64) error {
+	if !ctrl.caps.Resistance {
+		return ErrUnsupported
+	}
+
+	if r := ctrl.limits.Resistance; r != nil {
+		if clamped := r.Clamp(level); clamped != level {
+			slog.Debug("clamping target resistance to supported range", "level", level, "clamped", clamped, "range", r)
+			level = clamped
+		}
+	}
+
+	ctrl.request(FTMSStatusTargetResistanceChanged, level)
+	return ctrl.write(FTMSOpSetResistance, uint8(math.Round(level*10)))
+}
+
+// uint16  target_speed             km/h with resolution 1/100
+func (ctrl *FitnessMachineControl) SetTargetSpeed(kmh float64) error {
+	if !ctrl.caps.Speed {
+		return ErrUnsupported
+	}
+
+	buf := make([]byte, 2)
+	binary.LittleEndian.PutUint16(buf, uint16(kmh*100))
+
+	ctrl.request(FTMSStatusTargetSpeedChanged, kmh)
+	return ctrl.write(FTMSOpSetTargetSpeed, buf...)
+}
+
+// sint16  target_inclination       percent with resolution 1/10
+func (ctrl *FitnessMachineControl) SetTargetIncline(percent float64) error {
+	if !ctrl.caps.Incline {
+		return ErrUnsupported
+	}
+
+	buf := make([]byte, 2)
+	binary.LittleEndian.PutUint16(buf, uint16(int16(percent*10)))
+
+	ctrl.request(FTMSStatusTargetInclineChanged, percent)
+	return ctrl.write(FTMSOpSetIncline, buf...)
+}
+
+// SimulationParams describe the riding conditions for a trainer to
+// simulate, rather than holding a fixed power target.
+type SimulationParams struct {
+	// Meters per second, negative for a tailwind.
+	WindSpeed float64
+	// Percent
+	Grade float64
+	// Coefficient of rolling resistance
+	Crr float64
+	// Wind resistance coefficient, kg/m
+	Cw float64
+	// Rider and bike in kg, for trainers which take it (the KICKR) to
+	// work out how hard climbs should feel. Zero for the defaults.
+	Weight float64
+}
+
+var DefaultSimulationParams = SimulationParams{
+	WindSpeed: 0,
+	Grade:     0,
+	Crr:       0.004,
+	Cw:        0.51,
+}
+
+// sint16  wind_speed               meters per second with resolution 1/1000
+// sint16  grade                    percentage with resolution 1/100
+// uint8   crr                      unitless with resolution 1/10000
+// uint8   cw                       kg/m with resolution 1/100
+func (ctrl *FitnessMachineControl) SetGrade(params SimulationParams) error {
+	if !ctrl.caps.Grade {
+		return ErrUnsupported
+	}
+
+	buf := make([]byte, 6)
+	binary.LittleEndian.PutUint16(buf[0:], uint16(int16(params.WindSpeed*1000)))
+	binary.LittleEndian.PutUint16(buf[2:], uint16(int16(params.Grade*100)))
+	buf[4] = uint8(params.Crr * 10000)
+	buf[5] = uint8(params.Cw * 100)
+
+	ctrl.request(FTMSStatusSimulationChanged, params.Grade)
+	return ctrl.write(FTMSOpSetSimulation, buf...)
+}
+
+// uint8   spin_down_control        ftmsSpinDownStart
+//
+// The target speed comes back in the response, the rest through Fitness
+// Machine Status, which needs passing to HandleStatus.
+func (ctrl *FitnessMachineControl) Calibrate(onEvent func(CalibrationEvent)) error {
+	if !ctrl.caps.Calibrate {
+		return ErrUnsupported
+	}
+
+	ctrl.calibration.Listen(onEvent)
+	return ctrl.write(FTMSOpSpinDown, ftmsSpinDownStart)
+}
+
+// HandleStatus picks calibration progress out of the machine's status
+// notifications.
+func (ctrl *FitnessMachineControl) HandleStatus(event FitnessMachineEvent) {
+	if event.Op != FTMSStatusSpinDown {
+		return
+	}
+
+	calibration := CalibrationEvent{Message: event.Message}
+	switch event.SpinDown {
+	case ftmsSpinDownSuccess:
+		calibration.Done = true
+	case ftmsSpinDownError:
+		calibration.Done, calibration.Failed = true, true
+	case ftmsSpinDownStopPedaling:
+		calibration.StopPedaling = true
+	}
+
+	ctrl.calibration.Report(calibration)
+}
+
+// uint8  response_code  always FTMSOpResponseCode
+// uint8  request_op     op code this is a response to
+// uint8  result         one of the FTMSResult constants
+//
+// A successful spin down request is followed by the speeds to get up to:
+//
+// uint16  target_speed_low         km/h with resolution 1/100
+// uint16  target_speed_high        km/h with resolution 1/100
+func (ctrl *FitnessMachineControl) handleResponse(buf []byte) {
+	// malformed
+	if len(buf) < 3 || buf[0] != FTMSOpResponseCode {
+		return
+	}
+
+	if result := buf[2]; result != FTMSResultSuccess {
+		slog.Warn("FTMS request failed", "op", fmt.Sprintf("0x%02x", buf[1]), "result", FTMSResultNames[result])
+
+		if buf[1] == FTMSOpSpinDown {
+			ctrl.calibration.Report(CalibrationEvent{
+				Done:    true,
+				Failed:  true,
+				Message: "spin down refused: " + FTMSResultNames[result],
+			})
+		}
+		return
+	}
+
+	if buf[1] == FTMSOpSpinDown && len(buf) >= 7 {
+		low := float64(binary.LittleEndian.Uint16(buf[3:])) / 100
+		high := float64(binary.LittleEndian.Uint16(buf[5:])) / 100
+
+		ctrl.calibration.Report(CalibrationEvent{
+			TargetSpeed: high,
+			Message:     fmt.Sprintf("spin down target speed %.1f-%.1f km/h", low, high),
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package gatt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// The other side of FTMS, for when an app is controlling us rather than us
+// controlling a trainer.
+
+// Fitness Machine Feature bits we might claim to support. The first four
+// bytes are machine features, the next four target setting features.
+const (
+	FTMSFeatureCadence      = 1 << 1
+	FTMSFeatureHeartRate    = 1 << 10
+	FTMSFeaturePowerMeasure = 1 << 14
+
+	FTMSTargetSpeed            = 1 << 0
+	FTMSTargetIncline          = 1 << 1
+	FTMSTargetResistance       = 1 << 2
+	FTMSTargetPower            = 1 << 3
+	FTMSTargetIndoorSimulation = 1 << 13
+	FTMSTargetSpinDown         = 1 << 15
+)
+
+// EncodeFitnessMachineFeature builds the read-only Fitness Machine Feature
+// value.
+func EncodeFitnessMachineFeature(features, targets uint32) []byte {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint32(buf[0:], features)
+	binary.LittleEndian.PutUint32(buf[4:], targets)
+	return buf
+}
+
+// sint16  minimum_power            watts with resolution 1
+// sint16  maximum_power            watts with resolution 1
+// uint16  minimum_increment        watts with resolution 1
+func EncodeSupportedPowerRange(min, max, increment int) []byte {
+	buf := make([]byte, 6)
+	binary.LittleEndian.PutUint16(buf[0:], uint16(int16(min)))
+	binary.LittleEndian.PutUint16(buf[2:], uint16(int16(max)))
+	binary.LittleEndian.PutUint16(buf[4:], uint16(increment))
+	return buf
+}
+
+// FTMSRequest is a single write to our control point. Only the fields for
+// the op code are set.
+type FTMSRequest struct {
+	OpCode      byte
+	TargetPower int
+	Simulation  SimulationParams
+}
+
+// ParseFTMSRequest decodes a control point write, in the same format
+// FitnessMachineControl sends. Op codes we don't know are returned without
+// any parameters, for the caller to reject.
+func ParseFTMSRequest(buf []byte) (FTMSRequest, error) {
+	if len(buf) < 1 {
+		return FTMSRequest{}, fmt.Errorf("empty request")
+	}
+
+	req := FTMSRequest{OpCode: buf[0]}
+	params := buf[1:]
+
+	switch req.OpCode {
+	case FTMSOpSetTargetPower:
+		if len(params) < 2 {
+			return req, fmt.Errorf("short target power request")
+		}
+		req.TargetPower = int(int16(binary.LittleEndian.Uint16(params)))
+
+	case FTMSOpSetSimulation:
+		if len(params) < 6 {
+			return req, fmt.Errorf("short simulation request")
+		}
+		req.Simulation = SimulationParams{
+			WindSpeed: float64(int16(binary.LittleEndian.Uint16(params[0:]))) / 1000,
+			Grade:     float64(int16(binary.LittleEndian.Uint16(params[2:]))) / 100,
+			Crr:       float64(params[4]) / 10000,
+			Cw:        float64(params[5]) / 100,
+		}
+	}
+
+	return req, nil
+}
+
+// EncodeFTMSResponse builds the response to a control point request, see
+// handleResponse.
+func EncodeFTMSResponse(opCode, result byte) []byte {
+	return []byte{FTMSOpResponseCode, opCode, result}
+}
+
+// EncodeIndoorBikeData builds indoor bike data with speed (km/h), cadence
+// (RPM) and power (W), plus heart rate if it's above zero. See
+// handleIndoorBikeData for the layout.
+func EncodeIndoorBikeData(speed, cadence, power, heartRate float64) []byte {
+	flags := uint16(IndoorBikeFlagHasInstantaneousCadence | IndoorBikeFlagHasInstantaneousPower)
+	if heartRate > 0 {
+		flags |= IndoorBikeFlagHasHeartRate
+	}
+
+	buf := make([]byte, 8, 9)
+	binary.LittleEndian.PutUint16(buf[0:], flags)
+	binary.LittleEndian.PutUint16(buf[2:], uint16(math.Max(0, math.Min(speed*100, math.MaxUint16))))
+	binary.LittleEndian.PutUint16(buf[4:], uint16(math.Max(0, math.Min(cadence*2, math.MaxUint16))))
+	binary.LittleEndian.PutUint16(buf[6:], uint16(int16(math.Max(math.MinInt16, math.Min(power, math.MaxInt16)))))
+
+	if heartRate > 0 {
+		buf = append(buf, byte(math.Min(heartRate, math.MaxUint8)))
+	}
+
+	return buf
+}
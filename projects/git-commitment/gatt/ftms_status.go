@@ -0,0 +1,244 @@
+package gatt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"tinygo.org/x/bluetooth"
+)
+
+// Fitness Machine Status op codes. The machine notifies these whenever its
+// state changes, whichever client changed it.
+const (
+	FTMSStatusReset                   = 0x01
+	FTMSStatusStoppedOrPaused         = 0x02
+	FTMSStatusStoppedBySafetyKey      = 0x03
+	FTMSStatusStartedOrResumed        = 0x04
+	FTMSStatusTargetSpeedChanged      = 0x05
+	FTMSStatusTargetInclineChanged    = 0x06
+	FTMSStatusTargetResistanceChanged = 0x07
+	FTMSStatusTargetPowerChanged      = 0x08
+	FTMSStatusTargetHeartRateChanged  = 0x09
+	FTMSStatusSimulationChanged       = 0x12
+	FTMSStatusWheelChanged            = 0x13
+	FTMSStatusSpinDown                = 0x14
+	FTMSStatusTargetCadenceChanged    = 0x15
+	FTMSStatusControlPermissionLost   = 0xFF
+)
+
+// Size of the parameters for the status op codes that have them.
+var ftmsStatusParamSize = map[byte]int{
+	FTMSStatusStoppedOrPaused:         1,
+	FTMSStatusTargetSpeedChanged:      2,
+	FTMSStatusTargetInclineChanged:    2,
+	FTMSStatusTargetResistanceChanged: 1,
+	FTMSStatusTargetPowerChanged:      2,
+	FTMSStatusTargetHeartRateChanged:  1,
+	FTMSStatusSimulationChanged:       6,
+	FTMSStatusWheelChanged:            2,
+	FTMSStatusSpinDown:                1,
+	FTMSStatusTargetCadenceChanged:    2,
+}
+
+const (
+	ftmsSpinDownRequested    = 0x01
+	ftmsSpinDownSuccess      = 0x02
+	ftmsSpinDownError        = 0x03
+	ftmsSpinDownStopPedaling = 0x04
+)
+
+var ftmsSpinDownStatus = map[byte]string{
+	ftmsSpinDownRequested:    "spin down requested",
+	ftmsSpinDownSuccess:      "spin down calibration complete",
+	ftmsSpinDownError:        "spin down calibration failed",
+	ftmsSpinDownStopPedaling: "spin down: stop pedaling",
+}
+
+var ftmsTrainingStatus = map[byte]string{
+	0x00: "other",
+	0x01: "idle",
+	0x02: "warming up",
+	0x03: "low intensity interval",
+	0x04: "high intensity interval",
+	0x05: "recovery interval",
+	0x06: "isometric",
+	0x07: "heart rate control",
+	0x08: "fitness test",
+	0x09: "speed too low",
+	0x0A: "speed too high",
+	0x0B: "cool down",
+	0x0C: "watt control",
+	0x0D: "manual mode",
+	0x0E: "pre-workout",
+	0x0F: "post-workout",
+}
+
+const trainingStatusFlagHasString = 1 << 0
+
+// FitnessMachineEvent is something a fitness machine told us through the
+// Fitness Machine Status or Training Status characteristics.
+type FitnessMachineEvent struct {
+	// One of the FTMSStatus op codes, or 0 for a training status change.
+	Op byte
+	// Human readable, e.g. "target power changed to 200W"
+	Message string
+	// For target changes, the new target in the same units we'd send it.
+	Target float64
+	// For FTMSStatusSpinDown, how it's going.
+	SpinDown byte
+}
+
+func (e FitnessMachineEvent) String() string {
+	return e.Message
+}
+
+// TargetChange is whether this is a new target, which may or may not have
+// come from us.
+func (e FitnessMachineEvent) TargetChange() bool {
+	switch e.Op {
+	case FTMSStatusTargetSpeedChanged,
+		FTMSStatusTargetInclineChanged,
+		FTMSStatusTargetResistanceChanged,
+		FTMSStatusTargetPowerChanged,
+		FTMSStatusTargetHeartRateChanged,
+		FTMSStatusSimulationChanged,
+		FTMSStatusTargetCadenceChanged:
+		return true
+	}
+	return false
+}
+
+// uint8  op_code      one of the FTMSStatus constants
+// ...    parameters   depending on the op code, see below
+func DecodeMachineStatus(buf []byte) (FitnessMachineEvent, error) {
+	if len(buf) < 1 {
+		return FitnessMachineEvent{}, errShort(buf, 1)
+	}
+
+	event := FitnessMachineEvent{Op: buf[0]}
+	params := buf[1:]
+
+	if want := ftmsStatusParamSize[event.Op]; len(params) < want {
+		return FitnessMachineEvent{}, errShort(buf, want+1)
+	}
+
+	switch event.Op {
+	case FTMSStatusReset:
+		event.Message = "reset"
+
+	// uint8  1 for stopped, 2 for paused
+	case FTMSStatusStoppedOrPaused:
+		event.Message = "stopped by the user"
+		if params[0] == 0x02 {
+			event.Message = "paused by the user"
+		}
+
+	case FTMSStatusStoppedBySafetyKey:
+		event.Message = "stopped by the safety key"
+
+	case FTMSStatusStartedOrResumed:
+		event.Message = "started or resumed by the user"
+
+	// uint16  km/h with resolution 1/100
+	case FTMSStatusTargetSpeedChanged:
+		event.Target = float64(binary.LittleEndian.Uint16(params)) / 100
+		event.Message = fmt.Sprintf("target speed changed to %.1f km/h", event.Target)
+
+	// sint16  percent with resolution 1/10
+	case FTMSStatusTargetInclineChanged:
+		event.Target = float64(int16(binary.LittleEndian.Uint16(params))) / 10
+		event.Message = fmt.Sprintf("target incline changed to %.1f%%", event.Target)
+
+	// uint8  unitless with resolution 1/10
+	case FTMSStatusTargetResistanceChanged:
+		event.Target = float64(params[0]) / 10
+		event.Message = fmt.Sprintf("target resistance changed to %.1f", event.Target)
+
+	// sint16  watts with resolution 1
+	case FTMSStatusTargetPowerChanged:
+		event.Target = float64(int16(binary.LittleEndian.Uint16(params)))
+		event.Message = fmt.Sprintf("target power changed to %.0fW", event.Target)
+
+	// uint8  beats per minute with resolution 1
+	case FTMSStatusTargetHeartRateChanged:
+		event.Target = float64(params[0])
+		event.Message = fmt.Sprintf("target heart rate changed to %.0f bpm", event.Target)
+
+	// Same layout as FTMSOpSetSimulation, only the grade is kept.
+	case FTMSStatusSimulationChanged:
+		event.Target = float64(int16(binary.LittleEndian.Uint16(params[2:]))) / 100
+		event.Message = fmt.Sprintf("simulated grade changed to %.1f%%", event.Target)
+
+	// uint16  millimeters with resolution 1/10
+	case FTMSStatusWheelChanged:
+		circumference := float64(binary.LittleEndian.Uint16(params)) / 10
+		event.Message = fmt.Sprintf("wheel circumference changed to %.0fmm", circumference)
+
+	// uint8  see ftmsSpinDownStatus
+	case FTMSStatusSpinDown:
+		event.SpinDown = params[0]
+		if msg, ok := ftmsSpinDownStatus[params[0]]; ok {
+			event.Message = msg
+		} else {
+			event.Message = fmt.Sprintf("spin down status 0x%02x", params[0])
+		}
+
+	// uint16  rpm with resolution 1/2
+	case FTMSStatusTargetCadenceChanged:
+		event.Target = float64(binary.LittleEndian.Uint16(params)) / 2
+		event.Message = fmt.Sprintf("target cadence changed to %.0f rpm", event.Target)
+
+	case FTMSStatusControlPermissionLost:
+		event.Message = "control permission lost"
+
+	default:
+		event.Message = fmt.Sprintf("status 0x%02x", event.Op)
+	}
+
+	return event, nil
+}
+
+// uint8   flags
+// uint8   training_status          see ftmsTrainingStatus
+// utf8s   training_status_string   only if the flag is set
+func DecodeTrainingStatus(buf []byte) (FitnessMachineEvent, error) {
+	if len(buf) < 2 {
+		return FitnessMachineEvent{}, errShort(buf, 2)
+	}
+
+	status, ok := ftmsTrainingStatus[buf[1]]
+	if !ok {
+		status = fmt.Sprintf("0x%02x", buf[1])
+	}
+
+	event := FitnessMachineEvent{Message: "training status: " + status}
+	if buf[0]&trainingStatusFlagHasString != 0 {
+		if s := strings.TrimRight(string(buf[2:]), "\x00 "); s != "" {
+			event.Message += " (" + s + ")"
+		}
+	}
+
+	return event, nil
+}
+
+// WatchFitnessMachineStatus subscribes to either the Fitness Machine Status
+// or Training Status characteristic, calling onEvent with everything the
+// machine reports.
+func WatchFitnessMachineStatus(ch *bluetooth.DeviceCharacteristic, onEvent func(FitnessMachineEvent)) error {
+	decode := DecodeMachineStatus
+	if ch.UUID() == bluetooth.CharacteristicUUIDTrainingStatus {
+		decode = DecodeTrainingStatus
+	}
+
+	return ch.EnableNotifications(func(buf []byte) {
+		event, err := decode(buf)
+		if err != nil {
+			slog.Debug("failed to decode fitness machine status", "err", err)
+			return
+		}
+
+		onEvent(event)
+	})
+}
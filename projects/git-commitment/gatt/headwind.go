@@ -0,0 +1,60 @@
+package gatt
+
+import (
+	"tinygo.org/x/bluetooth"
+)
+
+// The Wahoo Headwind fan is controlled through its own service, using the
+// same framing as the KICKR control characteristic: an op code followed by
+// its parameters.
+var (
+	HeadwindServiceUUID        = mustParseUUID("a026ee0c-0a7d-4ab3-97fa-f1500f9feb8b")
+	HeadwindCharacteristicUUID = mustParseUUID("a026e038-0a7d-4ab3-97fa-f1500f9feb8b")
+)
+
+const (
+	HeadwindOpSetSpeed = 0x02
+	HeadwindOpSetMode  = 0x04
+
+	// Speed is taken from SetSpeed rather than heart rate or speed
+	// sensors.
+	HeadwindModeManual = 0x04
+)
+
+// Headwind drives a Wahoo Headwind fan in manual mode.
+type Headwind struct {
+	ch *bluetooth.DeviceCharacteristic
+}
+
+// NewHeadwind switches the fan to manual mode, so it only changes speed
+// when told to.
+func NewHeadwind(ch *bluetooth.DeviceCharacteristic) (*Headwind, error) {
+	fan := &Headwind{ch: ch}
+
+	if err := fan.write(HeadwindOpSetMode, HeadwindModeManual); err != nil {
+		return nil, err
+	}
+
+	return fan, nil
+}
+
+func (fan *Headwind) write(opCode byte, params ...byte) error {
+	buf := append([]byte{opCode}, params...)
+	_, err := fan.ch.WriteWithoutResponse(buf)
+	return err
+}
+
+// SetSpeed sets the fan speed, in percent.
+func (fan *Headwind) SetSpeed(percent int) error {
+	return fan.write(HeadwindOpSetSpeed, clampPercent(percent))
+}
+
+func clampPercent(percent int) byte {
+	if percent < 0 {
+		return 0
+	}
+	if percent > 100 {
+		return 100
+	}
+	return byte(percent)
+}
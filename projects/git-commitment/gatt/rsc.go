@@ -0,0 +1,80 @@
+package gatt
+
+import (
+	"encoding/binary"
+
+	"github.com/erik/git-commitment/metrics"
+)
+
+// https://www.bluetooth.com/specifications/specs/running-speed-and-cadence-service-1-0/
+
+const (
+	RSCFlagHasStrideLength  = 1 << 0
+	RSCFlagHasTotalDistance = 1 << 1
+	// 0 if walking, 1 if running
+	RSCFlagIsRunning = 1 << 2
+
+	// Bits 3-7 reserved
+)
+
+// One flag byte, followed by speed and cadence. Stride length and total
+// distance are optional based on the flag bits set.
+//
+// uint16  instantaneous_speed      meters per second with resolution 1/256
+// uint8   instantaneous_cadence    steps per minute with resolution 1
+// uint16  stride_length            meters with resolution 1/100
+// uint32  total_distance           meters with resolution 1/10
+func (d *Decoder) handleRunningSpeedCadenceMeasurement(buf []byte) error {
+	if len(buf) < 4 {
+		return errShort(buf, 4)
+	}
+
+	flags := buf[0]
+	speed := float64(binary.LittleEndian.Uint16(buf[1:])) / 256
+	cadence := buf[3]
+	offset := 4
+
+	// Footpods keep sending while standing still, and pace isn't defined
+	// when we're not moving.
+	if speed > 0 {
+		d.emit(metrics.Metric{
+			Kind:  metrics.RunningPace,
+			Value: 1000 / speed,
+		})
+	}
+
+	d.emit(metrics.Metric{
+		Kind:  metrics.RunningCadence,
+		Value: float64(cadence),
+	})
+
+	if flags&RSCFlagHasStrideLength != 0 {
+		if len(buf) < offset+2 {
+			return errShort(buf, offset+2)
+		}
+
+		stride := binary.LittleEndian.Uint16(buf[offset:])
+		d.emit(metrics.Metric{
+			Kind:  metrics.RunningStrideLength,
+			Value: float64(stride) / 100,
+		})
+
+		offset += 2
+	}
+
+	if flags&RSCFlagHasTotalDistance != 0 {
+		if len(buf) < offset+4 {
+			return errShort(buf, offset+4)
+		}
+
+		distance := binary.LittleEndian.Uint32(buf[offset:])
+		d.emit(metrics.Metric{
+			Kind:  metrics.RunningDistance,
+			Value: float64(distance) / 10,
+		})
+
+		offset += 4
+	}
+
+	return nil
+}
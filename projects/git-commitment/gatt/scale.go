@@ -0,0 +1,245 @@
+package gatt
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"tinygo.org/x/bluetooth"
+)
+
+// https://www.bluetooth.com/specifications/specs/weight-scale-service-1-0/
+// https://www.bluetooth.com/specifications/specs/body-composition-service-1-0/
+
+// Missing from the bluetooth package's list of services.
+var WeightScaleServiceUUID = bluetooth.New16BitUUID(0x181D)
+
+const (
+	// 0 for kilograms and meters, 1 for pounds and inches
+	WeightFlagImperial     = 1 << 0
+	WeightFlagHasTimestamp = 1 << 1
+	WeightFlagHasUserId    = 1 << 2
+	WeightFlagHasBMI       = 1 << 3
+
+	// Bits 4-7 reserved
+)
+
+const (
+	// Same as WeightFlagImperial
+	BodyCompositionFlagImperial           = 1 << 0
+	BodyCompositionFlagHasTimestamp       = 1 << 1
+	BodyCompositionFlagHasUserId          = 1 << 2
+	BodyCompositionFlagHasBasalMetabolism = 1 << 3
+	BodyCompositionFlagHasMusclePercent   = 1 << 4
+	BodyCompositionFlagHasMuscleMass      = 1 << 5
+	BodyCompositionFlagHasFatFreeMass     = 1 << 6
+	BodyCompositionFlagHasSoftLeanMass    = 1 << 7
+	BodyCompositionFlagHasBodyWaterMass   = 1 << 8
+	BodyCompositionFlagHasImpedance       = 1 << 9
+	BodyCompositionFlagHasWeight          = 1 << 10
+	BodyCompositionFlagHasHeight          = 1 << 11
+	BodyCompositionFlagMultiplePacket     = 1 << 12
+
+	// Bits 13-15 reserved
+)
+
+// Sent in place of the weight or body fat when the scale couldn't take a
+// measurement, e.g. someone stepped off too early.
+const scaleMeasurementUnsuccessful = 0xffff
+
+// ErrMeasurementFailed is returned when the scale reports that it couldn't
+// take a measurement.
+var ErrMeasurementFailed = errors.New("scale reported an unsuccessful measurement")
+
+// BodyMeasurement is a reading from a weight scale, in metric units
+// whatever the scale was set to. Zero means the scale didn't report it.
+type BodyMeasurement struct {
+	// When the scale says it was taken, zero if it didn't.
+	Time time.Time
+
+	// Kilograms
+	Weight float64
+	BMI    float64
+	// Percent
+	BodyFat float64
+	// Kilograms
+	MuscleMass float64
+}
+
+// Merge fills in anything m is missing from other, since weight and body
+// composition arrive separately.
+func (m *BodyMeasurement) Merge(other BodyMeasurement) {
+	if m.Time.IsZero() {
+		m.Time = other.Time
+	}
+	if m.Weight == 0 {
+		m.Weight = other.Weight
+	}
+	if m.BMI == 0 {
+		m.BMI = other.BMI
+	}
+	if m.BodyFat == 0 {
+		m.BodyFat = other.BodyFat
+	}
+	if m.MuscleMass == 0 {
+		m.MuscleMass = other.MuscleMass
+	}
+}
+
+// massScale converts a raw mass field to kilograms.
+func massScale(imperial bool) float64 {
+	if imperial {
+		// Pounds with resolution 1/100
+		return 0.01 * 0.45359237
+	}
+	// Kilograms with resolution 1/200
+	return 0.005
+}
+
+// uint16  year                     0 if unknown
+// uint8   month                    1-12, 0 if unknown
+// uint8   day                      1-31, 0 if unknown
+// uint8   hours
+// uint8   minutes
+// uint8   seconds
+//
+// In whatever the scale thinks local time is.
+func decodeDateTime(buf []byte) time.Time {
+	year := binary.LittleEndian.Uint16(buf)
+	if year == 0 || buf[2] == 0 || buf[3] == 0 {
+		return time.Time{}
+	}
+
+	return time.Date(int(year), time.Month(buf[2]), int(buf[3]),
+		int(buf[4]), int(buf[5]), int(buf[6]), 0, time.Local)
+}
+
+// One flag byte, followed by the weight. Everything else is optional based
+// on the flag bits set.
+//
+// uint16  weight                   see massScale
+// ...     timestamp                see decodeDateTime
+// uint8   user_id                  unused
+// uint16  bmi                      with resolution 1/10
+// uint16  height                   unused
+func DecodeWeightMeasurement(buf []byte) (BodyMeasurement, error) {
+	if len(buf) < 3 {
+		return BodyMeasurement{}, errShort(buf, 3)
+	}
+
+	flags := buf[0]
+	raw := binary.LittleEndian.Uint16(buf[1:])
+	offset := 3
+
+	if raw == scaleMeasurementUnsuccessful {
+		return BodyMeasurement{}, ErrMeasurementFailed
+	}
+
+	m := BodyMeasurement{
+		Weight: float64(raw) * massScale(flags&WeightFlagImperial != 0),
+	}
+
+	if flags&WeightFlagHasTimestamp != 0 {
+		if len(buf) < offset+7 {
+			return BodyMeasurement{}, errShort(buf, offset+7)
+		}
+
+		m.Time = decodeDateTime(buf[offset:])
+		offset += 7
+	}
+	if flags&WeightFlagHasUserId != 0 {
+		offset += 1
+	}
+
+	if flags&WeightFlagHasBMI != 0 {
+		if len(buf) < offset+2 {
+			return BodyMeasurement{}, errShort(buf, offset+2)
+		}
+
+		m.BMI = float64(binary.LittleEndian.Uint16(buf[offset:])) / 10
+	}
+
+	return m, nil
+}
+
+// Two flag bytes, followed by body fat. Everything else is optional based
+// on the flag bits set.
+//
+// uint16  body_fat                 percent with resolution 1/10
+// ...     timestamp                see decodeDateTime
+// uint8   user_id                  unused
+// uint16  basal_metabolism         unused
+// uint16  muscle_percentage        unused
+// uint16  muscle_mass              see massScale
+// uint16  fat_free_mass            unused
+// uint16  soft_lean_mass           unused
+// uint16  body_water_mass          unused
+// uint16  impedance                unused
+// uint16  weight                   see massScale
+// uint16  height                   unused
+func DecodeBodyComposition(buf []byte) (BodyMeasurement, error) {
+	if len(buf) < 4 {
+		return BodyMeasurement{}, errShort(buf, 4)
+	}
+
+	flags := binary.LittleEndian.Uint16(buf[0:])
+	raw := binary.LittleEndian.Uint16(buf[2:])
+	offset := 4
+
+	if raw == scaleMeasurementUnsuccessful {
+		return BodyMeasurement{}, ErrMeasurementFailed
+	}
+
+	m := BodyMeasurement{BodyFat: float64(raw) / 10}
+	mass := massScale(flags&BodyCompositionFlagImperial != 0)
+
+	if flags&BodyCompositionFlagHasTimestamp != 0 {
+		if len(buf) < offset+7 {
+			return BodyMeasurement{}, errShort(buf, offset+7)
+		}
+
+		m.Time = decodeDateTime(buf[offset:])
+		offset += 7
+	}
+	if flags&BodyCompositionFlagHasUserId != 0 {
+		offset += 1
+	}
+	if flags&BodyCompositionFlagHasBasalMetabolism != 0 {
+		offset += 2
+	}
+	if flags&BodyCompositionFlagHasMusclePercent != 0 {
+		offset += 2
+	}
+
+	if flags&BodyCompositionFlagHasMuscleMass != 0 {
+		if len(buf) < offset+2 {
+			return BodyMeasurement{}, errShort(buf, offset+2)
+		}
+
+		m.MuscleMass = float64(binary.LittleEndian.Uint16(buf[offset:])) * mass
+		offset += 2
+	}
+
+	if flags&BodyCompositionFlagHasFatFreeMass != 0 {
+		offset += 2
+	}
+	if flags&BodyCompositionFlagHasSoftLeanMass != 0 {
+		offset += 2
+	}
+	if flags&BodyCompositionFlagHasBodyWaterMass != 0 {
+		offset += 2
+	}
+	if flags&BodyCompositionFlagHasImpedance != 0 {
+		offset += 2
+	}
+
+	if flags&BodyCompositionFlagHasWeight != 0 {
+		if len(buf) < offset+2 {
+			return BodyMeasurement{}, errShort(buf, offset+2)
+		}
+
+		m.Weight = float64(binary.LittleEndian.Uint16(buf[offset:])) * mass
+	}
+
+	return m, nil
+}
@@ -0,0 +1,171 @@
+package gatt
+
+import (
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"tinygo.org/x/bluetooth"
+)
+
+// ErrUnsupported is returned when asking a trainer for something its
+// Capabilities say it can't do.
+var ErrUnsupported = errors.New("not supported by this trainer")
+
+// TrainerCapabilities are the modes a trainer can be put into.
+type TrainerCapabilities struct {
+	// ERG mode
+	Power bool
+	// Simulation mode, following a grade
+	Grade      bool
+	Resistance bool
+	// Spin down, or whatever else the trainer does to calibrate.
+	Calibrate bool
+	// Treadmills, see Treadmill.
+	Speed   bool
+	Incline bool
+}
+
+func (c TrainerCapabilities) String() string {
+	var modes []string
+	for _, mode := range []struct {
+		name string
+		ok   bool
+	}{
+		{"power", c.Power},
+		{"grade", c.Grade},
+		{"resistance", c.Resistance},
+		{"calibrate", c.Calibrate},
+		{"speed", c.Speed},
+		{"incline", c.Incline},
+	} {
+		if mode.ok {
+			modes = append(modes, mode.name)
+		}
+	}
+
+	if len(modes) == 0 {
+		return "none"
+	}
+	return strings.Join(modes, ",")
+}
+
+// TrainerController is a smart trainer we know how to control, either
+// through FTMS or a vendor specific protocol. Anything outside of the
+// trainer's Capabilities returns ErrUnsupported.
+type TrainerController interface {
+	// ERG mode, holding a target power whatever the cadence.
+	SetTargetPower(watts int) error
+	// Simulation mode, riding up params.Grade with the given wind and
+	// rolling resistance.
+	SetGrade(params SimulationParams) error
+	// Unitless, with whatever range the trainer supports.
+	SetResistance(level float64) error
+	// Starts the trainer's calibration. The rider usually needs to get
+	// the flywheel up to speed and then stop pedaling. Whatever the
+	// trainer reports along the way is passed to onEvent, from another
+	// goroutine.
+	Calibrate(onEvent func(CalibrationEvent)) error
+	Capabilities() TrainerCapabilities
+}
+
+// CalibrationEvent is progress reported by a trainer during calibration.
+// Trainers vary in how much they say, some nothing at all.
+type CalibrationEvent struct {
+	// km/h to get the flywheel up to before coasting, 0 if not given.
+	TargetSpeed float64
+	// The rider should stop pedaling and let the flywheel coast.
+	StopPedaling bool
+	// Calibration has finished, successfully unless Failed.
+	Done   bool
+	Failed bool
+	// Human readable, e.g. "spin down time 10.2s"
+	Message string
+}
+
+func (e CalibrationEvent) String() string {
+	return e.Message
+}
+
+// CalibrationListener passes calibration progress from a trainer's
+// notifications on to whoever last called Calibrate.
+type CalibrationListener struct {
+	mu      sync.Mutex
+	onEvent func(CalibrationEvent)
+}
+
+// Listen replaces the previous callback.
+func (l *CalibrationListener) Listen(onEvent func(CalibrationEvent)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.onEvent = onEvent
+}
+
+// Report does nothing if nobody is listening.
+func (l *CalibrationListener) Report(event CalibrationEvent) {
+	l.mu.Lock()
+	onEvent := l.onEvent
+	l.mu.Unlock()
+
+	if onEvent != nil {
+		onEvent(event)
+	}
+}
+
+// Treadmill is a fitness machine which takes speed and incline targets
+// rather than power.
+type Treadmill interface {
+	// km/h
+	SetTargetSpeed(kmh float64) error
+	// Percent
+	SetTargetIncline(percent float64) error
+}
+
+// TrainerControlPoints are the control characteristics discovered on a
+// device, any of which may be nil.
+type TrainerControlPoints struct {
+	FTMS *bluetooth.DeviceCharacteristic
+	// Fitness Machine Feature, saying which targets FTMS accepts.
+	FTMSFeature *bluetooth.DeviceCharacteristic
+	FTMSLimits  FitnessMachineLimits
+
+	WahooKickr *bluetooth.DeviceCharacteristic
+	TacxFEC    *bluetooth.DeviceCharacteristic
+	// Where Tacx trainers send FE-C pages back, for calibration results.
+	TacxFECNotify *bluetooth.DeviceCharacteristic
+}
+
+// NewTrainerController takes control of the trainer through whichever of
+// its control points works best. KICKRs and Tacx trainers may expose FTMS
+// as well, but we only want to be sending commands through one of them.
+// Returns nil if there's nothing to control.
+//
+// Elite trainers are only picked up here if they have FTMS. Their own
+// control service isn't documented, so older ones have to be controlled
+// over ANT+ FE-C instead, see ant.FECControl.
+func NewTrainerController(points TrainerControlPoints) (TrainerController, error) {
+	switch {
+	// More responsive than FTMS on older firmware.
+	case points.WahooKickr != nil:
+		return NewWahooKickrControl(points.WahooKickr)
+
+	case points.FTMS != nil:
+		caps := DefaultFitnessMachineCapabilities
+		if points.FTMSFeature != nil {
+			if c, err := ReadFitnessMachineCapabilities(points.FTMSFeature); err != nil {
+				slog.Warn("failed to read fitness machine features", "err", err)
+			} else {
+				caps = c
+			}
+		}
+		return NewFitnessMachineControl(points.FTMS, caps, points.FTMSLimits)
+
+	// Only for trainers without FTMS, which reports back far more.
+	case points.TacxFEC != nil:
+		return NewTacxFECControl(points.TacxFEC, points.TacxFECNotify)
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,103 @@
+// Package gatt decodes measurements from the standard (and a few vendor
+// specific) GATT characteristics of fitness sensors, and encodes commands
+// for smart trainers.
+package gatt
+
+import (
+	"tinygo.org/x/bluetooth"
+)
+
+// Services we know how to read from, and the characteristics we're
+// interested in for each.
+var KnownServiceUUIDs = []bluetooth.UUID{
+	bluetooth.ServiceUUIDCyclingSpeedAndCadence,
+	bluetooth.ServiceUUIDCyclingPower,
+	bluetooth.ServiceUUIDHeartRate,
+	bluetooth.ServiceUUIDFitnessMachine,
+	bluetooth.ServiceUUIDRunningSpeedAndCadence,
+	CoreServiceUUID,
+	HeadwindServiceUUID,
+	TacxFECServiceUUID,
+}
+
+var KnownServiceCharacteristicUUIDs = map[bluetooth.UUID][]bluetooth.UUID{
+	// https://www.bluetooth.com/specifications/specs/cycling-power-service-1-1/
+	bluetooth.ServiceUUIDCyclingPower: {
+		bluetooth.CharacteristicUUIDCyclingPowerMeasurement,
+		bluetooth.CharacteristicUUIDCyclingPowerVector,
+		WahooKickrControlCharacteristicUUID,
+	},
+	bluetooth.ServiceUUIDHeartRate: {
+		bluetooth.CharacteristicUUIDHeartRateMeasurement,
+	},
+	bluetooth.ServiceUUIDCyclingSpeedAndCadence: {
+		bluetooth.CharacteristicUUIDCSCMeasurement,
+	},
+	// Smart trainers which don't speak Cycling Power (or do so poorly),
+	// treadmills, ellipticals and stair climbers.
+	bluetooth.ServiceUUIDFitnessMachine: {
+		bluetooth.CharacteristicUUIDIndoorBikeData,
+		bluetooth.CharacteristicUUIDTreadmillData,
+		bluetooth.CharacteristicUUIDCrossTrainerData,
+		bluetooth.CharacteristicUUIDStairClimberData,
+		bluetooth.CharacteristicUUIDFitnessMachineStatus,
+		bluetooth.CharacteristicUUIDTrainingStatus,
+		bluetooth.CharacteristicUUIDSupportedPowerRange,
+		bluetooth.CharacteristicUUIDSupportedResistanceLevelRange,
+		bluetooth.CharacteristicUUIDFitnessMachineFeature,
+		bluetooth.CharacteristicUUIDFitnessMachineControlPoint,
+	},
+	// Footpods
+	bluetooth.ServiceUUIDRunningSpeedAndCadence: {
+		bluetooth.CharacteristicUUIDRSCMeasurement,
+	},
+	// Body temperature
+	CoreServiceUUID: {
+		CoreCharacteristicUUID,
+	},
+	// Fans
+	HeadwindServiceUUID: {
+		HeadwindCharacteristicUUID,
+	},
+	// Older Tacx trainers, for control only
+	TacxFECServiceUUID: {
+		TacxFECCharacteristicUUID,
+		TacxFECNotifyCharacteristicUUID,
+	},
+}
+
+var (
+	KnownServiceNames = map[bluetooth.UUID]string{
+		bluetooth.ServiceUUIDCyclingPower:           "Cycling Power",
+		bluetooth.ServiceUUIDHeartRate:              "Heart Rate",
+		bluetooth.ServiceUUIDCyclingSpeedAndCadence: "Cycling Speed and Cadence",
+		bluetooth.ServiceUUIDFitnessMachine:         "Fitness Machine",
+		bluetooth.ServiceUUIDRunningSpeedAndCadence: "Running Speed and Cadence",
+		CoreServiceUUID:                             "CORE Body Temperature",
+		HeadwindServiceUUID:                         "Wahoo Headwind",
+		TacxFECServiceUUID:                          "Tacx FE-C over BLE",
+	}
+	KnownCharacteristicNames = map[bluetooth.UUID]string{
+		bluetooth.CharacteristicUUIDCyclingPowerMeasurement: "Cycling Power Measure",
+		bluetooth.CharacteristicUUIDCyclingPowerVector:      "Cycling Power Vector",
+		bluetooth.CharacteristicUUIDHeartRateMeasurement:    "Heart Rate Measurement",
+		bluetooth.CharacteristicUUIDCSCMeasurement:          "Cycling Speed and Cadence Measurement",
+		bluetooth.CharacteristicUUIDIndoorBikeData:          "Indoor Bike Data",
+		bluetooth.CharacteristicUUIDTreadmillData:           "Treadmill Data",
+		bluetooth.CharacteristicUUIDCrossTrainerData:        "Cross Trainer Data",
+		bluetooth.CharacteristicUUIDStairClimberData:        "Stair Climber Data",
+		bluetooth.CharacteristicUUIDRSCMeasurement:          "Running Speed and Cadence Measurement",
+
+		bluetooth.CharacteristicUUIDFitnessMachineControlPoint:    "Fitness Machine Control Point",
+		bluetooth.CharacteristicUUIDFitnessMachineStatus:          "Fitness Machine Status",
+		bluetooth.CharacteristicUUIDTrainingStatus:                "Training Status",
+		bluetooth.CharacteristicUUIDSupportedPowerRange:           "Supported Power Range",
+		bluetooth.CharacteristicUUIDSupportedResistanceLevelRange: "Supported Resistance Level Range",
+
+		CoreCharacteristicUUID:              "CORE Body Temperature",
+		WahooKickrControlCharacteristicUUID: "Wahoo KICKR Control",
+		HeadwindCharacteristicUUID:          "Wahoo Headwind Control",
+		TacxFECCharacteristicUUID:           "Tacx FE-C Control",
+		TacxFECNotifyCharacteristicUUID:     "Tacx FE-C Data",
+	}
+)
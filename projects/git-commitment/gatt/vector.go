@@ -0,0 +1,183 @@
+package gatt
+
+import (
+	"encoding/binary"
+
+	"github.com/erik/git-commitment/metrics"
+)
+
+// https://www.bluetooth.com/specifications/specs/cycling-power-service-1-1/
+
+const (
+	CyclingPowerVectorFlagHasCrankRevolution  = 1 << 0
+	CyclingPowerVectorFlagHasFirstCrankAngle  = 1 << 1
+	CyclingPowerVectorFlagHasForceMagnitudes  = 1 << 2
+	CyclingPowerVectorFlagHasTorqueMagnitudes = 1 << 3
+
+	// 00 unknown
+	// 01 tangential
+	// 10 radial
+	// 11 lateral
+	CyclingPowerVectorFlagMeasurementDirection = (1 << 4) | (1 << 5)
+
+	// Bits 6-7 reserved
+)
+
+// crankProfile collects the force or torque samples for the current crank
+// revolution.
+type crankProfile struct {
+	started bool
+	rev     uint16
+
+	kind    metrics.Kind
+	angle   float64
+	samples []float64
+}
+
+// One flag byte, then optional fields based on the flag bits set. Whatever
+// is left over is an array of force or torque samples (never both) evenly
+// spaced through the revolution, starting at the first crank angle.
+//
+// uint16  crank_rev_cumulative     unitless
+// uint16  crank_rev_last_time      seconds with resolution 1/1024
+// uint16  first_crank_angle        degrees with resolution 1
+// sint16  force_magnitude[]        newtons with resolution 1
+// sint16  torque_magnitude[]       newton meters with resolution 1/32
+//
+// Samples for a single revolution may be split over several notifications,
+// in which case only the first has the angle. If the crank revolution count
+// is given we wait for it to tick over before emitting a profile, otherwise
+// every notification is a profile of its own.
+//
+// Torque profiles are also enough to work out torque effectiveness and
+// pedal smoothness, though only for both legs together, since the profile
+// is of the whole crank.
+//
+// Cadence isn't calculated here, since the Cycling Power Measurement
+// characteristic already gives us that.
+func (d *Decoder) handleCyclingPowerVector(buf []byte) error {
+	if len(buf) < 1 {
+		return errShort(buf, 1)
+	}
+
+	flags := buf[0]
+	offset := 1
+
+	hasRev := flags&CyclingPowerVectorFlagHasCrankRevolution != 0
+	var rev uint16
+
+	if hasRev {
+		if len(buf) < offset+4 {
+			return errShort(buf, offset+4)
+		}
+
+		rev = binary.LittleEndian.Uint16(buf[offset:])
+		offset += 2 + 2
+	}
+
+	// A new revolution, so whatever we had is complete.
+	if !hasRev || !d.vector.started || rev != d.vector.rev {
+		d.flushCrankProfile()
+		d.vector = crankProfile{started: true, rev: rev}
+	}
+
+	if flags&CyclingPowerVectorFlagHasFirstCrankAngle != 0 {
+		if len(buf) < offset+2 {
+			return errShort(buf, offset+2)
+		}
+
+		// Only the first notification of a revolution counts.
+		if len(d.vector.samples) == 0 {
+			d.vector.angle = float64(binary.LittleEndian.Uint16(buf[offset:]))
+		}
+		offset += 2
+	}
+
+	var scale float64
+	switch {
+	case flags&CyclingPowerVectorFlagHasTorqueMagnitudes != 0:
+		d.vector.kind = metrics.CrankTorqueProfile
+		scale = 1.0 / 32
+
+	case flags&CyclingPowerVectorFlagHasForceMagnitudes != 0:
+		d.vector.kind = metrics.CrankForceProfile
+		scale = 1
+
+	default:
+		return nil
+	}
+
+	for ; offset+2 <= len(buf); offset += 2 {
+		sample := int16(binary.LittleEndian.Uint16(buf[offset:]))
+		d.vector.samples = append(d.vector.samples, float64(sample)*scale)
+	}
+
+	if !hasRev {
+		d.flushCrankProfile()
+	}
+
+	return nil
+}
+
+// flushCrankProfile emits the samples collected so far, if any.
+func (d *Decoder) flushCrankProfile() {
+	profile := d.vector
+	if len(profile.samples) == 0 {
+		return
+	}
+	d.vector.samples = nil
+
+	sum := 0.0
+	for _, s := range profile.samples {
+		sum += s
+	}
+
+	d.emit(metrics.Metric{
+		Kind:  metrics.CrankProfileAngle,
+		Value: profile.angle,
+	})
+	d.emit(metrics.Metric{
+		Kind:    profile.kind,
+		Value:   sum / float64(len(profile.samples)),
+		Profile: profile.samples,
+	})
+
+	if profile.kind == metrics.CrankTorqueProfile {
+		d.emitPedalingTechnique(profile.samples)
+	}
+}
+
+// emitPedalingTechnique works out torque effectiveness and pedal smoothness
+// from the torque samples through a revolution. Samples are evenly spaced
+// in angle, and power is proportional to torque at a given cadence, so the
+// sums stand in for work done.
+//
+//	torque effectiveness = (positive + negative) / positive
+//	pedal smoothness     = mean / peak
+func (d *Decoder) emitPedalingTechnique(samples []float64) {
+	positive, negative, peak := 0.0, 0.0, 0.0
+	for _, s := range samples {
+		if s > 0 {
+			positive += s
+		} else {
+			negative += s
+		}
+		peak = max(peak, s)
+	}
+
+	// Not pedaling
+	if positive == 0 {
+		return
+	}
+
+	mean := (positive + negative) / float64(len(samples))
+
+	d.emit(metrics.Metric{
+		Kind:  metrics.TorqueEffectiveness,
+		Value: max((positive+negative)/positive*100, 0),
+	})
+	d.emit(metrics.Metric{
+		Kind:  metrics.PedalSmoothness,
+		Value: max(mean/peak*100, 0),
+	})
+}
@@ -1,7 +1,34 @@
 module github.com/erik/git-commitment
 
-go 1.16
+go 1.22.0
 
 replace tinygo.org/x/bluetooth => /Users/erik/code/bluetooth
 
-require tinygo.org/x/bluetooth v0.3.0
+require (
+	github.com/gdamore/tcell/v2 v2.5.4
+	github.com/gorilla/websocket v1.5.0
+	github.com/mattn/go-sqlite3 v1.14.22
+	google.golang.org/grpc v1.71.0
+	google.golang.org/protobuf v1.36.6
+	gopkg.in/yaml.v3 v3.0.1
+	tinygo.org/x/bluetooth v0.3.0
+)
+
+require (
+	github.com/JuulLabs-OSS/cbgo v0.0.2 // indirect
+	github.com/fatih/structs v1.1.0 // indirect
+	github.com/gdamore/encoding v1.0.0 // indirect
+	github.com/go-ole/go-ole v1.2.4 // indirect
+	github.com/godbus/dbus/v5 v5.0.3 // indirect
+	github.com/konsorten/go-windows-terminal-sequences v1.0.3 // indirect
+	github.com/lucasb-eyer/go-colorful v1.2.0 // indirect
+	github.com/mattn/go-runewidth v0.0.14 // indirect
+	github.com/muka/go-bluetooth v0.0.0-20200619025933-f6113f7141c5 // indirect
+	github.com/rivo/uniseg v0.2.0 // indirect
+	github.com/sirupsen/logrus v1.6.0 // indirect
+	golang.org/x/net v0.34.0 // indirect
+	golang.org/x/sys v0.29.0 // indirect
+	golang.org/x/term v0.28.0 // indirect
+	golang.org/x/text v0.21.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20250115164207-1a7da9e5054f // indirect
+)
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/erik/git-commitment/metrics"
+)
+
+// Default for -hr-lag. Heart rate takes the better part of a minute to
+// settle after a change in power.
+const DefaultHeartRateLag = 45 * time.Second
+
+// Smallest and largest single change in power, in watts.
+const (
+	hrControlMinStep = 5.0
+	hrControlMaxStep = 25.0
+)
+
+// Never go below this, so we don't stall the trainer.
+const hrControlMinPower = 50.0
+
+// HeartRateRange is a target range in BPM, inclusive.
+type HeartRateRange struct {
+	Low, High float64
+}
+
+// ParseHeartRateRange parses e.g. "130-140".
+func ParseHeartRateRange(s string) (HeartRateRange, error) {
+	parts := strings.SplitN(s, "-", 2)
+	if len(parts) != 2 {
+		return HeartRateRange{}, fmt.Errorf("expected <low>-<high>: %q", s)
+	}
+
+	low, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil {
+		return HeartRateRange{}, err
+	}
+	high, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil {
+		return HeartRateRange{}, err
+	}
+
+	if low <= 0 || high < low {
+		return HeartRateRange{}, fmt.Errorf("bad heart rate range: %q", s)
+	}
+
+	return HeartRateRange{Low: low, High: high}, nil
+}
+
+// HeartRateController holds heart rate in a range by adjusting the ERG
+// target, rather than holding power itself.
+//
+// Since heart rate lags behind power, the target is only changed once per
+// lag period, and by more the further out of range we are.
+type HeartRateController struct {
+	target   HeartRateRange
+	lag      time.Duration
+	commands chan<- ControlCommand
+
+	// Watts
+	power    float64
+	maxPower float64
+
+	// Smoothed, since single readings bounce around a fair bit.
+	heartRate  float64
+	lastChange time.Time
+}
+
+// NewHeartRateController starts at startPower watts, never going above
+// maxPower.
+func NewHeartRateController(
+	target HeartRateRange,
+	lag time.Duration,
+	startPower, maxPower float64,
+	commands chan<- ControlCommand,
+) *HeartRateController {
+	return &HeartRateController{
+		target:   target,
+		lag:      lag,
+		commands: commands,
+		power:    math.Max(startPower, hrControlMinPower),
+		maxPower: maxPower,
+	}
+}
+
+// Run consumes heart rate metrics, adjusting power as needed. Nothing
+// happens until the first heart rate arrives.
+func (c *HeartRateController) Run(in <-chan metrics.Metric) {
+	for m := range in {
+		c.Receive(m)
+	}
+}
+
+// Receive handles a single metric, for when something else (e.g. a
+// workout) is reading them.
+func (c *HeartRateController) Receive(m metrics.Metric) {
+	if m.Kind != metrics.HeartRate {
+		return
+	}
+
+	if c.heartRate == 0 {
+		c.heartRate = m.Value
+		c.lastChange = m.Timestamp
+		c.setPower()
+		return
+	}
+	c.heartRate += 0.2 * (m.Value - c.heartRate)
+
+	if m.Timestamp.Sub(c.lastChange) < c.lag {
+		return
+	}
+
+	// 1W per beat out of range
+	var step float64
+	switch {
+	case c.heartRate < c.target.Low:
+		step = c.target.Low - c.heartRate
+	case c.heartRate > c.target.High:
+		step = c.target.High - c.heartRate
+	default:
+		return
+	}
+
+	step = math.Copysign(math.Max(hrControlMinStep, math.Min(math.Abs(step), hrControlMaxStep)), step)
+	power := math.Max(hrControlMinPower, math.Min(c.power+step, c.maxPower))
+	if power == c.power {
+		return
+	}
+
+	slog.Info("heart rate out of range, adjusting power",
+		"heart_rate", math.Round(c.heartRate), "low", c.target.Low, "high", c.target.High)
+
+	c.power = power
+	c.lastChange = m.Timestamp
+	c.setPower()
+}
+
+func (c *HeartRateController) setPower() {
+	c.commands <- ControlCommand{kind: ControlTargetPower, value: math.Round(c.power)}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/erik/git-commitment/sinks"
+	"github.com/gdamore/tcell/v2"
+)
+
+// Shown on the dashboard.
+const keyHelp = "+/- 5W  [/] 10W  up/down grade  n skip step  e extend step  l lap  s sport  t tags  w note  f/d fan up/down  a fan auto"
+
+// How much each key press changes things by.
+const (
+	keyGradeStep   = 0.5
+	keyExtendSteps = 1 * time.Minute
+	keyFanStep     = 10
+)
+
+// keyHandler maps key presses on the dashboard to trainer commands,
+// workout changes, laps, fan speed and prompts for the session's sport,
+// tags and note. Any of workout, recorder and fans may be nil.
+func keyHandler(
+	commands chan<- ControlCommand,
+	workout *WorkoutRunner,
+	recorder *sinks.Recorder,
+	fans *FanController,
+	prompt func(label, initial string, done func(string)),
+) func(ev *tcell.EventKey) {
+	// Commands are sent in the background so the dashboard keeps
+	// responding while the trainer catches up.
+	send := func(cmd ControlCommand) {
+		go func() { commands <- cmd }()
+	}
+
+	return func(ev *tcell.EventKey) {
+		switch ev.Key() {
+		case tcell.KeyUp:
+			send(ControlCommand{kind: ControlAdjustGrade, value: keyGradeStep})
+			return
+		case tcell.KeyDown:
+			send(ControlCommand{kind: ControlAdjustGrade, value: -keyGradeStep})
+			return
+		}
+
+		switch ev.Rune() {
+		case '+', '=':
+			send(ControlCommand{kind: ControlAdjustPower, value: 5})
+		case '-', '_':
+			send(ControlCommand{kind: ControlAdjustPower, value: -5})
+		case ']':
+			send(ControlCommand{kind: ControlAdjustPower, value: 10})
+		case '[':
+			send(ControlCommand{kind: ControlAdjustPower, value: -10})
+
+		case 'n':
+			if workout != nil {
+				workout.Skip()
+			}
+		case 'e':
+			if workout != nil {
+				workout.Extend(keyExtendSteps)
+			}
+
+		case 'f':
+			if fans != nil {
+				fans.Adjust(keyFanStep)
+			}
+		case 'd':
+			if fans != nil {
+				fans.Adjust(-keyFanStep)
+			}
+		case 'a':
+			if fans != nil {
+				fans.Auto()
+			}
+
+		case 'l':
+			if recorder != nil {
+				recorder.Lap()
+				slog.Info("lap")
+			}
+
+		case 's':
+			if recorder == nil {
+				return
+			}
+			prompt("Sport (biking, running, other)", recorder.Sport(), func(text string) {
+				sport, err := sinks.ParseSport(text)
+				if err != nil {
+					slog.Warn("not changing sport", "err", err)
+					return
+				}
+				recorder.SetSport(sport)
+			})
+		case 't':
+			if recorder == nil {
+				return
+			}
+			prompt("Tags", strings.Join(recorder.Info().Tags, " "), func(text string) {
+				recorder.SetTags(sinks.ParseTags(text))
+			})
+		case 'w':
+			if recorder == nil {
+				return
+			}
+			prompt("Note", recorder.Info().Note, func(text string) {
+				recorder.SetNote(strings.TrimSpace(text))
+			})
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+)
+
+// setupLogging sends diagnostics to stderr, keeping stdout for metrics and
+// anything else meant to be read by a script. Text is meant for people,
+// JSON (one object per line) for machines.
+func setupLogging(verbose, quiet, asJSON bool) {
+	level := slog.LevelInfo
+	switch {
+	case verbose:
+		level = slog.LevelDebug
+	case quiet:
+		level = slog.LevelWarn
+	}
+
+	opts := &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			switch {
+			// Nobody wants to read nanoseconds.
+			case a.Value.Kind() == slog.KindDuration:
+				return slog.String(a.Key, a.Value.Duration().String())
+
+			// The date is just noise when watching a session go by.
+			case !asJSON && a.Key == slog.TimeKey && len(groups) == 0:
+				return slog.String(a.Key, a.Value.Time().Format("15:04:05.000"))
+			}
+			return a
+		},
+	}
+
+	var handler slog.Handler = slog.NewTextHandler(os.Stderr, opts)
+	if asJSON {
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	}
+
+	slog.SetDefault(slog.New(handler))
+}
+
+// fatal logs msg as an error and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
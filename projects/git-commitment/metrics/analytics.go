@@ -0,0 +1,212 @@
+package metrics
+
+import (
+	"math"
+	"time"
+)
+
+// How often to emit the derived metrics.
+const powerAnalyticsInterval = 5 * time.Second
+
+// Decoupling over less than this is mostly noise from heart rate still
+// catching up with the warmup.
+const minDecouplingTime = 10 * time.Minute
+
+// PowerAnalytics is a pipeline stage which computes Normalized Power,
+// Intensity Factor and Training Stress Score over the session so far, and
+// keeps track of average pedal balance for the summary.
+//
+// NP is the fourth root of the mean of the fourth powers of the 30 second
+// rolling average power, sampled once per second.
+//
+// With heart rate as well, it also computes Efficiency Factor (NP over
+// average heart rate) and aerobic decoupling: how much the ratio of average
+// power to average heart rate drops from the first half of the session to
+// the second.
+type PowerAnalytics struct {
+	ftp float64
+
+	// Most recent power reading
+	power float64
+
+	// Last 30 seconds of per-second power samples, used as a ring buffer.
+	window    [30]float64
+	windowLen int
+	windowSum float64
+
+	// Running sum of the 4th power of the rolling average, and how many
+	// have been summed.
+	sum4    float64
+	count4  int
+	seconds int
+
+	// Most recent left pedal balance, and the running sum of it for every
+	// second spent pedaling.
+	balance      float64
+	balanceSum   float64
+	balanceCount int
+
+	// Most recent heart rate, and running totals of power and heart rate
+	// for every second we've had a heart rate, so that the totals for
+	// any half of the session are a subtraction away.
+	heartRate       float64
+	powerTotals     []float64
+	heartRateTotals []float64
+}
+
+func NewPowerAnalytics(ftp float64) *PowerAnalytics {
+	return &PowerAnalytics{ftp: ftp}
+}
+
+// Run passes every metric from in through to out, interleaving the derived
+// metrics. Closes out once in is closed.
+func (a *PowerAnalytics) Run(in <-chan Metric, out chan<- Metric) {
+	defer close(out)
+
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case m, ok := <-in:
+			if !ok {
+				return
+			}
+
+			switch m.Kind {
+			case CyclingPower:
+				a.power = m.Value
+			case PedalPowerBalance:
+				a.balance = m.Value
+			case HeartRate:
+				a.heartRate = m.Value
+			}
+			out <- m
+
+		case now := <-ticker.C:
+			a.sample()
+
+			if a.seconds%int(powerAnalyticsInterval/time.Second) != 0 || a.count4 == 0 {
+				continue
+			}
+
+			out <- Metric{Kind: NormalizedPower, Timestamp: now, Value: a.NormalizedPower()}
+			out <- Metric{Kind: IntensityFactor, Timestamp: now, Value: a.IntensityFactor()}
+			out <- Metric{Kind: TrainingStressScore, Timestamp: now, Value: a.TrainingStressScore()}
+
+			if ef := a.EfficiencyFactor(); ef > 0 {
+				out <- Metric{Kind: EfficiencyFactor, Timestamp: now, Value: ef}
+			}
+			if decoupling, ok := a.Decoupling(); ok {
+				out <- Metric{Kind: AerobicDecoupling, Timestamp: now, Value: decoupling}
+			}
+		}
+	}
+}
+
+func (a *PowerAnalytics) sample() {
+	idx := a.seconds % len(a.window)
+	a.seconds++
+
+	if a.power > 0 && a.balance > 0 {
+		a.balanceSum += a.balance
+		a.balanceCount++
+	}
+
+	if a.heartRate > 0 {
+		prevPower, prevHR := 0.0, 0.0
+		if n := len(a.powerTotals); n > 0 {
+			prevPower, prevHR = a.powerTotals[n-1], a.heartRateTotals[n-1]
+		}
+
+		a.powerTotals = append(a.powerTotals, prevPower+a.power)
+		a.heartRateTotals = append(a.heartRateTotals, prevHR+a.heartRate)
+	}
+
+	a.windowSum += a.power - a.window[idx]
+	a.window[idx] = a.power
+
+	if a.windowLen < len(a.window) {
+		a.windowLen++
+		if a.windowLen < len(a.window) {
+			return
+		}
+	}
+
+	avg := a.windowSum / float64(len(a.window))
+	a.sum4 += math.Pow(avg, 4)
+	a.count4++
+}
+
+func (a *PowerAnalytics) NormalizedPower() float64 {
+	if a.count4 == 0 {
+		return 0
+	}
+
+	return math.Pow(a.sum4/float64(a.count4), 0.25)
+}
+
+func (a *PowerAnalytics) IntensityFactor() float64 {
+	return a.NormalizedPower() / a.ftp
+}
+
+func (a *PowerAnalytics) TrainingStressScore() float64 {
+	return float64(a.seconds) * a.NormalizedPower() * a.IntensityFactor() / (a.ftp * 3600) * 100
+}
+
+// EfficiencyFactor is NP over average heart rate, or 0 without both.
+func (a *PowerAnalytics) EfficiencyFactor() float64 {
+	n := len(a.heartRateTotals)
+	if n == 0 || a.count4 == 0 {
+		return 0
+	}
+
+	return a.NormalizedPower() / (a.heartRateTotals[n-1] / float64(n))
+}
+
+// Decoupling is the percentage drop in average power over average heart
+// rate from the first half of the session to the second. Positive means
+// heart rate drifted up (or power down) as the session went on. False until
+// there's enough of a session to say.
+func (a *PowerAnalytics) Decoupling() (float64, bool) {
+	n := len(a.heartRateTotals)
+	if n < int(minDecouplingTime/time.Second) {
+		return 0, false
+	}
+
+	half := n / 2
+	firstPower, firstHR := a.powerTotals[half-1], a.heartRateTotals[half-1]
+	secondPower := a.powerTotals[n-1] - firstPower
+	secondHR := a.heartRateTotals[n-1] - firstHR
+
+	if firstPower == 0 || secondHR == 0 {
+		return 0, false
+	}
+
+	first := firstPower / firstHR
+	second := secondPower / secondHR
+	return (first - second) / first * 100, true
+}
+
+// Summarize adds NP, IF and TSS, EF and decoupling, and the average pedal
+// balance if we have one, to the summary. Only call this once Run has
+// returned.
+func (a *PowerAnalytics) Summarize(s *SessionSummary) {
+	if a.count4 > 0 {
+		s.NormalizedPower = a.NormalizedPower()
+		s.IntensityFactor = a.IntensityFactor()
+		s.TrainingStressScore = a.TrainingStressScore()
+	}
+
+	s.EfficiencyFactor = a.EfficiencyFactor()
+	if decoupling, ok := a.Decoupling(); ok {
+		s.AerobicDecoupling = decoupling
+		s.HasDecoupling = true
+	}
+
+	if a.balanceCount == 0 {
+		return
+	}
+
+	s.PedalPowerBalance = a.balanceSum / float64(a.balanceCount)
+}
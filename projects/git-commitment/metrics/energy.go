@@ -0,0 +1,125 @@
+package metrics
+
+import "time"
+
+// Roughly how much of the energy burned goes into the pedals, which works
+// out at close to one kilocalorie burned per kilojoule of work.
+const (
+	grossEfficiency  = 0.24
+	kilojoulesPerCal = 4.184
+)
+
+// Power readings older than this don't count, and calories fall back to
+// being estimated from heart rate.
+const energyPowerTimeout = 5 * time.Second
+
+// RiderProfile is what the heart rate based calorie estimate needs to know
+// about the rider.
+type RiderProfile struct {
+	// Kilograms
+	Weight float64
+	// Years, 0 if unknown, which rules out estimating from heart rate.
+	Age float64
+	// "male" or "female", otherwise the estimate is the average of both.
+	Sex string
+}
+
+// heartRateCalories estimates kilocalories per second at the given heart
+// rate, using the formulas from Keytel et al. (2005).
+func (p RiderProfile) heartRateCalories(hr float64) float64 {
+	male := (-55.0969 + 0.6309*hr + 0.1988*p.Weight + 0.2017*p.Age) / kilojoulesPerCal
+	female := (-20.4022 + 0.4472*hr - 0.1263*p.Weight + 0.074*p.Age) / kilojoulesPerCal
+
+	perMinute := (male + female) / 2
+	switch p.Sex {
+	case "male":
+		perMinute = male
+	case "female":
+		perMinute = female
+	}
+
+	return max(perMinute, 0) / 60
+}
+
+// EnergyTracker is a pipeline stage which adds up the work done in
+// kilojoules from power, and estimates calories burned: from the work when
+// there's a power reading, otherwise from heart rate if the rider's profile
+// allows it.
+type EnergyTracker struct {
+	profile RiderProfile
+
+	power     float64
+	poweredAt time.Time
+	heartRate float64
+
+	// Kilojoules and kilocalories so far, and whether any of the calories
+	// came from heart rate.
+	work          float64
+	calories      float64
+	fromHeartRate bool
+	seconds       int
+}
+
+func NewEnergyTracker(profile RiderProfile) *EnergyTracker {
+	return &EnergyTracker{profile: profile}
+}
+
+// Run passes every metric from in through to out, adding the totals every
+// so often. Closes out once in is closed.
+func (et *EnergyTracker) Run(in <-chan Metric, out chan<- Metric) {
+	defer close(out)
+
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case m, ok := <-in:
+			if !ok {
+				return
+			}
+
+			switch m.Kind {
+			case CyclingPower:
+				et.power = m.Value
+				et.poweredAt = m.Timestamp
+			case HeartRate:
+				et.heartRate = m.Value
+			}
+			out <- m
+
+		case now := <-ticker.C:
+			et.sample(now)
+
+			if et.seconds%int(powerAnalyticsInterval/time.Second) != 0 || et.calories == 0 {
+				continue
+			}
+
+			if et.work > 0 {
+				out <- Metric{Kind: Work, Timestamp: now, Value: et.work}
+			}
+			out <- Metric{Kind: Calories, Timestamp: now, Value: et.calories}
+		}
+	}
+}
+
+func (et *EnergyTracker) sample(now time.Time) {
+	et.seconds++
+
+	if !et.poweredAt.IsZero() && now.Sub(et.poweredAt) < energyPowerTimeout {
+		kj := et.power / 1000
+		et.work += kj
+		et.calories += kj / (grossEfficiency * kilojoulesPerCal)
+	} else if et.heartRate > 0 && et.profile.Age > 0 {
+		et.calories += et.profile.heartRateCalories(et.heartRate)
+		et.fromHeartRate = true
+	}
+}
+
+// Summarize adds the work and calories to the summary. Only call this once
+// Run has returned.
+func (et *EnergyTracker) Summarize(s *SessionSummary) {
+	s.Work = et.work
+	s.Calories = et.calories
+	s.CaloriesFromHeartRate = et.fromHeartRate
+}
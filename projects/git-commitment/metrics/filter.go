@@ -0,0 +1,173 @@
+package metrics
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Filter smooths a stream of readings, returning the filtered value for
+// each new one.
+type Filter interface {
+	Apply(value float64) float64
+}
+
+// FilterSpec describes a filter, parsed from e.g. "median:5". Each source
+// (and window) gets its own filter made from it, since filters keep state.
+type FilterSpec struct {
+	// One of "average", "exponential" or "median".
+	Type string
+	// Number of readings for average and median, the smoothing factor
+	// between 0 and 1 for exponential.
+	Param float64
+}
+
+// ParseFilter parses a filter spec: "average:N" or "median:N" over the last
+// N readings, or "exponential:A" with smoothing factor A.
+func ParseFilter(s string) (FilterSpec, error) {
+	name, param, ok := strings.Cut(strings.TrimSpace(s), ":")
+	if !ok {
+		return FilterSpec{}, fmt.Errorf("filter %q: expected type:value", s)
+	}
+
+	value, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		return FilterSpec{}, fmt.Errorf("filter %q: %w", s, err)
+	}
+
+	spec := FilterSpec{Type: name, Param: value}
+	switch name {
+	case "average", "median":
+		if value < 1 || value != float64(int(value)) {
+			return FilterSpec{}, fmt.Errorf("filter %q: need a whole number of readings", s)
+		}
+	case "exponential":
+		if value <= 0 || value > 1 {
+			return FilterSpec{}, fmt.Errorf("filter %q: smoothing factor must be between 0 and 1", s)
+		}
+	default:
+		return FilterSpec{}, fmt.Errorf("filter %q: unknown type %q", s, name)
+	}
+
+	return spec, nil
+}
+
+func (spec FilterSpec) String() string {
+	return fmt.Sprintf("%s:%g", spec.Type, spec.Param)
+}
+
+func (spec FilterSpec) new() Filter {
+	switch spec.Type {
+	case "average":
+		return &movingAverage{window: make([]float64, 0, int(spec.Param))}
+	case "median":
+		return &movingMedian{window: make([]float64, 0, int(spec.Param))}
+	default:
+		return &exponential{alpha: spec.Param}
+	}
+}
+
+// movingAverage is the mean of the last few readings.
+type movingAverage struct {
+	window []float64
+	next   int
+}
+
+func (f *movingAverage) Apply(value float64) float64 {
+	f.next = push(&f.window, f.next, value)
+
+	sum := 0.0
+	for _, v := range f.window {
+		sum += v
+	}
+	return sum / float64(len(f.window))
+}
+
+// movingMedian is the median of the last few readings, which unlike the
+// mean ignores the odd wild spike entirely.
+type movingMedian struct {
+	window []float64
+	next   int
+}
+
+func (f *movingMedian) Apply(value float64) float64 {
+	f.next = push(&f.window, f.next, value)
+
+	sorted := slices.Clone(f.window)
+	slices.Sort(sorted)
+
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// exponential weights each new reading by alpha against everything before
+// it, so old readings fade out rather than dropping off a window.
+type exponential struct {
+	alpha       float64
+	initialized bool
+	value       float64
+}
+
+func (f *exponential) Apply(value float64) float64 {
+	if !f.initialized {
+		f.initialized = true
+		f.value = value
+	} else {
+		f.value += f.alpha * (value - f.value)
+	}
+	return f.value
+}
+
+// push adds value to a ring buffer which fills up to its capacity, and
+// returns where the next value goes.
+func push(window *[]float64, next int, value float64) int {
+	if len(*window) < cap(*window) {
+		*window = append(*window, value)
+	} else {
+		(*window)[next] = value
+	}
+	return (next + 1) % cap(*window)
+}
+
+// FilterStage is a pipeline stage which smooths the values of the
+// configured kinds of metric in place, so that every sink sees the same
+// displayable numbers from noisy sensors.
+type FilterStage struct {
+	specs map[Kind]FilterSpec
+
+	// Source address and metric name -> filter
+	filters map[string]Filter
+}
+
+func NewFilterStage(specs map[Kind]FilterSpec) *FilterStage {
+	return &FilterStage{
+		specs:   specs,
+		filters: map[string]Filter{},
+	}
+}
+
+// Run passes every metric from in through to out, filtering the values of
+// those with a filter configured. Closes out once in is closed.
+func (fs *FilterStage) Run(in <-chan Metric, out chan<- Metric) {
+	defer close(out)
+
+	for m := range in {
+		if spec, ok := fs.specs[m.Kind]; ok {
+			key := m.Address + "/" + m.Name()
+
+			filter, ok := fs.filters[key]
+			if !ok {
+				filter = spec.new()
+				fs.filters[key] = filter
+			}
+
+			m.Value = filter.Apply(m.Value)
+		}
+
+		out <- m
+	}
+}
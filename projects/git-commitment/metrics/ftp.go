@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"fmt"
+	"time"
+)
+
+// Critical power is fit to the points on the power curve between these
+// durations. Shorter efforts lean too much on W', and longer ones on
+// fatigue, for the two parameter model to hold.
+const (
+	cpFitShortest = 3 * time.Minute
+	cpFitLongest  = 20 * time.Minute
+)
+
+// FTPEstimate is what a session's power curve says about FTP. Zero means
+// there wasn't enough data for that estimate.
+type FTPEstimate struct {
+	// 95% of the best 20 minute power.
+	TwentyMinute float64
+
+	// Fit of the two parameter critical power model, in watts and
+	// joules. FTP is taken to be CP.
+	CP     float64
+	WPrime float64
+}
+
+// EstimateFTP estimates FTP from a power curve.
+//
+// The critical power model says the work done over an all-out effort of
+// duration t is W' + CP * t, so CP and W' are the slope and intercept of a
+// least squares line through work against time.
+func EstimateFTP(curve []PowerCurvePoint) FTPEstimate {
+	est := FTPEstimate{}
+
+	var n, sumT, sumW, sumTT, sumTW float64
+	for _, p := range curve {
+		if p.Duration == 20*time.Minute {
+			est.TwentyMinute = p.Power * 0.95
+		}
+
+		if p.Duration < cpFitShortest || p.Duration > cpFitLongest {
+			continue
+		}
+
+		t := p.Duration.Seconds()
+		w := p.Power * t
+		n++
+		sumT += t
+		sumW += w
+		sumTT += t * t
+		sumTW += t * w
+	}
+
+	if n < 2 {
+		return est
+	}
+
+	cp := (n*sumTW - sumT*sumW) / (n*sumTT - sumT*sumT)
+	wPrime := (sumW - cp*sumT) / n
+
+	// Flat or noisy efforts can fit nonsense, which is worse than no
+	// estimate at all.
+	if cp > 0 && wPrime > 0 {
+		est.CP, est.WPrime = cp, wPrime
+	}
+
+	return est
+}
+
+// FTP is the estimate to go with: 20 minute power if there is one, since
+// it's the better known test, otherwise CP.
+func (est FTPEstimate) FTP() float64 {
+	if est.TwentyMinute > 0 {
+		return est.TwentyMinute
+	}
+	return est.CP
+}
+
+func (est FTPEstimate) String() string {
+	switch {
+	case est.TwentyMinute > 0 && est.CP > 0:
+		return fmt.Sprintf("%.0fW from 20 min power, %.0fW from CP fit (W' %.1fkJ)",
+			est.TwentyMinute, est.CP, est.WPrime/1000)
+	case est.TwentyMinute > 0:
+		return fmt.Sprintf("%.0fW from 20 min power", est.TwentyMinute)
+	case est.CP > 0:
+		return fmt.Sprintf("%.0fW from CP fit (W' %.1fkJ)", est.CP, est.WPrime/1000)
+	}
+	return "not enough data"
+}
@@ -0,0 +1,136 @@
+package metrics
+
+import (
+	"math"
+	"time"
+)
+
+// RR intervals outside of this range (in milliseconds) are sensor glitches
+// or missed beats rather than anything a heart does.
+const (
+	minRRInterval = 300
+	maxRRInterval = 2000
+)
+
+// Successive intervals which differ by more than this fraction are taken to
+// be ectopic beats, and left out of RMSSD.
+const maxRRChange = 0.2
+
+// HRVCalculator is a pipeline stage which computes heart rate variability
+// from RR intervals, over each of the configured windows: RMSSD (root mean
+// square of successive differences) and SDNN (standard deviation of the
+// intervals), both in milliseconds.
+//
+// Windows are measured in beats rather than wall clock time, by adding up
+// the intervals, so they're not thrown off by notifications arriving late
+// or a replay running faster than real time.
+type HRVCalculator struct {
+	windows []time.Duration
+
+	// Intervals in milliseconds, oldest first, covering the longest window,
+	// and their sum.
+	intervals []float64
+	total     float64
+}
+
+func NewHRVCalculator(windows []time.Duration) *HRVCalculator {
+	return &HRVCalculator{windows: windows}
+}
+
+// Run passes every metric from in through to out, adding RMSSD and SDNN for
+// each window which is full every so often. Closes out once in is closed.
+func (hc *HRVCalculator) Run(in <-chan Metric, out chan<- Metric) {
+	defer close(out)
+
+	ticker := time.NewTicker(powerAnalyticsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case m, ok := <-in:
+			if !ok {
+				return
+			}
+
+			if m.Kind == HeartRateRRInterval {
+				hc.add(m.Value)
+			}
+			out <- m
+
+		case now := <-ticker.C:
+			for _, window := range hc.windows {
+				rmssd, sdnn, ok := hc.compute(window)
+				if !ok {
+					continue
+				}
+
+				out <- Metric{Kind: HRVRMSSD, Timestamp: now, Window: window, Value: rmssd}
+				out <- Metric{Kind: HRVSDNN, Timestamp: now, Window: window, Value: sdnn}
+			}
+		}
+	}
+}
+
+func (hc *HRVCalculator) add(rr float64) {
+	if rr < minRRInterval || rr > maxRRInterval || len(hc.windows) == 0 {
+		return
+	}
+
+	hc.intervals = append(hc.intervals, rr)
+	hc.total += rr
+
+	longest := 0.0
+	for _, w := range hc.windows {
+		longest = max(longest, float64(w.Milliseconds()))
+	}
+
+	// Keep just enough to tell when the longest window is full.
+	for len(hc.intervals) > 1 && hc.total-hc.intervals[0] >= longest {
+		hc.total -= hc.intervals[0]
+		hc.intervals = hc.intervals[1:]
+	}
+}
+
+// compute RMSSD and SDNN over the most recent window of intervals. False
+// until there's a full window.
+func (hc *HRVCalculator) compute(window time.Duration) (float64, float64, bool) {
+	limit := float64(window.Milliseconds())
+	if hc.total < limit {
+		return 0, 0, false
+	}
+
+	// Most recent intervals adding up to no more than the window.
+	start, sum := len(hc.intervals), 0.0
+	for start > 0 && sum+hc.intervals[start-1] <= limit {
+		start--
+		sum += hc.intervals[start]
+	}
+
+	tail := hc.intervals[start:]
+	if len(tail) < 2 {
+		return 0, 0, false
+	}
+
+	mean := sum / float64(len(tail))
+	variance := 0.0
+	for _, rr := range tail {
+		variance += (rr - mean) * (rr - mean)
+	}
+	sdnn := math.Sqrt(variance / float64(len(tail)-1))
+
+	squares, n := 0.0, 0
+	for i := 1; i < len(tail); i++ {
+		diff := tail[i] - tail[i-1]
+		if math.Abs(diff) > tail[i-1]*maxRRChange {
+			continue
+		}
+
+		squares += diff * diff
+		n++
+	}
+	if n == 0 {
+		return 0, 0, false
+	}
+
+	return math.Sqrt(squares / float64(n)), sdnn, true
+}
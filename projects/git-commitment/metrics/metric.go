@@ -0,0 +1,231 @@
+// Package metrics defines the metrics read from sensors, and the pipeline
+// stages which derive further metrics from them.
+package metrics
+
+import (
+	"fmt"
+	"time"
+
+	"tinygo.org/x/bluetooth"
+)
+
+type Kind int
+
+const (
+	HeartRate Kind = iota
+	CyclingPower
+	CyclingSpeed
+	CyclingCadence
+	CyclingDistance
+	HeartRateRRInterval
+	EnergyExpended
+	RunningPace
+	RunningCadence
+	RunningStrideLength
+	RunningDistance
+	// Percent grade of a treadmill.
+	Incline
+	// Total strides so far on an elliptical or stair climber.
+	StrideCount
+	// Percentage of power from the left pedal.
+	PedalPowerBalance
+	// Totals since we started listening, as reported by a power meter.
+	AccumulatedTorque
+	AccumulatedEnergy
+	// Samples through a single crank revolution, from the Cycling Power
+	// Vector characteristic. Value is the mean, Profile holds the samples.
+	CrankTorqueProfile
+	CrankForceProfile
+	// Angle of the crank at the first sample of a profile.
+	CrankProfileAngle
+	// Percentages for pedaling technique, per leg where the power meter
+	// can tell them apart, otherwise for both together: how much of the
+	// positive torque isn't undone by negative torque, and average over
+	// peak power through a revolution.
+	TorqueEffectiveness
+	TorqueEffectivenessLeft
+	TorqueEffectivenessRight
+	PedalSmoothness
+	PedalSmoothnessLeft
+	PedalSmoothnessRight
+	// Degrees Celsius from a body temperature sensor, and how much heat
+	// stress that adds up to, from 0 to 10.
+	CoreTemperature
+	SkinTemperature
+	HeatStrainIndex
+	// E-bike assist level, 0 for off, and how much of the effort the motor
+	// is providing, in percent.
+	AssistLevel
+	MotorAssist
+	// Current gear of electronic shifting, counting from 1.
+	FrontGear
+	RearGear
+	// Percent, read every so often rather than notified.
+	BatteryLevel
+
+	// Derived from other metrics rather than read from a sensor.
+	NormalizedPower
+	IntensityFactor
+	TrainingStressScore
+	PowerZone
+	PowerZoneTime
+	HeartRateZone
+	HeartRateZoneTime
+	SmoothedPower
+	// Kilojoules of anaerobic work capacity left.
+	WPrimeBalance
+	// Best average power over Window so far this session.
+	PeakPower
+	// NP over average heart rate, and the percentage drop in power over
+	// heart rate from the first half of the session to the second.
+	EfficiencyFactor
+	AerobicDecoupling
+	// Totals so far this session: kilojoules of work from power, and
+	// kilocalories burned.
+	Work
+	Calories
+	// Heart rate variability over Window, in milliseconds.
+	HRVRMSSD
+	HRVSDNN
+
+	// Not really a metric: 1 when a source stops sending data, 0 when it
+	// starts again.
+	SourceStale
+)
+
+var KindNames = map[Kind]string{
+	HeartRate:           "heart_rate",
+	CyclingPower:        "cycling_power",
+	CyclingSpeed:        "cycling_speed",
+	CyclingCadence:      "cycling_cadence",
+	CyclingDistance:     "cycling_distance",
+	HeartRateRRInterval: "heart_rate_rr_interval",
+	EnergyExpended:      "energy_expended",
+	RunningPace:         "running_pace",
+	RunningCadence:      "running_cadence",
+	RunningStrideLength: "running_stride_length",
+	RunningDistance:     "running_distance",
+	Incline:             "incline",
+	StrideCount:         "stride_count",
+	PedalPowerBalance:   "pedal_power_balance",
+	AccumulatedTorque:   "accumulated_torque",
+	AccumulatedEnergy:   "accumulated_energy",
+	CrankTorqueProfile:  "crank_torque_profile",
+	CrankForceProfile:   "crank_force_profile",
+	CrankProfileAngle:   "crank_profile_angle",
+
+	TorqueEffectiveness:      "torque_effectiveness",
+	TorqueEffectivenessLeft:  "torque_effectiveness_left",
+	TorqueEffectivenessRight: "torque_effectiveness_right",
+	PedalSmoothness:          "pedal_smoothness",
+	PedalSmoothnessLeft:      "pedal_smoothness_left",
+	PedalSmoothnessRight:     "pedal_smoothness_right",
+
+	CoreTemperature: "core_temperature",
+	SkinTemperature: "skin_temperature",
+	HeatStrainIndex: "heat_strain_index",
+	AssistLevel:     "assist_level",
+	MotorAssist:     "motor_assist",
+	FrontGear:       "front_gear",
+	RearGear:        "rear_gear",
+
+	BatteryLevel:        "battery_level",
+	NormalizedPower:     "normalized_power",
+	IntensityFactor:     "intensity_factor",
+	TrainingStressScore: "training_stress_score",
+	PowerZone:           "power_zone",
+	PowerZoneTime:       "power_zone_time",
+	HeartRateZone:       "heart_rate_zone",
+	HeartRateZoneTime:   "heart_rate_zone_time",
+	SmoothedPower:       "smoothed_power",
+	WPrimeBalance:       "w_prime_balance",
+	PeakPower:           "peak_power",
+	EfficiencyFactor:    "efficiency_factor",
+	AerobicDecoupling:   "aerobic_decoupling",
+	Work:                "work",
+	Calories:            "calories",
+	HRVRMSSD:            "hrv_rmssd",
+	HRVSDNN:             "hrv_sdnn",
+	SourceStale:         "source_stale",
+}
+
+func (k Kind) String() string {
+	if name, ok := KindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("<unknown: %d>", int(k))
+}
+
+// Derived is whether metrics of this kind are calculated by the pipeline
+// rather than read from a sensor. SourceStale comes from the sources
+// themselves, so isn't.
+func (k Kind) Derived() bool {
+	return k >= NormalizedPower && k != SourceStale
+}
+
+// ParseKind looks up a kind by the name used in output, e.g. "heart_rate".
+func ParseKind(name string) (Kind, error) {
+	for kind, n := range KindNames {
+		if n == name {
+			return kind, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown metric kind: %q", name)
+}
+
+// DeviceInfo is what the device reports about itself through the Device
+// Information Service. Any of these may be empty, since all of the
+// characteristics are optional.
+type DeviceInfo struct {
+	Manufacturer string
+	Model        string
+	Firmware     string
+	Serial       string
+}
+
+// Metric is a single reading from a sensor, or a value derived from them.
+type Metric struct {
+	Kind Kind
+	Info DeviceInfo
+
+	// When the notification this came from was received, or when it was
+	// calculated for derived metrics.
+	Timestamp time.Time
+
+	// Only set for metrics which are averaged over some window of time,
+	// to tell them apart.
+	Window time.Duration
+
+	// Where this metric came from
+	Address        string
+	Alias          string
+	Characteristic bluetooth.UUID
+
+	// Speed is in km/h, pace in seconds per km, distance and stride length
+	// in meters, cadence in RPM (or steps per minute), energy in kilojoules
+	// except for calories, RR intervals in milliseconds, torque in newton
+	// meters, force in newtons and angles in degrees, so this can't just be
+	// an int.
+	Value float64
+
+	// Only set for profiles, the individual samples making up Value.
+	Profile []float64
+}
+
+// Source is the alias of the device this metric came from, or its address
+// if it doesn't have one.
+func (m Metric) Source() string {
+	if m.Alias != "" {
+		return m.Alias
+	}
+	return m.Address
+}
+
+// Name identifies the metric in output, including the window for rolling
+// averages, e.g. "smoothed_power_3s".
+func (m Metric) Name() string {
+	if m.Window == 0 {
+		return m.Kind.String()
+	}
+	return fmt.Sprintf("%s_%s", m.Kind, m.Window)
+}
@@ -0,0 +1,32 @@
+package metrics
+
+// Metrics flow from every source into a single pipeline, through a series
+// of stages which can add derived metrics, and are then fanned out to each
+// of the sinks.
+//
+//	sources -> stage -> stage -> ... -> broadcast -> sinks
+
+// Broadcast sends every metric from in to each of the sinks, closing them
+// once in is closed.
+func Broadcast(in <-chan Metric, sinks []chan Metric) {
+	for m := range in {
+		for _, sink := range sinks {
+			sink <- m
+		}
+	}
+
+	for _, sink := range sinks {
+		close(sink)
+	}
+}
+
+// Tap passes every metric from in through to out, calling fn with each one
+// along the way. Closes out once in is closed.
+func Tap(in <-chan Metric, out chan<- Metric, fn func(Metric)) {
+	defer close(out)
+
+	for m := range in {
+		fn(m)
+		out <- m
+	}
+}
@@ -0,0 +1,145 @@
+package metrics
+
+import "time"
+
+// PowerCurveDurations are the durations the power curve tracks the best
+// average power for.
+var PowerCurveDurations = []time.Duration{
+	5 * time.Second,
+	15 * time.Second,
+	30 * time.Second,
+	1 * time.Minute,
+	5 * time.Minute,
+	10 * time.Minute,
+	20 * time.Minute,
+	60 * time.Minute,
+}
+
+// PowerCurvePoint is the best average power held for a duration.
+type PowerCurvePoint struct {
+	Duration time.Duration
+	Power    float64
+
+	// Best from earlier sessions, 0 if there isn't one.
+	PreviousBest float64
+}
+
+// NewBest is whether this beats every earlier session.
+func (p PowerCurvePoint) NewBest() bool {
+	return p.PreviousBest > 0 && p.Power > p.PreviousBest
+}
+
+// PowerCurve is a pipeline stage which keeps track of the mean-maximal
+// power curve over the session so far: the best average power held for
+// each of PowerCurveDurations.
+type PowerCurve struct {
+	// Most recent power reading
+	power float64
+
+	// Per-second power samples, long enough for the longest duration plus
+	// the one which has just dropped out of it.
+	samples []float64
+	seconds int
+
+	// Running sum over each duration, and the best average of each.
+	sums []float64
+	best []float64
+	// Whether the best has changed since it was last emitted.
+	changed []bool
+}
+
+func NewPowerCurve() *PowerCurve {
+	longest := PowerCurveDurations[len(PowerCurveDurations)-1]
+
+	return &PowerCurve{
+		samples: make([]float64, int(longest/time.Second)+1),
+		sums:    make([]float64, len(PowerCurveDurations)),
+		best:    make([]float64, len(PowerCurveDurations)),
+		changed: make([]bool, len(PowerCurveDurations)),
+	}
+}
+
+// Run passes every metric from in through to out, adding the peak power for
+// any durations which have a new best every so often. Closes out once in is
+// closed.
+func (pc *PowerCurve) Run(in <-chan Metric, out chan<- Metric) {
+	defer close(out)
+
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case m, ok := <-in:
+			if !ok {
+				return
+			}
+
+			if m.Kind == CyclingPower {
+				pc.power = m.Value
+			}
+			out <- m
+
+		case now := <-ticker.C:
+			pc.sample()
+
+			if pc.seconds%int(powerAnalyticsInterval/time.Second) != 0 {
+				continue
+			}
+
+			for i, duration := range PowerCurveDurations {
+				if !pc.changed[i] {
+					continue
+				}
+
+				pc.changed[i] = false
+				out <- Metric{Kind: PeakPower, Timestamp: now, Window: duration, Value: pc.best[i]}
+			}
+		}
+	}
+}
+
+func (pc *PowerCurve) sample() {
+	idx := pc.seconds % len(pc.samples)
+	pc.samples[idx] = pc.power
+	pc.seconds++
+
+	for i, duration := range PowerCurveDurations {
+		n := int(duration / time.Second)
+
+		pc.sums[i] += pc.power
+		if pc.seconds > n {
+			pc.sums[i] -= pc.samples[(pc.seconds-1-n)%len(pc.samples)]
+		}
+
+		if pc.seconds < n {
+			continue
+		}
+
+		if avg := pc.sums[i] / float64(n); avg > pc.best[i] {
+			pc.best[i] = avg
+			pc.changed[i] = true
+		}
+	}
+}
+
+// Curve returns the best power for each duration reached so far.
+func (pc *PowerCurve) Curve() []PowerCurvePoint {
+	curve := []PowerCurvePoint{}
+	for i, duration := range PowerCurveDurations {
+		if pc.best[i] == 0 {
+			continue
+		}
+
+		curve = append(curve, PowerCurvePoint{Duration: duration, Power: pc.best[i]})
+	}
+
+	return curve
+}
+
+// Summarize adds the power curve, and the FTP estimate from it, to the
+// summary. Only call this once Run has returned.
+func (pc *PowerCurve) Summarize(s *SessionSummary) {
+	s.PowerCurve = pc.Curve()
+	s.FTP = EstimateFTP(s.PowerCurve)
+}
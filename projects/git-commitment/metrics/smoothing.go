@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PowerSmoother is a pipeline stage which emits rolling averages of power
+// over each of the configured windows, since instantaneous power is far
+// too jumpy to be useful on a display.
+type PowerSmoother struct {
+	windows []time.Duration
+
+	// Most recent power reading
+	power float64
+
+	// Per-second power samples, long enough for the largest window.
+	samples []float64
+	seconds int
+}
+
+func NewPowerSmoother(windows []time.Duration) *PowerSmoother {
+	longest := 0
+	for _, w := range windows {
+		if secs := int(w / time.Second); secs > longest {
+			longest = secs
+		}
+	}
+
+	return &PowerSmoother{
+		windows: windows,
+		samples: make([]float64, longest),
+	}
+}
+
+// Run passes every metric from in through to out, adding a smoothed power
+// metric for each window every second. Closes out once in is closed.
+func (ps *PowerSmoother) Run(in <-chan Metric, out chan<- Metric) {
+	defer close(out)
+
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case m, ok := <-in:
+			if !ok {
+				return
+			}
+
+			if m.Kind == CyclingPower {
+				ps.power = m.Value
+			}
+			out <- m
+
+		case now := <-ticker.C:
+			if len(ps.samples) == 0 {
+				continue
+			}
+
+			ps.samples[ps.seconds%len(ps.samples)] = ps.power
+			ps.seconds++
+
+			for _, window := range ps.windows {
+				out <- Metric{
+					Kind:      SmoothedPower,
+					Timestamp: now,
+					Window:    window,
+					Value:     ps.average(int(window / time.Second)),
+				}
+			}
+		}
+	}
+}
+
+// average of the last n seconds, or however many we have if it's fewer.
+func (ps *PowerSmoother) average(n int) float64 {
+	if n > ps.seconds {
+		n = ps.seconds
+	}
+	if n == 0 {
+		return 0
+	}
+
+	sum := 0.0
+	for i := 1; i <= n; i++ {
+		sum += ps.samples[(ps.seconds-i)%len(ps.samples)]
+	}
+
+	return sum / float64(n)
+}
+
+// ParseWindows parses a comma separated list of durations, e.g. "3s,10s".
+// Bare numbers are taken to be seconds.
+func ParseWindows(s string) ([]time.Duration, error) {
+	windows := []time.Duration{}
+
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		if !strings.ContainsAny(part, "smh") {
+			part += "s"
+		}
+
+		window, err := time.ParseDuration(part)
+		if err != nil {
+			return nil, err
+		}
+		if window < time.Second {
+			return nil, fmt.Errorf("window too short: %s", window)
+		}
+
+		windows = append(windows, window.Truncate(time.Second))
+	}
+
+	return windows, nil
+}
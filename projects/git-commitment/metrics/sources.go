@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"log/slog"
+	"time"
+)
+
+// SourceSelector is a pipeline stage which passes each kind of metric
+// through from only one source at a time, so that e.g. a power meter and a
+// trainer both reporting power don't get mixed together.
+//
+// Sources are picked by priority, falling back to the next one when a
+// source goes quiet for longer than the timeout. Kinds without priorities
+// stick with whichever source was seen first, until it goes quiet.
+type SourceSelector struct {
+	// Kind -> source addresses, most preferred first
+	priorities map[Kind][]string
+	timeout    time.Duration
+
+	// Kind -> address -> when we last had a metric of that kind from it
+	lastSeen map[Kind]map[string]time.Time
+	// Kind -> address of the source currently being passed through
+	active map[Kind]string
+	// Address -> what to call the source in output
+	names map[string]string
+}
+
+func NewSourceSelector(priorities map[Kind][]string, timeout time.Duration) *SourceSelector {
+	return &SourceSelector{
+		priorities: priorities,
+		timeout:    timeout,
+		lastSeen:   map[Kind]map[string]time.Time{},
+		active:     map[Kind]string{},
+		names:      map[string]string{},
+	}
+}
+
+// Run passes metrics from the active source for each kind from in through
+// to out, dropping the rest. Closes out once in is closed.
+func (s *SourceSelector) Run(in <-chan Metric, out chan<- Metric) {
+	defer close(out)
+
+	for m := range in {
+		// Status of the device rather than a measurement, so always
+		// let it through.
+		if m.Kind == SourceStale || m.Kind == BatteryLevel || s.selected(m) {
+			out <- m
+		}
+	}
+}
+
+// selected records that the metric's source is alive, and reports whether
+// it's the one to use for this kind.
+func (s *SourceSelector) selected(m Metric) bool {
+	seen, ok := s.lastSeen[m.Kind]
+	if !ok {
+		seen = map[string]time.Time{}
+		s.lastSeen[m.Kind] = seen
+	}
+	seen[m.Address] = m.Timestamp
+	s.names[m.Address] = m.Source()
+
+	fresh := func(addr string) bool {
+		at, ok := seen[addr]
+		return ok && m.Timestamp.Sub(at) < s.timeout
+	}
+
+	// Highest priority source which is still sending, otherwise keep
+	// the current one while it lasts, otherwise take this one.
+	choice := ""
+	for _, addr := range s.priorities[m.Kind] {
+		if fresh(addr) {
+			choice = addr
+			break
+		}
+	}
+	if active, ok := s.active[m.Kind]; choice == "" && ok && fresh(active) {
+		choice = active
+	}
+	if choice == "" {
+		choice = m.Address
+	}
+
+	if prev, ok := s.active[m.Kind]; ok && prev != choice {
+		slog.Info("switching source", "kind", m.Kind, "from", s.names[prev], "to", s.names[choice])
+	}
+	s.active[m.Kind] = choice
+
+	return choice == m.Address
+}
@@ -0,0 +1,164 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// SessionSummary is printed at the end of a session. Each part of the
+// pipeline fills in what it knows about.
+type SessionSummary struct {
+	Duration time.Duration
+	// Part of Duration spent auto-paused.
+	Paused time.Duration
+
+	PowerZones        Zones
+	PowerZoneTime     []time.Duration
+	HeartRateZones    Zones
+	HeartRateZoneTime []time.Duration
+
+	// 0 unless we have power readings.
+	NormalizedPower     float64
+	IntensityFactor     float64
+	TrainingStressScore float64
+
+	// 0 unless we have both power and heart rate. Decoupling can be
+	// negative, so it has its own flag.
+	EfficiencyFactor  float64
+	AerobicDecoupling float64
+	HasDecoupling     bool
+
+	// Kilojoules of work from power, and kilocalories burned, some or all
+	// of which may have been estimated from heart rate.
+	Work                  float64
+	Calories              float64
+	CaloriesFromHeartRate bool
+
+	// Best average power by duration, shortest first.
+	PowerCurve []PowerCurvePoint
+	FTP        FTPEstimate
+
+	// Kilojoules, 0 unless critical power was given.
+	WPrime           float64
+	WPrimeBalanceMin float64
+
+	// Average percentage of power from the left pedal, 0 unless we have a
+	// dual-sided power meter.
+	PedalPowerBalance float64
+
+	// Gear changes, 0 unless we have electronic shifting.
+	FrontShifts int
+	RearShifts  int
+
+	Laps []LapSummary
+
+	// What to print distances in.
+	Units Units
+}
+
+// LapSummary averages a single lap. Zero means there were no readings.
+type LapSummary struct {
+	Duration time.Duration
+	// Meters
+	Distance float64
+
+	Power     float64
+	HeartRate float64
+	Cadence   float64
+
+	// "work" or "rest" for laps split at detected intervals, otherwise
+	// empty.
+	Interval string
+}
+
+func (s *SessionSummary) Print(w io.Writer) {
+	fmt.Fprintln(w, "Session summary:")
+	fmt.Fprintf(w, "\tduration: %s\n", s.Duration.Round(time.Second))
+	if s.Paused > 0 {
+		fmt.Fprintf(w, "\tmoving time: %s (paused %s)\n",
+			(s.Duration - s.Paused).Round(time.Second), s.Paused.Round(time.Second))
+	}
+
+	if s.NormalizedPower > 0 {
+		fmt.Fprintf(w, "\t%s\n", s.PowerLine())
+	}
+
+	if s.Calories > 0 {
+		line := fmt.Sprintf("calories: %.0fkcal", s.Calories)
+		if s.Work > 0 {
+			line = fmt.Sprintf("work: %.0fkJ, %s", s.Work, line)
+		}
+		if s.CaloriesFromHeartRate {
+			line += " (estimated from heart rate without power)"
+		}
+		fmt.Fprintf(w, "\t%s\n", line)
+	}
+
+	if s.EfficiencyFactor > 0 {
+		line := fmt.Sprintf("EF: %.2f", s.EfficiencyFactor)
+		if s.HasDecoupling {
+			line += fmt.Sprintf(", decoupling: %.1f%%", s.AerobicDecoupling)
+		}
+		fmt.Fprintf(w, "\t%s\n", line)
+	}
+
+	if len(s.PowerCurve) > 0 {
+		fmt.Fprintf(w, "\tpower curve:\n")
+		for _, p := range s.PowerCurve {
+			best := ""
+			if p.NewBest() {
+				best = fmt.Sprintf("  new best! (was %.0fW)", p.PreviousBest)
+			}
+			fmt.Fprintf(w, "\t\t%-6s %4.0fW%s\n", p.Duration, p.Power, best)
+		}
+	}
+
+	if s.FTP.FTP() > 0 {
+		fmt.Fprintf(w, "\testimated FTP: %s\n", s.FTP)
+	}
+
+	if s.WPrime > 0 {
+		fmt.Fprintf(w, "\tlowest W' balance: %.1fkJ of %.1fkJ (%.0f%%)\n",
+			s.WPrimeBalanceMin, s.WPrime, s.WPrimeBalanceMin/s.WPrime*100)
+	}
+
+	if s.PedalPowerBalance > 0 {
+		fmt.Fprintf(w, "\tpedal balance: %.1f%% L / %.1f%% R\n",
+			s.PedalPowerBalance, 100-s.PedalPowerBalance)
+	}
+
+	if s.FrontShifts > 0 || s.RearShifts > 0 {
+		fmt.Fprintf(w, "\tshifts: %d front, %d rear\n", s.FrontShifts, s.RearShifts)
+	}
+
+	printZones := func(title string, zones Zones, times []time.Duration) {
+		if zones.Len() == 0 {
+			return
+		}
+
+		fmt.Fprintf(w, "\t%s:\n", title)
+		for i, name := range zones.Names {
+			fmt.Fprintf(w, "\t\tZ%d %-16s %s\n", i+1, name, times[i])
+		}
+	}
+
+	printZones("time in power zones", s.PowerZones, s.PowerZoneTime)
+	printZones("time in heart rate zones", s.HeartRateZones, s.HeartRateZoneTime)
+
+	// A single lap would just repeat the session.
+	if len(s.Laps) > 1 {
+		fmt.Fprintf(w, "\tlaps:\n")
+		for i, lap := range s.Laps {
+			fmt.Fprintf(w, "\t\t%2d  %8s  %6.2f%s  %4.0fW  %3.0fbpm  %3.0frpm  %s\n",
+				i+1, lap.Duration.Round(time.Second), s.Units.Distance(lap.Distance), s.Units.DistanceUnit(),
+				lap.Power, lap.HeartRate, lap.Cadence, lap.Interval)
+		}
+	}
+}
+
+// PowerLine is a one line summary of NP, IF and TSS.
+func (s *SessionSummary) PowerLine() string {
+	return fmt.Sprintf("NP: %.0fW, IF: %.2f, TSS: %.0f",
+		s.NormalizedPower, s.IntensityFactor, s.TrainingStressScore)
+}
@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"fmt"
+)
+
+// Units are what to show measurements in. Metrics themselves are always
+// metric, this is only for display and exports meant for people.
+type Units int
+
+const (
+	MetricUnits Units = iota
+	ImperialUnits
+)
+
+const (
+	kmPerMile = 1.609344
+	kgPerLb   = 0.45359237
+)
+
+func ParseUnits(name string) (Units, error) {
+	switch name {
+	case "metric":
+		return MetricUnits, nil
+	case "imperial":
+		return ImperialUnits, nil
+	}
+
+	return MetricUnits, fmt.Errorf("unknown units %q, expected metric or imperial", name)
+}
+
+func (u Units) String() string {
+	if u == ImperialUnits {
+		return "imperial"
+	}
+	return "metric"
+}
+
+// Speed from km/h.
+func (u Units) Speed(kmh float64) float64 {
+	if u == ImperialUnits {
+		return kmh / kmPerMile
+	}
+	return kmh
+}
+
+func (u Units) SpeedUnit() string {
+	if u == ImperialUnits {
+		return "mph"
+	}
+	return "km/h"
+}
+
+// Distance from meters, in km or miles.
+func (u Units) Distance(meters float64) float64 {
+	if u == ImperialUnits {
+		return meters / 1000 / kmPerMile
+	}
+	return meters / 1000
+}
+
+func (u Units) DistanceUnit() string {
+	if u == ImperialUnits {
+		return "mi"
+	}
+	return "km"
+}
+
+// Weight from kg.
+func (u Units) Weight(kg float64) float64 {
+	if u == ImperialUnits {
+		return kg / kgPerLb
+	}
+	return kg
+}
+
+func (u Units) WeightUnit() string {
+	if u == ImperialUnits {
+		return "lb"
+	}
+	return "kg"
+}
+
+// Temperature from degrees Celsius.
+func (u Units) Temperature(celsius float64) float64 {
+	if u == ImperialUnits {
+		return celsius*9/5 + 32
+	}
+	return celsius
+}
+
+func (u Units) TemperatureUnit() string {
+	if u == ImperialUnits {
+		return "°F"
+	}
+	return "°C"
+}
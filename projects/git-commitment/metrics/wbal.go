@@ -0,0 +1,96 @@
+package metrics
+
+import "time"
+
+// DefaultWPrime is a typical anaerobic work capacity, in joules, for when
+// only critical power is known.
+const DefaultWPrime = 20000
+
+// WPrimeModel is a pipeline stage which models how much of the rider's
+// anaerobic work capacity (W') is left, using the differential form of
+// Skiba's W'bal model, sampled once a second:
+//
+//	above CP: W'bal -= (P - CP)
+//	below CP: W'bal += (W' - W'bal) * (CP - P) / W'
+//
+// so it drains in proportion to the power over critical power, and
+// recovers more slowly the closer it gets to full.
+type WPrimeModel struct {
+	// Watts and joules. Nothing is emitted without a critical power.
+	cp     float64
+	wPrime float64
+
+	// Most recent power reading, and whether we've had one yet.
+	power   float64
+	started bool
+
+	// Joules left, and the lowest it's been.
+	balance float64
+	lowest  float64
+}
+
+func NewWPrimeModel(cp, wPrime float64) *WPrimeModel {
+	return &WPrimeModel{
+		cp:      cp,
+		wPrime:  wPrime,
+		balance: wPrime,
+		lowest:  wPrime,
+	}
+}
+
+// Run passes every metric from in through to out, adding the balance in
+// kilojoules every second once there's power data. Closes out once in is
+// closed.
+func (wm *WPrimeModel) Run(in <-chan Metric, out chan<- Metric) {
+	defer close(out)
+
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case m, ok := <-in:
+			if !ok {
+				return
+			}
+
+			if m.Kind == CyclingPower {
+				wm.power = m.Value
+				wm.started = true
+			}
+			out <- m
+
+		case now := <-ticker.C:
+			if wm.cp <= 0 || wm.wPrime <= 0 || !wm.started {
+				continue
+			}
+
+			wm.sample()
+			out <- Metric{Kind: WPrimeBalance, Timestamp: now, Value: wm.balance / 1000}
+		}
+	}
+}
+
+func (wm *WPrimeModel) sample() {
+	if wm.power > wm.cp {
+		wm.balance -= wm.power - wm.cp
+	} else {
+		wm.balance += (wm.wPrime - wm.balance) * (wm.cp - wm.power) / wm.wPrime
+	}
+
+	// Going below zero just means CP or W' are set too low, but there's
+	// no sense in showing a negative battery.
+	wm.balance = max(wm.balance, 0)
+	wm.lowest = min(wm.lowest, wm.balance)
+}
+
+// Summarize adds the lowest balance reached to the summary. Only call this
+// once Run has returned.
+func (wm *WPrimeModel) Summarize(s *SessionSummary) {
+	if !wm.started || wm.cp <= 0 || wm.wPrime <= 0 {
+		return
+	}
+
+	s.WPrimeBalanceMin = wm.lowest / 1000
+	s.WPrime = wm.wPrime / 1000
+}
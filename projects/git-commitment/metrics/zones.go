@@ -0,0 +1,187 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+)
+
+// Zones divides a metric into ranges. Bounds holds the (exclusive) upper
+// bound of every zone except the last, which is open ended.
+type Zones struct {
+	Names  []string
+	Bounds []float64
+}
+
+// Classify returns the 0-based zone that value falls in.
+func (z Zones) Classify(value float64) int {
+	for i, bound := range z.Bounds {
+		if value < bound {
+			return i
+		}
+	}
+	return len(z.Bounds)
+}
+
+func (z Zones) Len() int {
+	return len(z.Names)
+}
+
+// Coggan's classic 7 power zones.
+func PowerZones(ftp float64) Zones {
+	return Zones{
+		Names: []string{
+			"Active Recovery",
+			"Endurance",
+			"Tempo",
+			"Threshold",
+			"VO2 Max",
+			"Anaerobic",
+			"Neuromuscular",
+		},
+		Bounds: []float64{
+			0.56 * ftp,
+			0.76 * ftp,
+			0.91 * ftp,
+			1.06 * ftp,
+			1.21 * ftp,
+			1.51 * ftp,
+		},
+	}
+}
+
+// Heart rate zones are based on lactate threshold HR if we have it, since
+// that's more accurate, otherwise percentage of max HR. Returns empty
+// zones if we have neither.
+func HeartRateZones(maxHR, thresholdHR float64) Zones {
+	names := []string{
+		"Recovery",
+		"Aerobic",
+		"Tempo",
+		"Threshold",
+		"Anaerobic",
+	}
+
+	switch {
+	case thresholdHR > 0:
+		return Zones{
+			Names: names,
+			Bounds: []float64{
+				0.81 * thresholdHR,
+				0.90 * thresholdHR,
+				0.94 * thresholdHR,
+				1.00 * thresholdHR,
+			},
+		}
+
+	case maxHR > 0:
+		return Zones{
+			Names: names,
+			Bounds: []float64{
+				0.60 * maxHR,
+				0.70 * maxHR,
+				0.80 * maxHR,
+				0.90 * maxHR,
+			},
+		}
+	}
+
+	return Zones{}
+}
+
+// ZoneTracker is a pipeline stage which classifies power and heart rate
+// into zones once per second, and keeps track of total time spent in each.
+type ZoneTracker struct {
+	mu sync.Mutex
+
+	powerZones     Zones
+	heartRateZones Zones
+
+	power     float64
+	heartRate float64
+
+	powerTime     []time.Duration
+	heartRateTime []time.Duration
+}
+
+func NewZoneTracker(powerZones, heartRateZones Zones) *ZoneTracker {
+	return &ZoneTracker{
+		powerZones:     powerZones,
+		heartRateZones: heartRateZones,
+		powerTime:      make([]time.Duration, powerZones.Len()),
+		heartRateTime:  make([]time.Duration, heartRateZones.Len()),
+	}
+}
+
+// Run passes every metric from in through to out, adding the current zone
+// (1-based) and time spent in it every second. Closes out once in is
+// closed.
+func (zt *ZoneTracker) Run(in <-chan Metric, out chan<- Metric) {
+	defer close(out)
+
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case m, ok := <-in:
+			if !ok {
+				return
+			}
+
+			zt.mu.Lock()
+			switch m.Kind {
+			case CyclingPower:
+				zt.power = m.Value
+			case HeartRate:
+				zt.heartRate = m.Value
+			}
+			zt.mu.Unlock()
+
+			out <- m
+
+		case now := <-ticker.C:
+			for _, m := range zt.tick(now, 1*time.Second) {
+				out <- m
+			}
+		}
+	}
+}
+
+func (zt *ZoneTracker) tick(now time.Time, elapsed time.Duration) []Metric {
+	zt.mu.Lock()
+	defer zt.mu.Unlock()
+
+	metrics := []Metric{}
+
+	if zt.power > 0 && zt.powerZones.Len() > 0 {
+		zone := zt.powerZones.Classify(zt.power)
+		zt.powerTime[zone] += elapsed
+
+		metrics = append(metrics,
+			Metric{Kind: PowerZone, Timestamp: now, Value: float64(zone + 1)},
+			Metric{Kind: PowerZoneTime, Timestamp: now, Value: zt.powerTime[zone].Seconds()},
+		)
+	}
+
+	if zt.heartRate > 0 && zt.heartRateZones.Len() > 0 {
+		zone := zt.heartRateZones.Classify(zt.heartRate)
+		zt.heartRateTime[zone] += elapsed
+
+		metrics = append(metrics,
+			Metric{Kind: HeartRateZone, Timestamp: now, Value: float64(zone + 1)},
+			Metric{Kind: HeartRateZoneTime, Timestamp: now, Value: zt.heartRateTime[zone].Seconds()},
+		)
+	}
+
+	return metrics
+}
+
+func (zt *ZoneTracker) Summarize(s *SessionSummary) {
+	zt.mu.Lock()
+	defer zt.mu.Unlock()
+
+	s.PowerZones = zt.powerZones
+	s.PowerZoneTime = append([]time.Duration{}, zt.powerTime...)
+	s.HeartRateZones = zt.heartRateZones
+	s.HeartRateZoneTime = append([]time.Duration{}, zt.heartRateTime...)
+}
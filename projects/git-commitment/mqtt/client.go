@@ -0,0 +1,309 @@
+// Package mqtt is a minimal MQTT 3.1.1 client, just enough to publish
+// messages at QoS 0. Nothing is ever subscribed to.
+package mqtt
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultKeepAlive is how long the broker waits to hear from us before
+// deciding we're gone.
+const DefaultKeepAlive = 60 * time.Second
+
+// Writes taking longer than this mean the broker has gone away.
+const writeTimeout = 10 * time.Second
+
+// Control packet types, already shifted into the high nibble.
+const (
+	packetConnect    = 1 << 4
+	packetConnack    = 2 << 4
+	packetPublish    = 3 << 4
+	packetPingreq    = 12 << 4
+	packetDisconnect = 14 << 4
+)
+
+// Connect flags
+const (
+	flagCleanSession = 1 << 1
+	flagWill         = 1 << 2
+	flagWillRetain   = 1 << 5
+	flagPassword     = 1 << 6
+	flagUsername     = 1 << 7
+)
+
+var connackErrors = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "client identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+// Message is a single message to publish.
+type Message struct {
+	Topic   string
+	Payload []byte
+	// Have the broker keep this as the last known value for the topic.
+	Retain bool
+}
+
+type Options struct {
+	ClientId string
+	Username string
+	Password string
+
+	// Zero means DefaultKeepAlive.
+	KeepAlive time.Duration
+
+	// Published by the broker if we disappear without disconnecting.
+	Will *Message
+}
+
+// Client is a connection to a broker. Safe to publish from multiple
+// goroutines.
+type Client struct {
+	conn net.Conn
+
+	mu     sync.Mutex
+	w      *bufio.Writer
+	closed bool
+
+	done chan struct{}
+	// Set if the connection dropped.
+	err error
+}
+
+// Dial connects to the broker at addr (host:port) and waits for it to
+// accept the connection.
+func Dial(addr string, opts Options) (*Client, error) {
+	if opts.KeepAlive == 0 {
+		opts.KeepAlive = DefaultKeepAlive
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, writeTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		conn: conn,
+		w:    bufio.NewWriter(conn),
+		done: make(chan struct{}),
+	}
+
+	if err := c.connect(opts); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	go c.read()
+	go c.ping(opts.KeepAlive / 2)
+
+	return c, nil
+}
+
+func (c *Client) connect(opts Options) error {
+	flags := byte(flagCleanSession)
+
+	body := appendString(nil, "MQTT")
+	body = append(body, 4, 0)
+	body = appendUint16(body, uint16(opts.KeepAlive/time.Second))
+
+	payload := appendString(nil, opts.ClientId)
+	if opts.Will != nil {
+		flags |= flagWill
+		if opts.Will.Retain {
+			flags |= flagWillRetain
+		}
+		payload = appendString(payload, opts.Will.Topic)
+		payload = appendBytes(payload, opts.Will.Payload)
+	}
+	if opts.Username != "" {
+		flags |= flagUsername
+		payload = appendString(payload, opts.Username)
+	}
+	if opts.Password != "" {
+		flags |= flagPassword
+		payload = appendString(payload, opts.Password)
+	}
+
+	// Connect flags go after the protocol level.
+	body[7] = flags
+
+	if err := c.write(packetConnect, append(body, payload...)); err != nil {
+		return err
+	}
+
+	c.conn.SetReadDeadline(time.Now().Add(writeTimeout))
+	defer c.conn.SetReadDeadline(time.Time{})
+
+	header, ack, err := readPacket(c.conn)
+	if err != nil {
+		return err
+	}
+	if header&0xf0 != packetConnack || len(ack) != 2 {
+		return errors.New("expected CONNACK")
+	}
+	if ack[1] != 0 {
+		if msg, ok := connackErrors[ack[1]]; ok {
+			return fmt.Errorf("connection refused: %s", msg)
+		}
+		return fmt.Errorf("connection refused: code %d", ack[1])
+	}
+
+	return nil
+}
+
+// read discards everything the broker sends (ping responses, really), and
+// notices when the connection drops.
+func (c *Client) read() {
+	r := bufio.NewReader(c.conn)
+	for {
+		if _, _, err := readPacket(r); err != nil {
+			c.mu.Lock()
+			if !c.closed {
+				c.err = err
+			}
+			c.mu.Unlock()
+
+			close(c.done)
+			return
+		}
+	}
+}
+
+func (c *Client) ping(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			if err := c.write(packetPingreq, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Publish sends a message at QoS 0, so there's no telling whether it
+// arrived.
+func (c *Client) Publish(msg Message) error {
+	header := byte(packetPublish)
+	if msg.Retain {
+		header |= 1
+	}
+
+	return c.write(header, append(appendString(nil, msg.Topic), msg.Payload...))
+}
+
+// Err is why the connection dropped, nil while it's still up.
+func (c *Client) Err() error {
+	select {
+	case <-c.done:
+	default:
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err == nil {
+		return errors.New("connection closed")
+	}
+	return c.err
+}
+
+// Close disconnects cleanly, so the will isn't published.
+func (c *Client) Close() error {
+	c.write(packetDisconnect, nil)
+
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+
+	return c.conn.Close()
+}
+
+func (c *Client) write(header byte, body []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return errors.New("connection closed")
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+
+	c.w.WriteByte(header)
+	c.w.Write(appendLength(nil, len(body)))
+	c.w.Write(body)
+	return c.w.Flush()
+}
+
+func readPacket(r io.Reader) (byte, []byte, error) {
+	var buf [1]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return 0, nil, err
+	}
+	header := buf[0]
+
+	// Remaining length is a varint of up to 4 bytes.
+	length, shift := 0, 0
+	for i := 0; ; i++ {
+		if i == 4 {
+			return 0, nil, errors.New("malformed remaining length")
+		}
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return 0, nil, err
+		}
+
+		length |= int(buf[0]&0x7f) << shift
+		shift += 7
+		if buf[0]&0x80 == 0 {
+			break
+		}
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+
+	return header, body, nil
+}
+
+func appendLength(buf []byte, n int) []byte {
+	for {
+		b := byte(n & 0x7f)
+		n >>= 7
+		if n > 0 {
+			b |= 0x80
+		}
+		buf = append(buf, b)
+
+		if n == 0 {
+			return buf
+		}
+	}
+}
+
+func appendUint16(buf []byte, n uint16) []byte {
+	return append(buf, byte(n>>8), byte(n))
+}
+
+func appendBytes(buf []byte, b []byte) []byte {
+	return append(appendUint16(buf, uint16(len(b))), b...)
+}
+
+func appendString(buf []byte, s string) []byte {
+	return appendBytes(buf, []byte(s))
+}
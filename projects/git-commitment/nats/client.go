@@ -0,0 +1,236 @@
+// Package nats is a minimal NATS client, just enough to publish messages
+// over plain TCP. Nothing is ever subscribed to.
+package nats
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultPort is used when the server address doesn't have one.
+const DefaultPort = "4222"
+
+// Writes taking longer than this mean the server has gone away.
+const writeTimeout = 10 * time.Second
+
+// Options authenticate us to the server, with either a user name and
+// password or a token. All of them may be empty.
+type Options struct {
+	Name     string
+	Username string
+	Password string
+	Token    string
+}
+
+// serverInfo is the part of the server's INFO we care about.
+type serverInfo struct {
+	TLSRequired bool `json:"tls_required"`
+	MaxPayload  int  `json:"max_payload"`
+}
+
+type connectOptions struct {
+	Verbose  bool   `json:"verbose"`
+	Pedantic bool   `json:"pedantic"`
+	Name     string `json:"name,omitempty"`
+	User     string `json:"user,omitempty"`
+	Pass     string `json:"pass,omitempty"`
+	Token    string `json:"auth_token,omitempty"`
+	Lang     string `json:"lang"`
+	Version  string `json:"version"`
+}
+
+// Client is a connection to a server. Safe to publish from multiple
+// goroutines.
+type Client struct {
+	conn       net.Conn
+	maxPayload int
+
+	mu     sync.Mutex
+	w      *bufio.Writer
+	closed bool
+
+	done chan struct{}
+	// Set if the connection dropped, or the server sent an error.
+	err error
+}
+
+// Dial connects to the server at addr (host or host:port) and waits for it
+// to accept the connection.
+func Dial(addr string, opts Options) (*Client, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, DefaultPort)
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, writeTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		conn: conn,
+		w:    bufio.NewWriter(conn),
+		done: make(chan struct{}),
+	}
+
+	r := bufio.NewReader(conn)
+	if err := c.connect(r, opts); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	go c.read(r)
+	return c, nil
+}
+
+// connect reads the server's INFO, sends CONNECT and then a PING, which
+// the server answers with either PONG or an error if it didn't like the
+// CONNECT.
+func (c *Client) connect(r *bufio.Reader, opts Options) error {
+	c.conn.SetReadDeadline(time.Now().Add(writeTimeout))
+	defer c.conn.SetReadDeadline(time.Time{})
+
+	line, err := readLine(r)
+	if err != nil {
+		return err
+	}
+	op, args, _ := strings.Cut(line, " ")
+	if !strings.EqualFold(op, "INFO") {
+		return fmt.Errorf("expected INFO, got %q", line)
+	}
+
+	var info serverInfo
+	if err := json.Unmarshal([]byte(args), &info); err != nil {
+		return fmt.Errorf("bad INFO: %w", err)
+	}
+	if info.TLSRequired {
+		return errors.New("server requires TLS, which isn't supported")
+	}
+	c.maxPayload = info.MaxPayload
+
+	connect, err := json.Marshal(connectOptions{
+		Name:    opts.Name,
+		User:    opts.Username,
+		Pass:    opts.Password,
+		Token:   opts.Token,
+		Lang:    "go",
+		Version: "0.1.0",
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := c.write("CONNECT " + string(connect) + "\r\nPING\r\n"); err != nil {
+		return err
+	}
+
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return err
+		}
+
+		switch op, args, _ := strings.Cut(line, " "); strings.ToUpper(op) {
+		case "PONG":
+			return nil
+		case "-ERR":
+			return fmt.Errorf("connection refused: %s", strings.Trim(args, "'"))
+		}
+	}
+}
+
+// read answers the server's pings and notices when the connection drops.
+func (c *Client) read(r *bufio.Reader) {
+	var err, serverErr error
+	for err == nil {
+		var line string
+		if line, err = readLine(r); err != nil {
+			break
+		}
+
+		switch op, args, _ := strings.Cut(line, " "); strings.ToUpper(op) {
+		case "PING":
+			err = c.write("PONG\r\n")
+		case "-ERR":
+			// Most errors are followed by the server closing the
+			// connection, so hang on to the reason rather than just
+			// seeing EOF.
+			serverErr = fmt.Errorf("server error: %s", strings.Trim(args, "'"))
+		}
+	}
+	if serverErr != nil {
+		err = serverErr
+	}
+
+	c.mu.Lock()
+	if !c.closed {
+		c.err = err
+	}
+	c.mu.Unlock()
+
+	close(c.done)
+}
+
+// Publish sends payload to subject. There's no telling whether anything
+// was listening.
+func (c *Client) Publish(subject string, payload []byte) error {
+	if c.maxPayload > 0 && len(payload) > c.maxPayload {
+		return fmt.Errorf("payload of %d bytes is over the server's limit of %d", len(payload), c.maxPayload)
+	}
+
+	return c.write(fmt.Sprintf("PUB %s %d\r\n%s\r\n", subject, len(payload), payload))
+}
+
+// Err is why the connection dropped, nil while it's still up.
+func (c *Client) Err() error {
+	select {
+	case <-c.done:
+	default:
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err == nil {
+		return errors.New("connection closed")
+	}
+	return c.err
+}
+
+// Close disconnects. NATS has nothing like a will, so there's nothing to
+// say first.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+
+	return c.conn.Close()
+}
+
+func (c *Client) write(s string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return errors.New("connection closed")
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+
+	c.w.WriteString(s)
+	return c.w.Flush()
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/erik/git-commitment/gatt"
+	"github.com/gdamore/tcell/v2"
+	"tinygo.org/x/bluetooth"
+)
+
+type pickerDevice struct {
+	addr     string
+	name     string
+	services []string
+	rssi     int16
+	selected bool
+}
+
+// pickDevices scans for devices while showing them in an interactive list,
+// and returns the addresses of the ones the user selects. Returns nil if
+// the user backs out without picking anything.
+//
+//	up/down   move
+//	space     toggle selection
+//	enter     connect to the selected devices (or the highlighted one)
+//	q/esc     cancel
+func pickDevices(ctx context.Context, adapter *bluetooth.Adapter) ([]string, error) {
+	screen, err := tcell.NewScreen()
+	if err != nil {
+		return nil, err
+	}
+	if err := screen.Init(); err != nil {
+		return nil, err
+	}
+	defer screen.Fini()
+
+	mu := sync.Mutex{}
+	devices := []*pickerDevice{}
+	byAddr := map[string]*pickerDevice{}
+
+	onScanResult := func(bt *bluetooth.Adapter, result bluetooth.ScanResult) {
+		serviceNames := []string{}
+		for _, s := range gatt.KnownServiceUUIDs {
+			if result.HasServiceUUID(s) {
+				serviceNames = append(serviceNames, gatt.KnownServiceNames[s])
+			}
+		}
+
+		if len(serviceNames) == 0 {
+			return
+		}
+
+		mu.Lock()
+		addr := result.Address.String()
+		if dev, ok := byAddr[addr]; ok {
+			dev.rssi = result.RSSI
+		} else {
+			dev := &pickerDevice{
+				addr:     addr,
+				name:     result.LocalName(),
+				services: serviceNames,
+				rssi:     result.RSSI,
+			}
+			devices = append(devices, dev)
+			byAddr[addr] = dev
+		}
+		mu.Unlock()
+
+		// Wake up the event loop to redraw
+		screen.PostEvent(tcell.NewEventInterrupt(nil))
+	}
+
+	scanErr := make(chan error, 1)
+	go func() {
+		scanErr <- adapter.Scan(onScanResult)
+	}()
+	defer adapter.StopScan()
+
+	go func() {
+		<-ctx.Done()
+		screen.PostEvent(tcell.NewEventInterrupt(nil))
+	}()
+
+	cursor := 0
+
+	draw := func() {
+		mu.Lock()
+		defer mu.Unlock()
+
+		screen.Clear()
+		bold := tcell.StyleDefault.Bold(true)
+		drawText(screen, 0, 0, bold, "Select devices: up/down to move, space to select, enter to connect, q to quit")
+
+		if len(devices) == 0 {
+			drawText(screen, 0, 2, tcell.StyleDefault, "Scanning...")
+		}
+
+		for i, dev := range devices {
+			mark := "[ ]"
+			if dev.selected {
+				mark = "[x]"
+			}
+
+			style := tcell.StyleDefault
+			if i == cursor {
+				style = style.Reverse(true)
+			}
+
+			line := fmt.Sprintf("%s %-24s %-20s %-40s RSSI:%d",
+				mark, config.DeviceName(dev.addr), dev.name,
+				strings.Join(dev.services, ","), dev.rssi)
+			drawText(screen, 0, i+2, style, line)
+		}
+
+		screen.Show()
+	}
+
+	for {
+		draw()
+
+		select {
+		case err := <-scanErr:
+			if err != nil {
+				return nil, err
+			}
+		default:
+		}
+
+		if ctx.Err() != nil {
+			return nil, nil
+		}
+
+		ev, ok := screen.PollEvent().(*tcell.EventKey)
+		if !ok {
+			continue
+		}
+
+		mu.Lock()
+		count := len(devices)
+		mu.Unlock()
+
+		switch {
+		case ev.Key() == tcell.KeyUp:
+			if cursor > 0 {
+				cursor--
+			}
+
+		case ev.Key() == tcell.KeyDown:
+			if cursor < count-1 {
+				cursor++
+			}
+
+		case ev.Rune() == ' ' && count > 0:
+			mu.Lock()
+			devices[cursor].selected = !devices[cursor].selected
+			mu.Unlock()
+
+		case ev.Key() == tcell.KeyEnter && count > 0:
+			mu.Lock()
+			defer mu.Unlock()
+
+			addrs := []string{}
+			for _, dev := range devices {
+				if dev.selected {
+					addrs = append(addrs, dev.addr)
+				}
+			}
+
+			if len(addrs) == 0 {
+				addrs = append(addrs, devices[cursor].addr)
+			}
+
+			return addrs, nil
+
+		case ev.Key() == tcell.KeyEscape || ev.Key() == tcell.KeyCtrlC || ev.Rune() == 'q':
+			return nil, nil
+		}
+	}
+}
+
+func drawText(s tcell.Screen, x, y int, style tcell.Style, text string) {
+	for _, r := range text {
+		s.SetContent(x, y, r, nil, style)
+		x++
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// How often the trainer's target is corrected with -power-match. Trainers
+// take a few seconds to settle on a new target, so correcting any faster
+// just fights their own control loop.
+const powerMatchInterval = 5 * time.Second
+
+// Power meter readings older than this aren't used.
+const powerMatchMaxAge = 3 * time.Second
+
+// Fraction of the remaining error corrected at each interval.
+const powerMatchGain = 0.5
+
+// Largest correction allowed, as a fraction of the target, in case the
+// power meter is reading something else entirely.
+const powerMatchMaxOffset = 0.25
+
+// powerMatcher works out what target to give the trainer so that the power
+// meter, rather than the trainer's own estimate, reads the ERG target.
+type powerMatcher struct {
+	// Smoothed power meter reading
+	measured   float64
+	measuredAt time.Time
+
+	// Watts added to the target sent to the trainer
+	offset float64
+}
+
+// observe records a power meter reading.
+func (pm *powerMatcher) observe(watts float64, at time.Time) {
+	if pm.measuredAt.IsZero() || at.Sub(pm.measuredAt) > powerMatchMaxAge {
+		pm.measured = watts
+	} else {
+		pm.measured += 0.3 * (watts - pm.measured)
+	}
+	pm.measuredAt = at
+}
+
+// adjust nudges the offset towards whatever makes the power meter read
+// target. Returns true if the trainer's target needs updating.
+func (pm *powerMatcher) adjust(target int, now time.Time) bool {
+	// Nothing to match, or not pedaling.
+	if target <= 0 || now.Sub(pm.measuredAt) > powerMatchMaxAge || pm.measured <= 0 {
+		return false
+	}
+
+	limit := float64(target) * powerMatchMaxOffset
+	offset := pm.offset + powerMatchGain*(float64(target)-pm.measured)
+	offset = math.Max(-limit, math.Min(offset, limit))
+
+	if math.Abs(math.Round(offset)-math.Round(pm.offset)) < 1 {
+		return false
+	}
+
+	pm.offset = offset
+	return true
+}
+
+// target is what to send the trainer for the power meter to read target.
+func (pm *powerMatcher) target(target int) int {
+	return target + int(math.Round(pm.offset))
+}
@@ -0,0 +1,221 @@
+// Package redis is a minimal Redis client, just enough to send commands
+// and read back their replies, in batches.
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultPort is used when the server address doesn't have one.
+const DefaultPort = "6379"
+
+// Commands taking longer than this mean the server has gone away.
+const timeout = 10 * time.Second
+
+// Error is an error reply from the server, e.g. for a bad command. The
+// connection is still fine.
+type Error string
+
+func (e Error) Error() string { return string(e) }
+
+type Options struct {
+	// Empty for the default user, which is all there is before Redis 6.
+	Username string
+	Password string
+	DB       int
+}
+
+// Client is a connection to a server. Safe to use from multiple
+// goroutines, though commands are sent one batch at a time.
+type Client struct {
+	conn net.Conn
+
+	mu sync.Mutex
+	r  *bufio.Reader
+	w  *bufio.Writer
+	// Set once the connection is no use, e.g. after a timeout.
+	err error
+}
+
+// Dial connects to the server at addr (host or host:port), authenticating
+// and picking the database if need be.
+func Dial(addr string, opts Options) (*Client, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, DefaultPort)
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+		w:    bufio.NewWriter(conn),
+	}
+
+	setup := [][]string{}
+	if opts.Password != "" {
+		if opts.Username != "" {
+			setup = append(setup, []string{"AUTH", opts.Username, opts.Password})
+		} else {
+			setup = append(setup, []string{"AUTH", opts.Password})
+		}
+	}
+	if opts.DB != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(opts.DB)})
+	}
+
+	if len(setup) > 0 {
+		replies, err := c.Pipeline(setup)
+		if err == nil {
+			err = FirstError(replies)
+		}
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// Do sends a single command, returning its reply. Error replies are
+// returned as an Error.
+func (c *Client) Do(args ...string) (interface{}, error) {
+	replies, err := c.Pipeline([][]string{args})
+	if err != nil {
+		return nil, err
+	}
+	if err, ok := replies[0].(Error); ok {
+		return nil, err
+	}
+	return replies[0], nil
+}
+
+// Pipeline sends every command before reading any replies, returning a
+// reply for each command in turn. Error replies are left in with the rest
+// as an Error, see FirstError; only failures of the connection itself are
+// returned as err.
+//
+// Replies are strings, int64s, nil or []interface{} of the same.
+func (c *Client) Pipeline(cmds [][]string) ([]interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	c.conn.SetDeadline(time.Now().Add(timeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	for _, args := range cmds {
+		fmt.Fprintf(c.w, "*%d\r\n", len(args))
+		for _, arg := range args {
+			fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+	}
+	if err := c.w.Flush(); err != nil {
+		c.err = err
+		return nil, err
+	}
+
+	replies := make([]interface{}, len(cmds))
+	for i := range cmds {
+		reply, err := readReply(c.r)
+		if err != nil {
+			// Out of step with the server, so there's no carrying on.
+			c.err = err
+			return nil, err
+		}
+		replies[i] = reply
+	}
+
+	return replies, nil
+}
+
+// FirstError is the first error reply, if there is one.
+func FirstError(replies []interface{}) error {
+	for _, reply := range replies {
+		if err, ok := reply.(Error); ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// Err is why the connection can't be used any more, nil while it's fine.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.err
+}
+
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err == nil {
+		c.err = errors.New("connection closed")
+	}
+	return c.conn.Close()
+}
+
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty reply")
+	}
+
+	switch kind, rest := line[0], line[1:]; kind {
+	case '+':
+		return rest, nil
+
+	case '-':
+		return Error(rest), nil
+
+	case ':':
+		return strconv.ParseInt(rest, 10, 64)
+
+	case '$':
+		n, err := strconv.Atoi(rest)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+
+	case '*':
+		n, err := strconv.Atoi(rest)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+
+	return nil, fmt.Errorf("unexpected reply: %q", line)
+}
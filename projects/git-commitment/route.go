@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"time"
+
+	"github.com/erik/git-commitment/metrics"
+)
+
+// Grade is taken over this distance (in meters) centered on the current
+// position, since GPS elevation is far too noisy point to point.
+const routeGradeWindow = 50.0
+
+// Trainers can't do much beyond this anyway.
+const routeMaxGrade = 20.0
+
+// Only send a new grade when it has changed by at least this much.
+const routeGradeStep = 0.1
+
+// Trainers take a moment to respond, so don't send grades any faster than
+// this.
+const routeGradeInterval = 1 * time.Second
+
+// Gaps between speed readings longer than this (e.g. a sensor dropping
+// out) don't count towards distance.
+const routeMaxSpeedGap = 5 * time.Second
+
+// RoutePoint is a point along a route, by distance from the start.
+type RoutePoint struct {
+	// Meters
+	Distance  float64
+	Elevation float64
+}
+
+// Route is the elevation profile of a real world route, to ride on the
+// trainer in simulation mode.
+type Route struct {
+	Name   string
+	Points []RoutePoint
+}
+
+// Length is the total distance in meters.
+func (r *Route) Length() float64 {
+	return r.Points[len(r.Points)-1].Distance
+}
+
+// ElevationAt interpolates the elevation at distance meters along the
+// route.
+func (r *Route) ElevationAt(distance float64) float64 {
+	if distance <= 0 {
+		return r.Points[0].Elevation
+	}
+
+	for i := 1; i < len(r.Points); i++ {
+		a, b := r.Points[i-1], r.Points[i]
+		if distance > b.Distance {
+			continue
+		}
+
+		if b.Distance == a.Distance {
+			return b.Elevation
+		}
+
+		frac := (distance - a.Distance) / (b.Distance - a.Distance)
+		return a.Elevation + (b.Elevation-a.Elevation)*frac
+	}
+
+	return r.Points[len(r.Points)-1].Elevation
+}
+
+// GradeAt is the grade (in percent) at distance meters along the route.
+func (r *Route) GradeAt(distance float64) float64 {
+	from := math.Max(0, distance-routeGradeWindow/2)
+	to := math.Min(r.Length(), from+routeGradeWindow)
+	if to <= from {
+		return 0
+	}
+
+	grade := (r.ElevationAt(to) - r.ElevationAt(from)) / (to - from) * 100
+	return math.Max(-routeMaxGrade, math.Min(grade, routeMaxGrade))
+}
+
+// Only the parts of GPX we care about. Tracks are what most apps export,
+// but routes are accepted too.
+type gpxFile struct {
+	XMLName xml.Name `xml:"gpx"`
+	Name    string   `xml:"metadata>name"`
+	Tracks  []struct {
+		Name     string `xml:"name"`
+		Segments []struct {
+			Points []gpxPoint `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+	Routes []struct {
+		Name   string     `xml:"name"`
+		Points []gpxPoint `xml:"rtept"`
+	} `xml:"rte"`
+}
+
+type gpxPoint struct {
+	Lat       float64  `xml:"lat,attr"`
+	Lon       float64  `xml:"lon,attr"`
+	Elevation *float64 `xml:"ele"`
+}
+
+// LoadGPX reads a route from a GPX file. Every point needs an elevation.
+func LoadGPX(path string) (*Route, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var file gpxFile
+	if err := xml.NewDecoder(f).Decode(&file); err != nil {
+		return nil, err
+	}
+
+	route := &Route{Name: file.Name}
+	points := []gpxPoint{}
+	for _, trk := range file.Tracks {
+		if route.Name == "" {
+			route.Name = trk.Name
+		}
+		for _, seg := range trk.Segments {
+			points = append(points, seg.Points...)
+		}
+	}
+	for _, rte := range file.Routes {
+		if route.Name == "" {
+			route.Name = rte.Name
+		}
+		points = append(points, rte.Points...)
+	}
+
+	if len(points) < 2 {
+		return nil, errors.New("route needs at least two points")
+	}
+
+	distance := 0.0
+	for i, p := range points {
+		if p.Elevation == nil {
+			return nil, fmt.Errorf("point %d has no elevation", i)
+		}
+
+		if i > 0 {
+			distance += haversine(points[i-1].Lat, points[i-1].Lon, p.Lat, p.Lon)
+		}
+		route.Points = append(route.Points, RoutePoint{Distance: distance, Elevation: *p.Elevation})
+	}
+
+	if route.Length() == 0 {
+		return nil, errors.New("route has no length")
+	}
+
+	return route, nil
+}
+
+// haversine is the distance in meters between two points, good enough at
+// the scale of GPS track points.
+func haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadius = 6371000
+
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := rad(lat2 - lat1)
+	dLon := rad(lon2 - lon1)
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(rad(lat1))*math.Cos(rad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+
+	return earthRadius * 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// RouteRunner rides a route: virtual distance is built up from the speed
+// the trainer reports, and the grade at that point along the route is sent
+// through the control channel.
+type RouteRunner struct {
+	route    *Route
+	commands chan<- ControlCommand
+}
+
+func NewRouteRunner(route *Route, commands chan<- ControlCommand) *RouteRunner {
+	return &RouteRunner{route: route, commands: commands}
+}
+
+// Run consumes speed metrics until the end of the route, then keeps
+// draining metrics so we don't block the sources.
+func (r *RouteRunner) Run(in <-chan metrics.Metric) {
+	distance := 0.0
+	lastGrade := math.NaN()
+	var lastSent time.Time
+	var lastSpeed metrics.Metric
+	nextReport := 0.0
+
+	for m := range in {
+		if m.Kind != metrics.CyclingSpeed || m.Window != 0 {
+			continue
+		}
+
+		if !lastSpeed.Timestamp.IsZero() {
+			if dt := m.Timestamp.Sub(lastSpeed.Timestamp); dt > 0 && dt < routeMaxSpeedGap {
+				distance += lastSpeed.Value / 3.6 * dt.Seconds()
+			}
+		}
+		lastSpeed = m
+
+		if distance >= r.route.Length() {
+			slog.Info("route complete", "km", math.Round(r.route.Length()/100)/10)
+			r.commands <- ControlCommand{kind: ControlGrade, value: 0}
+			break
+		}
+
+		if distance >= nextReport {
+			slog.Info("route", "km", math.Round(distance/100)/10,
+				"of", math.Round(r.route.Length()/100)/10, "elevation", math.Round(r.route.ElevationAt(distance)))
+			nextReport += 1000
+		}
+
+		grade := math.Round(r.route.GradeAt(distance)/routeGradeStep) * routeGradeStep
+		if grade != lastGrade && m.Timestamp.Sub(lastSent) >= routeGradeInterval {
+			lastGrade = grade
+			lastSent = m.Timestamp
+			r.commands <- ControlCommand{kind: ControlGrade, value: grade}
+		}
+	}
+
+	for range in {
+	}
+}
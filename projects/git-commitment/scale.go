@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/erik/git-commitment/ble"
+	"github.com/erik/git-commitment/gatt"
+	"github.com/erik/git-commitment/sinks"
+	"github.com/erik/git-commitment/upload"
+	"tinygo.org/x/bluetooth"
+)
+
+var flagWeighTimeout time.Duration
+
+// Body composition usually follows the weight, once the scale has finished
+// measuring impedance, so give it a moment to show up.
+const bodyCompositionGrace = 5 * time.Second
+
+// readScale connects to the scale at addr and waits for a measurement,
+// until ctx is cancelled. Scales only wake up and advertise once someone
+// steps on them, so we keep trying to connect until then.
+func readScale(ctx context.Context, addr string) (gatt.BodyMeasurement, error) {
+	adapter := bluetooth.DefaultAdapter
+	if err := adapter.Enable(); err != nil {
+		return gatt.BodyMeasurement{}, fmt.Errorf("failed to enable BLE: %w", err)
+	}
+
+	address, err := ble.ParseAddress(addr)
+	if err != nil {
+		return gatt.BodyMeasurement{}, err
+	}
+
+	var device *bluetooth.Device
+	for attempt := 0; device == nil; attempt++ {
+		select {
+		case <-ctx.Done():
+			return gatt.BodyMeasurement{}, ctx.Err()
+		case <-time.After(ble.ReconnectBackoff(attempt)):
+		}
+
+		device, err = ble.ConnectWithTimeout(adapter, address, ble.DefaultConnectTimeout)
+		if err != nil {
+			slog.Debug("scale connection failed", "address", addr, "err", err)
+		}
+	}
+	defer device.Disconnect()
+
+	services, err := device.DiscoverServices([]bluetooth.UUID{
+		gatt.WeightScaleServiceUUID,
+		bluetooth.ServiceUUIDBodyComposition,
+	})
+	if err != nil {
+		return gatt.BodyMeasurement{}, err
+	}
+
+	type reading struct {
+		m   gatt.BodyMeasurement
+		err error
+	}
+	readings := make(chan reading, 8)
+
+	for _, service := range services {
+		chars, err := service.DiscoverCharacteristics([]bluetooth.UUID{
+			bluetooth.CharacteristicUUIDWeightMeasurement,
+			bluetooth.CharacteristicUUIDBodyCompositionMeasurement,
+		})
+		if err != nil {
+			return gatt.BodyMeasurement{}, err
+		}
+
+		for _, char := range chars {
+			decode := gatt.DecodeWeightMeasurement
+			if char.UUID() == bluetooth.CharacteristicUUIDBodyCompositionMeasurement {
+				decode = gatt.DecodeBodyComposition
+			}
+
+			err := char.EnableNotifications(func(buf []byte) {
+				m, err := decode(buf)
+				select {
+				case readings <- reading{m, err}:
+				default:
+				}
+			})
+			if err != nil {
+				return gatt.BodyMeasurement{}, err
+			}
+		}
+	}
+
+	slog.Info("connected to scale, waiting for a measurement", "device", config.DeviceName(addr))
+
+	var measurement gatt.BodyMeasurement
+	var grace <-chan time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			if measurement.Weight > 0 {
+				return measurement, nil
+			}
+			return gatt.BodyMeasurement{}, ctx.Err()
+
+		case <-grace:
+			return measurement, nil
+
+		case r := <-readings:
+			if errors.Is(r.err, gatt.ErrMeasurementFailed) {
+				slog.Warn("scale couldn't take a measurement, try again")
+				continue
+			} else if r.err != nil {
+				slog.Debug("failed to decode scale measurement", "err", r.err)
+				continue
+			}
+
+			// Newer readings take precedence.
+			r.m.Merge(measurement)
+			measurement = r.m
+
+			if measurement.Weight > 0 && measurement.BodyFat > 0 {
+				return measurement, nil
+			}
+			if measurement.Weight > 0 && grace == nil {
+				grace = time.After(bodyCompositionGrace)
+			}
+		}
+	}
+}
+
+// weigh reads a measurement from the scale, prints it, stores it and
+// optionally sends it to intervals.icu.
+func weigh(ctx context.Context, addr string, store *sinks.Store, w io.Writer) error {
+	units := displayUnits()
+
+	m, err := readScale(ctx, config.ResolveDevice(addr))
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "weight: %.2f %s\n", units.Weight(m.Weight), units.WeightUnit())
+	if m.BMI > 0 {
+		fmt.Fprintf(w, "BMI: %.1f\n", m.BMI)
+	}
+	if m.BodyFat > 0 {
+		fmt.Fprintf(w, "body fat: %.1f%%\n", m.BodyFat)
+	}
+	if m.MuscleMass > 0 {
+		fmt.Fprintf(w, "muscle mass: %.2f %s\n", units.Weight(m.MuscleMass), units.WeightUnit())
+	}
+
+	if store != nil {
+		if err := store.AddBodyMeasurement(config.ResolveDevice(addr), m); err != nil {
+			return fmt.Errorf("failed to store measurement: %w", err)
+		}
+	}
+
+	if flagIntervalsKey != "" {
+		date := m.Time
+		if date.IsZero() {
+			date = time.Now()
+		}
+
+		client := upload.NewIntervalsICU(flagIntervalsAthlete, flagIntervalsKey)
+		err := client.UpdateWellness(date, upload.Wellness{
+			Weight:  m.Weight,
+			BodyFat: m.BodyFat,
+		})
+		if err != nil {
+			return err
+		}
+
+		slog.Info("sent to intervals.icu", "date", date.Format("2006-01-02"))
+	}
+
+	return nil
+}
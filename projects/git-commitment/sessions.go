@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/erik/git-commitment/metrics"
+	"github.com/erik/git-commitment/sinks"
+)
+
+func listSessions(store *sinks.Store, w io.Writer) error {
+	sessions, err := store.ListSessions()
+	if err != nil {
+		return err
+	}
+
+	for _, s := range sessions {
+		duration := "in progress"
+		if s.EndedAt.Valid {
+			duration = s.EndedAt.Time.Sub(s.StartedAt).Round(time.Second).String()
+		}
+
+		fmt.Fprintf(w, "%5d  %-8s %s  %-12s %d samples",
+			s.Id,
+			s.Sport,
+			s.StartedAt.Local().Format("2006-01-02 15:04"),
+			duration,
+			s.Samples,
+		)
+		for _, tag := range s.Tags {
+			fmt.Fprintf(w, " #%s", tag)
+		}
+		if s.Note != "" {
+			fmt.Fprintf(w, "  %q", s.Note)
+		}
+		fmt.Fprintln(w)
+	}
+
+	return nil
+}
+
+// serveSessions serves the session browser on addr until interrupted.
+func serveSessions(store *sinks.Store, addr string) error {
+	mux := http.NewServeMux()
+	sinks.NewSessionBrowser(store,
+		metrics.PowerZones(float64(flagFTP)),
+		metrics.HeartRateZones(float64(flagMaxHR), float64(flagThresholdHR)),
+		displayUnits(),
+	).Register(mux)
+	mux.Handle("/{$}", http.RedirectHandler("/sessions", http.StatusFound))
+
+	slog.Info("serving sessions", "addr", addr, "path", "/sessions")
+	return http.ListenAndServe(addr, mux)
+}
+
+// comparePowerBests fills in the best power from earlier sessions for each
+// point on the curve, then stores the curve for later sessions to compare
+// against.
+func comparePowerBests(store *sinks.Store, sessionId int64, curve []metrics.PowerCurvePoint) error {
+	bests, err := store.PowerBests(sessionId)
+	if err != nil {
+		return err
+	}
+
+	for i := range curve {
+		for _, best := range bests {
+			if best.Duration == curve[i].Duration {
+				curve[i].PreviousBest = best.Power
+			}
+		}
+	}
+
+	return store.AddPowerCurve(sessionId, curve)
+}
+
+// listPowerBests prints the best power for each duration across every
+// stored session.
+func listPowerBests(store *sinks.Store, w io.Writer) error {
+	bests, err := store.PowerBests(0)
+	if err != nil {
+		return err
+	}
+
+	for _, b := range bests {
+		fmt.Fprintf(w, "%-6s %4.0fW  session %d, %s\n",
+			b.Duration, b.Power, b.SessionId,
+			b.StartedAt.Local().Format("2006-01-02 15:04"))
+	}
+
+	return nil
+}
+
+// estimateFTP prints the FTP estimate from a stored session's power curve,
+// or the latest session with one if id is 0, and optionally saves it to
+// the config file.
+func estimateFTP(store *sinks.Store, id int64, update bool, w io.Writer) error {
+	if id == 0 {
+		sessions, err := store.ListSessions()
+		if err != nil {
+			return err
+		}
+
+		for _, s := range sessions {
+			curve, err := store.PowerCurve(s.Id)
+			if err != nil {
+				return err
+			}
+			if metrics.EstimateFTP(curve).FTP() > 0 {
+				id = s.Id
+				break
+			}
+		}
+
+		if id == 0 {
+			return fmt.Errorf("no sessions long enough to estimate FTP from")
+		}
+	}
+
+	curve, err := store.PowerCurve(id)
+	if err != nil {
+		return err
+	}
+
+	est := metrics.EstimateFTP(curve)
+	fmt.Fprintf(w, "session %d: %s\n", id, est)
+	if est.FTP() == 0 || !update {
+		return nil
+	}
+
+	ftp := fmt.Sprintf("%.0f", est.FTP())
+	if err := setConfigValue(flagConfigPath, "ftp", ftp); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "set FTP to %sW in %s\n", ftp, flagConfigPath)
+	return nil
+}
@@ -0,0 +1,70 @@
+package sim
+
+import (
+	"context"
+	"time"
+
+	"github.com/erik/git-commitment/metrics"
+)
+
+// Replay sends previously recorded metrics again, at the pace they were
+// originally received (or faster), as if the sensors were connected now.
+type Replay struct {
+	metrics []metrics.Metric
+	speed   float64
+}
+
+// NewReplay replays ms, which must be in order. Anything derived is
+// dropped, since the pipeline works that out again. A speed of 2 replays
+// twice as fast.
+func NewReplay(ms []metrics.Metric, speed float64) *Replay {
+	r := &Replay{speed: speed}
+	for _, m := range ms {
+		if !m.Kind.Derived() {
+			r.metrics = append(r.metrics, m)
+		}
+	}
+
+	return r
+}
+
+// Duration is how long the replay takes.
+func (r *Replay) Duration() time.Duration {
+	if len(r.metrics) == 0 {
+		return 0
+	}
+
+	elapsed := r.metrics[len(r.metrics)-1].Timestamp.Sub(r.metrics[0].Timestamp)
+	return time.Duration(float64(elapsed) / r.speed)
+}
+
+// Run sends every metric to out, stamped with the time it's sent, until
+// they run out or the context is cancelled.
+func (r *Replay) Run(ctx context.Context, out chan<- metrics.Metric) {
+	if len(r.metrics) == 0 {
+		return
+	}
+
+	start := time.Now()
+	first := r.metrics[0].Timestamp
+
+	for _, m := range r.metrics {
+		at := start.Add(time.Duration(float64(m.Timestamp.Sub(first)) / r.speed))
+
+		if wait := time.Until(at); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+
+		m.Timestamp = time.Now()
+
+		select {
+		case out <- m:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
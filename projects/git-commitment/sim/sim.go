@@ -0,0 +1,154 @@
+// Package sim generates plausible looking sensor data, or replays recorded
+// data, for trying out the rest of the pipeline without any hardware.
+package sim
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/erik/git-commitment/metrics"
+)
+
+// Address given to every simulated metric.
+const Address = "simulator"
+
+// Profile gives the target intensity (as a fraction of FTP) at a point in
+// the session.
+type Profile func(elapsed time.Duration) float64
+
+// Profiles are the built-in workouts to simulate, by name.
+var Profiles = map[string]Profile{
+	// Endurance pace, the whole way.
+	"steady": func(elapsed time.Duration) float64 {
+		return 0.7
+	},
+
+	// 5 minutes of warmup, then 3 minutes on, 3 minutes off forever.
+	"intervals": func(elapsed time.Duration) float64 {
+		const warmup = 5 * time.Minute
+		const interval = 3 * time.Minute
+
+		if elapsed < warmup {
+			return 0.55
+		}
+
+		if ((elapsed-warmup)/interval)%2 == 0 {
+			return 1.05
+		}
+		return 0.5
+	},
+
+	// Step up 5% every minute, from 40% to 120%, then start over.
+	"ramp": func(elapsed time.Duration) float64 {
+		steps := int(elapsed / time.Minute)
+		return 0.4 + 0.05*float64(steps%17)
+	},
+}
+
+// ProfileNames lists the built-in profiles, sorted.
+func ProfileNames() []string {
+	names := []string{}
+	for name := range Profiles {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// Resting heart rate, and the max we assume if none is given.
+const (
+	restingHR    = 60
+	defaultMaxHR = 185
+)
+
+// How quickly heart rate catches up with effort. Roughly the time to cover
+// two thirds of the difference.
+const heartRateLag = 30 * time.Second
+
+// Simulator produces power, cadence and heart rate once a second, following
+// a profile.
+type Simulator struct {
+	profile Profile
+	ftp     float64
+	maxHR   float64
+
+	heartRate float64
+	rand      *rand.Rand
+}
+
+// New returns a simulator for the named profile. If maxHR is 0, a typical
+// value is assumed.
+func New(profile string, ftp, maxHR float64) (*Simulator, error) {
+	p, ok := Profiles[profile]
+	if !ok {
+		return nil, fmt.Errorf("unknown profile: %q", profile)
+	}
+
+	if maxHR <= 0 {
+		maxHR = defaultMaxHR
+	}
+
+	return &Simulator{
+		profile:   p,
+		ftp:       ftp,
+		maxHR:     maxHR,
+		heartRate: restingHR,
+		rand:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}, nil
+}
+
+// Run sends metrics to out every second until the context is cancelled.
+func (s *Simulator) Run(ctx context.Context, out chan<- metrics.Metric) {
+	start := time.Now()
+
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		var now time.Time
+		select {
+		case <-ctx.Done():
+			return
+		case now = <-ticker.C:
+		}
+
+		for _, m := range s.step(now.Sub(start)) {
+			m.Timestamp = now
+			m.Address = Address
+
+			select {
+			case out <- m:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// step advances the simulation by a second.
+func (s *Simulator) step(elapsed time.Duration) []metrics.Metric {
+	intensity := s.profile(elapsed)
+
+	// Nobody holds power perfectly steady.
+	power := intensity * s.ftp * (1 + 0.04*s.rand.NormFloat64())
+	power = math.Max(0, math.Round(power))
+
+	// People tend to spin a little faster when working harder.
+	cadence := 75 + 15*math.Min(intensity, 1.2) + 2*s.rand.NormFloat64()
+	cadence = math.Max(0, math.Round(cadence))
+
+	// Heart rate drifts towards wherever the effort would put it.
+	targetHR := restingHR + (s.maxHR-restingHR)*math.Min(0.45+0.45*intensity, 1)
+	s.heartRate += (targetHR - s.heartRate) * (1 - math.Exp(-float64(time.Second)/float64(heartRateLag)))
+
+	return []metrics.Metric{
+		{Kind: metrics.CyclingPower, Value: power},
+		{Kind: metrics.CyclingCadence, Value: cadence},
+		{Kind: metrics.HeartRate, Value: math.Round(s.heartRate)},
+	}
+}
@@ -0,0 +1,168 @@
+package sinks
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/erik/git-commitment/ant"
+	"github.com/erik/git-commitment/metrics"
+)
+
+func init() {
+	Register("ant", func(opts Options) (Sink, error) {
+		if opts.ANTStick == nil {
+			return nil, errors.New("no ANT+ stick to broadcast through")
+		}
+		return NewANTBridge(opts.ANTStick, opts.ANTDevice, opts.WheelCircumference)
+	})
+}
+
+// ANTBridge broadcasts the heart rate, power, speed and cadence we receive
+// from BLE sensors as ANT+ sensors, so head units which only speak ANT+ can
+// show them.
+type ANTBridge struct {
+	heartRateTx, powerTx, speedCadenceTx *ant.Transmitter
+
+	// Guards everything below
+	mu sync.Mutex
+
+	heartRate, power, speed, cadence         float64
+	heartRateAt, powerAt, speedAt, cadenceAt time.Time
+
+	heartRateEnc    ant.HeartRateEncoder
+	powerEnc        ant.PowerEncoder
+	speedCadenceEnc ant.SpeedCadenceEncoder
+
+	closed bool
+	done   chan struct{}
+}
+
+// NewANTBridge opens a channel on the stick for each kind of sensor, all
+// with the same device number. Wheel circumference (in meters) is what
+// speed is turned back into wheel revolutions with.
+func NewANTBridge(stick *ant.Stick, device uint16, wheelCircumference float64) (*ANTBridge, error) {
+	b := &ANTBridge{
+		speedCadenceEnc: ant.SpeedCadenceEncoder{WheelCircumference: wheelCircumference},
+		done:            make(chan struct{}),
+	}
+
+	transmitters := []struct {
+		tx  **ant.Transmitter
+		typ ant.DeviceType
+	}{
+		{&b.heartRateTx, ant.HeartRate},
+		{&b.powerTx, ant.BikePower},
+		{&b.speedCadenceTx, ant.BikeSpeedCadence},
+	}
+
+	for _, t := range transmitters {
+		tx, err := stick.Transmitter(ant.Address{Type: t.typ, Device: device})
+		if err != nil {
+			return nil, err
+		}
+		if err := tx.Open(); err != nil {
+			return nil, fmt.Errorf("failed to start broadcasting as %s: %w", tx.Address(), err)
+		}
+		*t.tx = tx
+	}
+
+	go b.run()
+	return b, nil
+}
+
+func (b *ANTBridge) Receive(m metrics.Metric) {
+	// Only raw readings, not the averages we calculate from them. Anything
+	// from ANT+ sensors can already be received directly.
+	if m.Window != 0 || ant.IsAddress(m.Address) {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch m.Kind {
+	case metrics.HeartRate:
+		b.heartRate, b.heartRateAt = m.Value, m.Timestamp
+	case metrics.CyclingPower:
+		b.power, b.powerAt = m.Value, m.Timestamp
+	case metrics.CyclingSpeed:
+		b.speed, b.speedAt = m.Value, m.Timestamp
+	case metrics.CyclingCadence:
+		b.cadence, b.cadenceAt = m.Value, m.Timestamp
+	}
+}
+
+func (b *ANTBridge) run() {
+	ticker := time.NewTicker(rebroadcastInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.done:
+			return
+		case now := <-ticker.C:
+			b.send(now)
+		}
+	}
+}
+
+// send updates the page each transmitter broadcasts. Sensors we haven't
+// heard from lately aren't updated, so their ANT+ counterparts go quiet
+// rather than repeating the last value.
+func (b *ANTBridge) send(now time.Time) {
+	b.mu.Lock()
+	fresh := func(at time.Time) bool { return now.Sub(at) < rebroadcastMaxAge }
+
+	type update struct {
+		tx   *ant.Transmitter
+		page []byte
+	}
+	updates := []update{}
+
+	if fresh(b.heartRateAt) {
+		updates = append(updates, update{b.heartRateTx, b.heartRateEnc.Encode(b.heartRate, now)})
+	}
+
+	cadence := -1.0
+	if fresh(b.cadenceAt) {
+		cadence = b.cadence
+	}
+
+	if fresh(b.powerAt) {
+		updates = append(updates, update{b.powerTx, b.powerEnc.Encode(b.power, cadence)})
+	}
+
+	if fresh(b.speedAt) || cadence >= 0 {
+		speed := 0.0
+		if fresh(b.speedAt) {
+			speed = b.speed
+		}
+		updates = append(updates, update{b.speedCadenceTx, b.speedCadenceEnc.Encode(speed, math.Max(cadence, 0), now)})
+	}
+	b.mu.Unlock()
+
+	for _, u := range updates {
+		if err := u.tx.Send(u.page); err != nil {
+			slog.Debug("ant bridge: failed to send", "address", u.tx.Address().String(), "err", err)
+		}
+	}
+}
+
+func (b *ANTBridge) Flush() error { return nil }
+
+// Close stops updating the broadcasts. The channels themselves are closed
+// along with the stick.
+func (b *ANTBridge) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.closed {
+		b.closed = true
+		close(b.done)
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package sinks
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/erik/git-commitment/metrics"
+)
+
+// DefaultAudioStatsInterval is how often the session averages are read out.
+const DefaultAudioStatsInterval = 5 * time.Minute
+
+// A new zone has to hold for this long before it's announced, so hovering
+// around a boundary doesn't turn into a running commentary.
+const audioZoneHold = 5 * time.Second
+
+// Only this many announcements can be waiting to be spoken, any more are
+// dropped. Stale announcements are worse than none.
+const audioQueueLength = 4
+
+// Text to speech commands to try, in order, if none is given. Each takes
+// the text to say as its last argument.
+var ttsCommands = [][]string{
+	{"say"},
+	{"espeak-ng"},
+	{"espeak"},
+	{"spd-say", "--wait"},
+}
+
+func init() {
+	Register("audio", func(opts Options) (Sink, error) {
+		return NewAnnouncer(opts.TTSCommand, opts.AudioEvery)
+	})
+}
+
+// Announcer reads out zone changes and the session averages every so often,
+// and anything else it's told to Announce, so there's no need to look at a
+// screen. Without a text to speech command it falls back to the terminal
+// bell, which is better than nothing.
+type Announcer struct {
+	// Empty for the terminal bell.
+	command       []string
+	statsInterval time.Duration
+
+	queue chan string
+
+	zone        int
+	pendingZone int
+	pendingAt   time.Time
+
+	powerSum, heartRateSum     float64
+	powerCount, heartRateCount int
+	lastStats                  time.Time
+}
+
+// NewAnnouncer speaks through command (e.g. "espeak -s 160"), or the first
+// text to speech command found if it's empty.
+func NewAnnouncer(command string, statsInterval time.Duration) (*Announcer, error) {
+	a := &Announcer{
+		statsInterval: statsInterval,
+		queue:         make(chan string, audioQueueLength),
+	}
+
+	if command != "" {
+		a.command = strings.Fields(command)
+		if _, err := exec.LookPath(a.command[0]); err != nil {
+			return nil, err
+		}
+	} else {
+		for _, cmd := range ttsCommands {
+			if _, err := exec.LookPath(cmd[0]); err == nil {
+				a.command = cmd
+				break
+			}
+		}
+	}
+
+	if a.command == nil {
+		slog.Warn("no text to speech command found, only beeping")
+	}
+
+	go a.run()
+	return a, nil
+}
+
+// run speaks each announcement in turn.
+func (a *Announcer) run() {
+	for text := range a.queue {
+		if a.command == nil {
+			os.Stdout.WriteString("\a")
+			continue
+		}
+
+		args := append(a.command[1:len(a.command):len(a.command)], text)
+		if err := exec.Command(a.command[0], args...).Run(); err != nil {
+			slog.Debug("audio: failed to speak", "err", err)
+		}
+	}
+}
+
+// Announce queues text to be spoken, dropping it if too much is already
+// waiting.
+func (a *Announcer) Announce(text string) {
+	select {
+	case a.queue <- text:
+	default:
+	}
+}
+
+// Beep sounds the terminal bell, regardless of text to speech.
+func (a *Announcer) Beep() {
+	os.Stdout.WriteString("\a")
+}
+
+func (a *Announcer) Receive(m metrics.Metric) {
+	if m.Window != 0 {
+		return
+	}
+
+	switch m.Kind {
+	case metrics.CyclingPower:
+		a.powerSum += m.Value
+		a.powerCount++
+
+	case metrics.HeartRate:
+		a.heartRateSum += m.Value
+		a.heartRateCount++
+
+	case metrics.PowerZone:
+		a.updateZone(int(m.Value), m.Timestamp)
+	}
+
+	if a.statsInterval <= 0 {
+		return
+	}
+
+	if a.lastStats.IsZero() {
+		a.lastStats = m.Timestamp
+	} else if m.Timestamp.Sub(a.lastStats) >= a.statsInterval {
+		a.lastStats = m.Timestamp
+		a.announceStats()
+	}
+}
+
+func (a *Announcer) updateZone(zone int, now time.Time) {
+	if zone == a.zone {
+		a.pendingZone = 0
+		return
+	}
+
+	if zone != a.pendingZone {
+		a.pendingZone = zone
+		a.pendingAt = now
+		return
+	}
+
+	if now.Sub(a.pendingAt) < audioZoneHold {
+		return
+	}
+
+	// Nobody needs to be told they've started pedaling.
+	if a.zone != 0 {
+		a.Announce(fmt.Sprintf("zone %d", zone))
+	}
+
+	a.zone = zone
+	a.pendingZone = 0
+}
+
+func (a *Announcer) announceStats() {
+	parts := []string{}
+	if a.powerCount > 0 {
+		parts = append(parts, fmt.Sprintf("average power %.0f watts", math.Round(a.powerSum/float64(a.powerCount))))
+	}
+	if a.heartRateCount > 0 {
+		parts = append(parts, fmt.Sprintf("heart rate %.0f", math.Round(a.heartRateSum/float64(a.heartRateCount))))
+	}
+
+	if len(parts) > 0 {
+		a.Announce(strings.Join(parts, ", "))
+	}
+}
+
+func (a *Announcer) Flush() error {
+	return nil
+}
+
+// Close stops speaking once whatever is queued has been said.
+func (a *Announcer) Close() error {
+	close(a.queue)
+	return nil
+}
@@ -0,0 +1,83 @@
+package sinks
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/erik/git-commitment/metrics"
+)
+
+// ExportContentTypes are the formats stored sessions can be exported as,
+// see ExportSession.
+var ExportContentTypes = map[string]string{
+	"tcx": "application/vnd.garmin.tcx+xml",
+	"csv": "text/csv",
+}
+
+// ExportSession writes a stored session to w, as either "tcx" or "csv".
+// TCX is always metric, as the format requires.
+func ExportSession(w io.Writer, store *Store, id int64, format string, units metrics.Units) error {
+	if _, ok := ExportContentTypes[format]; !ok {
+		return fmt.Errorf("unknown export format: %q", format)
+	}
+
+	session, err := store.GetSession(id)
+	if err != nil {
+		return fmt.Errorf("session %d: %w", id, err)
+	}
+
+	samples, err := store.Samples(id)
+	if err != nil {
+		return err
+	}
+
+	if format == "csv" {
+		return EncodeCSV(w, samples, units)
+	}
+
+	if len(samples) == 0 {
+		return fmt.Errorf("session %d has no samples", id)
+	}
+	return EncodeTCX(w, samples, session.Info())
+}
+
+// EncodeCSV writes samples out with a header row, one row per sample.
+// Metric units are the same as in Sample. Imperial speed, distance and
+// temperature are in mph, miles and °F, with the header saying so.
+func EncodeCSV(w io.Writer, samples []Sample, units metrics.Units) error {
+	speed, distance, temperature := "speed", "distance", "core_temperature"
+	convertDistance := func(meters float64) float64 { return meters }
+	if units == metrics.ImperialUnits {
+		speed, distance, temperature = "speed_mph", "distance_mi", "core_temperature_f"
+		convertDistance = units.Distance
+	}
+
+	out := csv.NewWriter(w)
+	out.Write([]string{"time", "heart_rate", "power", "cadence", speed, distance, temperature, "front_gear", "rear_gear"})
+
+	for _, s := range samples {
+		// Zero means no reading, which shouldn't turn into 32°F.
+		coreTemperature := 0.0
+		if s.CoreTemperature != 0 {
+			coreTemperature = units.Temperature(s.CoreTemperature)
+		}
+
+		out.Write([]string{
+			s.Time.Format(time.RFC3339),
+			strconv.FormatFloat(s.HeartRate, 'f', -1, 64),
+			strconv.FormatFloat(s.Power, 'f', -1, 64),
+			strconv.FormatFloat(s.Cadence, 'f', -1, 64),
+			strconv.FormatFloat(units.Speed(s.Speed), 'f', -1, 64),
+			strconv.FormatFloat(convertDistance(s.Distance), 'f', -1, 64),
+			strconv.FormatFloat(coreTemperature, 'f', -1, 64),
+			strconv.FormatFloat(s.FrontGear, 'f', -1, 64),
+			strconv.FormatFloat(s.RearGear, 'f', -1, 64),
+		})
+	}
+
+	out.Flush()
+	return out.Error()
+}
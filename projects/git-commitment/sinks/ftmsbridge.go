@@ -0,0 +1,225 @@
+package sinks
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/erik/git-commitment/ble"
+	"github.com/erik/git-commitment/gatt"
+	"github.com/erik/git-commitment/metrics"
+	"tinygo.org/x/bluetooth"
+)
+
+func init() {
+	Register("ftms", func(opts Options) (Sink, error) {
+		if opts.Peripheral == nil {
+			return nil, errors.New("no BLE peripheral to expose the fitness machine through")
+		}
+		return NewFTMSBridge(opts.Peripheral)
+	})
+}
+
+// FTMSBridge pretends to be a Fitness Machine Service trainer, so that apps
+// which can only control FTMS trainers can control whichever trainer we're
+// connected to. Control point writes are handed off to OnTargetPower and
+// OnSimulation, and indoor bike data is made up from the metrics we
+// receive.
+type FTMSBridge struct {
+	peripheral *ble.Peripheral
+
+	bikeDataChar     bluetooth.Characteristic
+	controlPointChar bluetooth.Characteristic
+
+	// Called when the app sets an ERG target or simulation parameters.
+	// Must be set before the peripheral starts advertising.
+	OnTargetPower func(watts int)
+	OnSimulation  func(params gatt.SimulationParams)
+
+	// Guards everything below
+	mu sync.Mutex
+
+	speed, cadence, power, heartRate         float64
+	speedAt, cadenceAt, powerAt, heartRateAt time.Time
+
+	closed bool
+	done   chan struct{}
+}
+
+// NewFTMSBridge adds the Fitness Machine service to the peripheral and
+// starts sending indoor bike data. The peripheral still needs to advertise
+// it.
+func NewFTMSBridge(peripheral *ble.Peripheral) (*FTMSBridge, error) {
+	b := &FTMSBridge{
+		peripheral: peripheral,
+		done:       make(chan struct{}),
+	}
+
+	features := gatt.EncodeFitnessMachineFeature(
+		gatt.FTMSFeatureCadence|gatt.FTMSFeatureHeartRate|gatt.FTMSFeaturePowerMeasure,
+		gatt.FTMSTargetPower|gatt.FTMSTargetIndoorSimulation,
+	)
+
+	err := peripheral.AddService(&bluetooth.Service{
+		UUID: bluetooth.ServiceUUIDFitnessMachine,
+		Characteristics: []bluetooth.CharacteristicConfig{
+			{
+				UUID:  bluetooth.CharacteristicUUIDFitnessMachineFeature,
+				Value: features,
+				Flags: bluetooth.CharacteristicReadPermission,
+			},
+			{
+				UUID:  bluetooth.CharacteristicUUIDSupportedPowerRange,
+				Value: gatt.EncodeSupportedPowerRange(0, 2000, 1),
+				Flags: bluetooth.CharacteristicReadPermission,
+			},
+			{
+				Handle: &b.bikeDataChar,
+				UUID:   bluetooth.CharacteristicUUIDIndoorBikeData,
+				Flags:  bluetooth.CharacteristicNotifyPermission,
+			},
+			{
+				// The spec says responses are indications, but the
+				// bluetooth package can't set those up yet. Apps
+				// generally don't mind notifications instead.
+				Handle: &b.controlPointChar,
+				UUID:   bluetooth.CharacteristicUUIDFitnessMachineControlPoint,
+				Flags: bluetooth.CharacteristicWritePermission |
+					bluetooth.CharacteristicNotifyPermission,
+				WriteEvent: func(client bluetooth.Connection, offset int, value []byte) {
+					b.handleControlPoint(value)
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to add fitness machine service: %w", err)
+	}
+
+	go b.run()
+	return b, nil
+}
+
+// handleControlPoint acts on a request from the app, and responds to it.
+func (b *FTMSBridge) handleControlPoint(value []byte) {
+	b.mu.Lock()
+	closed := b.closed
+	b.mu.Unlock()
+
+	if closed {
+		return
+	}
+
+	req, err := gatt.ParseFTMSRequest(value)
+	if err != nil {
+		slog.Warn("bad FTMS request", "err", err)
+		if len(value) > 0 {
+			b.respond(value[0], gatt.FTMSResultInvalidParameter)
+		}
+		return
+	}
+
+	result := byte(gatt.FTMSResultSuccess)
+	switch req.OpCode {
+	case gatt.FTMSOpRequestControl, gatt.FTMSOpReset,
+		gatt.FTMSOpStartOrResume, gatt.FTMSOpStopOrPause:
+		// Nothing to do, we're always in control of the real trainer.
+
+	case gatt.FTMSOpSetTargetPower:
+		if b.OnTargetPower != nil {
+			b.OnTargetPower(req.TargetPower)
+		}
+
+	case gatt.FTMSOpSetSimulation:
+		if b.OnSimulation != nil {
+			b.OnSimulation(req.Simulation)
+		}
+
+	default:
+		result = gatt.FTMSResultOpCodeNotSupported
+	}
+
+	b.respond(req.OpCode, result)
+}
+
+func (b *FTMSBridge) respond(opCode, result byte) {
+	if err := b.peripheral.Notify(&b.controlPointChar, gatt.EncodeFTMSResponse(opCode, result)); err != nil {
+		slog.Debug("ftms: failed to respond to request", "err", err)
+	}
+}
+
+func (b *FTMSBridge) Receive(m metrics.Metric) {
+	// Only raw readings, not the averages we calculate from them.
+	if m.Window != 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch m.Kind {
+	case metrics.CyclingSpeed:
+		b.speed, b.speedAt = m.Value, m.Timestamp
+	case metrics.CyclingCadence:
+		b.cadence, b.cadenceAt = m.Value, m.Timestamp
+	case metrics.CyclingPower:
+		b.power, b.powerAt = m.Value, m.Timestamp
+	case metrics.HeartRate:
+		b.heartRate, b.heartRateAt = m.Value, m.Timestamp
+	}
+}
+
+func (b *FTMSBridge) run() {
+	ticker := time.NewTicker(rebroadcastInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.done:
+			return
+		case now := <-ticker.C:
+			b.notify(now)
+		}
+	}
+}
+
+// notify sends the latest values as indoor bike data. Anything which has
+// gone stale is sent as zero.
+func (b *FTMSBridge) notify(now time.Time) {
+	b.mu.Lock()
+	latest := func(value float64, at time.Time) float64 {
+		if now.Sub(at) < rebroadcastMaxAge {
+			return value
+		}
+		return 0
+	}
+
+	data := gatt.EncodeIndoorBikeData(
+		latest(b.speed, b.speedAt),
+		latest(b.cadence, b.cadenceAt),
+		latest(b.power, b.powerAt),
+		latest(b.heartRate, b.heartRateAt),
+	)
+	b.mu.Unlock()
+
+	if err := b.peripheral.Notify(&b.bikeDataChar, data); err != nil {
+		slog.Debug("ftms: failed to send indoor bike data", "err", err)
+	}
+}
+
+func (b *FTMSBridge) Flush() error { return nil }
+
+// Close stops sending indoor bike data. Control point writes are ignored
+// from then on.
+func (b *FTMSBridge) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.closed {
+		b.closed = true
+		close(b.done)
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package sinks
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/erik/git-commitment/api"
+	"github.com/erik/git-commitment/metrics"
+)
+
+func init() {
+	Register("grpc", func(opts Options) (Sink, error) {
+		if opts.GRPCAddr == "" {
+			return nil, fmt.Errorf("no address given")
+		}
+
+		// Listen up front so that a bad address is reported immediately.
+		ln, err := net.Listen("tcp", opts.GRPCAddr)
+		if err != nil {
+			return nil, err
+		}
+
+		srv := NewGRPCServer()
+		go func() {
+			if err := srv.server.Serve(ln); err != nil {
+				slog.Error("gRPC server stopped", "err", err)
+			}
+		}()
+
+		return srv, nil
+	})
+}
+
+// GRPCServer serves the Telemetry service from api/telemetry.proto without
+// TLS, so clients need to connect with e.g.
+// grpc.WithTransportCredentials(insecure.NewCredentials()) in Go.
+//
+// Control goes through the same LiveControl as the HTTP API.
+type GRPCServer struct {
+	api.UnimplementedTelemetryServer
+
+	mu      sync.Mutex
+	streams map[chan metrics.Metric]bool
+	control LiveControl
+
+	server *grpc.Server
+	done   chan struct{}
+}
+
+func NewGRPCServer() *GRPCServer {
+	srv := &GRPCServer{
+		streams: map[chan metrics.Metric]bool{},
+		server:  grpc.NewServer(),
+		done:    make(chan struct{}),
+	}
+
+	api.RegisterTelemetryServer(srv.server, srv)
+	return srv
+}
+
+// SetControl sets what the control methods can control.
+func (srv *GRPCServer) SetControl(control LiveControl) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	srv.control = control
+}
+
+func (srv *GRPCServer) getControl() LiveControl {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	return srv.control
+}
+
+// Receive sends the metric to every open stream, dropping it for any
+// that aren't keeping up.
+func (srv *GRPCServer) Receive(m metrics.Metric) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	for stream := range srv.streams {
+		select {
+		case stream <- m:
+		default:
+		}
+	}
+}
+
+func (srv *GRPCServer) Flush() error {
+	return nil
+}
+
+// Close ends every open stream, then stops the server once the calls in
+// flight have finished.
+func (srv *GRPCServer) Close() error {
+	close(srv.done)
+	srv.server.GracefulStop()
+	return nil
+}
+
+func (srv *GRPCServer) StreamMetrics(req *api.StreamMetricsRequest, out grpc.ServerStreamingServer[api.Metric]) error {
+	kinds := map[string]bool{}
+	for _, kind := range req.Kinds {
+		kinds[kind] = true
+	}
+
+	stream := make(chan metrics.Metric, liveClientBuffer)
+
+	srv.mu.Lock()
+	srv.streams[stream] = true
+	srv.mu.Unlock()
+
+	defer func() {
+		srv.mu.Lock()
+		delete(srv.streams, stream)
+		srv.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case m := <-stream:
+			if len(kinds) > 0 && !kinds[m.Kind.String()] {
+				continue
+			}
+
+			err := out.Send(&api.Metric{
+				Kind:         m.Kind.String(),
+				Value:        m.Value,
+				TimeUnixNano: m.Timestamp.UnixNano(),
+				Address:      m.Address,
+				Alias:        m.Alias,
+				WindowMillis: m.Window.Milliseconds(),
+				Profile:      m.Profile,
+			})
+			if err != nil {
+				return err
+			}
+
+		case <-out.Context().Done():
+			return out.Context().Err()
+
+		case <-srv.done:
+			return nil
+		}
+	}
+}
+
+func (srv *GRPCServer) SetTargetPower(ctx context.Context, req *api.SetTargetPowerRequest) (*api.ControlResponse, error) {
+	if req.Watts < 0 || req.Watts > MaxTargetPower {
+		return nil, status.Errorf(codes.InvalidArgument, "watts must be between 0 and %d", MaxTargetPower)
+	}
+
+	setTargetPower := srv.getControl().SetTargetPower
+	if setTargetPower == nil {
+		return nil, status.Error(codes.Unavailable, "no trainer control")
+	}
+
+	setTargetPower(int(req.Watts))
+	return &api.ControlResponse{}, nil
+}
+
+func (srv *GRPCServer) Lap(ctx context.Context, req *api.LapRequest) (*api.ControlResponse, error) {
+	rec := srv.getControl().Recorder
+	if rec == nil {
+		return nil, status.Error(codes.Unavailable, "not recording")
+	}
+
+	rec.Lap()
+	slog.Info("lap")
+	return &api.ControlResponse{}, nil
+}
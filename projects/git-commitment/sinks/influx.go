@@ -0,0 +1,198 @@
+package sinks
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/erik/git-commitment/metrics"
+)
+
+// How often buffered points are written to InfluxDB.
+const influxFlushInterval = 5 * time.Second
+
+// If InfluxDB is unreachable, start dropping the oldest points once we
+// have this many buffered, rather than growing forever.
+const influxMaxBuffered = 50000
+
+func init() {
+	Register("influx", func(opts Options) (Sink, error) {
+		if opts.InfluxURL == "" || opts.InfluxBucket == "" {
+			return nil, fmt.Errorf("no InfluxDB URL or bucket given")
+		}
+		return NewInfluxWriter(opts.InfluxURL, opts.InfluxOrg, opts.InfluxBucket, opts.InfluxToken), nil
+	})
+}
+
+// InfluxWriter sends every metric to an InfluxDB v2 bucket, using the line
+// protocol. Each kind of metric is its own measurement, tagged with the
+// device it came from:
+//
+//	heart_rate,address=D4:22:19:E8:5F:01,alias=hrm value=142 1700000000000000000
+//
+// Points are buffered and written in the background, so a slow or missing
+// server never holds up the pipeline.
+type InfluxWriter struct {
+	endpoint string
+	token    string
+	client   *http.Client
+
+	mu      sync.Mutex
+	pending []string
+
+	// Only one write at a time, so points arrive in order.
+	writeMu sync.Mutex
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func NewInfluxWriter(baseURL, org, bucket, token string) *InfluxWriter {
+	query := url.Values{}
+	query.Set("org", org)
+	query.Set("bucket", bucket)
+	query.Set("precision", "ns")
+
+	w := &InfluxWriter{
+		endpoint: strings.TrimSuffix(baseURL, "/") + "/api/v2/write?" + query.Encode(),
+		token:    token,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		pending:  []string{},
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+
+	go w.run()
+	return w
+}
+
+func (w *InfluxWriter) run() {
+	defer close(w.stopped)
+
+	ticker := time.NewTicker(influxFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+
+		case <-ticker.C:
+			if err := w.Flush(); err != nil {
+				slog.Warn("failed to write to InfluxDB", "err", err)
+			}
+		}
+	}
+}
+
+func (w *InfluxWriter) Receive(m metrics.Metric) {
+	line := influxLine(m)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.pending) >= influxMaxBuffered {
+		w.pending = w.pending[1:]
+	}
+	w.pending = append(w.pending, line)
+}
+
+// influxLine formats a metric as a single line protocol point.
+func influxLine(m metrics.Metric) string {
+	var b strings.Builder
+
+	b.WriteString(influxEscape(m.Kind.String()))
+	if m.Address != "" {
+		b.WriteString(",address=" + influxEscape(m.Address))
+	}
+	if m.Alias != "" {
+		b.WriteString(",alias=" + influxEscape(m.Alias))
+	}
+	if m.Window != 0 {
+		b.WriteString(",window=" + influxEscape(m.Window.String()))
+	}
+
+	b.WriteString(" value=")
+	b.WriteString(strconv.FormatFloat(m.Value, 'f', -1, 64))
+	b.WriteString(" ")
+	b.WriteString(strconv.FormatInt(m.Timestamp.UnixNano(), 10))
+
+	return b.String()
+}
+
+var influxEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// influxEscape escapes measurement names and tag keys and values.
+func influxEscape(s string) string {
+	return influxEscaper.Replace(s)
+}
+
+// Flush writes every buffered point. If the write fails, the points are
+// kept to try again next time.
+func (w *InfluxWriter) Flush() error {
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+
+	w.mu.Lock()
+	lines := w.pending
+	w.pending = []string{}
+	w.mu.Unlock()
+
+	if len(lines) == 0 {
+		return nil
+	}
+
+	if err := w.write(lines); err != nil {
+		w.mu.Lock()
+		w.pending = append(lines, w.pending...)
+		if extra := len(w.pending) - influxMaxBuffered; extra > 0 {
+			w.pending = w.pending[extra:]
+		}
+		w.mu.Unlock()
+
+		return err
+	}
+
+	return nil
+}
+
+func (w *InfluxWriter) write(lines []string) error {
+	body := strings.Join(lines, "\n")
+
+	req, err := http.NewRequest(http.MethodPost, w.endpoint, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.token != "" {
+		req.Header.Set("Authorization", "Token "+w.token)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
+
+// Close stops writing in the background. Anything still buffered should
+// have been flushed already.
+func (w *InfluxWriter) Close() error {
+	close(w.done)
+	<-w.stopped
+	return nil
+}
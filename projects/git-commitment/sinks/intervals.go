@@ -0,0 +1,88 @@
+package sinks
+
+import (
+	"time"
+)
+
+// Power is averaged over this many samples either side before deciding
+// whether a sample is work or rest, so a few seconds of coasting don't
+// end an interval.
+const intervalSmoothing = 5
+
+// IntervalWorkFraction of FTP makes a good threshold for DetectIntervals:
+// anything from tempo up counts as work.
+const IntervalWorkFraction = 0.75
+
+// Anything shorter is folded into the intervals around it.
+const minIntervalDuration = 30 * time.Second
+
+// DetectedInterval is a stretch of samples spent either working or
+// resting, as found by DetectIntervals.
+type DetectedInterval struct {
+	// Sample indices, End exclusive.
+	Start, End int
+	Work       bool
+}
+
+// DetectIntervals splits samples into alternating work and rest intervals,
+// work being when power is at or above threshold (watts). Returns nil
+// unless there's at least one of each, e.g. for a steady ride.
+func DetectIntervals(samples []Sample, threshold float64) []DetectedInterval {
+	if len(samples) == 0 || threshold <= 0 {
+		return nil
+	}
+
+	// Runs of samples on the same side of the threshold.
+	intervals := []DetectedInterval{}
+	for i := range samples {
+		lo, hi := max(0, i-intervalSmoothing), min(len(samples), i+intervalSmoothing+1)
+
+		sum := 0.0
+		for _, s := range samples[lo:hi] {
+			sum += s.Power
+		}
+		work := sum/float64(hi-lo) >= threshold
+
+		if n := len(intervals); n > 0 && intervals[n-1].Work == work {
+			intervals[n-1].End = i + 1
+		} else {
+			intervals = append(intervals, DetectedInterval{Start: i, End: i + 1, Work: work})
+		}
+	}
+
+	// Fold the shortest run into its neighbours until none are too short.
+	// Samples are a second apart.
+	minSamples := int(minIntervalDuration / time.Second)
+	for len(intervals) > 1 {
+		shortest := -1
+		for i, in := range intervals {
+			if in.End-in.Start < minSamples && (shortest < 0 || in.End-in.Start < intervals[shortest].End-intervals[shortest].Start) {
+				shortest = i
+			}
+		}
+		if shortest < 0 {
+			break
+		}
+
+		intervals[shortest].Work = !intervals[shortest].Work
+		intervals = mergeIntervals(intervals)
+	}
+
+	if len(intervals) < 2 {
+		return nil
+	}
+	return intervals
+}
+
+// mergeIntervals joins neighbouring intervals of the same kind.
+func mergeIntervals(intervals []DetectedInterval) []DetectedInterval {
+	merged := []DetectedInterval{intervals[0]}
+	for _, in := range intervals[1:] {
+		if last := &merged[len(merged)-1]; last.Work == in.Work {
+			last.End = in.End
+		} else {
+			merged = append(merged, in)
+		}
+	}
+	return merged
+}
@@ -0,0 +1,59 @@
+package sinks
+
+import (
+	"time"
+
+	"github.com/erik/git-commitment/metrics"
+)
+
+// SummarizeLaps splits samples into laps at each sample marked as one, and
+// averages each of them. Zero readings don't count towards the averages.
+func SummarizeLaps(samples []Sample) []metrics.LapSummary {
+	laps := []metrics.LapSummary{}
+	if len(samples) == 0 {
+		return laps
+	}
+
+	start := 0
+	for i := 1; i <= len(samples); i++ {
+		if i < len(samples) && !samples[i].Lap {
+			continue
+		}
+
+		laps = append(laps, summarizeLap(samples[start:i]))
+		start = i
+	}
+
+	return laps
+}
+
+func summarizeLap(samples []Sample) metrics.LapSummary {
+	first, last := samples[0], samples[len(samples)-1]
+	lap := metrics.LapSummary{
+		// Each sample covers a second, and none are taken while
+		// auto-paused.
+		Duration: time.Duration(len(samples)) * time.Second,
+		Distance: last.Distance - first.Distance,
+	}
+
+	average := func(value func(Sample) float64) float64 {
+		sum, n := 0.0, 0
+		for _, s := range samples {
+			if v := value(s); v > 0 {
+				sum += v
+				n++
+			}
+		}
+
+		if n == 0 {
+			return 0
+		}
+		return sum / float64(n)
+	}
+
+	lap.Power = average(func(s Sample) float64 { return s.Power })
+	lap.HeartRate = average(func(s Sample) float64 { return s.HeartRate })
+	lap.Cadence = average(func(s Sample) float64 { return s.Cadence })
+
+	return lap
+}
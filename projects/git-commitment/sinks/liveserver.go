@@ -0,0 +1,652 @@
+package sinks
+
+import (
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/erik/git-commitment/gatt"
+	"github.com/erik/git-commitment/metrics"
+	"github.com/gorilla/websocket"
+)
+
+func init() {
+	Register("http", func(opts Options) (Sink, error) {
+		if opts.HTTPAddr == "" {
+			return nil, fmt.Errorf("no address given")
+		}
+
+		// Listen up front so that a bad address is reported immediately.
+		ln, err := net.Listen("tcp", opts.HTTPAddr)
+		if err != nil {
+			return nil, err
+		}
+
+		srv := NewLiveServer()
+		srv.units = opts.Units
+		srv.token = opts.HTTPToken
+		if opts.Store != nil {
+			NewSessionBrowser(opts.Store, opts.PowerZones, opts.HeartRateZones, opts.Units).Register(srv.mux)
+		}
+
+		go func() {
+			if err := srv.server.Serve(ln); err != nil && err != http.ErrServerClosed {
+				slog.Error("HTTP server stopped", "err", err)
+			}
+		}()
+
+		return srv, nil
+	})
+}
+
+// How many metrics can be queued up for a client before we start dropping
+// them. A stalled browser tab shouldn't hold up the pipeline.
+const liveClientBuffer = 64
+
+// How many recent events to keep per device, see AddDeviceEvent.
+const liveDeviceEvents = 10
+
+// MaxTargetPower is the highest ERG target the control APIs accept. FTMS
+// trainers are held to their own supported range on top of this.
+const MaxTargetPower = 2000
+
+//go:embed web/overlay.html
+var overlayHTML []byte
+
+//go:embed web/dashboard.html
+var dashboardHTML []byte
+
+// LiveServer pushes every metric as JSON to any connected WebSocket
+// clients, so browser overlays and dashboards can subscribe to live data.
+//
+// Messages use the same shape as the JSON metric log:
+//
+//	{"time": "...", "address": "...", "characteristic": "...", "kind": "cycling_power", "value": 250}
+//
+// Workout progress is sent along with them every second, see
+// LiveWorkout:
+//
+//	{"time": "...", "kind": "workout", "workout": {"step": 2, "step_count": 8, ...}}
+//
+// It also serves a minimal overlay page at /overlay, with a transparent
+// background so it can be dropped into OBS as a browser source, a full
+// dashboard with charts at /dashboard for riding with a tablet rather than
+// a terminal, and a small JSON API for checking on and controlling a
+// headless setup:
+//
+//	GET  /api/metrics            latest value of every metric, per device
+//	GET  /api/devices            connection status of each device, how well
+//	                             its notifications are decoding, and recent
+//	                             events reported by fitness machines
+//	GET  /api/session            recording status, see RecorderStatus
+//	GET  /api/workout            progress of the running workout
+//	GET  /api/units              what pages should show measurements in
+//	POST /api/session            set the sport, tags or note, any of
+//	                             {"sport": "running", "tags": ["z2"], "note": "..."}
+//	POST /api/recording/start    resume recording
+//	POST /api/recording/stop     pause recording
+//	POST /api/lap                start a new lap (also POST /lap)
+//	POST /api/target-power       set the ERG target, {"watts": 200}
+//
+// Any page the rider has open in a browser could otherwise send requests
+// to the API, so POSTs from other origins are refused and ones with a body
+// have to be sent as application/json, which browsers won't do cross-origin
+// without asking first. With a token set (see Options.HTTPToken), POSTs
+// also need it, as "Authorization: Bearer <token>". WebSockets from other
+// origins, e.g. an overlay loaded from a local file, need it as a token
+// query parameter.
+//
+// With a session store, past sessions can be browsed at /sessions too, see
+// SessionBrowser.
+type LiveServer struct {
+	mu sync.Mutex
+	// Each gets metric records and workout messages.
+	clients map[chan interface{}]bool
+
+	upgrader websocket.Upgrader
+	mux      *http.ServeMux
+	server   *http.Server
+
+	// Kind and address -> latest metric
+	latest map[string]metricLogRecord
+	// Device name -> status
+	devices map[string]string
+	// Device name -> characteristic -> decode stats, see AddDecodeStats
+	decodeStats map[string]map[string]func() gatt.DecodeStats
+	// Device name -> most recent events, oldest first
+	events map[string][]liveDeviceEvent
+	// Nil until there's a workout, see SetWorkout.
+	workout *liveWorkoutMessage
+
+	// See SetControl. Guarded by mu.
+	control LiveControl
+
+	// Metrics are always sent metric, pages convert them.
+	units metrics.Units
+	// Needed for control, if set.
+	token string
+}
+
+// LiveControl is what the API can control. Either may be nil, in which
+// case the endpoints using it aren't available.
+type LiveControl struct {
+	Recorder       *Recorder
+	SetTargetPower func(watts int)
+}
+
+func NewLiveServer() *LiveServer {
+	srv := &LiveServer{
+		clients: map[chan interface{}]bool{},
+		latest:  map[string]metricLogRecord{},
+		devices: map[string]string{},
+
+		decodeStats: map[string]map[string]func() gatt.DecodeStats{},
+		events:      map[string][]liveDeviceEvent{},
+	}
+	srv.upgrader = websocket.Upgrader{
+		// Overlays loaded from somewhere else entirely (e.g. a local
+		// file) need the token.
+		CheckOrigin: func(r *http.Request) bool {
+			return sameOrigin(r) || (srv.token != "" && srv.hasToken(r))
+		},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", srv.handleWebSocket)
+	mux.HandleFunc("/overlay", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(overlayHTML)
+	})
+	mux.HandleFunc("/dashboard", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(dashboardHTML)
+	})
+	mux.HandleFunc("/lap", srv.handleLap)
+	mux.HandleFunc("/api/lap", srv.handleLap)
+	mux.HandleFunc("/api/metrics", srv.handleMetrics)
+	mux.HandleFunc("/api/devices", srv.handleDevices)
+	mux.HandleFunc("/api/session", srv.handleSession)
+	mux.HandleFunc("/api/workout", srv.handleWorkout)
+	mux.HandleFunc("/api/units", func(w http.ResponseWriter, r *http.Request) {
+		if allowMethod(w, r, http.MethodGet) {
+			writeJSON(w, newWebUnits(srv.units))
+		}
+	})
+	mux.HandleFunc("/api/recording/start", srv.handleRecording(true))
+	mux.HandleFunc("/api/recording/stop", srv.handleRecording(false))
+	mux.HandleFunc("/api/target-power", srv.handleTargetPower)
+	srv.mux = mux
+	srv.server = &http.Server{Handler: mux}
+
+	return srv
+}
+
+// SetControl sets what the API can control.
+func (srv *LiveServer) SetControl(control LiveControl) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	srv.control = control
+}
+
+// SetDeviceStatus updates the connection status reported for a device, e.g.
+// "connecting" or "connected".
+func (srv *LiveServer) SetDeviceStatus(name, status string) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	srv.devices[name] = status
+}
+
+// AddDecodeStats reports how well notifications from one of a device's
+// characteristics are decoding, with stats called whenever anyone asks.
+// Replaces whatever was there for the same characteristic, e.g. after a
+// reconnect.
+func (srv *LiveServer) AddDecodeStats(name, characteristic string, stats func() gatt.DecodeStats) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if srv.decodeStats[name] == nil {
+		srv.decodeStats[name] = map[string]func() gatt.DecodeStats{}
+	}
+	srv.decodeStats[name][characteristic] = stats
+}
+
+type liveDeviceEvent struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// AddDeviceEvent records something a device told us about, e.g. a trainer
+// reporting that another app changed its target.
+func (srv *LiveServer) AddDeviceEvent(name, message string) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	events := append(srv.events[name], liveDeviceEvent{Time: time.Now(), Message: message})
+	if len(events) > liveDeviceEvents {
+		events = events[len(events)-liveDeviceEvents:]
+	}
+	srv.events[name] = events
+}
+
+// LiveWorkout is the progress of the running workout.
+type LiveWorkout struct {
+	// Counting from 1
+	Step      int    `json:"step"`
+	StepCount int    `json:"step_count"`
+	StepName  string `json:"step_name"`
+	// Seconds
+	StepRemaining float64 `json:"step_remaining"`
+	Remaining     float64 `json:"remaining"`
+
+	// Zero if the step doesn't have one.
+	TargetPower     float64 `json:"target_power"`
+	TargetHeartRate float64 `json:"target_heart_rate"`
+	TargetCadence   float64 `json:"target_cadence"`
+	// Seconds per km
+	TargetPace float64 `json:"target_pace"`
+
+	// Set while the power target is backed off for a stall.
+	Stalled bool `json:"stalled"`
+	Done    bool `json:"done"`
+}
+
+type liveWorkoutMessage struct {
+	Time time.Time `json:"time"`
+	// Always "workout", so clients can tell it apart from metrics.
+	Kind    string      `json:"kind"`
+	Workout LiveWorkout `json:"workout"`
+}
+
+// SetWorkout sends the workout's progress to every connected client, and
+// to any which connect later on.
+func (srv *LiveServer) SetWorkout(workout LiveWorkout) {
+	msg := &liveWorkoutMessage{Time: time.Now(), Kind: "workout", Workout: workout}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	srv.workout = msg
+	srv.broadcast(msg)
+}
+
+// broadcast sends msg to every connected client which is keeping up. Must
+// hold mu.
+func (srv *LiveServer) broadcast(msg interface{}) {
+	for client := range srv.clients {
+		select {
+		case client <- msg:
+		default:
+			// Client isn't keeping up, drop it on the floor.
+		}
+	}
+}
+
+func (srv *LiveServer) getControl() LiveControl {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	return srv.control
+}
+
+// webUnits are the units web pages should show measurements in.
+type webUnits struct {
+	// "metric" or "imperial"
+	System      string `json:"system"`
+	Speed       string `json:"speed"`
+	Distance    string `json:"distance"`
+	Weight      string `json:"weight"`
+	Temperature string `json:"temperature"`
+}
+
+func newWebUnits(units metrics.Units) webUnits {
+	return webUnits{
+		System:      units.String(),
+		Speed:       units.SpeedUnit(),
+		Distance:    units.DistanceUnit(),
+		Weight:      units.WeightUnit(),
+		Temperature: units.TemperatureUnit(),
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("failed to write API response", "err", err)
+	}
+}
+
+// allowMethod reports whether the request uses method, responding with an
+// error if it doesn't.
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+// sameOrigin reports whether the request came from one of our own pages,
+// or from something other than a browser, which doesn't send an Origin.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// hasToken reports whether the request carries the token, always true if
+// there isn't one.
+func (srv *LiveServer) hasToken(r *http.Request) bool {
+	if srv.token == "" {
+		return true
+	}
+
+	given := r.URL.Query().Get("token")
+	if auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		given = auth
+	}
+	return subtle.ConstantTimeCompare([]byte(given), []byte(srv.token)) == 1
+}
+
+// allowControl reports whether the request is a POST allowed to control
+// things, responding with an error if it isn't. With a body, it also has
+// to be JSON.
+func (srv *LiveServer) allowControl(w http.ResponseWriter, r *http.Request, body bool) bool {
+	if !allowMethod(w, r, http.MethodPost) {
+		return false
+	}
+
+	if !sameOrigin(r) {
+		http.Error(w, "cross-origin requests aren't allowed", http.StatusForbidden)
+		return false
+	}
+	if !srv.hasToken(r) {
+		http.Error(w, "missing or wrong token", http.StatusUnauthorized)
+		return false
+	}
+
+	if body {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType != "application/json" {
+			http.Error(w, "expected Content-Type: application/json", http.StatusUnsupportedMediaType)
+			return false
+		}
+	}
+
+	return true
+}
+
+func (srv *LiveServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	srv.mu.Lock()
+	records := make([]metricLogRecord, 0, len(srv.latest))
+	for _, rec := range srv.latest {
+		records = append(records, rec)
+	}
+	srv.mu.Unlock()
+
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Kind != records[j].Kind {
+			return records[i].Kind < records[j].Kind
+		}
+		return records[i].Address < records[j].Address
+	})
+
+	writeJSON(w, records)
+}
+
+func (srv *LiveServer) handleDevices(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	type device struct {
+		Name   string                      `json:"name"`
+		Status string                      `json:"status"`
+		Decode map[string]gatt.DecodeStats `json:"decode,omitempty"`
+		Events []liveDeviceEvent           `json:"events,omitempty"`
+	}
+
+	srv.mu.Lock()
+	devices := []device{}
+	for name, status := range srv.devices {
+		dev := device{Name: name, Status: status, Events: srv.events[name]}
+		if len(srv.decodeStats[name]) > 0 {
+			dev.Decode = map[string]gatt.DecodeStats{}
+			for characteristic, stats := range srv.decodeStats[name] {
+				dev.Decode[characteristic] = stats()
+			}
+		}
+		devices = append(devices, dev)
+	}
+	srv.mu.Unlock()
+
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].Name < devices[j].Name
+	})
+
+	writeJSON(w, devices)
+}
+
+func (srv *LiveServer) handleSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	if r.Method == http.MethodPost && !srv.allowControl(w, r, true) {
+		return
+	}
+
+	rec := srv.getControl().Recorder
+	if rec == nil {
+		http.Error(w, "not recording", http.StatusServiceUnavailable)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		writeJSON(w, rec.Status())
+		return
+	}
+
+	// Anything left out is left alone.
+	var req struct {
+		Sport *string   `json:"sport"`
+		Tags  *[]string `json:"tags"`
+		Note  *string   `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `expected {"sport": ..., "tags": [...], "note": ...}`, http.StatusBadRequest)
+		return
+	}
+
+	if req.Sport != nil {
+		sport, err := ParseSport(*req.Sport)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rec.SetSport(sport)
+	}
+	if req.Tags != nil {
+		rec.SetTags(ParseTags(strings.Join(*req.Tags, ",")))
+	}
+	if req.Note != nil {
+		rec.SetNote(*req.Note)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (srv *LiveServer) handleWorkout(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	srv.mu.Lock()
+	workout := srv.workout
+	srv.mu.Unlock()
+
+	if workout == nil {
+		http.Error(w, "no workout", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, workout.Workout)
+}
+
+func (srv *LiveServer) handleRecording(recording bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !srv.allowControl(w, r, false) {
+			return
+		}
+
+		rec := srv.getControl().Recorder
+		if rec == nil {
+			http.Error(w, "not recording", http.StatusServiceUnavailable)
+			return
+		}
+
+		if recording {
+			rec.Resume()
+		} else {
+			rec.Pause()
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (srv *LiveServer) handleLap(w http.ResponseWriter, r *http.Request) {
+	if !srv.allowControl(w, r, false) {
+		return
+	}
+
+	rec := srv.getControl().Recorder
+	if rec == nil {
+		http.Error(w, "not recording", http.StatusServiceUnavailable)
+		return
+	}
+
+	rec.Lap()
+	slog.Info("lap")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (srv *LiveServer) handleTargetPower(w http.ResponseWriter, r *http.Request) {
+	if !srv.allowControl(w, r, true) {
+		return
+	}
+
+	setTargetPower := srv.getControl().SetTargetPower
+	if setTargetPower == nil {
+		http.Error(w, "no trainer control", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req struct {
+		Watts *int `json:"watts"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Watts == nil {
+		http.Error(w, `expected {"watts": <target>}`, http.StatusBadRequest)
+		return
+	}
+	if *req.Watts < 0 || *req.Watts > MaxTargetPower {
+		http.Error(w, fmt.Sprintf("watts must be between 0 and %d", MaxTargetPower), http.StatusBadRequest)
+		return
+	}
+
+	setTargetPower(*req.Watts)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListenAndServe blocks serving HTTP on addr, e.g. ":8080".
+func (srv *LiveServer) ListenAndServe(addr string) error {
+	srv.server.Addr = addr
+	return srv.server.ListenAndServe()
+}
+
+// Receive sends the metric to every connected client.
+func (srv *LiveServer) Receive(m metrics.Metric) {
+	rec := newMetricLogRecord(m)
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	srv.latest[rec.Kind+" "+rec.Address] = rec
+	srv.broadcast(rec)
+}
+
+func (srv *LiveServer) Flush() error {
+	return nil
+}
+
+// Close stops the server from accepting new clients.
+func (srv *LiveServer) Close() error {
+	return srv.server.Close()
+}
+
+func (srv *LiveServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := srv.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.Warn("websocket upgrade failed", "err", err)
+		return
+	}
+	defer conn.Close()
+
+	client := make(chan interface{}, liveClientBuffer)
+
+	// Workout progress only comes once a second, so start with where
+	// it's at.
+	srv.mu.Lock()
+	if srv.workout != nil {
+		client <- srv.workout
+	}
+	srv.clients[client] = true
+	srv.mu.Unlock()
+
+	defer func() {
+		srv.mu.Lock()
+		delete(srv.clients, client)
+		srv.mu.Unlock()
+	}()
+
+	// We never expect anything from the client, but need to keep reading
+	// to notice when it goes away.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case msg := <-client:
+			if err := conn.WriteJSON(msg); err != nil {
+				return
+			}
+
+		case <-closed:
+			return
+		}
+	}
+}
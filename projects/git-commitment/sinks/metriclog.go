@@ -0,0 +1,205 @@
+package sinks
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/erik/git-commitment/metrics"
+	"tinygo.org/x/bluetooth"
+)
+
+func init() {
+	Register("log", func(opts Options) (Sink, error) {
+		if opts.LogFile == "" {
+			return nil, fmt.Errorf("no log file given")
+		}
+		return NewMetricLogger(opts.LogFile, opts.LogFormat)
+	})
+}
+
+// MetricLogger appends every metric it receives to a file, without any
+// aggregation, for offline analysis.
+type MetricLogger struct {
+	f *os.File
+
+	csv  *csv.Writer
+	json *json.Encoder
+}
+
+type metricLogRecord struct {
+	Time           time.Time `json:"time"`
+	Address        string    `json:"address"`
+	Alias          string    `json:"alias,omitempty"`
+	Characteristic string    `json:"characteristic"`
+	Kind           string    `json:"kind"`
+	Value          float64   `json:"value"`
+	// Only in JSON, CSV doesn't have anywhere to put it.
+	Profile []float64 `json:"profile,omitempty"`
+}
+
+var metricLogCSVHeader = []string{
+	"time", "address", "characteristic", "kind", "value", "alias",
+}
+
+// NewMetricLogger opens (or creates) the file at path for appending.
+// Format is either "csv" or "jsonl".
+func NewMetricLogger(path, format string) (*MetricLogger, error) {
+	if format != "csv" && format != "jsonl" {
+		return nil, fmt.Errorf("unknown log format: %q", format)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	logger := &MetricLogger{f: f}
+
+	switch format {
+	case "csv":
+		logger.csv = csv.NewWriter(f)
+
+		// Only write the header once, we might be appending to an
+		// existing log.
+		if stat, err := f.Stat(); err == nil && stat.Size() == 0 {
+			logger.csv.Write(metricLogCSVHeader)
+			logger.csv.Flush()
+		}
+
+	case "jsonl":
+		logger.json = json.NewEncoder(f)
+	}
+
+	return logger, nil
+}
+
+// ReadMetricLog reads back everything written by a MetricLogger, in either
+// format. Rolling averages can't be told apart from their kind once
+// written, so they're skipped.
+func ReadMetricLog(path string) ([]metrics.Metric, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	records := []metricLogRecord{}
+
+	// JSON lines always start with an object, CSV with the header.
+	if first, err := r.Peek(1); err == nil && first[0] == '{' {
+		dec := json.NewDecoder(r)
+		for {
+			var rec metricLogRecord
+			if err := dec.Decode(&rec); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			records = append(records, rec)
+		}
+	} else {
+		rows, err := csv.NewReader(r).ReadAll()
+		if err != nil {
+			return nil, err
+		}
+
+		for i, row := range rows {
+			if i == 0 || len(row) < len(metricLogCSVHeader) {
+				continue
+			}
+
+			rec := metricLogRecord{
+				Address:        row[1],
+				Characteristic: row[2],
+				Kind:           row[3],
+				Alias:          row[5],
+			}
+			if rec.Time, err = time.Parse(time.RFC3339Nano, row[0]); err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+			if rec.Value, err = strconv.ParseFloat(row[4], 64); err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+			records = append(records, rec)
+		}
+	}
+
+	ms := []metrics.Metric{}
+	for _, rec := range records {
+		kind, err := metrics.ParseKind(rec.Kind)
+		if err != nil {
+			continue
+		}
+
+		m := metrics.Metric{
+			Kind:      kind,
+			Timestamp: rec.Time,
+			Address:   rec.Address,
+			Alias:     rec.Alias,
+			Value:     rec.Value,
+			Profile:   rec.Profile,
+		}
+		// Not worth failing over, nothing much looks at it.
+		m.Characteristic, _ = bluetooth.ParseUUID(rec.Characteristic)
+
+		ms = append(ms, m)
+	}
+
+	return ms, nil
+}
+
+func (logger *MetricLogger) Receive(m metrics.Metric) {
+	if err := logger.write(m); err != nil {
+		slog.Warn("failed to write metric log", "err", err)
+	}
+}
+
+func newMetricLogRecord(m metrics.Metric) metricLogRecord {
+	return metricLogRecord{
+		Time:           m.Timestamp,
+		Address:        m.Address,
+		Alias:          m.Alias,
+		Characteristic: m.Characteristic.String(),
+		Kind:           m.Name(),
+		Value:          m.Value,
+		Profile:        m.Profile,
+	}
+}
+
+func (logger *MetricLogger) write(m metrics.Metric) error {
+	rec := newMetricLogRecord(m)
+
+	if logger.json != nil {
+		return logger.json.Encode(rec)
+	}
+
+	logger.csv.Write([]string{
+		rec.Time.Format(time.RFC3339Nano),
+		rec.Address,
+		rec.Characteristic,
+		rec.Kind,
+		strconv.FormatFloat(rec.Value, 'f', -1, 64),
+		rec.Alias,
+	})
+	logger.csv.Flush()
+
+	return logger.csv.Error()
+}
+
+// Flush makes sure everything written so far is on disk. Every metric is
+// written through as soon as it's received.
+func (logger *MetricLogger) Flush() error {
+	return logger.f.Sync()
+}
+
+func (logger *MetricLogger) Close() error {
+	return logger.f.Close()
+}
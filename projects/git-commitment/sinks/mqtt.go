@@ -0,0 +1,309 @@
+package sinks
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/erik/git-commitment/metrics"
+	"github.com/erik/git-commitment/mqtt"
+)
+
+// DefaultMQTTTopic is the topic everything is published under.
+const DefaultMQTTTopic = "git-commitment"
+
+// DefaultMQTTDiscoveryPrefix is where Home Assistant looks for discovery
+// payloads.
+const DefaultMQTTDiscoveryPrefix = "homeassistant"
+
+// How often the latest values are published. Sensors notify a few times a
+// second, which is more than anyone needs.
+const mqttPublishInterval = 1 * time.Second
+
+func init() {
+	Register("mqtt", func(opts Options) (Sink, error) {
+		if opts.MQTTBroker == "" {
+			return nil, fmt.Errorf("no MQTT broker given")
+		}
+		return NewMQTTPublisher(opts)
+	})
+}
+
+// mqttEntity is a single value we publish, along with what Home Assistant
+// needs to know to show it.
+type mqttEntity struct {
+	name        string
+	unit        string
+	deviceClass string
+	icon        string
+}
+
+// Session-wide values, by kind. Battery levels are per device, so they're
+// handled separately.
+var mqttEntities = map[metrics.Kind]mqttEntity{
+	metrics.HeartRate:      {name: "Heart rate", unit: "bpm", icon: "mdi:heart-pulse"},
+	metrics.CyclingPower:   {name: "Power", unit: "W", deviceClass: "power"},
+	metrics.CyclingCadence: {name: "Cadence", unit: "rpm", icon: "mdi:rotate-right"},
+	metrics.CyclingSpeed:   {name: "Speed", unit: "km/h", deviceClass: "speed"},
+	metrics.PowerZone:      {name: "Power zone", icon: "mdi:gauge"},
+	metrics.HeartRateZone:  {name: "Heart rate zone", icon: "mdi:heart-cog"},
+	metrics.WPrimeBalance:  {name: "W' balance", unit: "kJ", icon: "mdi:battery-charging"},
+
+	metrics.CoreTemperature: {name: "Core temperature", unit: "°C", deviceClass: "temperature"},
+}
+
+// MQTTPublisher publishes the latest value of each metric, once a second,
+// to <topic>/<name>. Battery levels go to <topic>/<device>_battery.
+//
+// Unless the discovery prefix is empty, Home Assistant discovery payloads
+// are published as well, so everything shows up as a sensor entity without
+// any configuration.
+type MQTTPublisher struct {
+	opts Options
+
+	// Held while publishing, since both run and the pipeline flush.
+	flushMu sync.Mutex
+	client  *mqtt.Client
+
+	mu sync.Mutex
+	// Object id -> latest value, only what's changed since the last
+	// publish.
+	pending map[string]float64
+	// Object ids we've already published discovery payloads for.
+	discovered map[string]bool
+	// Object id -> entity, for discovery.
+	entities map[string]mqttEntity
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func NewMQTTPublisher(opts Options) (*MQTTPublisher, error) {
+	if opts.MQTTTopic == "" {
+		opts.MQTTTopic = DefaultMQTTTopic
+	}
+
+	p := &MQTTPublisher{
+		opts:       opts,
+		pending:    map[string]float64{},
+		discovered: map[string]bool{},
+		entities:   map[string]mqttEntity{},
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+
+	if err := p.connect(); err != nil {
+		return nil, err
+	}
+
+	go p.run()
+	return p, nil
+}
+
+func (p *MQTTPublisher) availabilityTopic() string {
+	return p.opts.MQTTTopic + "/status"
+}
+
+func (p *MQTTPublisher) connect() error {
+	host, _ := os.Hostname()
+
+	client, err := mqtt.Dial(p.opts.MQTTBroker, mqtt.Options{
+		ClientId: fmt.Sprintf("git-commitment-%s-%d", host, os.Getpid()),
+		Username: p.opts.MQTTUsername,
+		Password: p.opts.MQTTPassword,
+		Will: &mqtt.Message{
+			Topic:   p.availabilityTopic(),
+			Payload: []byte("offline"),
+			Retain:  true,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	p.client = client
+	// The broker has forgotten nothing retained, but Home Assistant may
+	// have restarted since, so announce everything again.
+	p.discovered = map[string]bool{}
+
+	return client.Publish(mqtt.Message{
+		Topic:   p.availabilityTopic(),
+		Payload: []byte("online"),
+		Retain:  true,
+	})
+}
+
+func (p *MQTTPublisher) run() {
+	defer close(p.stopped)
+
+	ticker := time.NewTicker(mqttPublishInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+
+		case <-ticker.C:
+			if err := p.Flush(); err != nil {
+				slog.Warn("failed to publish to MQTT", "err", err)
+			}
+		}
+	}
+}
+
+func (p *MQTTPublisher) Receive(m metrics.Metric) {
+	if m.Window != 0 {
+		return
+	}
+
+	var id string
+	var entity mqttEntity
+
+	if m.Kind == metrics.BatteryLevel {
+		id = mqttObjectId(m.Source()) + "_battery"
+		entity = mqttEntity{
+			name:        m.Source() + " battery",
+			unit:        "%",
+			deviceClass: "battery",
+		}
+	} else if e, ok := mqttEntities[m.Kind]; ok {
+		id = m.Kind.String()
+		entity = e
+	} else {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.pending[id] = m.Value
+	p.entities[id] = entity
+}
+
+// mqttObjectId turns a device name into something safe to use in topics
+// and entity ids.
+func mqttObjectId(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return '_'
+	}, name)
+}
+
+// Flush publishes every value which has changed since the last flush,
+// announcing any new entities first. Reconnects if the connection dropped.
+func (p *MQTTPublisher) Flush() error {
+	p.flushMu.Lock()
+	defer p.flushMu.Unlock()
+
+	p.mu.Lock()
+	pending := p.pending
+	p.pending = map[string]float64{}
+	p.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	if p.client.Err() != nil {
+		p.client.Close()
+		if err := p.connect(); err != nil {
+			return err
+		}
+	}
+
+	for id, value := range pending {
+		if err := p.discover(id); err != nil {
+			return err
+		}
+
+		err := p.client.Publish(mqtt.Message{
+			Topic:   p.stateTopic(id),
+			Payload: []byte(strconv.FormatFloat(value, 'f', -1, 64)),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *MQTTPublisher) stateTopic(id string) string {
+	return p.opts.MQTTTopic + "/" + id
+}
+
+// discover publishes the Home Assistant discovery payload for an entity,
+// the first time we see it.
+func (p *MQTTPublisher) discover(id string) error {
+	if p.opts.MQTTDiscoveryPrefix == "" || p.discovered[id] {
+		return nil
+	}
+
+	p.mu.Lock()
+	entity := p.entities[id]
+	p.mu.Unlock()
+
+	config := map[string]interface{}{
+		"name":               entity.name,
+		"unique_id":          "git_commitment_" + id,
+		"object_id":          "git_commitment_" + id,
+		"state_topic":        p.stateTopic(id),
+		"state_class":        "measurement",
+		"availability_topic": p.availabilityTopic(),
+		"device": map[string]interface{}{
+			"identifiers": []string{"git_commitment"},
+			"name":        "git-commitment",
+		},
+	}
+	if entity.unit != "" {
+		config["unit_of_measurement"] = entity.unit
+	}
+	if entity.deviceClass != "" {
+		config["device_class"] = entity.deviceClass
+	}
+	if entity.icon != "" {
+		config["icon"] = entity.icon
+	}
+
+	payload, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	err = p.client.Publish(mqtt.Message{
+		Topic:   fmt.Sprintf("%s/sensor/git_commitment/%s/config", p.opts.MQTTDiscoveryPrefix, id),
+		Payload: payload,
+		Retain:  true,
+	})
+	if err != nil {
+		return err
+	}
+
+	p.discovered[id] = true
+	return nil
+}
+
+// Close marks us as offline and disconnects. Anything still pending should
+// have been flushed already.
+func (p *MQTTPublisher) Close() error {
+	close(p.done)
+	<-p.stopped
+
+	p.client.Publish(mqtt.Message{
+		Topic:   p.availabilityTopic(),
+		Payload: []byte("offline"),
+		Retain:  true,
+	})
+	return p.client.Close()
+}
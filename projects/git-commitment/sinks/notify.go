@@ -0,0 +1,110 @@
+package sinks
+
+import (
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"runtime"
+	"strconv"
+
+	"github.com/erik/git-commitment/metrics"
+)
+
+// Battery level in percent to warn about a sensor at, once per sensor.
+const notifyBatteryLow = 20
+
+// Only this many notifications can be waiting to be shown, any more are
+// dropped.
+const notifyQueueLength = 8
+
+func init() {
+	Register("notify", func(opts Options) (Sink, error) {
+		return NewNotifier()
+	})
+}
+
+type notification struct {
+	title, body string
+}
+
+// Notifier shows desktop notifications, through notify-send on Linux and
+// osascript on macOS. Low sensor batteries are picked up from the metrics,
+// anything else worth knowing about (devices coming and going, workout
+// steps) has to be passed to Notify.
+type Notifier struct {
+	queue chan notification
+
+	// Sources we've already warned about a low battery for
+	batteryWarned map[string]bool
+}
+
+func NewNotifier() (*Notifier, error) {
+	command := "notify-send"
+	if runtime.GOOS == "darwin" {
+		command = "osascript"
+	}
+	if _, err := exec.LookPath(command); err != nil {
+		return nil, err
+	}
+
+	n := &Notifier{
+		queue:         make(chan notification, notifyQueueLength),
+		batteryWarned: map[string]bool{},
+	}
+
+	go n.run()
+	return n, nil
+}
+
+// run shows each notification in turn.
+func (n *Notifier) run() {
+	for msg := range n.queue {
+		var cmd *exec.Cmd
+		if runtime.GOOS == "darwin" {
+			// strconv quoting is close enough to AppleScript's for text.
+			script := fmt.Sprintf("display notification %s with title %s",
+				strconv.Quote(msg.body), strconv.Quote(msg.title))
+			cmd = exec.Command("osascript", "-e", script)
+		} else {
+			cmd = exec.Command("notify-send", "--app-name=git-commitment", msg.title, msg.body)
+		}
+
+		if err := cmd.Run(); err != nil {
+			slog.Debug("notify: failed to show notification", "err", err)
+		}
+	}
+}
+
+// Notify queues a notification to be shown, dropping it if too many are
+// already waiting.
+func (n *Notifier) Notify(title, body string) {
+	select {
+	case n.queue <- notification{title, body}:
+	default:
+	}
+}
+
+func (n *Notifier) Receive(m metrics.Metric) {
+	if m.Kind != metrics.BatteryLevel {
+		return
+	}
+
+	// Warn again should it get charged up and run down again.
+	source := m.Source()
+	if m.Value > notifyBatteryLow {
+		delete(n.batteryWarned, source)
+		return
+	}
+
+	if !n.batteryWarned[source] {
+		n.batteryWarned[source] = true
+		n.Notify("Battery low", fmt.Sprintf("%s is down to %.0f%%", source, m.Value))
+	}
+}
+
+func (n *Notifier) Flush() error { return nil }
+
+func (n *Notifier) Close() error {
+	close(n.queue)
+	return nil
+}
@@ -0,0 +1,117 @@
+package sinks
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"strings"
+
+	"github.com/erik/git-commitment/metrics"
+)
+
+// DefaultOSCPattern is the address each metric is sent to, unless given
+// one of its own.
+const DefaultOSCPattern = "/git-commitment/{metric}"
+
+func init() {
+	Register("osc", func(opts Options) (Sink, error) {
+		if opts.OSCAddr == "" {
+			return nil, fmt.Errorf("no OSC address given")
+		}
+		return NewOSCSender(opts.OSCAddr, opts.OSCPattern, opts.OSCAddresses)
+	})
+}
+
+// OSCSender sends every metric as an Open Sound Control message over UDP,
+// as soon as it arrives, with the value as a single float32 argument. For
+// driving TouchDesigner, SuperCollider, Max and friends.
+//
+// The address each metric goes to comes from a pattern, in which {metric}
+// is replaced with the metric's name (e.g. smoothed_power_3s) and {device}
+// with the device it came from, or "derived". Metrics can also be given
+// patterns of their own, by name.
+type OSCSender struct {
+	conn *net.UDPConn
+
+	pattern string
+	// Metric name -> pattern, overriding pattern.
+	patterns map[string]string
+}
+
+// NewOSCSender sends to addr (host:port), which can be a broadcast
+// address.
+func NewOSCSender(addr, pattern string, patterns map[string]string) (*OSCSender, error) {
+	if pattern == "" {
+		pattern = DefaultOSCPattern
+	}
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("OSC address %q doesn't start with /", pattern)
+	}
+	for name, p := range patterns {
+		if !strings.HasPrefix(p, "/") {
+			return nil, fmt.Errorf("OSC address %q for %s doesn't start with /", p, name)
+		}
+	}
+
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OSCSender{
+		conn:     conn,
+		pattern:  pattern,
+		patterns: patterns,
+	}, nil
+}
+
+// address fills in the pattern for m. Spaces aren't allowed in OSC
+// addresses, so they're swapped for underscores.
+func (s *OSCSender) address(m metrics.Metric) string {
+	pattern, ok := s.patterns[m.Name()]
+	if !ok {
+		pattern = s.pattern
+	}
+
+	device := m.Source()
+	if device == "" {
+		device = "derived"
+	}
+
+	address := strings.NewReplacer("{metric}", m.Name(), "{device}", device).Replace(pattern)
+	return strings.ReplaceAll(address, " ", "_")
+}
+
+func (s *OSCSender) Receive(m metrics.Metric) {
+	msg := oscMessage(s.address(m), float32(m.Value))
+	if _, err := s.conn.Write(msg); err != nil {
+		slog.Debug("osc: failed to send", "err", err)
+	}
+}
+
+// oscMessage encodes a message with a single float argument.
+func oscMessage(address string, value float32) []byte {
+	buf := oscString(nil, address)
+	buf = oscString(buf, ",f")
+	return binary.BigEndian.AppendUint32(buf, math.Float32bits(value))
+}
+
+// oscString appends s null terminated and padded out to a multiple of 4
+// bytes.
+func oscString(buf []byte, s string) []byte {
+	buf = append(buf, s...)
+	return append(buf, make([]byte, 4-len(s)%4)...)
+}
+
+func (s *OSCSender) Flush() error { return nil }
+
+func (s *OSCSender) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,184 @@
+package sinks
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/erik/git-commitment/ble"
+	"github.com/erik/git-commitment/gatt"
+	"github.com/erik/git-commitment/metrics"
+	"tinygo.org/x/bluetooth"
+)
+
+func init() {
+	Register("peripheral", func(opts Options) (Sink, error) {
+		if opts.Peripheral == nil {
+			return nil, errors.New("no BLE peripheral to rebroadcast through")
+		}
+		return NewRebroadcaster(opts.Peripheral)
+	})
+}
+
+// How often the latest values are sent, about as often as a real sensor.
+const rebroadcastInterval = 1 * time.Second
+
+// Values older than this aren't sent, rather than repeating the last
+// reading forever after a sensor drops.
+const rebroadcastMaxAge = 5 * time.Second
+
+// Rebroadcaster mirrors the heart rate, power and cadence we receive as
+// standard Heart Rate and Cycling Power services, so that a second app can
+// use sensors which only allow a single connection.
+type Rebroadcaster struct {
+	peripheral *ble.Peripheral
+
+	heartRateChar bluetooth.Characteristic
+	powerChar     bluetooth.Characteristic
+
+	// Guards everything below
+	mu sync.Mutex
+
+	heartRate, power, cadence       float64
+	heartRateAt, powerAt, cadenceAt time.Time
+
+	crank gatt.CrankCounter
+
+	closed bool
+	done   chan struct{}
+}
+
+// NewRebroadcaster adds the services to the peripheral and starts sending
+// notifications. The peripheral still needs to advertise them.
+func NewRebroadcaster(peripheral *ble.Peripheral) (*Rebroadcaster, error) {
+	r := &Rebroadcaster{
+		peripheral: peripheral,
+		done:       make(chan struct{}),
+	}
+
+	err := peripheral.AddService(&bluetooth.Service{
+		UUID: bluetooth.ServiceUUIDHeartRate,
+		Characteristics: []bluetooth.CharacteristicConfig{
+			{
+				Handle: &r.heartRateChar,
+				UUID:   bluetooth.CharacteristicUUIDHeartRateMeasurement,
+				Flags:  bluetooth.CharacteristicNotifyPermission,
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to add heart rate service: %w", err)
+	}
+
+	feature := []byte{gatt.CyclingPowerFeatureCrankRevolution, 0, 0, 0}
+	err = peripheral.AddService(&bluetooth.Service{
+		UUID: bluetooth.ServiceUUIDCyclingPower,
+		Characteristics: []bluetooth.CharacteristicConfig{
+			{
+				Handle: &r.powerChar,
+				UUID:   bluetooth.CharacteristicUUIDCyclingPowerMeasurement,
+				Flags:  bluetooth.CharacteristicNotifyPermission,
+			},
+			{
+				UUID:  bluetooth.CharacteristicUUIDCyclingPowerFeature,
+				Value: feature,
+				Flags: bluetooth.CharacteristicReadPermission,
+			},
+			{
+				// "Other"
+				UUID:  bluetooth.CharacteristicUUIDSensorLocation,
+				Value: []byte{0},
+				Flags: bluetooth.CharacteristicReadPermission,
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to add cycling power service: %w", err)
+	}
+
+	go r.run()
+	return r, nil
+}
+
+func (r *Rebroadcaster) Receive(m metrics.Metric) {
+	// Only raw readings, not the averages we calculate from them.
+	if m.Window != 0 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch m.Kind {
+	case metrics.HeartRate:
+		r.heartRate, r.heartRateAt = m.Value, m.Timestamp
+	case metrics.CyclingPower:
+		r.power, r.powerAt = m.Value, m.Timestamp
+	case metrics.CyclingCadence:
+		r.cadence, r.cadenceAt = m.Value, m.Timestamp
+	}
+}
+
+func (r *Rebroadcaster) run() {
+	ticker := time.NewTicker(rebroadcastInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.done:
+			return
+		case now := <-ticker.C:
+			r.notify(now)
+		}
+	}
+}
+
+// notify sends the latest values to anything subscribed.
+func (r *Rebroadcaster) notify(now time.Time) {
+	r.mu.Lock()
+	fresh := func(at time.Time) bool { return now.Sub(at) < rebroadcastMaxAge }
+
+	var heartRate, power []byte
+	if fresh(r.heartRateAt) {
+		heartRate = gatt.EncodeHeartRateMeasurement(r.heartRate)
+	}
+
+	if fresh(r.powerAt) {
+		cadence := 0.0
+		if fresh(r.cadenceAt) {
+			cadence = r.cadence
+		}
+
+		crank := r.crank.Update(cadence, now)
+		power = gatt.EncodeCyclingPowerMeasurement(r.power, &crank)
+	}
+	r.mu.Unlock()
+
+	if heartRate != nil {
+		if err := r.peripheral.Notify(&r.heartRateChar, heartRate); err != nil {
+			slog.Debug("rebroadcast: failed to send heart rate", "err", err)
+		}
+	}
+	if power != nil {
+		if err := r.peripheral.Notify(&r.powerChar, power); err != nil {
+			slog.Debug("rebroadcast: failed to send power", "err", err)
+		}
+	}
+}
+
+func (r *Rebroadcaster) Flush() error { return nil }
+
+// Close stops sending notifications. The services stay registered until we
+// exit, there's no way to remove them.
+func (r *Rebroadcaster) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.closed {
+		r.closed = true
+		close(r.done)
+	}
+	return nil
+}
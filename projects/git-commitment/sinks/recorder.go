@@ -0,0 +1,461 @@
+// Package sinks contains the consumers at the end of the metric pipeline:
+// recording, logging, storage, and live display.
+package sinks
+
+import (
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/erik/git-commitment/metrics"
+)
+
+// Sample is a snapshot of the most recent value of every metric, taken once
+// per second while recording. Zero means we didn't have a reading.
+type Sample struct {
+	Time time.Time
+
+	HeartRate float64
+	Power     float64
+	Cadence   float64
+	// km/h
+	Speed float64
+	// Meters
+	Distance float64
+	// Degrees Celsius
+	CoreTemperature float64
+	// Counting from 1
+	FrontGear float64
+	RearGear  float64
+
+	// Set on the first sample of each lap after the first.
+	Lap bool
+}
+
+func init() {
+	Register("recorder", func(opts Options) (Sink, error) {
+		return NewRecorder(), nil
+	})
+}
+
+// Recorder collects per-second samples from the metric stream, so that
+// they can be written out to an activity file at the end of the session.
+// Sampling starts with the first metric received.
+type Recorder struct {
+	mu sync.Mutex
+
+	start   time.Time
+	current Sample
+	samples []Sample
+
+	// Set if we've seen any running metrics, otherwise assume this is a
+	// bike ride.
+	running bool
+
+	// Given by SetSport, SetTags and SetNote.
+	info SessionInfo
+
+	// Gear changes so far, as reported by electronic shifting.
+	frontShifts int
+	rearShifts  int
+
+	// Whether each lap was work or rest, if set by LapIntervals.
+	intervals []bool
+
+	// Set by Lap, marks the next sample.
+	lapPending bool
+	// Where the current lap started
+	lapStart         time.Time
+	lapStartDistance float64
+
+	// Last power or speed reading above zero, for auto-pause.
+	lastMoving time.Time
+	// Set by Pause, until Resume.
+	stopped bool
+	// Zero unless we're paused.
+	pausedAt time.Time
+	// Total time spent paused, not counting the current pause.
+	paused time.Duration
+
+	started sync.Once
+	closed  bool
+	done    chan struct{}
+
+	// Called (outside of the lock) with every new sample. Must be set
+	// before the first metric is received.
+	OnSample func(Sample)
+
+	// Start a new lap automatically after this long, or this many meters,
+	// zero to disable. Must be set before the first metric is received.
+	AutoLapTime     time.Duration
+	AutoLapDistance float64
+
+	// Stop taking samples once there's been no power or speed for this
+	// long, and start again as soon as there is. Zero to disable. Must be
+	// set before the first metric is received.
+	AutoPause time.Duration
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{
+		start:   time.Now(),
+		samples: []Sample{},
+		done:    make(chan struct{}),
+	}
+}
+
+func (rec *Recorder) Receive(m metrics.Metric) {
+	rec.started.Do(func() {
+		go rec.run()
+	})
+
+	rec.update(m)
+}
+
+func (rec *Recorder) Flush() error {
+	return nil
+}
+
+// Close stops taking samples. Everything recorded so far is kept.
+func (rec *Recorder) Close() error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if !rec.closed {
+		rec.closed = true
+		close(rec.done)
+	}
+
+	return nil
+}
+
+// run takes a sample every second until the recorder is closed.
+func (rec *Recorder) run() {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-rec.done:
+			return
+
+		case now := <-ticker.C:
+			rec.mu.Lock()
+			if rec.pauseDue(now) {
+				rec.mu.Unlock()
+				continue
+			}
+
+			sample := rec.current
+			sample.Time = now
+			sample.Lap = rec.lapDue(sample)
+			rec.samples = append(rec.samples, sample)
+			rec.mu.Unlock()
+
+			if rec.OnSample != nil {
+				rec.OnSample(sample)
+			}
+		}
+	}
+}
+
+func (rec *Recorder) update(m metrics.Metric) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	switch m.Kind {
+	case metrics.HeartRate:
+		rec.current.HeartRate = m.Value
+	case metrics.CyclingPower:
+		rec.current.Power = m.Value
+	case metrics.CyclingCadence:
+		rec.current.Cadence = m.Value
+	case metrics.CyclingSpeed:
+		rec.current.Speed = m.Value
+	case metrics.CyclingDistance:
+		rec.current.Distance = m.Value
+	case metrics.CoreTemperature:
+		rec.current.CoreTemperature = m.Value
+
+	// Shifting systems repeat the current gear, so only count changes.
+	case metrics.FrontGear:
+		if rec.current.FrontGear != 0 && m.Value != rec.current.FrontGear {
+			rec.frontShifts++
+		}
+		rec.current.FrontGear = m.Value
+	case metrics.RearGear:
+		if rec.current.RearGear != 0 && m.Value != rec.current.RearGear {
+			rec.rearShifts++
+		}
+		rec.current.RearGear = m.Value
+
+	case metrics.RunningCadence:
+		rec.running = true
+		rec.current.Cadence = m.Value
+	case metrics.RunningPace:
+		rec.running = true
+		rec.current.Speed = 3600 / m.Value
+	case metrics.RunningDistance:
+		rec.running = true
+		rec.current.Distance = m.Value
+	}
+
+	switch m.Kind {
+	case metrics.CyclingPower, metrics.CyclingSpeed, metrics.RunningPace:
+		if m.Value > 0 {
+			rec.lastMoving = m.Timestamp
+		}
+	}
+}
+
+// pauseDue reports whether we're paused at now, either by Pause or
+// auto-pause, pausing or resuming as needed. Must hold mu.
+func (rec *Recorder) pauseDue(now time.Time) bool {
+	idle := rec.stopped
+
+	if rec.AutoPause > 0 {
+		// Give sensors a chance to send something before pausing.
+		if rec.lastMoving.IsZero() {
+			rec.lastMoving = now
+		}
+
+		idle = idle || now.Sub(rec.lastMoving) > rec.AutoPause
+	}
+
+	switch {
+	case idle && rec.pausedAt.IsZero():
+		slog.Info("recording paused")
+		rec.pausedAt = now
+
+	case !idle && !rec.pausedAt.IsZero():
+		pause := now.Sub(rec.pausedAt)
+		slog.Info("recording resumed", "paused", pause.Round(time.Second))
+
+		rec.paused += pause
+		rec.pausedAt = time.Time{}
+		// Auto-lap goes by recorded time.
+		if !rec.lapStart.IsZero() {
+			rec.lapStart = rec.lapStart.Add(pause)
+		}
+	}
+
+	return idle
+}
+
+// lapDue reports whether the sample starts a new lap, either because one
+// was asked for or an auto-lap is due. Must hold mu.
+func (rec *Recorder) lapDue(sample Sample) bool {
+	if rec.lapStart.IsZero() {
+		rec.lapStart = sample.Time
+		rec.lapStartDistance = sample.Distance
+		return false
+	}
+
+	due := rec.lapPending ||
+		(rec.AutoLapTime > 0 && sample.Time.Sub(rec.lapStart) >= rec.AutoLapTime) ||
+		(rec.AutoLapDistance > 0 && sample.Distance-rec.lapStartDistance >= rec.AutoLapDistance)
+	if !due {
+		return false
+	}
+
+	rec.lapPending = false
+	rec.lapStart = sample.Time
+	rec.lapStartDistance = sample.Distance
+	return true
+}
+
+// Summarize adds a summary of each lap, and the time spent paused, to the
+// session summary.
+func (rec *Recorder) Summarize(s *metrics.SessionSummary) {
+	s.Laps = SummarizeLaps(rec.Samples())
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if len(rec.intervals) == len(s.Laps) {
+		for i, work := range rec.intervals {
+			s.Laps[i].Interval = "rest"
+			if work {
+				s.Laps[i].Interval = "work"
+			}
+		}
+	}
+
+	s.Paused = rec.paused
+	if !rec.pausedAt.IsZero() {
+		s.Paused += time.Since(rec.pausedAt)
+	}
+
+	s.FrontShifts = rec.frontShifts
+	s.RearShifts = rec.rearShifts
+}
+
+// Pause stops taking samples until Resume is called, as if auto-paused.
+func (rec *Recorder) Pause() {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	rec.stopped = true
+}
+
+// Resume undoes Pause. Auto-pause still applies.
+func (rec *Recorder) Resume() {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	rec.stopped = false
+	// Don't go straight back into auto-pause before there's been a
+	// chance to start moving.
+	rec.lastMoving = time.Now()
+}
+
+// RecorderStatus is a snapshot of the recording so far.
+type RecorderStatus struct {
+	StartedAt time.Time `json:"started_at"`
+	// False while paused
+	Recording bool `json:"recording"`
+	// Seconds, not counting time spent paused.
+	MovingTime float64 `json:"moving_time"`
+	PausedTime float64 `json:"paused_time"`
+	// Meters
+	Distance float64 `json:"distance"`
+	Laps     int     `json:"laps"`
+
+	Sport string   `json:"sport"`
+	Tags  []string `json:"tags"`
+	Note  string   `json:"note"`
+}
+
+func (rec *Recorder) Status() RecorderStatus {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	status := RecorderStatus{
+		StartedAt:  rec.start,
+		Recording:  rec.pausedAt.IsZero() && !rec.stopped,
+		MovingTime: float64(len(rec.samples)),
+		PausedTime: rec.paused.Seconds(),
+		Distance:   rec.current.Distance,
+		Laps:       1,
+
+		Sport: rec.sport(),
+		Tags:  slices.Clone(rec.info.Tags),
+		Note:  rec.info.Note,
+	}
+
+	if !rec.pausedAt.IsZero() {
+		status.PausedTime += time.Since(rec.pausedAt).Seconds()
+	}
+	for _, s := range rec.samples {
+		if s.Lap {
+			status.Laps++
+		}
+	}
+
+	return status
+}
+
+// LapIntervals starts a new lap at each work or rest interval found by
+// DetectIntervals, after the fact. It does nothing if there are already
+// laps. Returns the times of the samples which now start a lap.
+func (rec *Recorder) LapIntervals(threshold float64) []time.Time {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	for _, s := range rec.samples {
+		if s.Lap {
+			return nil
+		}
+	}
+
+	intervals := DetectIntervals(rec.samples, threshold)
+	if intervals == nil {
+		return nil
+	}
+
+	laps := []time.Time{}
+	rec.intervals = []bool{}
+	for i, in := range intervals {
+		if i > 0 {
+			rec.samples[in.Start].Lap = true
+			laps = append(laps, rec.samples[in.Start].Time)
+		}
+		rec.intervals = append(rec.intervals, in.Work)
+	}
+
+	return laps
+}
+
+// Lap starts a new lap from the next sample on.
+func (rec *Recorder) Lap() {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	rec.lapPending = true
+}
+
+// Sport is whatever was given with SetSport, otherwise either "Running"
+// or "Biking" based on which metrics we've seen so far.
+func (rec *Recorder) Sport() string {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	return rec.sport()
+}
+
+// Must hold mu.
+func (rec *Recorder) sport() string {
+	switch {
+	case rec.info.Sport != "":
+		return rec.info.Sport
+	case rec.running:
+		return SportRunning
+	}
+	return SportBiking
+}
+
+// SetSport overrides the sport, see ParseSport. Empty goes back to
+// guessing from the metrics.
+func (rec *Recorder) SetSport(sport string) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	rec.info.Sport = sport
+}
+
+// SetTags replaces the session's tags.
+func (rec *Recorder) SetTags(tags []string) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	rec.info.Tags = slices.Clone(tags)
+}
+
+func (rec *Recorder) SetNote(note string) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	rec.info.Note = note
+}
+
+// Info is the session's tags and note, and its sport from Sport.
+func (rec *Recorder) Info() SessionInfo {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	info := rec.info
+	info.Sport = rec.sport()
+	info.Tags = slices.Clone(info.Tags)
+	return info
+}
+
+// Samples returns a copy of everything recorded so far.
+func (rec *Recorder) Samples() []Sample {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	samples := make([]Sample, len(rec.samples))
+	copy(samples, rec.samples)
+	return samples
+}
@@ -0,0 +1,256 @@
+package sinks
+
+import (
+	"bytes"
+	"database/sql"
+	_ "embed"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/erik/git-commitment/metrics"
+)
+
+//go:embed web/sessions.html
+var sessionsHTML []byte
+
+// SessionBrowser serves a page for looking back over stored sessions, with
+// charts of each one and buttons to download them, backed by a small JSON
+// API:
+//
+//	GET /sessions                     the page
+//	GET /api/sessions                 every stored session, newest first
+//	GET /api/sessions/{id}            a session's samples and time in each zone
+//	GET /api/sessions/{id}/export     download as ?format=tcx (default) or csv
+//
+// Time in zone goes by the zones we were given, not whatever they were at
+// the time of the session. Speed and distance are in units, as are CSV
+// downloads.
+type SessionBrowser struct {
+	store *Store
+
+	powerZones     metrics.Zones
+	heartRateZones metrics.Zones
+	units          metrics.Units
+}
+
+func NewSessionBrowser(store *Store, powerZones, heartRateZones metrics.Zones, units metrics.Units) *SessionBrowser {
+	return &SessionBrowser{
+		store:          store,
+		powerZones:     powerZones,
+		heartRateZones: heartRateZones,
+		units:          units,
+	}
+}
+
+// Register adds the page and API to mux.
+func (b *SessionBrowser) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/sessions", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(sessionsHTML)
+	})
+	mux.HandleFunc("/api/sessions", b.handleList)
+	mux.HandleFunc("/api/sessions/{id}", b.handleSession)
+	mux.HandleFunc("/api/sessions/{id}/export", b.handleExport)
+}
+
+type browserSession struct {
+	Id        int64      `json:"id"`
+	Sport     string     `json:"sport"`
+	StartedAt time.Time  `json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+	Samples   int        `json:"samples"`
+	Tags      []string   `json:"tags"`
+	Note      string     `json:"note"`
+}
+
+func newBrowserSession(s StoredSession) browserSession {
+	session := browserSession{
+		Id:        s.Id,
+		Sport:     s.Sport,
+		StartedAt: s.StartedAt,
+		Samples:   s.Samples,
+		Tags:      s.Tags,
+		Note:      s.Note,
+	}
+	if s.EndedAt.Valid {
+		session.EndedAt = &s.EndedAt.Time
+	}
+	return session
+}
+
+// One array per metric rather than an object per sample, since there's a
+// sample every second.
+type browserSeries struct {
+	// Seconds since the first sample
+	Time      []float64 `json:"time"`
+	Power     []float64 `json:"power"`
+	HeartRate []float64 `json:"heart_rate"`
+	Cadence   []float64 `json:"cadence"`
+	// In the browser's units
+	Speed []float64 `json:"speed"`
+	// Sample indices which start a new lap
+	Laps []int `json:"laps"`
+}
+
+type browserZone struct {
+	Name string `json:"name"`
+	// Seconds
+	Time float64 `json:"time"`
+}
+
+func (b *SessionBrowser) handleList(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	stored, err := b.store.ListSessions()
+	if err != nil {
+		slog.Warn("failed to list sessions", "err", err)
+		http.Error(w, "failed to list sessions", http.StatusInternalServerError)
+		return
+	}
+
+	sessions := []browserSession{}
+	for _, s := range stored {
+		sessions = append(sessions, newBrowserSession(s))
+	}
+
+	writeJSON(w, sessions)
+}
+
+// getSession looks up the session with the id in the request's path,
+// responding with an error if there isn't one.
+func (b *SessionBrowser) getSession(w http.ResponseWriter, r *http.Request) (StoredSession, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "bad session id", http.StatusBadRequest)
+		return StoredSession{}, false
+	}
+
+	session, err := b.store.GetSession(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return StoredSession{}, false
+	} else if err != nil {
+		slog.Warn("failed to read session", "id", id, "err", err)
+		http.Error(w, "failed to read session", http.StatusInternalServerError)
+		return StoredSession{}, false
+	}
+
+	return session, true
+}
+
+func (b *SessionBrowser) handleSession(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	session, ok := b.getSession(w, r)
+	if !ok {
+		return
+	}
+
+	samples, err := b.store.Samples(session.Id)
+	if err != nil {
+		slog.Warn("failed to read samples", "id", session.Id, "err", err)
+		http.Error(w, "failed to read samples", http.StatusInternalServerError)
+		return
+	}
+
+	series := browserSeries{
+		Time:      make([]float64, len(samples)),
+		Power:     make([]float64, len(samples)),
+		HeartRate: make([]float64, len(samples)),
+		Cadence:   make([]float64, len(samples)),
+		Speed:     make([]float64, len(samples)),
+		Laps:      []int{},
+	}
+	for i, s := range samples {
+		series.Time[i] = s.Time.Sub(samples[0].Time).Seconds()
+		series.Power[i] = s.Power
+		series.HeartRate[i] = s.HeartRate
+		series.Cadence[i] = s.Cadence
+		series.Speed[i] = b.units.Speed(s.Speed)
+		if s.Lap {
+			series.Laps = append(series.Laps, i)
+		}
+	}
+
+	distance := 0.0
+	if len(samples) > 0 {
+		distance = b.units.Distance(samples[len(samples)-1].Distance)
+	}
+
+	writeJSON(w, struct {
+		Session        browserSession `json:"session"`
+		Units          webUnits       `json:"units"`
+		Distance       float64        `json:"distance"`
+		Series         browserSeries  `json:"series"`
+		PowerZones     []browserZone  `json:"power_zones"`
+		HeartRateZones []browserZone  `json:"heart_rate_zones"`
+	}{
+		Session:        newBrowserSession(session),
+		Units:          newWebUnits(b.units),
+		Distance:       distance,
+		Series:         series,
+		PowerZones:     timeInZones(b.powerZones, series.Power),
+		HeartRateZones: timeInZones(b.heartRateZones, series.HeartRate),
+	})
+}
+
+// timeInZones counts a second in the zone of each value, leaving out zeros
+// since they're missing readings.
+func timeInZones(zones metrics.Zones, values []float64) []browserZone {
+	times := make([]browserZone, zones.Len())
+	for i, name := range zones.Names {
+		times[i].Name = name
+	}
+
+	for _, v := range values {
+		if v <= 0 {
+			continue
+		}
+		if zone := zones.Classify(v); zone < len(times) {
+			times[zone].Time++
+		}
+	}
+
+	return times
+}
+
+func (b *SessionBrowser) handleExport(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "tcx"
+	}
+	contentType, ok := ExportContentTypes[format]
+	if !ok {
+		http.Error(w, "unknown format", http.StatusBadRequest)
+		return
+	}
+
+	session, ok := b.getSession(w, r)
+	if !ok {
+		return
+	}
+
+	// Buffered so that failures still get an error status.
+	var buf bytes.Buffer
+	if err := ExportSession(&buf, b.store, session.Id, format, b.units); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("%s-%d.%s", session.StartedAt.Local().Format("2006-01-02-150405"), session.Id, format)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,71 @@
+package sinks
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Sports, as named in TCX files.
+const (
+	SportBiking  = "Biking"
+	SportRunning = "Running"
+	SportOther   = "Other"
+)
+
+// SessionInfo is what the rider tells us about a session, rather than
+// anything we measured. Any of it may be empty.
+type SessionInfo struct {
+	// One of the Sport* constants, empty to go by the metrics we see.
+	Sport string   `json:"sport"`
+	Tags  []string `json:"tags"`
+	Note  string   `json:"note"`
+}
+
+// ParseSport accepts any case, and a few other names for each sport.
+func ParseSport(name string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "biking", "bike", "cycling", "ride":
+		return SportBiking, nil
+	case "running", "run":
+		return SportRunning, nil
+	case "other":
+		return SportOther, nil
+	}
+
+	return "", fmt.Errorf("unknown sport %q, expected biking, running or other", name)
+}
+
+// ParseTags splits tags on commas and spaces, e.g. "indoor, #z2 recovery",
+// dropping any leading #.
+func ParseTags(s string) []string {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+
+	tags := []string{}
+	for _, field := range fields {
+		if tag := strings.TrimLeft(field, "#"); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// Description is the note followed by the tags as hashtags, for activity
+// files and uploads.
+func (info SessionInfo) Description() string {
+	lines := []string{}
+	if info.Note != "" {
+		lines = append(lines, info.Note)
+	}
+
+	if len(info.Tags) > 0 {
+		hashtags := make([]string, len(info.Tags))
+		for i, tag := range info.Tags {
+			hashtags[i] = "#" + tag
+		}
+		lines = append(lines, strings.Join(hashtags, " "))
+	}
+
+	return strings.Join(lines, "\n")
+}
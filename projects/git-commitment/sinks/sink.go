@@ -0,0 +1,160 @@
+package sinks
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/erik/git-commitment/ant"
+	"github.com/erik/git-commitment/ble"
+	"github.com/erik/git-commitment/metrics"
+)
+
+// Sink consumes metrics at the end of the pipeline. Receive is only ever
+// called from one goroutine at a time, but sinks which do work in the
+// background (e.g. redrawing the screen) must be safe to use alongside it.
+type Sink interface {
+	// Receive handles a single metric. It shouldn't block for long, or
+	// it'll hold up every other sink.
+	Receive(m metrics.Metric)
+	// Flush writes out anything buffered.
+	Flush() error
+	// Close releases everything held by the sink. Nothing is received
+	// after Close is called.
+	Close() error
+}
+
+// Run feeds every metric from in to sink until the channel is closed, then
+// flushes it. Closing the sink is left up to the caller.
+func Run(sink Sink, in <-chan metrics.Metric) {
+	for m := range in {
+		sink.Receive(m)
+	}
+
+	if err := sink.Flush(); err != nil {
+		slog.Warn("failed to flush sink", "err", err)
+	}
+}
+
+// Options are everything the registered sinks can be configured with. Each
+// sink only looks at the options relevant to it.
+type Options struct {
+	// Metric log, see NewMetricLogger.
+	LogFile   string
+	LogFormat string
+
+	// Address for the live server to listen on, e.g. ":8080", and the
+	// token its control API needs, if any.
+	HTTPAddr  string
+	HTTPToken string
+	// Address for the gRPC server to listen on, e.g. ":50051".
+	GRPCAddr string
+	// Where to broadcast metrics to, e.g. "255.255.255.255:5005", and
+	// how often.
+	UDPAddr     string
+	UDPInterval time.Duration
+
+	// Text to speech command for the audio sink, see NewAnnouncer, and
+	// how often it reads out the averages.
+	TTSCommand string
+	AudioEvery time.Duration
+
+	// Where the status bar sink writes its line, empty for stdout. See
+	// NewStatusBar.
+	StatusBarFile string
+
+	// Used to color values on the dashboard.
+	PowerZones     metrics.Zones
+	HeartRateZones metrics.Zones
+	// What to show measurements in, on the dashboard and web pages.
+	Units metrics.Units
+
+	// Where sessions are being stored, for the live server to serve past
+	// ones from. Nil if we aren't storing them.
+	Store *Store
+
+	// For sinks which act as a BLE peripheral, nil if we aren't one.
+	Peripheral *ble.Peripheral
+
+	// For broadcasting as ANT+ sensors, nil if there's no stick. Everything
+	// is broadcast with the same device number.
+	ANTStick  *ant.Stick
+	ANTDevice uint16
+	// In meters, for broadcasting speed as wheel revolutions.
+	WheelCircumference float64
+
+	// Where to POST session start, lap and end events, see NewWebhook.
+	WebhookURL string
+
+	// InfluxDB v2 server to write metrics to, see NewInfluxWriter.
+	InfluxURL    string
+	InfluxOrg    string
+	InfluxBucket string
+	InfluxToken  string
+
+	// MQTT broker (host:port) to publish to, see NewMQTTPublisher.
+	MQTTBroker   string
+	MQTTUsername string
+	MQTTPassword string
+	MQTTTopic    string
+	// Empty to not publish Home Assistant discovery payloads.
+	MQTTDiscoveryPrefix string
+
+	// NATS server to stream metrics to, see NewNATSPublisher.
+	NATSURL     string
+	NATSSubject string
+
+	// RedisTimeSeries server to write metrics to, see NewRedisWriter.
+	RedisURL       string
+	RedisPrefix    string
+	RedisRetention time.Duration
+
+	// Where to send OSC messages, see NewOSCSender.
+	OSCAddr      string
+	OSCPattern   string
+	OSCAddresses map[string]string
+
+	// WLED controller to show the current zone on, see NewWLEDLights.
+	WLEDURL string
+	// Either power or hr.
+	WLEDZone string
+	// Hex colors for each zone, empty for the defaults.
+	WLEDColors []string
+}
+
+// Factory creates a sink from options.
+type Factory func(opts Options) (Sink, error)
+
+var registry = map[string]Factory{}
+
+// Register makes a sink available by name. Panics if the name is already
+// taken.
+func Register(name string, factory Factory) {
+	if _, ok := registry[name]; ok {
+		panic("sink registered twice: " + name)
+	}
+
+	registry[name] = factory
+}
+
+// New creates the sink registered under name.
+func New(name string, opts Options) (Sink, error) {
+	factory, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown sink: %q", name)
+	}
+
+	return factory(opts)
+}
+
+// Names lists every registered sink, sorted.
+func Names() []string {
+	names := []string{}
+	for name := range registry {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,204 @@
+package sinks
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/erik/git-commitment/metrics"
+)
+
+// How often the status line is redrawn, and how long a value is shown for
+// after the sensor goes quiet.
+const (
+	statusBarInterval = 1 * time.Second
+	statusBarStale    = 5 * time.Second
+)
+
+func init() {
+	Register("statusbar", func(opts Options) (Sink, error) {
+		return NewStatusBar(opts.StatusBarFile)
+	})
+}
+
+type statusBarValue struct {
+	value float64
+	at    time.Time
+}
+
+// StatusBar keeps a single compact line of the latest values up to date,
+// e.g. "♥162 ⚡245W ↻92", for showing in tmux, i3bar, polybar and the like.
+//
+// Without a path the line is redrawn in place on stdout. With a named pipe
+// each refresh is written to it as a line of its own, skipping refreshes
+// while nothing is reading. Any other path is rewritten with the latest
+// line, for bars which poll a file.
+type StatusBar struct {
+	path string
+
+	// Held while writing, since Flush is called from outside the redraw
+	// loop too.
+	writeMu sync.Mutex
+	pipe    *os.File
+
+	mu     sync.Mutex
+	latest map[metrics.Kind]statusBarValue
+	// Smoothed power is much easier to read, so prefer it once we've seen
+	// some.
+	haveSmoothed bool
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func NewStatusBar(path string) (*StatusBar, error) {
+	if path != "" {
+		if dir, err := os.Stat(path); err == nil && dir.IsDir() {
+			return nil, fmt.Errorf("%s is a directory", path)
+		}
+	}
+
+	bar := &StatusBar{
+		path:    path,
+		latest:  map[metrics.Kind]statusBarValue{},
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	go bar.run()
+	return bar, nil
+}
+
+func (bar *StatusBar) run() {
+	defer close(bar.stopped)
+
+	ticker := time.NewTicker(statusBarInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-bar.done:
+			return
+
+		case <-ticker.C:
+			if err := bar.Flush(); err != nil {
+				slog.Debug("statusbar: failed to write", "path", bar.path, "err", err)
+			}
+		}
+	}
+}
+
+func (bar *StatusBar) Receive(m metrics.Metric) {
+	kind := m.Kind
+	switch {
+	case kind == metrics.SmoothedPower && m.Window == 3*time.Second:
+		bar.haveSmoothed = true
+		kind = metrics.CyclingPower
+	case kind == metrics.CyclingPower && bar.haveSmoothed:
+		return
+	case kind == metrics.RunningCadence:
+		kind = metrics.CyclingCadence
+	case kind != metrics.HeartRate && kind != metrics.CyclingPower && kind != metrics.CyclingCadence:
+		return
+	}
+
+	bar.mu.Lock()
+	defer bar.mu.Unlock()
+
+	bar.latest[kind] = statusBarValue{value: m.Value, at: time.Now()}
+}
+
+// line is the status line as it stands, leaving out anything stale.
+func (bar *StatusBar) line() string {
+	bar.mu.Lock()
+	defer bar.mu.Unlock()
+
+	parts := []string{}
+	add := func(kind metrics.Kind, format string) {
+		if v, ok := bar.latest[kind]; ok && time.Since(v.at) < statusBarStale {
+			parts = append(parts, fmt.Sprintf(format, v.value))
+		}
+	}
+
+	add(metrics.HeartRate, "♥%.0f")
+	add(metrics.CyclingPower, "⚡%.0fW")
+	add(metrics.CyclingCadence, "↻%.0f")
+
+	return strings.Join(parts, " ")
+}
+
+// Flush writes out the status line straight away.
+func (bar *StatusBar) Flush() error {
+	line := bar.line()
+
+	bar.writeMu.Lock()
+	defer bar.writeMu.Unlock()
+
+	if bar.path == "" {
+		// Back to the start of the line, clearing whatever was longer.
+		_, err := fmt.Printf("\r%s\033[K", line)
+		return err
+	}
+
+	info, err := os.Stat(bar.path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err != nil || info.Mode()&fs.ModeNamedPipe == 0 {
+		return os.WriteFile(bar.path, []byte(line+"\n"), 0644)
+	}
+
+	return bar.writePipe(line)
+}
+
+// writePipe writes a line to the named pipe, opening it if need be. Doesn't
+// block waiting for something to read it, the line is just dropped.
+func (bar *StatusBar) writePipe(line string) error {
+	if bar.pipe == nil {
+		pipe, err := os.OpenFile(bar.path, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+		if errors.Is(err, syscall.ENXIO) {
+			// Nothing has it open for reading yet.
+			return nil
+		} else if err != nil {
+			return err
+		}
+		bar.pipe = pipe
+	}
+
+	bar.pipe.SetWriteDeadline(time.Now().Add(statusBarInterval))
+	if _, err := bar.pipe.WriteString(line + "\n"); err != nil {
+		// Most likely the reader went away, so start over next time.
+		bar.pipe.Close()
+		bar.pipe = nil
+
+		if errors.Is(err, syscall.EPIPE) || errors.Is(err, os.ErrDeadlineExceeded) {
+			return nil
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (bar *StatusBar) Close() error {
+	close(bar.done)
+	<-bar.stopped
+
+	bar.writeMu.Lock()
+	defer bar.writeMu.Unlock()
+
+	if bar.pipe != nil {
+		bar.pipe.Close()
+	}
+	if bar.path == "" {
+		fmt.Println()
+	}
+
+	return nil
+}
@@ -0,0 +1,23 @@
+package sinks
+
+import (
+	"fmt"
+
+	"github.com/erik/git-commitment/metrics"
+)
+
+func init() {
+	Register("stdout", func(opts Options) (Sink, error) {
+		return Stdout{}, nil
+	})
+}
+
+// Stdout prints every metric on its own line.
+type Stdout struct{}
+
+func (Stdout) Receive(m metrics.Metric) {
+	fmt.Printf("Metric: %-24s %8.2f [%s]\n", m.Name(), m.Value, m.Source())
+}
+
+func (Stdout) Flush() error { return nil }
+func (Stdout) Close() error { return nil }
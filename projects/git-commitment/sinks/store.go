@@ -0,0 +1,428 @@
+package sinks
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/erik/git-commitment/gatt"
+	"github.com/erik/git-commitment/metrics"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var MIGRATIONS = []string{
+	`CREATE TABLE schema_versions (version INT, ts DATETIME DEFAULT current_timestamp)`,
+	`
+CREATE TABLE IF NOT EXISTS sessions (
+  id          INTEGER PRIMARY KEY autoincrement,
+  sport       TEXT NOT NULL DEFAULT 'Biking',
+  started_at  DATETIME NOT NULL,
+  ended_at    DATETIME
+);
+
+-- One row per second of recording
+CREATE TABLE IF NOT EXISTS samples (
+  session_id  INTEGER NOT NULL,
+  ts          DATETIME NOT NULL,
+
+  heart_rate  REAL,
+  power       REAL,
+  cadence     REAL,
+  speed       REAL,
+  distance    REAL,
+
+  FOREIGN KEY (session_id) REFERENCES sessions(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_samples_by_session ON samples(session_id, ts);
+
+CREATE TABLE IF NOT EXISTS devices (
+  id            INTEGER PRIMARY KEY autoincrement,
+  session_id    INTEGER NOT NULL,
+
+  address       TEXT NOT NULL,
+  manufacturer  TEXT,
+  model         TEXT,
+  firmware      TEXT,
+  serial        TEXT,
+
+  FOREIGN KEY (session_id) REFERENCES sessions(id)
+);
+`,
+	`ALTER TABLE devices ADD COLUMN alias TEXT`,
+	`ALTER TABLE samples ADD COLUMN lap BOOLEAN NOT NULL DEFAULT 0`,
+	`
+-- Best average power for each duration in a session, in seconds and watts
+CREATE TABLE IF NOT EXISTS power_bests (
+  session_id  INTEGER NOT NULL,
+  duration    INTEGER NOT NULL,
+  power       REAL NOT NULL,
+
+  FOREIGN KEY (session_id) REFERENCES sessions(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_power_bests_by_duration ON power_bests(duration, power);
+`,
+	`ALTER TABLE samples ADD COLUMN core_temperature REAL NOT NULL DEFAULT 0`,
+	`ALTER TABLE samples ADD COLUMN front_gear INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE samples ADD COLUMN rear_gear INTEGER NOT NULL DEFAULT 0`,
+	`
+-- Readings from a weight scale, zero where the scale didn't report one
+CREATE TABLE IF NOT EXISTS body_measurements (
+  ts           DATETIME NOT NULL,
+  address      TEXT NOT NULL,
+
+  weight       REAL NOT NULL DEFAULT 0,
+  bmi          REAL NOT NULL DEFAULT 0,
+  body_fat     REAL NOT NULL DEFAULT 0,
+  muscle_mass  REAL NOT NULL DEFAULT 0
+);
+`,
+	`
+-- Trainer calibrations, e.g. spindowns, which need redoing every so often
+CREATE TABLE IF NOT EXISTS calibrations (
+  ts       DATETIME NOT NULL,
+  address  TEXT NOT NULL,
+  result   TEXT NOT NULL DEFAULT ''
+);
+`,
+	`ALTER TABLE sessions ADD COLUMN tags TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE sessions ADD COLUMN note TEXT NOT NULL DEFAULT ''`,
+}
+
+// PowerBest is the best power for a duration across every stored session.
+type PowerBest struct {
+	Duration  time.Duration
+	Power     float64
+	SessionId int64
+	StartedAt time.Time
+}
+
+// StoredSession is a summary of a session as stored in the database.
+type StoredSession struct {
+	Id        int64
+	Sport     string
+	StartedAt time.Time
+	EndedAt   sql.NullTime
+	Samples   int
+	Tags      []string
+	Note      string
+}
+
+// Info is the sport, tags and note stored for the session.
+func (s StoredSession) Info() SessionInfo {
+	return SessionInfo{Sport: s.Sport, Tags: s.Tags, Note: s.Note}
+}
+
+type Store struct {
+	conn *sql.DB
+}
+
+// DefaultStorePath is where sessions go unless told otherwise, following
+// the XDG convention.
+func DefaultStorePath() string {
+	dataDir := os.Getenv("XDG_DATA_HOME")
+	if dataDir == "" {
+		home, _ := os.UserHomeDir()
+		dataDir = filepath.Join(home, ".local", "share")
+	}
+
+	return filepath.Join(dataDir, "git-commitment", "sessions.db")
+}
+
+func NewStore(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	conn, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+
+	store := Store{conn: conn}
+	if err := store.initializeSchema(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &store, nil
+}
+
+func (store *Store) initializeSchema() error {
+	maxVersion := store.maxMigration()
+	if maxVersion >= len(MIGRATIONS)-1 {
+		return nil
+	}
+
+	for i := maxVersion + 1; i < len(MIGRATIONS); i++ {
+		if _, err := store.conn.Exec(MIGRATIONS[i]); err != nil {
+			return err
+		}
+	}
+
+	sql := `INSERT INTO schema_versions (version) VALUES (?)`
+	_, err := store.conn.Exec(sql, len(MIGRATIONS)-1)
+	return err
+}
+
+func (store *Store) maxMigration() int {
+	version := -1
+	sql := `SELECT COALESCE(MAX(version), -1) FROM schema_versions;`
+	_ = store.conn.QueryRow(sql).Scan(&version)
+	return version
+}
+
+func (store *Store) Close() error {
+	return store.conn.Close()
+}
+
+func (store *Store) CreateSession(startedAt time.Time) (int64, error) {
+	sql := `INSERT INTO sessions (started_at) VALUES (?)`
+	res, err := store.conn.Exec(sql, startedAt.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	return res.LastInsertId()
+}
+
+// EndSession stores when the session ended, along with whatever the rider
+// said about it. Tags are stored comma separated.
+func (store *Store) EndSession(id int64, endedAt time.Time, info SessionInfo) error {
+	sql := `UPDATE sessions SET ended_at = ?, sport = ?, tags = ?, note = ? WHERE id = ?`
+	_, err := store.conn.Exec(sql, endedAt.UTC(), info.Sport, strings.Join(info.Tags, ","), info.Note, id)
+	return err
+}
+
+func (store *Store) AddDevice(sessionId int64, address, alias string, info metrics.DeviceInfo) error {
+	sql := `
+INSERT INTO devices (session_id, address, alias, manufacturer, model, firmware, serial)
+VALUES (?, ?, ?, ?, ?, ?, ?)`
+
+	_, err := store.conn.Exec(sql, sessionId, address, alias,
+		info.Manufacturer, info.Model, info.Firmware, info.Serial)
+	return err
+}
+
+func (store *Store) AddSample(sessionId int64, s Sample) error {
+	sql := `
+INSERT INTO samples (session_id, ts, heart_rate, power, cadence, speed, distance, lap,
+  core_temperature, front_gear, rear_gear)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	_, err := store.conn.Exec(sql, sessionId, s.Time.UTC(),
+		s.HeartRate, s.Power, s.Cadence, s.Speed, s.Distance, s.Lap,
+		s.CoreTemperature, s.FrontGear, s.RearGear)
+	return err
+}
+
+func (store *Store) ListSessions() ([]StoredSession, error) {
+	sql := `
+SELECT s.id, s.sport, s.started_at, s.ended_at, COUNT(x.ts), s.tags, s.note
+FROM sessions s
+LEFT JOIN samples x ON x.session_id = s.id
+GROUP BY s.id
+ORDER BY s.started_at DESC`
+
+	rows, err := store.conn.Query(sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []StoredSession{}
+	for rows.Next() {
+		var s StoredSession
+		var tags string
+		if err := rows.Scan(&s.Id, &s.Sport, &s.StartedAt, &s.EndedAt, &s.Samples, &tags, &s.Note); err != nil {
+			return nil, err
+		}
+		s.Tags = ParseTags(tags)
+		sessions = append(sessions, s)
+	}
+
+	return sessions, rows.Err()
+}
+
+func (store *Store) GetSession(id int64) (StoredSession, error) {
+	var s StoredSession
+	var tags string
+
+	sql := `
+SELECT s.id, s.sport, s.started_at, s.ended_at, COUNT(x.ts), s.tags, s.note
+FROM sessions s
+LEFT JOIN samples x ON x.session_id = s.id
+WHERE s.id = ?
+GROUP BY s.id`
+
+	err := store.conn.QueryRow(sql, id).
+		Scan(&s.Id, &s.Sport, &s.StartedAt, &s.EndedAt, &s.Samples, &tags, &s.Note)
+	s.Tags = ParseTags(tags)
+	return s, err
+}
+
+func (store *Store) Samples(sessionId int64) ([]Sample, error) {
+	sql := `
+SELECT ts, heart_rate, power, cadence, speed, distance, lap, core_temperature, front_gear, rear_gear
+FROM samples
+WHERE session_id = ?
+ORDER BY ts`
+
+	rows, err := store.conn.Query(sql, sessionId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	samples := []Sample{}
+	for rows.Next() {
+		var s Sample
+		if err := rows.Scan(&s.Time, &s.HeartRate, &s.Power, &s.Cadence, &s.Speed, &s.Distance, &s.Lap,
+			&s.CoreTemperature, &s.FrontGear, &s.RearGear); err != nil {
+			return nil, err
+		}
+		samples = append(samples, s)
+	}
+
+	return samples, rows.Err()
+}
+
+// AddBodyMeasurement stores a reading from the weight scale at address,
+// timestamped now if the scale didn't say when it was taken.
+func (store *Store) AddBodyMeasurement(address string, m gatt.BodyMeasurement) error {
+	ts := m.Time
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+
+	sql := `
+INSERT INTO body_measurements (ts, address, weight, bmi, body_fat, muscle_mass)
+VALUES (?, ?, ?, ?, ?, ?)`
+
+	_, err := store.conn.Exec(sql, ts.UTC(), address, m.Weight, m.BMI, m.BodyFat, m.MuscleMass)
+	return err
+}
+
+// MarkLaps marks the samples at each of times as starting a lap, for laps
+// decided on after the samples were stored.
+func (store *Store) MarkLaps(sessionId int64, times []time.Time) error {
+	tx, err := store.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	sql := `UPDATE samples SET lap = 1 WHERE session_id = ? AND ts = ?`
+	for _, ts := range times {
+		if _, err := tx.Exec(sql, sessionId, ts.UTC()); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// AddCalibration records that the trainer at address was calibrated,
+// along with whatever it reported as the result.
+func (store *Store) AddCalibration(address string, ts time.Time, result string) error {
+	sql := `INSERT INTO calibrations (ts, address, result) VALUES (?, ?, ?)`
+
+	_, err := store.conn.Exec(sql, ts.UTC(), address, result)
+	return err
+}
+
+// LastCalibration is when the trainer at address was last calibrated, or
+// zero if it never has been.
+func (store *Store) LastCalibration(address string) (time.Time, error) {
+	query := `
+SELECT ts
+FROM calibrations
+WHERE address = ?
+ORDER BY ts DESC
+LIMIT 1`
+
+	var ts time.Time
+	err := store.conn.QueryRow(query, address).Scan(&ts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	return ts, err
+}
+
+func (store *Store) AddPowerCurve(sessionId int64, curve []metrics.PowerCurvePoint) error {
+	tx, err := store.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	sql := `INSERT INTO power_bests (session_id, duration, power) VALUES (?, ?, ?)`
+	for _, p := range curve {
+		if _, err := tx.Exec(sql, sessionId, int64(p.Duration/time.Second), p.Power); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// PowerCurve returns the power curve stored for a session, shortest
+// duration first.
+func (store *Store) PowerCurve(sessionId int64) ([]metrics.PowerCurvePoint, error) {
+	sql := `SELECT duration, power FROM power_bests WHERE session_id = ? ORDER BY duration`
+
+	rows, err := store.conn.Query(sql, sessionId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	curve := []metrics.PowerCurvePoint{}
+	for rows.Next() {
+		var p metrics.PowerCurvePoint
+		var seconds int64
+		if err := rows.Scan(&seconds, &p.Power); err != nil {
+			return nil, err
+		}
+		p.Duration = time.Duration(seconds) * time.Second
+		curve = append(curve, p)
+	}
+
+	return curve, rows.Err()
+}
+
+// PowerBests returns the best power stored for each duration, from any
+// session other than exceptSessionId.
+func (store *Store) PowerBests(exceptSessionId int64) ([]PowerBest, error) {
+	// SQLite takes the other columns from the row with the MAX.
+	sql := `
+SELECT b.duration, MAX(b.power), s.id, s.started_at
+FROM power_bests b
+JOIN sessions s ON s.id = b.session_id
+WHERE b.session_id != ?
+GROUP BY b.duration
+ORDER BY b.duration`
+
+	rows, err := store.conn.Query(sql, exceptSessionId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bests := []PowerBest{}
+	for rows.Next() {
+		var b PowerBest
+		var seconds int64
+		if err := rows.Scan(&seconds, &b.Power, &b.SessionId, &b.StartedAt); err != nil {
+			return nil, err
+		}
+		b.Duration = time.Duration(seconds) * time.Second
+		bests = append(bests, b)
+	}
+
+	return bests, rows.Err()
+}
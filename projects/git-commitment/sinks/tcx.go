@@ -0,0 +1,161 @@
+package sinks
+
+import (
+	"encoding/xml"
+	"io"
+	"os"
+	"time"
+)
+
+// Just enough of the Training Center XML schema to describe an activity.
+// Element order matters here, the schema uses xsd:sequence.
+//
+// https://www8.garmin.com/xmlschemas/TrainingCenterDatabasev2.xsd
+type tcxDatabase struct {
+	XMLName    xml.Name      `xml:"TrainingCenterDatabase"`
+	Xmlns      string        `xml:"xmlns,attr"`
+	XmlnsNs3   string        `xml:"xmlns:ns3,attr"`
+	Activities []tcxActivity `xml:"Activities>Activity"`
+}
+
+type tcxActivity struct {
+	Sport string   `xml:"Sport,attr"`
+	Id    string   `xml:"Id"`
+	Laps  []tcxLap `xml:"Lap"`
+	Notes string   `xml:"Notes,omitempty"`
+}
+
+type tcxLap struct {
+	StartTime        string          `xml:"StartTime,attr"`
+	TotalTimeSeconds float64         `xml:"TotalTimeSeconds"`
+	DistanceMeters   float64         `xml:"DistanceMeters"`
+	Calories         int             `xml:"Calories"`
+	Intensity        string          `xml:"Intensity"`
+	TriggerMethod    string          `xml:"TriggerMethod"`
+	Trackpoints      []tcxTrackpoint `xml:"Track>Trackpoint"`
+}
+
+type tcxTrackpoint struct {
+	Time           string         `xml:"Time"`
+	DistanceMeters float64        `xml:"DistanceMeters,omitempty"`
+	HeartRateBpm   *tcxValue      `xml:"HeartRateBpm,omitempty"`
+	Cadence        int            `xml:"Cadence,omitempty"`
+	Extensions     *tcxExtensions `xml:"Extensions,omitempty"`
+}
+
+type tcxValue struct {
+	Value int `xml:"Value"`
+}
+
+type tcxExtensions struct {
+	TPX tcxTPX `xml:"ns3:TPX"`
+}
+
+type tcxTPX struct {
+	// m/s
+	Speed float64 `xml:"ns3:Speed,omitempty"`
+	Watts int     `xml:"ns3:Watts,omitempty"`
+}
+
+const tcxTimeFormat = "2006-01-02T15:04:05Z"
+
+// EncodeTCX writes samples out as a TCX activity, starting a new lap at
+// each sample marked as one. The note and tags go in the activity's notes.
+func EncodeTCX(w io.Writer, samples []Sample, info SessionInfo) error {
+	laps := []tcxLap{}
+	start := 0
+	for i := range samples {
+		if i > start && samples[i].Lap {
+			laps = append(laps, encodeTCXLap(samples[start:i]))
+			start = i
+		}
+	}
+	laps = append(laps, encodeTCXLap(samples[start:]))
+
+	db := tcxDatabase{
+		Xmlns:    "http://www.garmin.com/xmlschemas/TrainingCenterDatabase/v2",
+		XmlnsNs3: "http://www.garmin.com/xmlschemas/ActivityExtension/v2",
+		Activities: []tcxActivity{{
+			Sport: info.Sport,
+			Id:    laps[0].StartTime,
+			Laps:  laps,
+			Notes: info.Description(),
+		}},
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(db)
+}
+
+// encodeTCXLap turns a lap's worth of samples into trackpoints. Distance
+// is cumulative over the whole activity, so the lap's distance is the
+// difference.
+func encodeTCXLap(samples []Sample) tcxLap {
+	lap := tcxLap{
+		Intensity:     "Active",
+		TriggerMethod: "Manual",
+		Trackpoints:   []tcxTrackpoint{},
+	}
+
+	if len(samples) > 0 {
+		first, last := samples[0], samples[len(samples)-1]
+
+		lap.StartTime = first.Time.UTC().Format(tcxTimeFormat)
+		// One sample per second, so this leaves out any time spent
+		// auto-paused.
+		lap.TotalTimeSeconds = float64(len(samples) - 1)
+		lap.DistanceMeters = last.Distance - first.Distance
+	}
+
+	for _, s := range samples {
+		tp := tcxTrackpoint{
+			Time:           s.Time.UTC().Format(tcxTimeFormat),
+			DistanceMeters: s.Distance,
+			Cadence:        int(s.Cadence),
+		}
+
+		if s.HeartRate > 0 {
+			tp.HeartRateBpm = &tcxValue{Value: int(s.HeartRate)}
+		}
+
+		if s.Power > 0 || s.Speed > 0 {
+			tp.Extensions = &tcxExtensions{
+				TPX: tcxTPX{
+					Speed: s.Speed / 3.6,
+					Watts: int(s.Power),
+				},
+			}
+		}
+
+		lap.Trackpoints = append(lap.Trackpoints, tp)
+	}
+
+	return lap
+}
+
+// WriteTCX writes everything recorded so far to a new TCX file at path.
+func (rec *Recorder) WriteTCX(path string) error {
+	samples := rec.Samples()
+
+	if len(samples) == 0 {
+		// Still want a valid file, so just use the current time.
+		samples = []Sample{{Time: time.Now()}}
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := EncodeTCX(f, samples, rec.Info()); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,470 @@
+package sinks
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/erik/git-commitment/metrics"
+	"github.com/gdamore/tcell/v2"
+)
+
+func init() {
+	Register("tui", func(opts Options) (Sink, error) {
+		return NewDashboard(opts.PowerZones, opts.HeartRateZones, opts.Units)
+	})
+}
+
+// Number of per-second samples kept for sparklines.
+const dashboardHistory = 120
+
+// Number of lines of captured output shown at the bottom of the screen.
+const dashboardLogLines = 6
+
+var (
+	sparklineChars = []rune("▁▂▃▄▅▆▇█")
+
+	powerZoneColors = []tcell.Color{
+		tcell.ColorGray,
+		tcell.ColorBlue,
+		tcell.ColorGreen,
+		tcell.ColorYellow,
+		tcell.ColorOrange,
+		tcell.ColorRed,
+		tcell.ColorPurple,
+	}
+	heartRateZoneColors = []tcell.Color{
+		tcell.ColorGray,
+		tcell.ColorBlue,
+		tcell.ColorGreen,
+		tcell.ColorOrange,
+		tcell.ColorRed,
+	}
+)
+
+// dashboardRow is a single line of the dashboard: a metric, its latest
+// value and a sparkline of recent history.
+type dashboardRow struct {
+	label string
+	units string
+	kinds []metrics.Kind
+	// From the metric's own units to the ones shown, nil to show as is.
+	convert func(float64) float64
+
+	zones  metrics.Zones
+	colors []tcell.Color
+}
+
+// Dashboard is a full-screen terminal UI showing live metrics, as an
+// alternative to printing every metric on its own line.
+type Dashboard struct {
+	mu sync.Mutex
+
+	screen tcell.Screen
+	rows   []dashboardRow
+
+	// Latest value and per-second history for each row
+	values  []float64
+	history [][]float64
+
+	// Device address -> connection status
+	devices map[string]string
+	log     []string
+
+	// While running, anything written to stdout is captured and shown in
+	// the log area rather than scribbling all over the screen.
+	stdout *os.File
+	closed bool
+	done   chan struct{}
+
+	// Shown in the header, see SetKeyHelp.
+	keyHelp string
+
+	// Set while asking for a line of text, see Prompt.
+	prompt     string
+	promptText []rune
+	promptDone func(string)
+
+	// Called with every key press other than quitting. Must be set before
+	// calling HandleEvents.
+	OnKey func(ev *tcell.EventKey)
+}
+
+func NewDashboard(powerZones, heartRateZones metrics.Zones, units metrics.Units) (*Dashboard, error) {
+	screen, err := tcell.NewScreen()
+	if err != nil {
+		return nil, err
+	}
+	if err := screen.Init(); err != nil {
+		return nil, err
+	}
+
+	rows := []dashboardRow{
+		{
+			label:  "Power",
+			units:  "W",
+			kinds:  []metrics.Kind{metrics.CyclingPower},
+			zones:  powerZones,
+			colors: powerZoneColors,
+		},
+		{
+			label:  "Heart rate",
+			units:  "bpm",
+			kinds:  []metrics.Kind{metrics.HeartRate},
+			zones:  heartRateZones,
+			colors: heartRateZoneColors,
+		},
+		{
+			label: "Cadence",
+			units: "rpm",
+			kinds: []metrics.Kind{metrics.CyclingCadence, metrics.RunningCadence},
+		},
+		{
+			label:   "Speed",
+			units:   units.SpeedUnit(),
+			kinds:   []metrics.Kind{metrics.CyclingSpeed},
+			convert: units.Speed,
+		},
+		{
+			label: "NP",
+			units: "W",
+			kinds: []metrics.Kind{metrics.NormalizedPower},
+		},
+	}
+
+	dash := &Dashboard{
+		screen:  screen,
+		rows:    rows,
+		values:  make([]float64, len(rows)),
+		history: make([][]float64, len(rows)),
+		devices: map[string]string{},
+		log:     []string{},
+		done:    make(chan struct{}),
+	}
+
+	if err := dash.captureStdout(); err != nil {
+		screen.Fini()
+		return nil, err
+	}
+
+	go dash.run()
+
+	return dash, nil
+}
+
+func (dash *Dashboard) captureStdout() error {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+
+	dash.stdout = os.Stdout
+	os.Stdout = w
+
+	go func() {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			dash.mu.Lock()
+			dash.log = append(dash.log, scanner.Text())
+			if len(dash.log) > dashboardLogLines {
+				dash.log = dash.log[len(dash.log)-dashboardLogLines:]
+			}
+			dash.mu.Unlock()
+		}
+	}()
+
+	return nil
+}
+
+// Close restores the terminal and stdout. Safe to call more than once.
+func (dash *Dashboard) Close() error {
+	dash.mu.Lock()
+	defer dash.mu.Unlock()
+
+	if dash.closed {
+		return nil
+	}
+
+	if dash.stdout != nil {
+		os.Stdout = dash.stdout
+		dash.stdout = nil
+	}
+	dash.closed = true
+	close(dash.done)
+	dash.screen.Fini()
+
+	return nil
+}
+
+// SetDeviceStatus updates the connection status shown for a device, e.g.
+// "connecting" or "connected".
+func (dash *Dashboard) SetDeviceStatus(addr, status string) {
+	dash.mu.Lock()
+	defer dash.mu.Unlock()
+
+	dash.devices[addr] = status
+}
+
+// SetKeyHelp sets a short description of the keys handled by OnKey, to
+// show alongside the title.
+func (dash *Dashboard) SetKeyHelp(help string) {
+	dash.mu.Lock()
+	defer dash.mu.Unlock()
+
+	dash.keyHelp = help
+}
+
+// Prompt asks for a line of text below the metrics, starting from
+// initial. Key presses go to the prompt rather than OnKey until it's
+// answered with enter, when done is called with the text, or cancelled
+// with escape.
+func (dash *Dashboard) Prompt(label, initial string, done func(string)) {
+	dash.mu.Lock()
+	defer dash.mu.Unlock()
+
+	dash.prompt = label
+	dash.promptText = []rune(initial)
+	dash.promptDone = done
+}
+
+// promptKey handles a key press while prompting, reporting whether there
+// was a prompt to handle it.
+func (dash *Dashboard) promptKey(ev *tcell.EventKey) bool {
+	dash.mu.Lock()
+	if dash.promptDone == nil {
+		dash.mu.Unlock()
+		return false
+	}
+
+	var done func(string)
+	text := string(dash.promptText)
+
+	switch ev.Key() {
+	case tcell.KeyEnter:
+		done = dash.promptDone
+		dash.promptDone = nil
+	case tcell.KeyEscape:
+		dash.promptDone = nil
+	case tcell.KeyBackspace, tcell.KeyBackspace2:
+		if n := len(dash.promptText); n > 0 {
+			dash.promptText = dash.promptText[:n-1]
+		}
+	case tcell.KeyRune:
+		dash.promptText = append(dash.promptText, ev.Rune())
+	}
+	dash.mu.Unlock()
+
+	if done != nil {
+		done(text)
+	}
+	return true
+}
+
+// Receive updates the latest value shown for the metric, if it's one we
+// display.
+func (dash *Dashboard) Receive(m metrics.Metric) {
+	dash.update(m)
+}
+
+// Flush redraws the screen immediately.
+func (dash *Dashboard) Flush() error {
+	dash.draw()
+	return nil
+}
+
+// run redraws the screen a few times a second until the dashboard is
+// closed.
+func (dash *Dashboard) run() {
+	redraw := time.NewTicker(250 * time.Millisecond)
+	defer redraw.Stop()
+
+	sample := time.NewTicker(1 * time.Second)
+	defer sample.Stop()
+
+	for {
+		select {
+		case <-dash.done:
+			return
+
+		case <-sample.C:
+			dash.mu.Lock()
+			for i, value := range dash.values {
+				dash.history[i] = append(dash.history[i], value)
+				if len(dash.history[i]) > dashboardHistory {
+					dash.history[i] = dash.history[i][1:]
+				}
+			}
+			dash.mu.Unlock()
+
+		case <-redraw.C:
+			dash.draw()
+		}
+	}
+}
+
+// HandleEvents processes key presses until the user quits, then restores
+// the terminal and sends ourselves an interrupt so the session is wrapped
+// up the same way as hitting ^C normally would.
+func (dash *Dashboard) HandleEvents() {
+	for {
+		switch ev := dash.screen.PollEvent().(type) {
+		case *tcell.EventResize:
+			dash.screen.Sync()
+
+		case *tcell.EventKey:
+			if ev.Key() != tcell.KeyCtrlC && dash.promptKey(ev) {
+				continue
+			}
+
+			if ev.Key() == tcell.KeyCtrlC || ev.Key() == tcell.KeyEscape || ev.Rune() == 'q' {
+				dash.Close()
+				syscall.Kill(os.Getpid(), syscall.SIGINT)
+				return
+			}
+
+			if dash.OnKey != nil {
+				dash.OnKey(ev)
+			}
+
+		case nil:
+			// Screen was finalized
+			return
+		}
+	}
+}
+
+func (dash *Dashboard) update(m metrics.Metric) {
+	dash.mu.Lock()
+	defer dash.mu.Unlock()
+
+	for i, row := range dash.rows {
+		for _, kind := range row.kinds {
+			if m.Kind != kind || m.Window != 0 {
+				continue
+			}
+
+			dash.values[i] = m.Value
+			if row.convert != nil {
+				dash.values[i] = row.convert(m.Value)
+			}
+		}
+	}
+}
+
+func (dash *Dashboard) draw() {
+	dash.mu.Lock()
+	defer dash.mu.Unlock()
+
+	if dash.closed {
+		return
+	}
+
+	s := dash.screen
+	s.Clear()
+
+	width, height := s.Size()
+	plain := tcell.StyleDefault
+	bold := plain.Bold(true)
+
+	y := 0
+	drawText(s, 0, y, bold, "git-commitment  (q to quit)")
+	if dash.keyHelp != "" {
+		drawText(s, 29, y, plain, dash.keyHelp)
+	}
+	y += 2
+
+	for i, row := range dash.rows {
+		style := bold
+		if row.zones.Len() > 0 && dash.values[i] > 0 {
+			zone := row.zones.Classify(dash.values[i])
+			if zone < len(row.colors) {
+				style = style.Foreground(row.colors[zone])
+			}
+		}
+
+		drawText(s, 0, y, plain, row.label)
+		drawText(s, 12, y, style, fmt.Sprintf("%7.1f", dash.values[i]))
+		drawText(s, 20, y, plain, row.units)
+
+		if sparkWidth := width - 26; sparkWidth > 0 {
+			drawText(s, 26, y, style, sparkline(dash.history[i], sparkWidth))
+		}
+		y++
+	}
+
+	y++
+	drawText(s, 0, y, bold, "Devices")
+	y++
+
+	addrs := make([]string, 0, len(dash.devices))
+	for addr := range dash.devices {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	for _, addr := range addrs {
+		drawText(s, 2, y, plain, fmt.Sprintf("%-40s %s", addr, dash.devices[addr]))
+		y++
+	}
+
+	if dash.promptDone != nil {
+		y++
+		drawText(s, 0, y, bold, dash.prompt+": ")
+		drawText(s, len(dash.prompt)+2, y, plain, string(dash.promptText)+"_")
+		y++
+	}
+
+	// Captured output goes at the bottom of the screen
+	logY := height - len(dash.log)
+	if logY <= y {
+		logY = y + 1
+	}
+	for i, line := range dash.log {
+		drawText(s, 0, logY+i, plain.Dim(true), line)
+	}
+
+	s.Show()
+}
+
+func drawText(s tcell.Screen, x, y int, style tcell.Style, text string) {
+	for _, r := range text {
+		s.SetContent(x, y, r, nil, style)
+		x++
+	}
+}
+
+// sparkline renders the most recent (up to width) values, scaled between
+// the min and max of what's shown.
+func sparkline(values []float64, width int) string {
+	if len(values) > width {
+		values = values[len(values)-width:]
+	}
+	if len(values) == 0 {
+		return ""
+	}
+
+	lo, hi := values[0], values[0]
+	for _, v := range values {
+		if v < lo {
+			lo = v
+		}
+		if v > hi {
+			hi = v
+		}
+	}
+
+	line := make([]rune, len(values))
+	for i, v := range values {
+		idx := 0
+		if hi > lo {
+			idx = int((v - lo) / (hi - lo) * float64(len(sparklineChars)-1))
+		}
+		line[i] = sparklineChars[idx]
+	}
+
+	return string(line)
+}
@@ -0,0 +1,130 @@
+package sinks
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/erik/git-commitment/metrics"
+)
+
+// DefaultUDPInterval is how often the latest values are broadcast.
+const DefaultUDPInterval = 1 * time.Second
+
+func init() {
+	Register("udp", func(opts Options) (Sink, error) {
+		if opts.UDPAddr == "" {
+			return nil, fmt.Errorf("no address given")
+		}
+		return NewUDPBroadcaster(opts.UDPAddr, opts.UDPInterval)
+	})
+}
+
+// udpMessage is what gets broadcast, e.g.
+//
+//	{"time": "...", "metrics": {"cycling_power": 250, "heart_rate": 142}}
+type udpMessage struct {
+	Time    time.Time          `json:"time"`
+	Metrics map[string]float64 `json:"metrics"`
+}
+
+// UDPBroadcaster sends the latest value of every metric as a single JSON
+// datagram every interval, so anything on the LAN can listen in without
+// having to connect to anything.
+type UDPBroadcaster struct {
+	conn     *net.UDPConn
+	interval time.Duration
+
+	mu     sync.Mutex
+	latest map[string]float64
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// NewUDPBroadcaster sends to addr, usually a broadcast address such as
+// "255.255.255.255:5005".
+func NewUDPBroadcaster(addr string, interval time.Duration) (*UDPBroadcaster, error) {
+	if interval <= 0 {
+		interval = DefaultUDPInterval
+	}
+
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &UDPBroadcaster{
+		conn:     conn,
+		interval: interval,
+		latest:   map[string]float64{},
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+
+	go b.run()
+	return b, nil
+}
+
+func (b *UDPBroadcaster) run() {
+	defer close(b.stopped)
+
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.done:
+			return
+
+		case <-ticker.C:
+			if err := b.Flush(); err != nil {
+				slog.Debug("udp: failed to broadcast", "err", err)
+			}
+		}
+	}
+}
+
+func (b *UDPBroadcaster) Receive(m metrics.Metric) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.latest[m.Name()] = m.Value
+}
+
+// Flush broadcasts the latest values straight away, if we have any.
+func (b *UDPBroadcaster) Flush() error {
+	b.mu.Lock()
+	msg := udpMessage{Time: time.Now(), Metrics: make(map[string]float64, len(b.latest))}
+	for name, value := range b.latest {
+		msg.Metrics[name] = value
+	}
+	b.mu.Unlock()
+
+	if len(msg.Metrics) == 0 {
+		return nil
+	}
+
+	buf, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	_, err = b.conn.Write(buf)
+	return err
+}
+
+func (b *UDPBroadcaster) Close() error {
+	close(b.done)
+	<-b.stopped
+
+	return b.conn.Close()
+}
@@ -0,0 +1,309 @@
+package sinks
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/erik/git-commitment/metrics"
+)
+
+// Webhooks which take longer than this are given up on.
+const webhookTimeout = 10 * time.Second
+
+const (
+	WebhookSessionStart = "session_start"
+	WebhookLap          = "lap"
+	WebhookSessionEnd   = "session_end"
+)
+
+func init() {
+	Register("webhook", func(opts Options) (Sink, error) {
+		if opts.WebhookURL == "" {
+			return nil, fmt.Errorf("no webhook URL given")
+		}
+		return NewWebhook(opts.WebhookURL), nil
+	})
+}
+
+// WebhookPayload is POSTed as JSON for each event, e.g.
+//
+//	{"event": "session_end", "text": "Just finished: 1h02, 203W NP", ...}
+//
+// Text is a one line description of the event, and also sent as content,
+// so Slack and Discord webhooks can be used as they are.
+type WebhookPayload struct {
+	Event   string      `json:"event"`
+	Time    time.Time   `json:"time"`
+	Text    string      `json:"text"`
+	Content string      `json:"content"`
+	Session SessionInfo `json:"session"`
+
+	// Only for lap events, the lap which just finished.
+	Lap *WebhookLapSummary `json:"lap,omitempty"`
+	// Only for session end events.
+	Summary *WebhookSummary `json:"summary,omitempty"`
+}
+
+// WebhookLapSummary averages a single lap. Zero means there were no
+// readings.
+type WebhookLapSummary struct {
+	// Counting from 1
+	Number int `json:"number"`
+	// Seconds
+	Duration float64 `json:"duration"`
+	// Meters
+	Distance  float64 `json:"distance"`
+	Power     float64 `json:"power"`
+	HeartRate float64 `json:"heart_rate"`
+	Cadence   float64 `json:"cadence"`
+}
+
+// WebhookSummary is the gist of metrics.SessionSummary. Zero means there
+// were no readings.
+type WebhookSummary struct {
+	// Seconds
+	Duration   float64 `json:"duration"`
+	MovingTime float64 `json:"moving_time"`
+
+	Power               float64 `json:"power"`
+	HeartRate           float64 `json:"heart_rate"`
+	NormalizedPower     float64 `json:"normalized_power"`
+	IntensityFactor     float64 `json:"intensity_factor"`
+	TrainingStressScore float64 `json:"training_stress_score"`
+	// Kilojoules and kilocalories
+	Work     float64 `json:"work"`
+	Calories float64 `json:"calories"`
+
+	Laps []WebhookLapSummary `json:"laps"`
+}
+
+// webhookAverage is a running average, leaving out zeros since they're
+// missing readings.
+type webhookAverage struct {
+	sum   float64
+	count int
+}
+
+func (a *webhookAverage) add(v float64) {
+	if v > 0 {
+		a.sum += v
+		a.count++
+	}
+}
+
+func (a *webhookAverage) value() float64 {
+	if a.count == 0 {
+		return 0
+	}
+	return a.sum / float64(a.count)
+}
+
+// Webhook POSTs JSON to a URL as a session starts, at each lap and when it
+// ends, see WebhookPayload. The caller has to say when each of these
+// happen; the metrics it receives only go towards the averages.
+type Webhook struct {
+	url    string
+	client *http.Client
+
+	mu   sync.Mutex
+	info SessionInfo
+
+	power, heartRate       webhookAverage
+	lapPower, lapHeartRate webhookAverage
+	lapCadence             webhookAverage
+	lapNumber              int
+	lapStart               time.Time
+	lapStartDistance       float64
+
+	// Closed once the last request queued has been sent. Each request
+	// waits on the one before, so events arrive in order.
+	last chan struct{}
+}
+
+func NewWebhook(url string) *Webhook {
+	return &Webhook{
+		url:    url,
+		client: &http.Client{Timeout: webhookTimeout},
+	}
+}
+
+func (wh *Webhook) Receive(m metrics.Metric) {
+	if m.Window != 0 {
+		return
+	}
+
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+
+	switch m.Kind {
+	case metrics.CyclingPower:
+		wh.power.add(m.Value)
+		wh.lapPower.add(m.Value)
+	case metrics.HeartRate:
+		wh.heartRate.add(m.Value)
+		wh.lapHeartRate.add(m.Value)
+	case metrics.CyclingCadence, metrics.RunningCadence:
+		wh.lapCadence.add(m.Value)
+	}
+}
+
+// Start sends the session start event.
+func (wh *Webhook) Start(info SessionInfo) {
+	wh.mu.Lock()
+	wh.info = info
+	wh.lapNumber = 1
+	wh.lapStart = time.Now()
+	wh.mu.Unlock()
+
+	wh.post(WebhookPayload{Event: WebhookSessionStart, Text: "Started a session", Session: info}, false)
+}
+
+// Lap sends a lap event for the lap which just finished, given the
+// recorder's sample which starts the next one.
+func (wh *Webhook) Lap(sample Sample) {
+	wh.mu.Lock()
+	duration := sample.Time.Sub(wh.lapStart)
+	lap := WebhookLapSummary{
+		Number:    wh.lapNumber,
+		Duration:  duration.Seconds(),
+		Distance:  sample.Distance - wh.lapStartDistance,
+		Power:     wh.lapPower.value(),
+		HeartRate: wh.lapHeartRate.value(),
+		Cadence:   wh.lapCadence.value(),
+	}
+	info := wh.info
+
+	wh.lapNumber++
+	wh.lapStart = sample.Time
+	wh.lapStartDistance = sample.Distance
+	wh.lapPower, wh.lapHeartRate, wh.lapCadence = webhookAverage{}, webhookAverage{}, webhookAverage{}
+	wh.mu.Unlock()
+
+	text := fmt.Sprintf("Lap %d: %s", lap.Number, webhookDuration(duration))
+	if lap.Power > 0 {
+		text += fmt.Sprintf(", %.0fW", lap.Power)
+	}
+	if lap.HeartRate > 0 {
+		text += fmt.Sprintf(", %.0fbpm", lap.HeartRate)
+	}
+
+	wh.post(WebhookPayload{Event: WebhookLap, Text: text, Session: info, Lap: &lap}, false)
+}
+
+// End sends the session end event, waiting for it to go through. Unlike
+// the other events it can be sent after Close, since the summary is only
+// ready once every sink is done.
+func (wh *Webhook) End(summary metrics.SessionSummary, info SessionInfo) {
+	wh.mu.Lock()
+	s := WebhookSummary{
+		Duration:            summary.Duration.Seconds(),
+		MovingTime:          (summary.Duration - summary.Paused).Seconds(),
+		Power:               wh.power.value(),
+		HeartRate:           wh.heartRate.value(),
+		NormalizedPower:     summary.NormalizedPower,
+		IntensityFactor:     summary.IntensityFactor,
+		TrainingStressScore: summary.TrainingStressScore,
+		Work:                summary.Work,
+		Calories:            summary.Calories,
+		Laps:                []WebhookLapSummary{},
+	}
+	wh.mu.Unlock()
+
+	for i, lap := range summary.Laps {
+		s.Laps = append(s.Laps, WebhookLapSummary{
+			Number:    i + 1,
+			Duration:  lap.Duration.Seconds(),
+			Distance:  lap.Distance,
+			Power:     lap.Power,
+			HeartRate: lap.HeartRate,
+			Cadence:   lap.Cadence,
+		})
+	}
+
+	text := "Just finished: " + webhookDuration(summary.Duration-summary.Paused)
+	switch {
+	case s.NormalizedPower > 0:
+		text += fmt.Sprintf(", %.0fW NP", s.NormalizedPower)
+	case s.Power > 0:
+		text += fmt.Sprintf(", %.0fW", s.Power)
+	}
+	if s.HeartRate > 0 {
+		text += fmt.Sprintf(", %.0fbpm", s.HeartRate)
+	}
+	if s.TrainingStressScore > 0 {
+		text += fmt.Sprintf(", %.0f TSS", s.TrainingStressScore)
+	}
+
+	wh.post(WebhookPayload{Event: WebhookSessionEnd, Text: text, Session: info, Summary: &s}, true)
+}
+
+// webhookDuration is a short human duration, e.g. "1h02" or "45m".
+func webhookDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	if d < time.Hour {
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	}
+	return fmt.Sprintf("%dh%02d", int(d.Hours()), int(d.Minutes())%60)
+}
+
+// post sends the payload after any before it, in the background unless
+// wait is set so as not to hold anything up on some server.
+func (wh *Webhook) post(payload WebhookPayload, wait bool) {
+	payload.Time = time.Now()
+	payload.Content = payload.Text
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		slog.Warn("failed to encode webhook", "event", payload.Event, "err", err)
+		return
+	}
+
+	send := func() {
+		resp, err := wh.client.Post(wh.url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			slog.Warn("failed to send webhook", "event", payload.Event, "err", err)
+			return
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode/100 != 2 {
+			slog.Warn("webhook failed", "event", payload.Event, "status", resp.Status)
+		}
+	}
+
+	wh.mu.Lock()
+	prev, done := wh.last, make(chan struct{})
+	wh.last = done
+	wh.mu.Unlock()
+
+	go func() {
+		defer close(done)
+		if prev != nil {
+			<-prev
+		}
+		send()
+	}()
+
+	if wait {
+		<-done
+	}
+}
+
+func (wh *Webhook) Flush() error { return nil }
+
+// Close waits for any webhooks still being sent.
+func (wh *Webhook) Close() error {
+	wh.mu.Lock()
+	last := wh.last
+	wh.mu.Unlock()
+
+	if last != nil {
+		<-last
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+package sinks
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/erik/git-commitment/metrics"
+)
+
+// DefaultWLEDPort is where WLED listens for realtime UDP.
+const DefaultWLEDPort = "21324"
+
+const (
+	// How often the color is sent over UDP. WLED goes back to whatever it
+	// was showing once wledUDPTimeout passes without hearing from us.
+	wledUDPInterval = 1 * time.Second
+	wledUDPTimeout  = 5
+	// Most LEDs a single DRGB packet can set. WLED ignores any past the
+	// end of the strip, so we always send this many.
+	wledUDPLEDs = 490
+
+	// Over HTTP the color is only sent when it changes, and every so often
+	// in case WLED restarted.
+	wledHTTPRefresh = 30 * time.Second
+)
+
+var (
+	// Same as the dashboard, near enough.
+	wledPowerColors = []wledColor{
+		{0x80, 0x80, 0x80},
+		{0x00, 0x00, 0xff},
+		{0x00, 0xff, 0x00},
+		{0xff, 0xc0, 0x00},
+		{0xff, 0x60, 0x00},
+		{0xff, 0x00, 0x00},
+		{0xa0, 0x00, 0xff},
+	}
+	wledHeartRateColors = []wledColor{
+		{0x80, 0x80, 0x80},
+		{0x00, 0x00, 0xff},
+		{0x00, 0xff, 0x00},
+		{0xff, 0x60, 0x00},
+		{0xff, 0x00, 0x00},
+	}
+)
+
+func init() {
+	Register("wled", func(opts Options) (Sink, error) {
+		if opts.WLEDURL == "" {
+			return nil, fmt.Errorf("no WLED address given")
+		}
+
+		kind, colors := metrics.PowerZone, wledPowerColors
+		switch opts.WLEDZone {
+		case "", "power":
+		case "hr":
+			if opts.HeartRateZones.Len() == 0 {
+				return nil, fmt.Errorf("heart rate zones need a threshold or max heart rate")
+			}
+			kind, colors = metrics.HeartRateZone, wledHeartRateColors
+		default:
+			return nil, fmt.Errorf("unknown zone %q, must be power or hr", opts.WLEDZone)
+		}
+
+		if len(opts.WLEDColors) > 0 {
+			var err error
+			if colors, err = parseWLEDColors(opts.WLEDColors); err != nil {
+				return nil, err
+			}
+		}
+
+		return NewWLEDLights(opts.WLEDURL, kind, colors)
+	})
+}
+
+type wledColor [3]uint8
+
+// parseWLEDColors parses hex colors such as "#ff8000", one per zone.
+func parseWLEDColors(colors []string) ([]wledColor, error) {
+	parsed := make([]wledColor, len(colors))
+	for i, c := range colors {
+		b, err := hex.DecodeString(strings.TrimPrefix(c, "#"))
+		if err != nil || len(b) != 3 {
+			return nil, fmt.Errorf("bad color %q, should be like #ff8000", c)
+		}
+		copy(parsed[i][:], b)
+	}
+	return parsed, nil
+}
+
+// WLEDLights sets a WLED LED strip to a color for the current power or
+// heart rate zone, so the zone can be seen out of the corner of an eye
+// without a screen.
+//
+// Over HTTP the whole strip is set to a solid color through the JSON API,
+// and stays that way after we stop. Over UDP the color is streamed with
+// the realtime DRGB protocol, and WLED goes back to its own effects a few
+// seconds after we stop.
+type WLEDLights struct {
+	send    func(c wledColor) error
+	refresh time.Duration
+	// Nil over HTTP.
+	conn *net.UDPConn
+
+	kind metrics.Kind
+	// One per zone, zones past the end use the last one.
+	colors []wledColor
+
+	mu    sync.Mutex
+	color wledColor
+	// Set once we've seen the zone
+	ok bool
+
+	changed chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// NewWLEDLights talks to the WLED controller at addr, either a host
+// (for HTTP), an http:// URL or udp://host[:port] for realtime UDP. Zones
+// come from metrics of kind, either PowerZone or HeartRateZone.
+func NewWLEDLights(addr string, kind metrics.Kind, colors []wledColor) (*WLEDLights, error) {
+	if len(colors) == 0 {
+		return nil, fmt.Errorf("no zone colors given")
+	}
+
+	l := &WLEDLights{
+		kind:    kind,
+		colors:  colors,
+		changed: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		client := &http.Client{Timeout: 5 * time.Second}
+		endpoint := u.JoinPath("json", "state").String()
+
+		l.refresh = wledHTTPRefresh
+		l.send = func(c wledColor) error { return wledPost(client, endpoint, c) }
+
+	case "udp":
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), DefaultWLEDPort)
+		}
+
+		raddr, err := net.ResolveUDPAddr("udp", host)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := net.DialUDP("udp", nil, raddr)
+		if err != nil {
+			return nil, err
+		}
+
+		l.conn = conn
+		l.refresh = wledUDPInterval
+		l.send = func(c wledColor) error {
+			_, err := conn.Write(wledDRGB(c))
+			return err
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q, only http:// and udp:// are", u.Scheme)
+	}
+
+	go l.run()
+	return l, nil
+}
+
+// wledPost sets every segment to a solid color. Colors are spelled out as
+// ints, since a []uint8 would be encoded as base64.
+func wledPost(client *http.Client, endpoint string, c wledColor) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"on": true,
+		"seg": map[string]interface{}{
+			"fx":  0,
+			"col": [][]int{{int(c[0]), int(c[1]), int(c[2])}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Post(endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
+
+// wledDRGB is a realtime packet setting every LED to c.
+func wledDRGB(c wledColor) []byte {
+	buf := []byte{2, wledUDPTimeout}
+	for i := 0; i < wledUDPLEDs; i++ {
+		buf = append(buf, c[:]...)
+	}
+	return buf
+}
+
+func (l *WLEDLights) run() {
+	defer close(l.stopped)
+
+	ticker := time.NewTicker(wledUDPInterval)
+	defer ticker.Stop()
+
+	var sent wledColor
+	var sentAt time.Time
+
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-l.changed:
+		case <-ticker.C:
+		}
+
+		l.mu.Lock()
+		color, ok := l.color, l.ok
+		l.mu.Unlock()
+
+		if !ok || (color == sent && time.Since(sentAt) < l.refresh) {
+			continue
+		}
+
+		if err := l.send(color); err != nil {
+			slog.Debug("wled: failed to set color", "err", err)
+			continue
+		}
+		sent, sentAt = color, time.Now()
+	}
+}
+
+func (l *WLEDLights) Receive(m metrics.Metric) {
+	if m.Kind != l.kind {
+		return
+	}
+
+	// Zones are numbered from 1.
+	zone := min(max(int(m.Value)-1, 0), len(l.colors)-1)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.ok && l.color == l.colors[zone] {
+		return
+	}
+	l.color, l.ok = l.colors[zone], true
+
+	select {
+	case l.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (l *WLEDLights) Flush() error { return nil }
+
+func (l *WLEDLights) Close() error {
+	close(l.done)
+	<-l.stopped
+
+	if l.conn != nil {
+		return l.conn.Close()
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/erik/git-commitment/metrics"
+	"github.com/erik/git-commitment/sinks"
+	"github.com/erik/git-commitment/upload"
+)
+
+// uploadToIntervals sends the recorded session to intervals.icu as a TCX
+// file, with the rider's note and tags and the NP/IF/TSS we computed in the
+// description.
+func uploadToIntervals(recorder *sinks.Recorder, name string, summary metrics.SessionSummary) error {
+	if recorder == nil {
+		return errors.New("the recorder sink isn't enabled")
+	}
+
+	samples := recorder.Samples()
+	if len(samples) == 0 {
+		return errors.New("nothing was recorded")
+	}
+
+	info := recorder.Info()
+
+	var tcx bytes.Buffer
+	if err := sinks.EncodeTCX(&tcx, samples, info); err != nil {
+		return err
+	}
+
+	description := info.Description()
+	if summary.NormalizedPower > 0 {
+		description = strings.TrimSpace(description + "\n\n" + summary.PowerLine())
+	}
+
+	start := samples[0].Time
+	client := upload.NewIntervalsICU(flagIntervalsAthlete, flagIntervalsKey)
+	id, err := client.Upload(upload.Activity{
+		Name:        name,
+		Description: description,
+		ExternalId:  fmt.Sprintf("git-commitment-%d", start.Unix()),
+		Filename:    start.Format("2006-01-02-150405") + ".tcx",
+		File:        &tcx,
+	})
+	if err != nil {
+		return err
+	}
+
+	slog.Info("uploaded to intervals.icu", "id", id)
+	return nil
+}
@@ -0,0 +1,156 @@
+// Package upload sends finished sessions to training platforms.
+package upload
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// IntervalsBaseURL is where the intervals.icu API lives.
+const IntervalsBaseURL = "https://intervals.icu/api/v1"
+
+// IntervalsDefaultAthlete means whichever athlete the API key belongs to.
+const IntervalsDefaultAthlete = "0"
+
+// Activity is what to upload, along with the activity file itself.
+type Activity struct {
+	Name        string
+	Description string
+	// Lets the platform spot an activity that was already uploaded.
+	ExternalId string
+
+	// Name of the activity file, the extension tells the platform its
+	// format (e.g. session.tcx).
+	Filename string
+	File     io.Reader
+}
+
+// IntervalsICU uploads activities to intervals.icu, using the API key from
+// the athlete's developer settings.
+type IntervalsICU struct {
+	athlete string
+	apiKey  string
+
+	BaseURL string
+	client  *http.Client
+}
+
+func NewIntervalsICU(athlete, apiKey string) *IntervalsICU {
+	if athlete == "" {
+		athlete = IntervalsDefaultAthlete
+	}
+
+	return &IntervalsICU{
+		athlete: athlete,
+		apiKey:  apiKey,
+		BaseURL: IntervalsBaseURL,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Upload creates a new activity, returning its id. intervals.icu works out
+// the rest (NP, load, zones) from the file itself.
+func (c *IntervalsICU) Upload(activity Activity) (string, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+
+	part, err := form.CreateFormFile("file", activity.Filename)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(part, activity.File); err != nil {
+		return "", err
+	}
+	if err := form.Close(); err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	if activity.Name != "" {
+		query.Set("name", activity.Name)
+	}
+	if activity.Description != "" {
+		query.Set("description", activity.Description)
+	}
+	if activity.ExternalId != "" {
+		query.Set("external_id", activity.ExternalId)
+	}
+
+	endpoint := fmt.Sprintf("%s/athlete/%s/activities", c.BaseURL, url.PathEscape(c.athlete))
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, &body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.SetBasicAuth("API_KEY", c.apiKey)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("intervals.icu upload failed: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	var created struct {
+		Id string `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return "", fmt.Errorf("bad response from intervals.icu: %w", err)
+	}
+
+	return created.Id, nil
+}
+
+// Wellness is a day's body measurements for intervals.icu. Zero values are
+// left out, rather than clearing whatever was there.
+type Wellness struct {
+	// Kilograms
+	Weight float64 `json:"weight,omitempty"`
+	// Percent
+	BodyFat float64 `json:"bodyFat,omitempty"`
+}
+
+// UpdateWellness sets the athlete's wellness values for the day of date.
+func (c *IntervalsICU) UpdateWellness(date time.Time, wellness Wellness) error {
+	body, err := json.Marshal(wellness)
+	if err != nil {
+		return err
+	}
+
+	endpoint := fmt.Sprintf("%s/athlete/%s/wellness/%s",
+		c.BaseURL, url.PathEscape(c.athlete), date.Format("2006-01-02"))
+
+	req, err := http.NewRequest(http.MethodPut, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth("API_KEY", c.apiKey)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("intervals.icu wellness update failed: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	return nil
+}
@@ -0,0 +1,619 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/erik/git-commitment/metrics"
+	"github.com/erik/git-commitment/sinks"
+)
+
+// WorkoutStep is a single block of a structured workout. Any of the
+// targets may be zero, meaning there is no target for that metric.
+//
+// Power and pace are sent to the trainer or treadmill. A heart rate
+// target without a power target is held by adjusting ERG power. Cadence
+// can't be forced, so the rider is told when they're off it.
+type WorkoutStep struct {
+	Name     string
+	Duration time.Duration
+
+	// Watts. For ramps, the target goes linearly from Power to PowerEnd
+	// over the course of the step. Either end can be zero.
+	Power    float64
+	PowerEnd float64
+	Ramp     bool
+	// BPM
+	HeartRate float64
+	// RPM
+	Cadence float64
+
+	// Time per km, for running on a treadmill, which is sent the speed
+	// to match. Incline is in percent.
+	Pace    time.Duration
+	Incline float64
+}
+
+type Workout struct {
+	Name  string
+	Steps []WorkoutStep
+}
+
+// Running is whether this is a treadmill workout, i.e. any of the steps
+// have a pace target.
+func (w *Workout) Running() bool {
+	for _, step := range w.Steps {
+		if step.Pace > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Speed is the pace target as km/h, or 0 without one.
+func (step *WorkoutStep) Speed() float64 {
+	if step.Pace <= 0 {
+		return 0
+	}
+	return float64(time.Hour) / float64(step.Pace)
+}
+
+// parsePace parses a time per km, e.g. "5:30".
+func parsePace(s string) (time.Duration, error) {
+	minutes, seconds, ok := strings.Cut(s, ":")
+	if !ok {
+		return 0, fmt.Errorf("bad pace %q, expected minutes:seconds per km", s)
+	}
+
+	d, err := time.ParseDuration(minutes + "m" + seconds + "s")
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("bad pace %q, expected minutes:seconds per km", s)
+	}
+
+	return d, nil
+}
+
+// formatPace is the opposite of parsePace.
+func formatPace(d time.Duration) string {
+	d = d.Round(time.Second)
+	return fmt.Sprintf("%d:%02d", d/time.Minute, d%time.Minute/time.Second)
+}
+
+// PowerAt returns the power target at a given offset into the step.
+func (step *WorkoutStep) PowerAt(elapsed time.Duration) float64 {
+	if !step.Ramp || step.Duration == 0 {
+		return step.Power
+	}
+
+	frac := math.Max(0, math.Min(1, float64(elapsed)/float64(step.Duration)))
+
+	return step.Power + (step.PowerEnd-step.Power)*frac
+}
+
+// Spoken describes the step the way it'd be read out at the start of it,
+// e.g. "Intervals, 250 watts for 5 minutes".
+func (step *WorkoutStep) Spoken() string {
+	parts := []string{}
+	if step.Name != "" {
+		parts = append(parts, step.Name)
+	}
+
+	target := ""
+	switch {
+	case step.Ramp:
+		target = fmt.Sprintf("%.0f to %.0f watts", step.Power, step.PowerEnd)
+	case step.Power > 0:
+		target = fmt.Sprintf("%.0f watts", step.Power)
+	case step.Pace > 0:
+		target = formatPace(step.Pace) + " per kilometer"
+		if step.Incline != 0 {
+			target += fmt.Sprintf(" at %g percent incline", step.Incline)
+		}
+	case step.HeartRate > 0:
+		target = fmt.Sprintf("heart rate %.0f", step.HeartRate)
+	default:
+		target = "free ride"
+	}
+
+	if step.Cadence > 0 {
+		target += fmt.Sprintf(" at %.0f RPM", step.Cadence)
+	}
+	if d := step.Duration.Round(time.Second); d > 0 {
+		target += " for " + spokenDuration(d)
+	}
+
+	return strings.Join(append(parts, target), ", ")
+}
+
+func spokenDuration(d time.Duration) string {
+	plural := func(n time.Duration, unit string) string {
+		if n == 1 {
+			return "1 " + unit
+		}
+		return fmt.Sprintf("%d %ss", n, unit)
+	}
+
+	minutes, seconds := d/time.Minute, d%time.Minute/time.Second
+	switch {
+	case minutes == 0:
+		return plural(seconds, "second")
+	case seconds == 0:
+		return plural(minutes, "minute")
+	default:
+		return plural(minutes, "minute") + " " + plural(seconds, "second")
+	}
+}
+
+func (w *Workout) Duration() time.Duration {
+	var total time.Duration
+	for _, step := range w.Steps {
+		total += step.Duration
+	}
+	return total
+}
+
+// JSON representation of a workout, for example:
+//
+//	{
+//	  "name": "Sweet spot",
+//	  "steps": [
+//	    {"name": "Warmup", "seconds": 600, "power": 150},
+//	    {"seconds": 1200, "power": 250, "cadence": 90},
+//	    {"name": "Cooldown", "seconds": 300, "power": 120}
+//	  ]
+//	}
+//
+// or for a treadmill, with pace per km and incline in percent:
+//
+//	{
+//	  "name": "Tempo run",
+//	  "steps": [
+//	    {"name": "Warmup", "seconds": 600, "pace": "6:30"},
+//	    {"seconds": 1200, "pace": "4:50", "incline": 1},
+//	    {"name": "Cooldown", "seconds": 300, "pace": "7:00"}
+//	  ]
+//	}
+type jsonWorkout struct {
+	Name  string `json:"name"`
+	Steps []struct {
+		Name      string  `json:"name"`
+		Seconds   int     `json:"seconds"`
+		Power     float64 `json:"power"`
+		HeartRate float64 `json:"heart_rate"`
+		Cadence   float64 `json:"cadence"`
+		Pace      string  `json:"pace"`
+		Incline   float64 `json:"incline"`
+	} `json:"steps"`
+}
+
+// LoadWorkout reads a workout file, picking the format based on the file
+// extension. Formats which describe power relative to FTP are scaled by the
+// given ftp.
+func LoadWorkout(path string, ftp float64) (*Workout, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		var parsed jsonWorkout
+		if err := json.NewDecoder(f).Decode(&parsed); err != nil {
+			return nil, err
+		}
+
+		workout := &Workout{Name: parsed.Name}
+		for _, s := range parsed.Steps {
+			step := WorkoutStep{
+				Name:      s.Name,
+				Duration:  time.Duration(s.Seconds) * time.Second,
+				Power:     s.Power,
+				HeartRate: s.HeartRate,
+				Cadence:   s.Cadence,
+				Incline:   s.Incline,
+			}
+
+			if s.Pace != "" {
+				pace, err := parsePace(s.Pace)
+				if err != nil {
+					return nil, err
+				}
+				step.Pace = pace
+			}
+
+			workout.Steps = append(workout.Steps, step)
+		}
+
+		// Heart rate is held through ERG power, which treadmills don't
+		// have.
+		if workout.Running() {
+			for i, step := range workout.Steps {
+				if step.HeartRate > 0 && step.Pace == 0 {
+					return nil, fmt.Errorf("step %d: heart rate targets can't be held on a treadmill, give a pace instead", i+1)
+				}
+			}
+		}
+
+		return workout, nil
+
+	case ".zwo":
+		return parseZwiftWorkout(f, ftp)
+
+	case ".erg", ".mrc":
+		return parseErgWorkout(f, ftp)
+
+	default:
+		return nil, fmt.Errorf("unsupported workout format: %q", ext)
+	}
+}
+
+// WorkoutProgress is sent once per second while a workout is running.
+type WorkoutProgress struct {
+	Step      int
+	StepCount int
+	StepName  string
+
+	StepRemaining time.Duration
+	Remaining     time.Duration
+
+	Target WorkoutStep
+	// Most recent values we've seen for each metric
+	ActualPower     float64
+	ActualHeartRate float64
+	ActualCadence   float64
+	ActualPace      time.Duration
+
+	// Set while the power target is backed off by stall protection.
+	Stalled bool
+	// Set when the rider should speed up (positive) or slow down
+	// (negative) to get back to the cadence target.
+	CadencePrompt float64
+
+	Done bool
+}
+
+// Live is the progress as shown by the live server.
+func (p WorkoutProgress) Live() sinks.LiveWorkout {
+	return sinks.LiveWorkout{
+		Step:            min(p.Step+1, p.StepCount),
+		StepCount:       p.StepCount,
+		StepName:        p.StepName,
+		StepRemaining:   p.StepRemaining.Seconds(),
+		Remaining:       p.Remaining.Seconds(),
+		TargetPower:     p.Target.Power,
+		TargetHeartRate: p.Target.HeartRate,
+		TargetCadence:   p.Target.Cadence,
+		TargetPace:      p.Target.Pace.Seconds(),
+		Stalled:         p.Stalled,
+		Done:            p.Done,
+	}
+}
+
+// StallProtection backs off the ERG target when cadence collapses, since
+// the trainer holding power at ever lower cadence means ever more torque,
+// until the rider grinds to a halt.
+type StallProtection struct {
+	// Cadence (RPM) below which we're stalling, zero to disable.
+	Cadence float64
+	// Cadence to get back up to before ramping back to the target.
+	RecoverCadence float64
+	// Fraction of the target to drop to while stalled.
+	Reduction float64
+	// How long to take ramping back up to the target.
+	Ramp time.Duration
+}
+
+var DefaultStallProtection = StallProtection{
+	Cadence:        50,
+	RecoverCadence: 70,
+	Reduction:      0.5,
+	Ramp:           10 * time.Second,
+}
+
+// Cadence readings older than this count as zero, since sensors tend to
+// just stop sending when the cranks stop.
+const stallCadenceMaxAge = 3 * time.Second
+
+// stallState tracks whether stall protection has kicked in.
+type stallState struct {
+	stalled bool
+	// Set once cadence has recovered, while ramping back up.
+	recovered time.Time
+}
+
+// adjust returns the power to actually ask for, given the step's target.
+func (s *stallState) adjust(p StallProtection, target, cadence float64, cadenceAt, now time.Time) float64 {
+	// Without a cadence sensor there's no telling.
+	if p.Cadence <= 0 || cadenceAt.IsZero() {
+		return target
+	}
+	if now.Sub(cadenceAt) > stallCadenceMaxAge {
+		cadence = 0
+	}
+
+	reduced := target * p.Reduction
+
+	switch {
+	case cadence < p.Cadence:
+		if !s.stalled {
+			slog.Info("cadence dropped, backing off", "cadence", cadence, "power", reduced)
+		}
+		s.stalled = true
+		s.recovered = time.Time{}
+		return reduced
+
+	case !s.stalled:
+		return target
+
+	case cadence < p.RecoverCadence && s.recovered.IsZero():
+		return reduced
+
+	case s.recovered.IsZero():
+		s.recovered = now
+	}
+
+	frac := float64(now.Sub(s.recovered)) / float64(p.Ramp)
+	if p.Ramp <= 0 || frac >= 1 {
+		s.stalled = false
+		s.recovered = time.Time{}
+		return target
+	}
+
+	return reduced + (target-reduced)*frac
+}
+
+// Heart rate targets are held to within this many BPM either way.
+const workoutHeartRateTolerance = 5.0
+
+// The rider is told to get back to a cadence target once they've been more
+// than cadenceTolerance (RPM) off it for cadencePromptAfter, and again every
+// cadencePromptAfter while they still are.
+const (
+	cadenceTolerance   = 5.0
+	cadencePromptAfter = 15 * time.Second
+)
+
+// WorkoutRunner steps through a workout, setting trainer targets through
+// the control channel as each step begins.
+type WorkoutRunner struct {
+	workout  *Workout
+	commands chan<- ControlCommand
+	progress chan<- WorkoutProgress
+
+	// Applied to every step with a power target. Set before calling Run.
+	StallProtection StallProtection
+	// For steps with only a heart rate target, held as -target-hr does.
+	// Set before calling Run.
+	HeartRateLag time.Duration
+	FTP          float64
+
+	// Step length changes from Skip and Extend.
+	changes chan time.Duration
+}
+
+func NewWorkoutRunner(
+	workout *Workout,
+	commands chan<- ControlCommand,
+	progress chan<- WorkoutProgress,
+) *WorkoutRunner {
+	return &WorkoutRunner{
+		workout:  workout,
+		commands: commands,
+		progress: progress,
+
+		StallProtection: DefaultStallProtection,
+		HeartRateLag:    DefaultHeartRateLag,
+		FTP:             200,
+		changes:         make(chan time.Duration, 4),
+	}
+}
+
+// Skip ends the current step early.
+func (r *WorkoutRunner) Skip() {
+	r.change(-1)
+}
+
+// Extend adds d to the current step.
+func (r *WorkoutRunner) Extend(d time.Duration) {
+	r.change(d)
+}
+
+// change queues a change to the length of the current step, negative to
+// skip it. Dropped if the runner isn't keeping up, or the workout is over.
+func (r *WorkoutRunner) change(d time.Duration) {
+	select {
+	case r.changes <- d:
+	default:
+	}
+}
+
+// Run consumes metrics to track actual values against the workout targets.
+// The workout clock doesn't start until the first metric arrives, so we
+// don't burn through the warmup while sensors are still connecting.
+func (r *WorkoutRunner) Run(in <-chan metrics.Metric) {
+	var actual WorkoutProgress
+	var cadenceAt time.Time
+	// Set during steps with only a heart rate target.
+	var heartRate *HeartRateController
+
+	update := func(m metrics.Metric) {
+		if heartRate != nil {
+			heartRate.Receive(m)
+		}
+
+		switch m.Kind {
+		case metrics.CyclingPower:
+			actual.ActualPower = m.Value
+		case metrics.HeartRate:
+			actual.ActualHeartRate = m.Value
+		case metrics.CyclingCadence:
+			actual.ActualCadence = m.Value
+			cadenceAt = m.Timestamp
+		case metrics.RunningPace:
+			actual.ActualPace = time.Duration(m.Value * float64(time.Second))
+		}
+	}
+
+	m, ok := <-in
+	if !ok {
+		return
+	}
+	update(m)
+
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	start := time.Now()
+
+	step := -1
+	stepStart, stepEnd := start, start
+	lastPower := 0
+	stall := stallState{}
+	// When cadence first went off target, zero while it's on.
+	var cadenceOff time.Time
+	// How much longer (or shorter) than planned the workout is running.
+	shift := time.Duration(0)
+
+	setPower := func(watts float64) {
+		if int(watts) == lastPower {
+			return
+		}
+
+		lastPower = int(watts)
+		r.commands <- ControlCommand{kind: ControlTargetPower, value: watts}
+	}
+
+	for {
+		select {
+		case m, ok := <-in:
+			if !ok {
+				return
+			}
+			update(m)
+			continue
+
+		case d := <-r.changes:
+			if step < 0 || step >= len(r.workout.Steps) {
+				continue
+			}
+
+			now := time.Now()
+			if d < 0 {
+				// Starts the next step on the next tick.
+				d = now.Sub(stepEnd)
+			}
+			stepEnd = stepEnd.Add(d)
+			shift += d
+			continue
+
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+
+		// Might need to skip over more than one step if any are very
+		// short.
+		for step < len(r.workout.Steps) && !now.Before(stepEnd) {
+			step++
+			if step >= len(r.workout.Steps) {
+				break
+			}
+
+			next := r.workout.Steps[step]
+			stepStart, stepEnd = stepEnd, stepEnd.Add(next.Duration)
+			heartRate = nil
+			cadenceOff = time.Time{}
+
+			switch {
+			// Treadmills only change speed when asked, so a step
+			// without a pace keeps whatever the runner has set.
+			case r.workout.Running():
+				if next.Pace > 0 {
+					r.commands <- ControlCommand{kind: ControlTargetSpeed, value: next.Speed()}
+				}
+				r.commands <- ControlCommand{kind: ControlIncline, value: next.Incline}
+
+			// Carry on from the current power, or start easy, it's
+			// quicker to come up to the range than to wait for heart
+			// rate to come down.
+			case next.HeartRate > 0 && next.Power == 0 && !next.Ramp:
+				start := float64(lastPower)
+				if start <= 0 {
+					start = r.FTP * 0.5
+				}
+				target := HeartRateRange{
+					Low:  next.HeartRate - workoutHeartRateTolerance,
+					High: next.HeartRate + workoutHeartRateTolerance,
+				}
+				heartRate = NewHeartRateController(target, r.HeartRateLag, start, r.FTP*1.2, r.commands)
+				// Whatever the controller sets, the next power step
+				// needs to set its own.
+				lastPower = -1
+
+			// Without a power target, let the rider do whatever they
+			// want on a flat road.
+			case next.Power == 0 && !next.Ramp:
+				lastPower = 0
+				r.commands <- ControlCommand{kind: ControlGrade, value: 0}
+			}
+		}
+
+		progress := actual
+		progress.StepCount = len(r.workout.Steps)
+
+		if step >= len(r.workout.Steps) {
+			progress.Step = len(r.workout.Steps)
+			progress.Done = true
+			r.progress <- progress
+			break
+		}
+
+		current := r.workout.Steps[step]
+		// From the start rather than the end, which Extend moves, so an
+		// extended ramp holds its end power for the extra time.
+		elapsed := now.Sub(stepStart)
+
+		if current.Power > 0 || current.Ramp {
+			setPower(stall.adjust(r.StallProtection, current.PowerAt(elapsed),
+				actual.ActualCadence, cadenceAt, now))
+		}
+
+		progress.Step = step
+		progress.StepName = current.Name
+		progress.Target = current
+		progress.Target.Power = current.PowerAt(elapsed)
+		progress.StepRemaining = stepEnd.Sub(now)
+		progress.Remaining = r.workout.Duration() + shift - now.Sub(start)
+		progress.Stalled = stall.stalled
+
+		if current.Cadence > 0 && !cadenceAt.IsZero() {
+			cadence := actual.ActualCadence
+			if now.Sub(cadenceAt) > stallCadenceMaxAge {
+				cadence = 0
+			}
+
+			switch off := current.Cadence - cadence; {
+			case math.Abs(off) <= cadenceTolerance:
+				cadenceOff = time.Time{}
+			case cadenceOff.IsZero():
+				cadenceOff = now
+			case now.Sub(cadenceOff) >= cadencePromptAfter:
+				progress.CadencePrompt = off
+				cadenceOff = now
+			}
+		}
+
+		r.progress <- progress
+	}
+
+	// Keep draining metrics so we don't block the sources.
+	for range in {
+	}
+}
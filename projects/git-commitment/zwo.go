@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Zwift workout files. There's no official spec, but the format is simple
+// enough and well documented by the community:
+// https://github.com/h4l/zwift-workout-file-reference
+type zwoFile struct {
+	XMLName xml.Name `xml:"workout_file"`
+	Name    string   `xml:"name"`
+	Workout struct {
+		Blocks []zwoBlock `xml:",any"`
+	} `xml:"workout"`
+}
+
+// Every block type shares the same set of attributes, only some of which
+// are used for each. Power values are fractions of FTP.
+type zwoBlock struct {
+	XMLName xml.Name
+
+	Duration  float64 `xml:"Duration,attr"`
+	Power     float64 `xml:"Power,attr"`
+	PowerLow  float64 `xml:"PowerLow,attr"`
+	PowerHigh float64 `xml:"PowerHigh,attr"`
+	Cadence   float64 `xml:"Cadence,attr"`
+
+	// IntervalsT
+	Repeat         int     `xml:"Repeat,attr"`
+	OnDuration     float64 `xml:"OnDuration,attr"`
+	OffDuration    float64 `xml:"OffDuration,attr"`
+	OnPower        float64 `xml:"OnPower,attr"`
+	OffPower       float64 `xml:"OffPower,attr"`
+	CadenceResting float64 `xml:"CadenceResting,attr"`
+}
+
+func zwoSeconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+func parseZwiftWorkout(r io.Reader, ftp float64) (*Workout, error) {
+	var file zwoFile
+	if err := xml.NewDecoder(r).Decode(&file); err != nil {
+		return nil, err
+	}
+
+	workout := &Workout{Name: file.Name}
+
+	for _, b := range file.Workout.Blocks {
+		switch b.XMLName.Local {
+		case "SteadyState":
+			workout.Steps = append(workout.Steps, WorkoutStep{
+				Name:     "Steady State",
+				Duration: zwoSeconds(b.Duration),
+				Power:    b.Power * ftp,
+				Cadence:  b.Cadence,
+			})
+
+		case "Warmup", "Cooldown", "Ramp":
+			workout.Steps = append(workout.Steps, WorkoutStep{
+				Name:     b.XMLName.Local,
+				Duration: zwoSeconds(b.Duration),
+				Power:    b.PowerLow * ftp,
+				PowerEnd: b.PowerHigh * ftp,
+				Ramp:     true,
+				Cadence:  b.Cadence,
+			})
+
+		case "IntervalsT":
+			for i := 0; i < b.Repeat; i++ {
+				workout.Steps = append(workout.Steps,
+					WorkoutStep{
+						Name:     fmt.Sprintf("Interval %d/%d", i+1, b.Repeat),
+						Duration: zwoSeconds(b.OnDuration),
+						Power:    b.OnPower * ftp,
+						Cadence:  b.Cadence,
+					},
+					WorkoutStep{
+						Name:     fmt.Sprintf("Recovery %d/%d", i+1, b.Repeat),
+						Duration: zwoSeconds(b.OffDuration),
+						Power:    b.OffPower * ftp,
+						Cadence:  b.CadenceResting,
+					},
+				)
+			}
+
+		case "FreeRide":
+			workout.Steps = append(workout.Steps, WorkoutStep{
+				Name:     "Free Ride",
+				Duration: zwoSeconds(b.Duration),
+				Cadence:  b.Cadence,
+			})
+
+		default:
+			// Text events and the like, nothing we can do with these.
+			continue
+		}
+	}
+
+	if len(workout.Steps) == 0 {
+		return nil, fmt.Errorf("workout has no steps")
+	}
+
+	return workout, nil
+}